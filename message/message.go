@@ -92,9 +92,9 @@ func (m *ExpiringMessage) ExpireNow() {
 	m.Expiry = time.Now()
 }
 
-/* Determines whether the message will be expired when a given time comes to pass. */
+/* Determines whether the message will be expired when a given time comes to pass. Messages are expired from their expiry time onward. */
 func (m ExpiringMessage) IsExpiredAt(time time.Time) bool {
-	return time.After(m.Expiry)
+	return !time.Before(m.Expiry)
 }
 
 /* Determines whether the message is expired at the current time. */
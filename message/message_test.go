@@ -55,3 +55,12 @@ func TestExpiringMessage(t *testing.T) {
 		}
 	}
 }
+
+func TestExpiringMessageBoundary(t *testing.T) {
+	//Messages are expired at exactly their expiry time, but not before it
+	expiry := time.Now().Add(time.Hour)
+	expm, _ := NewExpiringMessage(expiry)
+	if expm.IsExpiredAt(expiry.Add(-time.Nanosecond)) || !expm.IsExpiredAt(expiry) {
+		t.Fatalf("bad expiry boundary; expiry: %s", expm.Expiry)
+	}
+}
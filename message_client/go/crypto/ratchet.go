@@ -0,0 +1,376 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	//The maximum number of message keys that can be skipped in a single chain, or held at once.
+	RATCHET_MAX_SKIP = 1000
+
+	//The size of root, chain, and message keys in bytes.
+	_RATCHET_KEY_SIZE = 32
+
+	//Domain separation strings for the KDFs.
+	_RATCHET_ROOT_INFO = "WraithDoubleRatchetRoot"
+	_RATCHET_MSG_INFO  = "WraithDoubleRatchetMessage"
+)
+
+var (
+	//Returned when a message can't be decrypted with any of the keys of the session.
+	ErrRatchetDecrypt = errors.New("ratchet: message could not be decrypted")
+
+	//Returned when a message would require skipping more message keys than is allowed.
+	ErrRatchetTooManySkipped = errors.New("ratchet: too many skipped messages")
+
+	//Returned when a session tries sending before it has a sending chain.
+	ErrRatchetNoSendingChain = errors.New("ratchet: session cannot send until it receives a message")
+)
+
+/*
+Represents the header of a message encrypted by a Double Ratchet session. It's
+sent in the clear, but is authenticated as part of the associated data.
+*/
+type RatchetHeader struct {
+	DH []byte `json:"dh"` //The sender's current ratchet public key.
+	PN uint32 `json:"pn"` //The number of messages in the sender's previous sending chain.
+	N  uint32 `json:"n"`  //The number of the message in the current sending chain.
+}
+
+// Represents a message encrypted by a Double Ratchet session.
+type RatchetMessage struct {
+	Header     RatchetHeader `json:"header"`     //The header of the message.
+	Ciphertext []byte        `json:"ciphertext"` //The encrypted body of the message.
+}
+
+/*
+Represents one side of a 1:1 Double Ratchet session, per the Signal spec. The
+state is fully serializable, so it can be stashed between page loads; it must
+be saved after every call to `Encrypt()` or `Decrypt()`, otherwise keys will
+be reused or lost. The Diffie-Hellman ratchet runs on X25519, the KDF chains
+on HKDF-SHA256 and HMAC-SHA256, and messages are sealed with
+ChaCha20-Poly1305.
+*/
+type RatchetSession struct {
+	DHs     []byte            `json:"dhs"`           //The private half of our current ratchet keypair.
+	DHr     []byte            `json:"dhr,omitempty"` //The peer's current ratchet public key.
+	RK      []byte            `json:"rk"`            //The root key.
+	CKs     []byte            `json:"cks,omitempty"` //The sending chain key.
+	CKr     []byte            `json:"ckr,omitempty"` //The receiving chain key.
+	Ns      uint32            `json:"ns"`            //The number of messages sent in the current sending chain.
+	Nr      uint32            `json:"nr"`            //The number of messages received in the current receiving chain.
+	PN      uint32            `json:"pn"`            //The number of messages sent in the previous sending chain.
+	Skipped map[string][]byte `json:"skipped"`       //Keys of messages that were skipped, keyed by ratchet public key and message number.
+}
+
+/*
+Starts a session as the party that sends the first message. The shared secret
+is the output of the key agreement (ie: X3DH), and the public key is the peer's
+signed prekey, which doubles as their first ratchet key.
+*/
+func RatchetInitSender(sk []byte, theirs *ecdh.PublicKey) (*RatchetSession, error) {
+	//Generate the first ratchet keypair
+	dhs, err := X25519Keygen()
+	if err != nil {
+		return nil, err
+	}
+
+	//Derive the first sending chain
+	dhOut, err := dhs.ECDH(theirs)
+	if err != nil {
+		return nil, err
+	}
+	rk, cks, err := ratchetKDFRoot(sk, dhOut)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RatchetSession{
+		DHs:     dhs.Bytes(),
+		DHr:     theirs.Bytes(),
+		RK:      rk,
+		CKs:     cks,
+		Skipped: make(map[string][]byte),
+	}, nil
+}
+
+/*
+Starts a session as the party that receives the first message. The shared
+secret must match the sender's, and the keypair is the one whose public half
+the sender used, ie: the signed prekey.
+*/
+func RatchetInitReceiver(sk []byte, ours *ecdh.PrivateKey) *RatchetSession {
+	return &RatchetSession{
+		DHs:     ours.Bytes(),
+		RK:      bytes.Clone(sk),
+		Skipped: make(map[string][]byte),
+	}
+}
+
+// Derives a session object from a JSON string.
+func RatchetFromJSON(jsons string) (*RatchetSession, error) {
+	s := &RatchetSession{}
+	if err := json.Unmarshal([]byte(jsons), s); err != nil {
+		return nil, err
+	}
+	if s.Skipped == nil {
+		s.Skipped = make(map[string][]byte)
+	}
+	return s, nil
+}
+
+// Derives a message object from a JSON string.
+func RatchetMessageFromJSON(jsons string) (RatchetMessage, error) {
+	msg := RatchetMessage{}
+	err := json.Unmarshal([]byte(jsons), &msg)
+	return msg, err
+}
+
+// Encrypts a message, authenticating the associated data along with it.
+func (s *RatchetSession) Encrypt(plaintext, ad []byte) (RatchetMessage, error) {
+	if s.CKs == nil {
+		return RatchetMessage{}, ErrRatchetNoSendingChain
+	}
+
+	//Get our current ratchet public key
+	dhs, err := ecdh.X25519().NewPrivateKey(s.DHs)
+	if err != nil {
+		return RatchetMessage{}, err
+	}
+
+	//Step the sending chain
+	var mk []byte
+	s.CKs, mk = ratchetKDFChain(s.CKs)
+	header := RatchetHeader{DH: dhs.PublicKey().Bytes(), PN: s.PN, N: s.Ns}
+	s.Ns++
+
+	//Seal the message
+	ct, err := ratchetSeal(mk, plaintext, header.associate(ad))
+	if err != nil {
+		return RatchetMessage{}, err
+	}
+	return RatchetMessage{Header: header, Ciphertext: ct}, nil
+}
+
+/*
+Decrypts a message, verifying the associated data along with it. The session
+is only modified if decryption succeeds, so forged or corrupted messages
+can't knock it out of sync.
+*/
+func (s *RatchetSession) Decrypt(msg RatchetMessage, ad []byte) ([]byte, error) {
+	//Try the keys of skipped messages first
+	skey := skippedKey(msg.Header.DH, msg.Header.N)
+	if mk, ok := s.Skipped[skey]; ok {
+		pt, err := ratchetOpen(mk, msg.Ciphertext, msg.Header.associate(ad))
+		if err != nil {
+			return nil, ErrRatchetDecrypt
+		}
+		delete(s.Skipped, skey)
+		return pt, nil
+	}
+
+	//Work on a copy of the state so failures leave the session untouched
+	next := s.clone()
+
+	//Step the DH ratchet if the peer has a new ratchet key
+	if !bytes.Equal(msg.Header.DH, next.DHr) {
+		if err := next.skipMessageKeys(msg.Header.PN); err != nil {
+			return nil, err
+		}
+		if err := next.stepDH(msg.Header.DH); err != nil {
+			return nil, err
+		}
+	}
+
+	//Step the receiving chain up to the message
+	if err := next.skipMessageKeys(msg.Header.N); err != nil {
+		return nil, err
+	}
+	var mk []byte
+	next.CKr, mk = ratchetKDFChain(next.CKr)
+	next.Nr++
+
+	//Open the message, committing the new state only if it's authentic
+	pt, err := ratchetOpen(mk, msg.Ciphertext, msg.Header.associate(ad))
+	if err != nil {
+		return nil, ErrRatchetDecrypt
+	}
+	*s = *next
+	return pt, nil
+}
+
+// Returns the JSON representation of the session.
+func (s RatchetSession) JSON() string {
+	json, _ := json.Marshal(s)
+	return string(json)
+}
+
+// Returns the JSON representation of the message.
+func (m RatchetMessage) JSON() string {
+	json, _ := json.Marshal(m)
+	return string(json)
+}
+
+// Creates a deep copy of the session.
+func (s RatchetSession) clone() *RatchetSession {
+	out := s
+	out.DHs = bytes.Clone(s.DHs)
+	out.DHr = bytes.Clone(s.DHr)
+	out.RK = bytes.Clone(s.RK)
+	out.CKs = bytes.Clone(s.CKs)
+	out.CKr = bytes.Clone(s.CKr)
+	out.Skipped = make(map[string][]byte, len(s.Skipped))
+	for k, v := range s.Skipped {
+		out.Skipped[k] = bytes.Clone(v)
+	}
+	return &out
+}
+
+// Stores the keys of the messages in the receiving chain that come before the given one.
+func (s *RatchetSession) skipMessageKeys(until uint32) error {
+	if s.CKr == nil {
+		return nil
+	}
+	if until > s.Nr && len(s.Skipped)+int(until-s.Nr) > RATCHET_MAX_SKIP {
+		return ErrRatchetTooManySkipped
+	}
+	for s.Nr < until {
+		var mk []byte
+		s.CKr, mk = ratchetKDFChain(s.CKr)
+		s.Skipped[skippedKey(s.DHr, s.Nr)] = mk
+		s.Nr++
+	}
+	return nil
+}
+
+// Steps the DH ratchet upon receiving a new ratchet key from the peer.
+func (s *RatchetSession) stepDH(theirs []byte) error {
+	//Parse the keys
+	dhr, err := ecdh.X25519().NewPublicKey(theirs)
+	if err != nil {
+		return err
+	}
+	dhs, err := ecdh.X25519().NewPrivateKey(s.DHs)
+	if err != nil {
+		return err
+	}
+
+	//Reset the chain counters
+	s.PN = s.Ns
+	s.Ns = 0
+	s.Nr = 0
+	s.DHr = bytes.Clone(theirs)
+
+	//Derive the new receiving chain
+	dhOut, err := dhs.ECDH(dhr)
+	if err != nil {
+		return err
+	}
+	if s.RK, s.CKr, err = ratchetKDFRoot(s.RK, dhOut); err != nil {
+		return err
+	}
+
+	//Generate a new ratchet keypair and derive the new sending chain
+	if dhs, err = X25519Keygen(); err != nil {
+		return err
+	}
+	if dhOut, err = dhs.ECDH(dhr); err != nil {
+		return err
+	}
+	if s.RK, s.CKs, err = ratchetKDFRoot(s.RK, dhOut); err != nil {
+		return err
+	}
+	s.DHs = dhs.Bytes()
+	return nil
+}
+
+// Serializes the header and prepends the associated data to it.
+func (h RatchetHeader) associate(ad []byte) []byte {
+	out := make([]byte, 0, len(ad)+len(h.DH)+8)
+	out = append(out, ad...)
+	out = append(out, h.DH...)
+	out = binary.BigEndian.AppendUint32(out, h.PN)
+	return binary.BigEndian.AppendUint32(out, h.N)
+}
+
+// Gets the key under which a skipped message key is stored.
+func skippedKey(dh []byte, n uint32) string {
+	return fmt.Sprintf("%s:%d", hex.EncodeToString(dh), n)
+}
+
+/*
+Derives x random bytes via HKDF. Unlike `hkdfHelper()`, this is pinned to
+SHA-256, since both parties of a session must derive the same keys regardless
+of what `HKDFHashFunc` is set to.
+*/
+func ratchetHKDF(dest []byte, secret, salt []byte, info string) error {
+	_, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), dest)
+	return err
+}
+
+// Derives a new root key and chain key from the current root key and a DH output.
+func ratchetKDFRoot(rk, dhOut []byte) ([]byte, []byte, error) {
+	out := make([]byte, 2*_RATCHET_KEY_SIZE)
+	if err := ratchetHKDF(out, dhOut, rk, _RATCHET_ROOT_INFO); err != nil {
+		return nil, nil, err
+	}
+	return out[:_RATCHET_KEY_SIZE], out[_RATCHET_KEY_SIZE:], nil
+}
+
+// Derives the next chain key and a message key from the current chain key.
+func ratchetKDFChain(ck []byte) ([]byte, []byte) {
+	mac := hmac.New(sha256.New, ck)
+	mac.Write([]byte{0x01})
+	mk := mac.Sum(nil)
+
+	mac.Reset()
+	mac.Write([]byte{0x02})
+	return mac.Sum(nil), mk
+}
+
+// Derives the AEAD key and nonce from a message key.
+func ratchetAEAD(mk []byte) ([]byte, []byte, error) {
+	out := make([]byte, chacha20poly1305.KeySize+chacha20poly1305.NonceSize)
+	if err := ratchetHKDF(out, mk, make([]byte, _RATCHET_KEY_SIZE), _RATCHET_MSG_INFO); err != nil {
+		return nil, nil, err
+	}
+	return out[:chacha20poly1305.KeySize], out[chacha20poly1305.KeySize:], nil
+}
+
+// Encrypts a message with a message key.
+func ratchetSeal(mk, plaintext, ad []byte) ([]byte, error) {
+	key, nonce, err := ratchetAEAD(mk)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, nonce, plaintext, ad), nil
+}
+
+// Decrypts a message with a message key.
+func ratchetOpen(mk, ciphertext, ad []byte) ([]byte, error) {
+	key, nonce, err := ratchetAEAD(mk)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, nonce, ciphertext, ad)
+}
@@ -0,0 +1,239 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+)
+
+var (
+	//Returned when a group message was made with a sender key other than the one held.
+	ErrSenderKeyMismatch = errors.New("senderkey: message was made with a different sender key")
+
+	//Returned when a group message's signature doesn't check out.
+	ErrSenderKeySignature = errors.New("senderkey: bad message signature")
+
+	//Returned when a sender key that's only good for receiving is used to send.
+	ErrSenderKeyReceiveOnly = errors.New("senderkey: key can only be used to receive")
+)
+
+/*
+Represents a sender key, which is how messages are encrypted for a group. Each
+member of a room generates their own, hands a `SenderKeyDistribution` to every
+other member over a pairwise session (as a `TypeEK` message), and then encrypts
+each group message only once. The chain key is stepped with every message for
+forward secrecy, and every message is signed so members can't impersonate one
+another. Keys must be thrown away and redistributed whenever someone is
+removed from the room, otherwise they could keep reading along.
+
+Like `RatchetSession`, the state must be saved after every call to `Encrypt()`
+or `Decrypt()`.
+*/
+type SenderKey struct {
+	ID          uint32            `json:"id"`                     //The random ID of the key, which is sent with each message.
+	Iteration   uint32            `json:"iteration"`              //The number of the next message in the chain.
+	ChainKey    []byte            `json:"chain_key"`              //The current chain key.
+	SigningPub  []byte            `json:"signing_pub"`            //The key that verifies the owner's messages.
+	SigningPriv []byte            `json:"signing_priv,omitempty"` //The key that signs messages; only held by the owner.
+	Skipped     map[uint32][]byte `json:"skipped"`                //Keys of messages that were skipped, keyed by iteration.
+}
+
+// Represents the part of a sender key that is handed to other members of a room.
+type SenderKeyDistribution struct {
+	ID         uint32 `json:"id"`          //The ID of the key.
+	Iteration  uint32 `json:"iteration"`   //The iteration of the chain key.
+	ChainKey   []byte `json:"chain_key"`   //The chain key at that iteration.
+	SigningPub []byte `json:"signing_pub"` //The key that verifies the owner's messages.
+}
+
+// Represents a message encrypted with a sender key.
+type GroupMessage struct {
+	KeyID      uint32 `json:"key_id"`     //The ID of the sender key.
+	Iteration  uint32 `json:"iteration"`  //The iteration of the chain key the message was encrypted with.
+	Ciphertext []byte `json:"ciphertext"` //The encrypted body of the message.
+	Signature  []byte `json:"signature"`  //The signature of everything above, made by the sender.
+}
+
+// Generates a fresh sender key for the current user.
+func SenderKeygen() (*SenderKey, error) {
+	//Generate the signing keypair
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	//Generate the ID and the initial chain key
+	buf := make([]byte, 4+_RATCHET_KEY_SIZE)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+
+	return &SenderKey{
+		ID:          binary.BigEndian.Uint32(buf[:4]),
+		ChainKey:    buf[4:],
+		SigningPub:  pub,
+		SigningPriv: priv,
+		Skipped:     make(map[uint32][]byte),
+	}, nil
+}
+
+// Creates a receive-only sender key from one that another member handed out.
+func SenderKeyFromDistribution(d SenderKeyDistribution) *SenderKey {
+	return &SenderKey{
+		ID:         d.ID,
+		Iteration:  d.Iteration,
+		ChainKey:   bytes.Clone(d.ChainKey),
+		SigningPub: bytes.Clone(d.SigningPub),
+		Skipped:    make(map[uint32][]byte),
+	}
+}
+
+// Derives a sender key object from a JSON string.
+func SenderKeyFromJSON(jsons string) (*SenderKey, error) {
+	k := &SenderKey{}
+	if err := json.Unmarshal([]byte(jsons), k); err != nil {
+		return nil, err
+	}
+	if k.Skipped == nil {
+		k.Skipped = make(map[uint32][]byte)
+	}
+	return k, nil
+}
+
+// Derives a sender key distribution object from a JSON string.
+func SenderKeyDistributionFromJSON(jsons string) (SenderKeyDistribution, error) {
+	d := SenderKeyDistribution{}
+	err := json.Unmarshal([]byte(jsons), &d)
+	return d, err
+}
+
+// Derives a group message object from a JSON string.
+func GroupMessageFromJSON(jsons string) (GroupMessage, error) {
+	msg := GroupMessage{}
+	err := json.Unmarshal([]byte(jsons), &msg)
+	return msg, err
+}
+
+/*
+Gets the distribution message for this sender key, to be sent to the other
+members of the room. It carries the current iteration, so recipients can't
+read anything that was sent before they got it.
+*/
+func (k SenderKey) Distribution() SenderKeyDistribution {
+	return SenderKeyDistribution{
+		ID:         k.ID,
+		Iteration:  k.Iteration,
+		ChainKey:   bytes.Clone(k.ChainKey),
+		SigningPub: bytes.Clone(k.SigningPub),
+	}
+}
+
+// Encrypts a message for the group, authenticating the associated data along with it.
+func (k *SenderKey) Encrypt(plaintext, ad []byte) (GroupMessage, error) {
+	if len(k.SigningPriv) != ed25519.PrivateKeySize {
+		return GroupMessage{}, ErrSenderKeyReceiveOnly
+	}
+
+	//Step the chain
+	var mk []byte
+	msg := GroupMessage{KeyID: k.ID, Iteration: k.Iteration}
+	k.ChainKey, mk = ratchetKDFChain(k.ChainKey)
+	k.Iteration++
+
+	//Seal and sign the message
+	ct, err := ratchetSeal(mk, plaintext, msg.associate(ad))
+	if err != nil {
+		return GroupMessage{}, err
+	}
+	msg.Ciphertext = ct
+	msg.Signature = ed25519.Sign(k.SigningPriv, msg.signed(ad))
+	return msg, nil
+}
+
+/*
+Decrypts a message from the group, verifying its signature and the associated
+data. The key is only modified if decryption succeeds.
+*/
+func (k *SenderKey) Decrypt(msg GroupMessage, ad []byte) ([]byte, error) {
+	//Check that the message was made by the holder of this key
+	if msg.KeyID != k.ID {
+		return nil, ErrSenderKeyMismatch
+	}
+	if len(k.SigningPub) != ed25519.PublicKeySize || !ed25519.Verify(k.SigningPub, msg.signed(ad), msg.Signature) {
+		return nil, ErrSenderKeySignature
+	}
+
+	//Try the keys of skipped messages first
+	if mk, ok := k.Skipped[msg.Iteration]; ok {
+		pt, err := ratchetOpen(mk, msg.Ciphertext, msg.associate(ad))
+		if err != nil {
+			return nil, ErrRatchetDecrypt
+		}
+		delete(k.Skipped, msg.Iteration)
+		return pt, nil
+	}
+
+	//Messages from before this key was handed out, or that were already read, can't be opened
+	if msg.Iteration < k.Iteration {
+		return nil, ErrRatchetDecrypt
+	}
+	if len(k.Skipped)+int(msg.Iteration-k.Iteration) > RATCHET_MAX_SKIP {
+		return nil, ErrRatchetTooManySkipped
+	}
+
+	//Step a copy of the chain up to the message, holding onto the keys that are skipped over
+	ck := bytes.Clone(k.ChainKey)
+	skipped := make(map[uint32][]byte)
+	var mk []byte
+	for i := k.Iteration; i < msg.Iteration; i++ {
+		ck, mk = ratchetKDFChain(ck)
+		skipped[i] = mk
+	}
+	ck, mk = ratchetKDFChain(ck)
+
+	//Open the message, committing the new state only if it's authentic
+	pt, err := ratchetOpen(mk, msg.Ciphertext, msg.associate(ad))
+	if err != nil {
+		return nil, ErrRatchetDecrypt
+	}
+	for i, key := range skipped {
+		k.Skipped[i] = key
+	}
+	k.ChainKey = ck
+	k.Iteration = msg.Iteration + 1
+	return pt, nil
+}
+
+// Returns the JSON representation of the sender key.
+func (k SenderKey) JSON() string {
+	json, _ := json.Marshal(k)
+	return string(json)
+}
+
+// Returns the JSON representation of the distribution message.
+func (d SenderKeyDistribution) JSON() string {
+	json, _ := json.Marshal(d)
+	return string(json)
+}
+
+// Returns the JSON representation of the group message.
+func (m GroupMessage) JSON() string {
+	json, _ := json.Marshal(m)
+	return string(json)
+}
+
+// Prepends the associated data to the key ID and iteration of the message.
+func (m GroupMessage) associate(ad []byte) []byte {
+	out := make([]byte, 0, len(ad)+8)
+	out = append(out, ad...)
+	out = binary.BigEndian.AppendUint32(out, m.KeyID)
+	return binary.BigEndian.AppendUint32(out, m.Iteration)
+}
+
+// Gets the bytes of the message that are covered by its signature.
+func (m GroupMessage) signed(ad []byte) []byte {
+	return append(m.associate(ad), m.Ciphertext...)
+}
@@ -0,0 +1,71 @@
+package crypto
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha512"
+	"fmt"
+	"math/big"
+)
+
+const (
+	X25519_LEN = 32
+)
+
+// The prime that defines the field of Curve25519, 2^255 - 19.
+var curve25519P, _ = new(big.Int).SetString("7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffed", 16)
+
+/*
+Derives the X25519 private key that corresponds to an Ed25519 keypair. This
+is the clamped scalar that Ed25519 itself derives from the seed, so the
+resulting public key matches `X25519PubFromEd25519(kp.PK)`.
+*/
+func X25519FromEd25519(kp Ed25519KP) (*ecdh.PrivateKey, error) {
+	h := sha512.Sum512(kp.SK[:])
+	return ecdh.X25519().NewPrivateKey(h[:X25519_LEN])
+}
+
+/*
+Converts an Ed25519 public key to its X25519 equivalent via the birational map
+`u = (1 + y) / (1 - y)` between the Edwards and Montgomery forms of the curve.
+Public keys aren't secret, so this doesn't need to run in constant time.
+*/
+func X25519PubFromEd25519(pk []byte) (*ecdh.PublicKey, error) {
+	if len(pk) != ED25519_LEN {
+		return nil, fmt.Errorf("mismatched byte array size (%d); expected: %d", len(pk), ED25519_LEN)
+	}
+
+	//Decode the y coordinate; it's little endian with the sign of x in the top bit
+	be := make([]byte, ED25519_LEN)
+	for i, b := range pk {
+		be[ED25519_LEN-1-i] = b
+	}
+	be[0] &= 0x7F
+	y := new(big.Int).SetBytes(be)
+	if y.Cmp(curve25519P) >= 0 {
+		return nil, fmt.Errorf("public key is not a valid point")
+	}
+
+	//Compute u = (1 + y) / (1 - y) mod p
+	num := new(big.Int).Add(big.NewInt(1), y)
+	den := new(big.Int).Sub(big.NewInt(1), y)
+	den.Mod(den, curve25519P)
+	if den.Sign() == 0 {
+		return nil, fmt.Errorf("public key is the identity point")
+	}
+	u := num.Mul(num, den.ModInverse(den, curve25519P))
+	u.Mod(u, curve25519P)
+
+	//Encode u back to little endian
+	out := make([]byte, X25519_LEN)
+	ube := u.FillBytes(make([]byte, X25519_LEN))
+	for i, b := range ube {
+		out[X25519_LEN-1-i] = b
+	}
+	return ecdh.X25519().NewPublicKey(out)
+}
+
+// Generates a fresh X25519 keypair, such as for use as a ratchet key.
+func X25519Keygen() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
@@ -10,6 +10,7 @@ require (
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/norunners/vert v0.0.0-20221203075838-106a353d42dd // indirect
 	golang.org/x/crypto v0.25.0 // indirect
+	golang.org/x/sys v0.22.0 // indirect
 )
 
 replace wraith.me/message_server v0.0.0 => ../../message_server
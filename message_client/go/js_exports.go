@@ -1,6 +1,9 @@
 package main
 
 import (
+	"crypto/ecdh"
+	"encoding/base64"
+	"encoding/json"
 	"strings"
 	"syscall/js"
 
@@ -31,6 +34,21 @@ func main() {
 	js.Global().Set("ed25519String", js.FuncOf(ed25519String))
 	js.Global().Set("ed25519Verify", js.FuncOf(ed25519Verify))
 
+	//Double Ratchet
+	js.Global().Set("x25519FromEd25519", js.FuncOf(x25519FromEd25519))
+	js.Global().Set("x25519PubFromEd25519", js.FuncOf(x25519PubFromEd25519))
+	js.Global().Set("ratchetInitSender", js.FuncOf(ratchetInitSender))
+	js.Global().Set("ratchetInitReceiver", js.FuncOf(ratchetInitReceiver))
+	js.Global().Set("ratchetEncrypt", js.FuncOf(ratchetEncrypt))
+	js.Global().Set("ratchetDecrypt", js.FuncOf(ratchetDecrypt))
+
+	//Sender keys
+	js.Global().Set("senderKeygen", js.FuncOf(senderKeygen))
+	js.Global().Set("senderKeyDistribution", js.FuncOf(senderKeyDistribution))
+	js.Global().Set("senderKeyFromDistribution", js.FuncOf(senderKeyFromDistribution))
+	js.Global().Set("senderKeyEncrypt", js.FuncOf(senderKeyEncrypt))
+	js.Global().Set("senderKeyDecrypt", js.FuncOf(senderKeyDecrypt))
+
 	//Functions
 
 	//Block the channel to prevent termination; termination will cause errors in the JS RT
@@ -130,7 +148,185 @@ func ed25519Verify(_ js.Value, args []js.Value) interface{} {
 	return obj.Verify([]byte(msg), ccrypto.MustFromString[ccrypto.Signature](ccrypto.ParseSignature, sig))
 }
 
+/* //-- Double Ratchet */
+// x25519FromEd25519(this Ed25519KP) -> string
+func x25519FromEd25519(_ js.Value, args []js.Value) interface{} {
+	xsk, err := crypto.X25519FromEd25519(ed25519ObjFromJSON(args[0]))
+	if err != nil {
+		return jsError(err)
+	}
+	return base64.StdEncoding.EncodeToString(xsk.Bytes())
+}
+
+// x25519PubFromEd25519(pk string) -> string
+func x25519PubFromEd25519(_ js.Value, args []js.Value) interface{} {
+	pk, err := base64.StdEncoding.DecodeString(args[0].String())
+	if err != nil {
+		return jsError(err)
+	}
+	xpk, err := crypto.X25519PubFromEd25519(pk)
+	if err != nil {
+		return jsError(err)
+	}
+	return base64.StdEncoding.EncodeToString(xpk.Bytes())
+}
+
+// ratchetInitSender(sk string, theirPub string) -> string
+func ratchetInitSender(_ js.Value, args []js.Value) interface{} {
+	//Get the args as well-typed items
+	sk, err := base64.StdEncoding.DecodeString(args[0].String())
+	if err != nil {
+		return jsError(err)
+	}
+	pub, err := base64.StdEncoding.DecodeString(args[1].String())
+	if err != nil {
+		return jsError(err)
+	}
+	theirs, err := ecdh.X25519().NewPublicKey(pub)
+	if err != nil {
+		return jsError(err)
+	}
+
+	//Start the session and return its state
+	session, err := crypto.RatchetInitSender(sk, theirs)
+	if err != nil {
+		return jsError(err)
+	}
+	return session.JSON()
+}
+
+// ratchetInitReceiver(sk string, ourPriv string) -> string
+func ratchetInitReceiver(_ js.Value, args []js.Value) interface{} {
+	//Get the args as well-typed items
+	sk, err := base64.StdEncoding.DecodeString(args[0].String())
+	if err != nil {
+		return jsError(err)
+	}
+	priv, err := base64.StdEncoding.DecodeString(args[1].String())
+	if err != nil {
+		return jsError(err)
+	}
+	ours, err := ecdh.X25519().NewPrivateKey(priv)
+	if err != nil {
+		return jsError(err)
+	}
+
+	//Start the session and return its state
+	return crypto.RatchetInitReceiver(sk, ours).JSON()
+}
+
+// ratchetEncrypt(state string, plaintext string, ad string) -> {state: string, message: RatchetMessage}
+func ratchetEncrypt(_ js.Value, args []js.Value) interface{} {
+	//Get the args as well-typed items
+	session, err := crypto.RatchetFromJSON(args[0].String())
+	if err != nil {
+		return jsError(err)
+	}
+
+	//Encrypt the message; the caller must persist the new state
+	msg, err := session.Encrypt([]byte(args[1].String()), []byte(args[2].String()))
+	if err != nil {
+		return jsError(err)
+	}
+	return jsObject(map[string]any{"state": session.JSON(), "message": msg})
+}
+
+// ratchetDecrypt(state string, message string, ad string) -> {state: string, plaintext: string}
+func ratchetDecrypt(_ js.Value, args []js.Value) interface{} {
+	//Get the args as well-typed items
+	session, err := crypto.RatchetFromJSON(args[0].String())
+	if err != nil {
+		return jsError(err)
+	}
+	msg, err := crypto.RatchetMessageFromJSON(args[1].String())
+	if err != nil {
+		return jsError(err)
+	}
+
+	//Decrypt the message; the caller must persist the new state
+	pt, err := session.Decrypt(msg, []byte(args[2].String()))
+	if err != nil {
+		return jsError(err)
+	}
+	return jsObject(map[string]any{"state": session.JSON(), "plaintext": string(pt)})
+}
+
+/* //-- Sender keys */
+// senderKeygen() -> string
+func senderKeygen(_ js.Value, _ []js.Value) interface{} {
+	key, err := crypto.SenderKeygen()
+	if err != nil {
+		return jsError(err)
+	}
+	return key.JSON()
+}
+
+// senderKeyDistribution(state string) -> string
+func senderKeyDistribution(_ js.Value, args []js.Value) interface{} {
+	key, err := crypto.SenderKeyFromJSON(args[0].String())
+	if err != nil {
+		return jsError(err)
+	}
+	return key.Distribution().JSON()
+}
+
+// senderKeyFromDistribution(dist string) -> string
+func senderKeyFromDistribution(_ js.Value, args []js.Value) interface{} {
+	dist, err := crypto.SenderKeyDistributionFromJSON(args[0].String())
+	if err != nil {
+		return jsError(err)
+	}
+	return crypto.SenderKeyFromDistribution(dist).JSON()
+}
+
+// senderKeyEncrypt(state string, plaintext string, ad string) -> {state: string, message: GroupMessage}
+func senderKeyEncrypt(_ js.Value, args []js.Value) interface{} {
+	//Get the args as well-typed items
+	key, err := crypto.SenderKeyFromJSON(args[0].String())
+	if err != nil {
+		return jsError(err)
+	}
+
+	//Encrypt the message; the caller must persist the new state
+	msg, err := key.Encrypt([]byte(args[1].String()), []byte(args[2].String()))
+	if err != nil {
+		return jsError(err)
+	}
+	return jsObject(map[string]any{"state": key.JSON(), "message": msg})
+}
+
+// senderKeyDecrypt(state string, message string, ad string) -> {state: string, plaintext: string}
+func senderKeyDecrypt(_ js.Value, args []js.Value) interface{} {
+	//Get the args as well-typed items
+	key, err := crypto.SenderKeyFromJSON(args[0].String())
+	if err != nil {
+		return jsError(err)
+	}
+	msg, err := crypto.GroupMessageFromJSON(args[1].String())
+	if err != nil {
+		return jsError(err)
+	}
+
+	//Decrypt the message; the caller must persist the new state
+	pt, err := key.Decrypt(msg, []byte(args[2].String()))
+	if err != nil {
+		return jsError(err)
+	}
+	return jsObject(map[string]any{"state": key.JSON(), "plaintext": string(pt)})
+}
+
 /* //-- Utility functions */
+// Marshals a Go object to JSON and parses it into a JS object.
+func jsObject(obj any) js.Value {
+	json, _ := json.Marshal(obj)
+	return js.Global().Get("JSON").Call("parse", string(json))
+}
+
+// Wraps an error into a JS object of the form `{error: string}`.
+func jsError(err error) js.Value {
+	return jsObject(map[string]string{"error": err.Error()})
+}
+
 //Converts a JSONObject representation of an Ed25519 keypair to its equivalent Go counterpart.
 func ed25519ObjFromJSON(arg js.Value) crypto.Ed25519KP {
 	//Create a new Ed25519 keypair object
@@ -0,0 +1,211 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+
+	cc "wraith.me/clientside_crypto/crypto"
+)
+
+// The shared secret and associated data used by the sessions in these tests.
+var (
+	ratchetSK = bytes.Repeat([]byte{0x42}, 32)
+	ratchetAD = []byte("wraith")
+)
+
+// Sets up a sender and receiver session that share a secret.
+func ratchetPair(t *testing.T) (*cc.RatchetSession, *cc.RatchetSession) {
+	bobKP, err := cc.X25519Keygen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, err := cc.RatchetInitSender(ratchetSK, bobKP.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return alice, cc.RatchetInitReceiver(ratchetSK, bobKP)
+}
+
+// Encrypts a message, failing the test on error.
+func ratchetSend(t *testing.T, s *cc.RatchetSession, msg string) cc.RatchetMessage {
+	out, err := s.Encrypt([]byte(msg), ratchetAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// Decrypts a message and ensures it matches the expected plaintext.
+func ratchetRecv(t *testing.T, s *cc.RatchetSession, msg cc.RatchetMessage, expected string) {
+	pt, err := s.Decrypt(msg, ratchetAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pt) != expected {
+		t.Fatalf("mismatched plaintext; expected '%s', got '%s'", expected, pt)
+	}
+}
+
+func TestX25519FromEd25519(t *testing.T) {
+	//Test vector; the expected values were derived independently of this library
+	kp, err := cc.Ed25519FromJSON(`{"sk":"eK7Rv8dfHPrWgeVcHIoskqMNke2EjWUFaIgafCaU3ZE=","pk":"wDw04q6c94g7zn5IwGe1M0E6NJRDuHCa0x+joia8DFg="}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedSK := "5f735b5211608687b9f4f8c443571bad2f4911ebb1c0101965d962872d6fe24d"
+	expectedPK := "e0da8b0d7367d011d5031288265e130ede5a847186bd105353520bb9eec68343"
+
+	//Convert the private key
+	xsk, err := cc.X25519FromEd25519(kp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(xsk.Bytes()) != expectedSK {
+		t.Fatalf("mismatched private key; expected %s, got %x", expectedSK, xsk.Bytes())
+	}
+
+	//Convert the public key; it should match the one derived from the private key
+	xpk, err := cc.X25519PubFromEd25519(kp.PK[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(xpk.Bytes()) != expectedPK || !xpk.Equal(xsk.PublicKey()) {
+		t.Fatalf("mismatched public key; expected %s, got %x", expectedPK, xpk.Bytes())
+	}
+
+	//Fresh keypairs should convert consistently too
+	for i := 0; i < 16; i++ {
+		kp := cc.Ed25519Keygen()
+		xsk, _ := cc.X25519FromEd25519(kp)
+		xpk, err := cc.X25519PubFromEd25519(kp.PK[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !xpk.Equal(xsk.PublicKey()) {
+			t.Fatalf("converted keys of %s do not correspond", kp)
+		}
+	}
+}
+
+func TestRatchetVector(t *testing.T) {
+	//Set up the receiving side with a fixed prekey
+	priv, _ := hex.DecodeString("5f735b5211608687b9f4f8c443571bad2f4911ebb1c0101965d962872d6fe24d")
+	spk, err := ecdh.X25519().NewPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob := cc.RatchetInitReceiver(ratchetSK, spk)
+
+	//Decrypt the first message of a session; this pins the KDFs and the wire format
+	msg, err := cc.RatchetMessageFromJSON(`{"header":{"dh":"n2A3EAn8WUhg1pm1aXL2qfzRbLpljpRZruanW5g2fF0=","pn":0,"n":0},"ciphertext":"KCszLaZ8qAoO42bLlwo3MdT0Lab7p1dRSVA="}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ratchetRecv(t, bob, msg, "hello, bob")
+}
+
+func TestRatchetConversation(t *testing.T) {
+	alice, bob := ratchetPair(t)
+
+	//The receiver can't speak first
+	if _, err := bob.Encrypt([]byte("hi"), ratchetAD); !errors.Is(err, cc.ErrRatchetNoSendingChain) {
+		t.Fatalf("expected %v; got %v", cc.ErrRatchetNoSendingChain, err)
+	}
+
+	//Trade messages back and forth, stepping the DH ratchet each turn
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 3; j++ {
+			text := fmt.Sprintf("alice %d.%d", i, j)
+			ratchetRecv(t, bob, ratchetSend(t, alice, text), text)
+		}
+		text := fmt.Sprintf("bob %d", i)
+		ratchetRecv(t, alice, ratchetSend(t, bob, text), text)
+	}
+}
+
+func TestRatchetOutOfOrder(t *testing.T) {
+	alice, bob := ratchetPair(t)
+
+	//Alice sends a few messages, which arrive out of order
+	m0 := ratchetSend(t, alice, "m0")
+	m1 := ratchetSend(t, alice, "m1")
+	m2 := ratchetSend(t, alice, "m2")
+	ratchetRecv(t, bob, m2, "m2")
+	ratchetRecv(t, bob, m0, "m0")
+
+	//Bob replies, then Alice sends in the new chain; the stragglers should still open
+	ratchetRecv(t, alice, ratchetSend(t, bob, "reply"), "reply")
+	m3 := ratchetSend(t, alice, "m3")
+	ratchetRecv(t, bob, m3, "m3")
+	ratchetRecv(t, bob, m1, "m1")
+	if len(bob.Skipped) != 0 {
+		t.Fatalf("expected no skipped keys to be left; got %d", len(bob.Skipped))
+	}
+
+	//Replays should be refused once the key has been used
+	if _, err := bob.Decrypt(m1, ratchetAD); err == nil {
+		t.Fatal("replayed message was accepted")
+	}
+}
+
+func TestRatchetTooManySkipped(t *testing.T) {
+	alice, bob := ratchetPair(t)
+
+	//Jump too far ahead in the chain
+	var last cc.RatchetMessage
+	for i := 0; i <= cc.RATCHET_MAX_SKIP+1; i++ {
+		last = ratchetSend(t, alice, "spam")
+	}
+	if _, err := bob.Decrypt(last, ratchetAD); !errors.Is(err, cc.ErrRatchetTooManySkipped) {
+		t.Fatalf("expected %v; got %v", cc.ErrRatchetTooManySkipped, err)
+	}
+}
+
+func TestRatchetTamper(t *testing.T) {
+	alice, bob := ratchetPair(t)
+	msg := ratchetSend(t, alice, "untouched")
+
+	//Flip a bit of the ciphertext
+	bad := msg
+	bad.Ciphertext = bytes.Clone(msg.Ciphertext)
+	bad.Ciphertext[0] ^= 0x01
+	if _, err := bob.Decrypt(bad, ratchetAD); !errors.Is(err, cc.ErrRatchetDecrypt) {
+		t.Fatalf("expected %v; got %v", cc.ErrRatchetDecrypt, err)
+	}
+
+	//Change the associated data
+	if _, err := bob.Decrypt(msg, []byte("not wraith")); !errors.Is(err, cc.ErrRatchetDecrypt) {
+		t.Fatalf("expected %v; got %v", cc.ErrRatchetDecrypt, err)
+	}
+
+	//The failures shouldn't have disturbed the session
+	ratchetRecv(t, bob, msg, "untouched")
+}
+
+func TestRatchetSerialization(t *testing.T) {
+	alice, bob := ratchetPair(t)
+
+	//Leave a skipped key behind so it gets serialized too
+	skipped := ratchetSend(t, alice, "late")
+	ratchetRecv(t, bob, ratchetSend(t, alice, "early"), "early")
+
+	//Round-trip both sessions through JSON mid-conversation
+	alice2, err := cc.RatchetFromJSON(alice.JSON())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob2, err := cc.RatchetFromJSON(bob.JSON())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Printf("JSON: %s\n", bob2.JSON())
+
+	//The restored sessions should carry on where the originals left off
+	ratchetRecv(t, bob2, skipped, "late")
+	ratchetRecv(t, alice2, ratchetSend(t, bob2, "restored"), "restored")
+	ratchetRecv(t, bob2, ratchetSend(t, alice2, "still here"), "still here")
+}
@@ -0,0 +1,155 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	cc "wraith.me/clientside_crypto/crypto"
+)
+
+// Sets up a sender key and a copy of it held by another member.
+func senderKeyPair(t *testing.T) (*cc.SenderKey, *cc.SenderKey) {
+	sk, err := cc.SenderKeygen()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Hand the key over via its JSON form, as it would be over the wire
+	dist, err := cc.SenderKeyDistributionFromJSON(sk.Distribution().JSON())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sk, cc.SenderKeyFromDistribution(dist)
+}
+
+// Encrypts a group message, failing the test on error.
+func groupSend(t *testing.T, k *cc.SenderKey, msg string) cc.GroupMessage {
+	out, err := k.Encrypt([]byte(msg), ratchetAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// Decrypts a group message and ensures it matches the expected plaintext.
+func groupRecv(t *testing.T, k *cc.SenderKey, msg cc.GroupMessage, expected string) {
+	pt, err := k.Decrypt(msg, ratchetAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pt) != expected {
+		t.Fatalf("mismatched plaintext; expected '%s', got '%s'", expected, pt)
+	}
+}
+
+func TestSenderKeyGroup(t *testing.T) {
+	//Alice hands her sender key to Bob and Carol
+	alice, err := cc.SenderKeygen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob := cc.SenderKeyFromDistribution(alice.Distribution())
+	carol := cc.SenderKeyFromDistribution(alice.Distribution())
+
+	//Every message is encrypted once and read by everyone
+	for i := 0; i < 5; i++ {
+		text := fmt.Sprintf("hello group %d", i)
+		msg := groupSend(t, alice, text)
+		if msg.KeyID != alice.ID || msg.Iteration != uint32(i) {
+			t.Fatalf("bad key ID or iteration: %d/%d", msg.KeyID, msg.Iteration)
+		}
+		groupRecv(t, bob, msg, text)
+		groupRecv(t, carol, msg, text)
+	}
+
+	//Receivers can't send with the key
+	if _, err := bob.Encrypt([]byte("forged"), ratchetAD); !errors.Is(err, cc.ErrSenderKeyReceiveOnly) {
+		t.Fatalf("expected %v; got %v", cc.ErrSenderKeyReceiveOnly, err)
+	}
+}
+
+func TestSenderKeyOutOfOrder(t *testing.T) {
+	alice, bob := senderKeyPair(t)
+
+	m0 := groupSend(t, alice, "m0")
+	m1 := groupSend(t, alice, "m1")
+	m2 := groupSend(t, alice, "m2")
+	groupRecv(t, bob, m2, "m2")
+	groupRecv(t, bob, m0, "m0")
+	groupRecv(t, bob, m1, "m1")
+	if len(bob.Skipped) != 0 {
+		t.Fatalf("expected no skipped keys to be left; got %d", len(bob.Skipped))
+	}
+
+	//Replays should be refused once the key has been used
+	if _, err := bob.Decrypt(m1, ratchetAD); err == nil {
+		t.Fatal("replayed message was accepted")
+	}
+}
+
+func TestSenderKeyLateJoiner(t *testing.T) {
+	alice, _ := senderKeyPair(t)
+	before := groupSend(t, alice, "before")
+
+	//Dave gets the key after a message was sent; he shouldn't be able to read it
+	dave := cc.SenderKeyFromDistribution(alice.Distribution())
+	if _, err := dave.Decrypt(before, ratchetAD); err == nil {
+		t.Fatal("late joiner read a message sent before they got the key")
+	}
+	groupRecv(t, dave, groupSend(t, alice, "after"), "after")
+}
+
+func TestSenderKeyForgery(t *testing.T) {
+	alice, bob := senderKeyPair(t)
+	msg := groupSend(t, alice, "genuine")
+
+	//Mallory holds the same chain key, but not Alice's signing key
+	mallory := cc.SenderKeyFromDistribution(alice.Distribution())
+	mallory.SigningPriv = bytes.Repeat([]byte{0x01}, 64)
+	forged, err := mallory.Encrypt([]byte("forged"), ratchetAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.Decrypt(forged, ratchetAD); !errors.Is(err, cc.ErrSenderKeySignature) {
+		t.Fatalf("expected %v; got %v", cc.ErrSenderKeySignature, err)
+	}
+
+	//Rotated keys shouldn't open messages made with the old one
+	rotated, err := cc.SenderKeygen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cc.SenderKeyFromDistribution(rotated.Distribution()).Decrypt(msg, ratchetAD); !errors.Is(err, cc.ErrSenderKeyMismatch) {
+		t.Fatalf("expected %v; got %v", cc.ErrSenderKeyMismatch, err)
+	}
+
+	//None of that should have disturbed Bob's copy
+	groupRecv(t, bob, msg, "genuine")
+}
+
+func TestSenderKeySerialization(t *testing.T) {
+	alice, bob := senderKeyPair(t)
+	late := groupSend(t, alice, "late")
+	groupRecv(t, bob, groupSend(t, alice, "early"), "early")
+
+	//Round-trip both keys through JSON
+	alice2, err := cc.SenderKeyFromJSON(alice.JSON())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob2, err := cc.SenderKeyFromJSON(bob.JSON())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Printf("JSON: %s\n", bob2.JSON())
+
+	//The restored keys should carry on where the originals left off
+	groupRecv(t, bob2, late, "late")
+	msg, err := cc.GroupMessageFromJSON(groupSend(t, alice2, "restored").JSON())
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupRecv(t, bob2, msg, "restored")
+}
@@ -21,6 +21,7 @@ require (
 	github.com/xeipuuv/gojsonschema v1.2.0
 	github.com/xhit/go-simple-mail/v2 v2.16.0
 	go.mongodb.org/mongo-driver v1.16.0
+	wraith.me/message v0.0.0
 )
 
 require (
@@ -58,3 +59,6 @@ require (
 	golang.org/x/text v0.14.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+//The shared message types live in a sibling module; builds outside of the workspace, such as the Docker image, need this to find it
+replace wraith.me/message => ../message
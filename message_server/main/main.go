@@ -2,27 +2,36 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"time"
 
 	"wraith.me/message_server/pkg/amqp"
+	"wraith.me/message_server/pkg/blob"
 	"wraith.me/message_server/pkg/config"
 	"wraith.me/message_server/pkg/consts"
 	"wraith.me/message_server/pkg/db"
 	"wraith.me/message_server/pkg/email"
 	"wraith.me/message_server/pkg/globals"
+	"wraith.me/message_server/pkg/http_types/ws/chat"
 	"wraith.me/message_server/pkg/mw"
+	"wraith.me/message_server/pkg/obj/notification"
 	cr "wraith.me/message_server/pkg/redis"
 	"wraith.me/message_server/pkg/router"
 	"wraith.me/message_server/pkg/router/auth"
 	"wraith.me/message_server/pkg/router/challenges"
+	"wraith.me/message_server/pkg/router/dm"
+	"wraith.me/message_server/pkg/router/keys"
 	"wraith.me/message_server/pkg/router/notifications"
 	"wraith.me/message_server/pkg/router/room"
 	"wraith.me/message_server/pkg/router/user"
 	"wraith.me/message_server/pkg/router/users"
+	"wraith.me/message_server/pkg/router/ws"
 	"wraith.me/message_server/pkg/task"
+	"wraith.me/message_server/pkg/ws/wschat"
+	"wraith.me/message_server/pkg/ws/wsnotif"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -40,6 +49,19 @@ func main() {
 	}
 	fmt.Printf("config:%+v\n", cfg)
 
+	//Rotate the server's keys instead of starting it, if asked to
+	rotateKeys := flag.Bool("rotate-keys", false, "add a new active key to the env's keyring and exit")
+	keyGrace := flag.Duration("key-grace", time.Duration(cfg.Token.RefreshLifetime)*time.Second, "how long the keys that were in use remain valid after rotating them")
+	flag.Parse()
+	if *rotateKeys {
+		active, err := config.RotateEnvKeys("", *keyGrace)
+		if err != nil {
+			log.Fatalf("Encountered unrecoverable error while rotating keys: %s\n", err.Error())
+		}
+		fmt.Printf("rotated keys; active key is now %s and old keys retire in %s\n", active.ID(), *keyGrace)
+		return
+	}
+
 	//Acquire an env instance, but cease further operation if an error occurred
 	env, envErr := config.EnvInit("")
 	if envErr != nil {
@@ -72,15 +94,70 @@ func main() {
 	defer eclient.Close()
 
 	//Connect to AMQP
-	amqpclient, aerr := amqp.GetInstance().Connect(&cfg.AMQP)
+	_, aerr := amqp.GetInstance().Connect(&cfg.AMQP)
 	if aerr != nil {
 		panic(fmt.Sprintf("amqp connection: %s", aerr))
 	}
-	defer amqpclient.Close()
+	defer amqp.GetInstance().Disconnect()
+
+	//Start the event bus
+	bus := amqp.GetBus()
+	if err := bus.Start(); err != nil {
+		panic(fmt.Sprintf("event bus: %s", err))
+	}
+	defer bus.Close()
 
 	//Setup globals
 	globals.Initialize(&cfg, &env)
 
+	//Setup collection indexes
+	if err := globals.RC.SetupIndexes(context.Background()); err != nil {
+		panic(fmt.Sprintf("room indexes: %s", err))
+	}
+	if err := globals.MC.SetupIndexes(context.Background()); err != nil {
+		panic(fmt.Sprintf("message indexes: %s", err))
+	}
+	if err := globals.MSC.SetupIndexes(context.Background()); err != nil {
+		panic(fmt.Sprintf("member state indexes: %s", err))
+	}
+	if err := globals.PKC.SetupIndexes(context.Background()); err != nil {
+		panic(fmt.Sprintf("prekey indexes: %s", err))
+	}
+	if err := globals.FRC.SetupIndexes(context.Background()); err != nil {
+		panic(fmt.Sprintf("friend request indexes: %s", err))
+	}
+	if err := globals.NC.SetupIndexes(context.Background()); err != nil {
+		panic(fmt.Sprintf("notification indexes: %s", err))
+	}
+	if err := globals.AC.SetupIndexes(context.Background()); err != nil {
+		panic(fmt.Sprintf("attachment indexes: %s", err))
+	}
+
+	//Setup attachment storage
+	blobs, berr := blob.NewStore(&cfg.Attachments)
+	if berr != nil {
+		panic(fmt.Sprintf("attachment storage: %s", berr))
+	}
+	globals.Blobs = blobs
+
+	//Publish chat events to the event bus, and let friends know when users come and go
+	wschat.GetInstance().SetEventBus(bus)
+	wschat.GetInstance().SetBlobStore(blobs)
+	if err := wschat.GetInstance().SubscribePresence(bus); err != nil {
+		panic(fmt.Sprintf("presence consumer: %s", err))
+	}
+
+	//Store notifications from the event bus and push them to their recipients
+	err := notification.Subscribe(bus, func(notif notification.Notification) {
+		wsnotif.GetInstance().Push(notif)
+		if err := wschat.GetInstance().SendToUser(notif.Recipient, chat.EventNOTIFICATION, notif); err != nil {
+			fmt.Printf("failed to push notification %s: %s\n", notif.ID, err)
+		}
+	})
+	if err != nil {
+		panic(fmt.Sprintf("notification consumer: %s", err))
+	}
+
 	//Setup scheduled tasks
 	if err := setupScheduledTasks(rclient); err != nil {
 		panic(err)
@@ -180,6 +257,13 @@ func setupServer() chi.Router {
 
 	//Chat routes
 	apir.Mount("/chat/room", room.RoomRoutes())
+	apir.Mount("/chat/dm", dm.DMRoutes())
+
+	//Key exchange routes
+	apir.Mount("/keys", keys.KeysRoutes())
+
+	//Multiplexed WebSocket routes
+	apir.Mount("/ws", ws.WSRoutes())
 
 	//Bind the API routes to the outgoing router
 	r.Mount("/api", apir)
@@ -195,10 +279,21 @@ func setupScheduledTasks(rc *redis.Client) error {
 		RC:  rc,
 		CTX: context.Background(),
 	}
+	expireTask := task.ExpireMessagesTask{
+		TQ:     time.Second * 10,
+		Server: wschat.GetInstance(),
+		CTX:    context.Background(),
+	}
+	uploadsTask := task.PurgeUploadsTask{
+		TQ:       time.Minute * 10,
+		Store:    globals.Blobs,
+		Lifetime: time.Duration(globals.Cfg.Attachments.UploadLifetime) * time.Second,
+		CTX:      context.Background(),
+	}
 
 	//Setup the scheduler and run it
 	sch := task.Scheduler{}
-	if err := sch.Register(purgeTask); err != nil {
+	if err := sch.Register(purgeTask, expireTask, uploadsTask); err != nil {
 		return err
 	}
 	if err := sch.Start(); err != nil {
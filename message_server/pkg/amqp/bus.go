@@ -0,0 +1,312 @@
+package amqp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	// Returned when publishing to a bus that hasn't been started.
+	ErrBusNotRunning = errors.New("amqp: the event bus is not running")
+
+	// Returned when the broker refuses to take ownership of a published event.
+	ErrPublishNacked = errors.New("amqp: the broker did not acknowledge the event")
+)
+
+// Holds the instance object for the global event bus.
+var busInstance *Bus
+
+// Guard mutex to ensure that only one singleton object is created.
+var busOnce sync.Once
+
+/*
+Represents a durable event bus built on top of the AMQP client. Events are
+published to topic exchanges and consumed by consumer groups: each group gets
+its own durable queue, so every group sees every event, while the members of a
+group share the work between them. Events that a group fails to process are
+dead-lettered to a queue of their own for later inspection.
+*/
+type Bus struct {
+	client    *AMQPClient
+	pubCh     *amqp.Channel
+	consumers []*consumer
+	mutex     *sync.Mutex
+}
+
+// Represents a consumer group member that's attached to the bus.
+type consumer struct {
+	group    string
+	exchange Exchange
+	keys     []string
+	handler  Handler
+	ch       *amqp.Channel
+}
+
+// Gets the currently active event bus instance.
+func GetBus() *Bus {
+	busOnce.Do(func() {
+		busInstance = NewBus(GetInstance())
+	})
+	return busInstance
+}
+
+// Creates a new event bus on top of an AMQP client.
+func NewBus(client *AMQPClient) *Bus {
+	bus := &Bus{
+		client: client,
+		mutex:  &sync.Mutex{},
+	}
+	client.OnReconnect(bus.reconnect)
+	return bus
+}
+
+// Declares the bus topology and readies it for publishing. The client must be connected.
+func (b *Bus) Start() error {
+	conn := b.client.GetConn()
+	if conn == nil {
+		return errors.New("amqp: cannot start the event bus; client is not currently connected to a server")
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.open(conn)
+}
+
+// Shuts down all of the channels held by the bus.
+func (b *Bus) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var errs []error
+	for _, c := range b.consumers {
+		if c.ch != nil {
+			errs = append(errs, c.ch.Close())
+		}
+	}
+	if b.pubCh != nil {
+		errs = append(errs, b.pubCh.Close())
+		b.pubCh = nil
+	}
+	b.consumers = nil
+	return errors.Join(errs...)
+}
+
+/*
+Publishes an event to an exchange and waits for the broker to confirm that
+it has taken ownership of it.
+*/
+func (b *Bus) Publish(ctx context.Context, exchange Exchange, key string, payload any) error {
+	//Get the publishing channel
+	b.mutex.Lock()
+	ch := b.pubCh
+	b.mutex.Unlock()
+	if ch == nil {
+		return ErrBusNotRunning
+	}
+
+	//Construct the event
+	ev, err := NewEvent(key, payload)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	//Publish the event
+	dc, err := ch.PublishWithDeferredConfirmWithContext(ctx, string(exchange), key, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    ev.ID.String(),
+		Timestamp:    ev.Time,
+		Type:         key,
+		Body:         body,
+	})
+	if err != nil {
+		return err
+	}
+
+	//Wait for the broker to confirm the event
+	acked, err := dc.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return ErrPublishNacked
+	}
+	return nil
+}
+
+/*
+Attaches a handler to the bus as a member of a consumer group, receiving the
+events published to an exchange under the given routing keys. Topic wildcards
+(`*` and `#`) may be used in the keys. Events are acknowledged once the handler
+returns successfully; if it returns an error, the event is dead-lettered.
+*/
+func (b *Bus) Subscribe(group string, exchange Exchange, keys []string, handler Handler) error {
+	conn := b.client.GetConn()
+	if conn == nil {
+		return ErrBusNotRunning
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	c := &consumer{
+		group:    group,
+		exchange: exchange,
+		keys:     keys,
+		handler:  handler,
+	}
+	if err := c.start(conn); err != nil {
+		return err
+	}
+	b.consumers = append(b.consumers, c)
+	return nil
+}
+
+// Gets the name of the queue that a consumer group reads from.
+func QueueName(group string, exchange Exchange) string {
+	return fmt.Sprintf("%s.%s", exchange, group)
+}
+
+// Gets the name of the queue that holds the events a consumer group failed to process.
+func DeadQueueName(group string, exchange Exchange) string {
+	return QueueName(group, exchange) + ".dead"
+}
+
+// Declares the exchanges and opens the publishing channel. The mutex must be held.
+func (b *Bus) open(conn *amqp.Connection) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	//Declare the exchanges
+	for _, exch := range Exchanges {
+		if err := ch.ExchangeDeclare(string(exch), "topic", true, false, false, false, nil); err != nil {
+			ch.Close()
+			return err
+		}
+	}
+	if err := ch.ExchangeDeclare(string(ExchangeDEAD_LETTER), "direct", true, false, false, false, nil); err != nil {
+		ch.Close()
+		return err
+	}
+
+	//Put the channel into confirm mode
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return err
+	}
+
+	b.pubCh = ch
+	return nil
+}
+
+// Re-opens the channels of the bus once the client reconnects.
+func (b *Bus) reconnect(conn *amqp.Connection) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	//Skip buses that were never started
+	if b.pubCh == nil && len(b.consumers) == 0 {
+		return
+	}
+
+	//Re-open the publishing channel
+	if err := b.open(conn); err != nil {
+		fmt.Printf("amqp: failed to re-open the event bus: %s\n", err)
+		b.pubCh = nil
+	}
+
+	//Restart the consumers
+	for _, c := range b.consumers {
+		if err := c.start(conn); err != nil {
+			fmt.Printf("amqp: failed to restart consumer group %s: %s\n", c.group, err)
+		}
+	}
+}
+
+// Declares the queues of the consumer group and starts consuming from them.
+func (c *consumer) start(conn *amqp.Connection) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	//Declare the dead-letter queue
+	qname := QueueName(c.group, c.exchange)
+	dqname := DeadQueueName(c.group, c.exchange)
+	if _, err := ch.QueueDeclare(dqname, true, false, false, false, nil); err != nil {
+		ch.Close()
+		return err
+	}
+	if err := ch.QueueBind(dqname, qname, string(ExchangeDEAD_LETTER), false, nil); err != nil {
+		ch.Close()
+		return err
+	}
+
+	//Declare the queue of the group, routing rejects to the dead-letter queue
+	_, err = ch.QueueDeclare(qname, true, false, false, false, amqp.Table{
+		"x-dead-letter-exchange":    string(ExchangeDEAD_LETTER),
+		"x-dead-letter-routing-key": qname,
+	})
+	if err != nil {
+		ch.Close()
+		return err
+	}
+	for _, key := range c.keys {
+		if err := ch.QueueBind(qname, key, string(c.exchange), false, nil); err != nil {
+			ch.Close()
+			return err
+		}
+	}
+
+	//Start consuming
+	if err := ch.Qos(consumerPrefetch, 0, false); err != nil {
+		ch.Close()
+		return err
+	}
+	deliveries, err := ch.Consume(qname, "", false, false, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return err
+	}
+	c.ch = ch
+	go c.run(deliveries)
+	return nil
+}
+
+// Processes deliveries until the channel closes.
+func (c *consumer) run(deliveries <-chan amqp.Delivery) {
+	for d := range deliveries {
+		if err := c.handle(d); err != nil {
+			fmt.Printf("amqp: consumer group %s failed to process event %s: %s\n", c.group, d.MessageId, err)
+			d.Nack(false, false)
+		} else {
+			d.Ack(false)
+		}
+	}
+}
+
+// Decodes a delivery and passes it to the handler, converting panics into errors.
+func (c *consumer) handle(d amqp.Delivery) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+
+	var ev Event
+	if err := json.Unmarshal(d.Body, &ev); err != nil {
+		return err
+	}
+	return c.handler(ev)
+}
@@ -2,10 +2,22 @@ package amqp
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/creasty/defaults"
 )
 
+const (
+	// How long to wait before the first attempt at reconnecting to the server.
+	reconnectMinBackoff = time.Second
+
+	// The longest to wait between attempts at reconnecting to the server.
+	reconnectMaxBackoff = 30 * time.Second
+
+	// The number of unacknowledged events a consumer may hold at once.
+	consumerPrefetch = 16
+)
+
 // Configuration object for the AMQP client.
 type AMQPConfig struct {
 	//The address that the AMQP server is located at.
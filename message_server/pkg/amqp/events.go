@@ -0,0 +1,128 @@
+package amqp
+
+import (
+	"encoding/json"
+	"time"
+
+	"wraith.me/message_server/pkg/util"
+)
+
+// Represents the name of an exchange on the event bus.
+type Exchange string
+
+const (
+	// Carries events about chat messages.
+	ExchangeCHAT Exchange = "wraith.chat"
+
+	// Carries events about users joining and leaving chat rooms.
+	ExchangeMEMBERSHIP Exchange = "wraith.membership"
+
+	// Carries events about the lifecycle of friend requests.
+	ExchangeFRIEND_REQUEST Exchange = "wraith.friend_request"
+
+	// Carries notifications that are destined for users.
+	ExchangeNOTIFICATION Exchange = "wraith.notification"
+
+	// Carries changes to whether users are online.
+	ExchangePRESENCE Exchange = "wraith.presence"
+
+	// Receives events that consumers failed to process.
+	ExchangeDEAD_LETTER Exchange = "wraith.dlx"
+)
+
+// The exchanges that domain events are published to.
+var Exchanges = []Exchange{
+	ExchangeCHAT,
+	ExchangeMEMBERSHIP,
+	ExchangeFRIEND_REQUEST,
+	ExchangeNOTIFICATION,
+	ExchangePRESENCE,
+}
+
+// Routing keys for the events published to the bus.
+const (
+	// A chat message was sent to a room.
+	KeyMESSAGE_CREATED = "message.created"
+
+	// A chat message was edited by its sender.
+	KeyMESSAGE_EDITED = "message.edited"
+
+	// A chat message was deleted, leaving a tombstone behind.
+	KeyMESSAGE_DELETED = "message.deleted"
+
+	// A user joined a chat room.
+	KeyMEMBER_JOINED = "member.joined"
+
+	// A user left a chat room.
+	KeyMEMBER_LEFT = "member.left"
+
+	// A friend request was sent.
+	KeyFRQ_CREATED = "frq.created"
+
+	// A friend request was accepted.
+	KeyFRQ_ACCEPTED = "frq.accepted"
+
+	// A friend request was rejected.
+	KeyFRQ_REJECTED = "frq.rejected"
+
+	// A friend request was withdrawn by its sender.
+	KeyFRQ_CANCELLED = "frq.cancelled"
+
+	// A notification was issued to a user.
+	KeyNOTIFICATION_CREATED = "notification.created"
+
+	// A user came online, went away, or went offline.
+	KeyPRESENCE_CHANGED = "presence.changed"
+)
+
+// Represents a single domain event carried by the bus.
+type Event struct {
+	//The unique ID of the event.
+	ID util.UUID `json:"id"`
+
+	//The routing key of the event, which doubles as its type.
+	Key string `json:"key"`
+
+	//The time at which the event was published.
+	Time time.Time `json:"time"`
+
+	//The event-specific payload.
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Creates a new event, marshalling the payload to JSON.
+func NewEvent(key string, payload any) (Event, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{
+		ID:      util.MustNewUUID7(),
+		Key:     key,
+		Time:    time.Now(),
+		Payload: raw,
+	}, nil
+}
+
+// Unmarshals the payload of the event into the given object.
+func (e Event) Decode(dest any) error {
+	return json.Unmarshal(e.Payload, dest)
+}
+
+// A function that processes events delivered to a consumer group.
+type Handler func(ev Event) error
+
+/*
+Wraps a function that takes a concrete payload type into a `Handler`. Events
+whose payloads can't be decoded into the type are treated as failures and are
+dead-lettered.
+*/
+func Typed[T any](fn func(ev Event, payload T) error) Handler {
+	return func(ev Event) error {
+		var payload T
+		if err := ev.Decode(&payload); err != nil {
+			return err
+		}
+		return fn(ev, payload)
+	}
+}
@@ -2,6 +2,7 @@ package amqp
 
 import (
 	"errors"
+	"fmt"
 	"sync"
 	"time"
 
@@ -12,9 +13,11 @@ import (
 Represents an AMQP client. This struct acts as a singleton wrapper on a message broker client.
 */
 type AMQPClient struct {
-	client *amqp.Connection
-	config *AMQPConfig
-	mutex  *sync.Mutex
+	client  *amqp.Connection
+	config  *AMQPConfig
+	mutex   *sync.Mutex
+	closing bool
+	hooks   []func(*amqp.Connection)
 }
 
 // Holds the instance object for the global AMQP client.
@@ -39,6 +42,8 @@ AMQP server. If the client is not currently connected, then this
 object will be `nil`.
 */
 func (c *AMQPClient) GetConn() *amqp.Connection {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	return c.client
 }
 
@@ -68,12 +73,82 @@ func (c *AMQPClient) Connect(cfg *AMQPConfig) (*amqp.Connection, error) {
 		return nil, err
 	}
 
-	//Assign the config and return the client
+	//Assign the config and watch for the connection dropping
 	c.config = cfg
+	c.closing = false
+	go c.watch(c.client)
+
+	//Return the client
 	return c.client, nil
 }
 
-// Disconnects the client from the AMQP server and nullifies the instance.
+/*
+Registers a function to be called whenever the connection is re-established
+after dropping. Since channels die with the connection they were opened on,
+this is where consumers should re-open theirs.
+*/
+func (c *AMQPClient) OnReconnect(hook func(*amqp.Connection)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.hooks = append(c.hooks, hook)
+}
+
+// Waits for a connection to close and re-dials the server if it wasn't closed via `Disconnect()`.
+func (c *AMQPClient) watch(conn *amqp.Connection) {
+	//Wait for the connection to close
+	reason := <-conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	//Bail if the close was deliberate
+	c.mutex.Lock()
+	if c.closing || c.client != conn {
+		c.mutex.Unlock()
+		return
+	}
+	cfg := c.config
+	c.mutex.Unlock()
+	fmt.Printf("amqp: connection lost (%v); reconnecting\n", reason)
+
+	//Re-dial the server with exponential backoff
+	backoff := reconnectMinBackoff
+	for {
+		time.Sleep(backoff)
+
+		//Ensure nobody disconnected the client in the meantime
+		c.mutex.Lock()
+		if c.closing {
+			c.mutex.Unlock()
+			return
+		}
+		c.mutex.Unlock()
+
+		//Attempt to reconnect
+		nconn, err := amqp.Dial(cfg.ConnURL())
+		if err != nil {
+			fmt.Printf("amqp: reconnect failed: %s\n", err)
+			backoff = min(backoff*2, reconnectMaxBackoff)
+			continue
+		}
+
+		//Swap in the new connection and notify interested parties
+		c.mutex.Lock()
+		c.client = nconn
+		hooks := append([]func(*amqp.Connection){}, c.hooks...)
+		c.mutex.Unlock()
+		go c.watch(nconn)
+		for _, hook := range hooks {
+			hook(nconn)
+		}
+		fmt.Printf("amqp: reconnected\n")
+		return
+	}
+}
+
+/*
+Disconnects the client from the AMQP server. The instance itself is kept, so
+it can be connected again via `Connect()`. The reconnect hooks are kept as
+well, since they're registered once by long-lived consumers such as the event
+bus, which re-open their channels on the next connection.
+*/
 func (c *AMQPClient) Disconnect() error {
 	//Lock the mutex and defer its unlock
 	c.mutex.Lock()
@@ -85,9 +160,10 @@ func (c *AMQPClient) Disconnect() error {
 	}
 
 	//Disconnect from the AMQP server and return any errors
+	c.closing = true
 	err := c.client.Close()
 	c.client = nil
-	instance = nil // Clear instance for future connections.
+	c.config = nil
 	return err
 }
 
@@ -97,13 +173,14 @@ ping time in microseconds.
 */
 func (c *AMQPClient) Heartbeat() (int64, error) {
 	//Check if the connection is closed
-	if c.client == nil || c.client.IsClosed() {
+	conn := c.GetConn()
+	if conn == nil || conn.IsClosed() {
 		return 0, errors.New("amqp: cannot perform a heartbeat; client is not currently connected to a server")
 	}
 
 	start := time.Now()
 	//Attempt to create a new channel to check if the connection is alive
-	channel, err := c.client.Channel()
+	channel, err := conn.Channel()
 	if err != nil {
 		return 0, err
 	}
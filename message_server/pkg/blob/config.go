@@ -0,0 +1,56 @@
+package blob
+
+import (
+	"mime"
+	"strings"
+
+	"github.com/creasty/defaults"
+)
+
+// Configuration object for attachment storage.
+type BConfig struct {
+	//Where attachments are stored; `fs` for the local filesystem or `gridfs` for MongoDB. Default: fs.
+	Backend string `toml:"backend" env:"ATT_BACKEND" default:"fs"`
+
+	//The directory that attachments are stored in when using the filesystem backend. Default: ./attachments.
+	Path string `toml:"path" env:"ATT_PATH" default:"./attachments"`
+
+	//The maximum size of an attachment (in bytes). Default: 26214400 (25 MiB).
+	MaxFileSize int64 `toml:"max_file_size" env:"ATT_MAX_FILE_SIZE" default:"26214400"`
+
+	//The size of each chunk of an upload (in bytes); only the last one may be smaller. Default: 1048576 (1 MiB).
+	ChunkSize int `toml:"chunk_size" env:"ATT_CHUNK_SIZE" default:"1048576"`
+
+	//The comma-separated MIME types that attachments may have; `image/*` and the like allow a whole family.
+	AllowedTypes string `toml:"allowed_types" env:"ATT_ALLOWED_TYPES" default:"image/*,video/*,audio/*,text/plain,application/pdf,application/zip"`
+
+	//How long unfinished uploads are kept before they're discarded (in seconds). Default: 86400 (1 day).
+	UploadLifetime int `toml:"upload_lifetime" env:"ATT_UPLOAD_LIFETIME" default:"86400"`
+
+	//How long signed download URLs stay valid (in seconds). Default: 300 (5 minutes).
+	URLLifetime int `toml:"url_lifetime" env:"ATT_URL_LIFETIME" default:"300"`
+}
+
+func DefaultBConfig() *BConfig {
+	obj := &BConfig{}
+	if err := defaults.Set(obj); err != nil {
+		panic(err)
+	}
+	return obj
+}
+
+// Checks whether attachments may have the given MIME type. Parameters such as the charset are ignored.
+func (c BConfig) AllowsType(mimeType string) bool {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return false
+	}
+	family, _, _ := strings.Cut(mediaType, "/")
+	for _, allowed := range strings.Split(c.AllowedTypes, ",") {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == mediaType || allowed == family+"/*" {
+			return true
+		}
+	}
+	return false
+}
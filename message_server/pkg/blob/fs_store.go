@@ -0,0 +1,91 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+/*
+Stores blobs as files in a directory on the local filesystem; implements
+`BlobStore`. Blobs are written to a temporary file first and moved into
+place once complete, so readers never see a partial blob.
+*/
+type FSStore struct {
+	//The directory that blobs are stored in.
+	root string
+}
+
+var _ BlobStore = (*FSStore)(nil) // Type assertion check to ensure compliance with `BlobStore` interface.
+
+// Creates a filesystem store rooted at the given directory, creating it if it doesn't exist.
+func NewFSStore(root string) (*FSStore, error) {
+	if err := os.MkdirAll(root, 0o700); err != nil {
+		return nil, err
+	}
+	return &FSStore{root: root}, nil
+}
+
+func (s FSStore) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	if !validKey(key) {
+		return 0, ErrBadKey
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	//Write to a temporary file that's hidden from readers
+	tmp, err := os.CreateTemp(s.root, ".put-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return n, err
+	}
+
+	//Swap it into place
+	return n, os.Rename(tmp.Name(), s.path(key))
+}
+
+func (s FSStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if !validKey(key) {
+		return nil, ErrBadKey
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+func (s FSStore) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return ErrBadKey
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Gets the path of the file that holds a blob.
+func (s FSStore) path(key string) string {
+	return filepath.Join(s.root, key)
+}
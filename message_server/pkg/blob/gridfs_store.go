@@ -0,0 +1,90 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"wraith.me/message_server/pkg/db"
+)
+
+// The name of the GridFS bucket that blobs are stored in.
+const gridFSBucket = "blobs"
+
+/*
+Stores blobs in a GridFS bucket on the existing MongoDB connection;
+implements `BlobStore`. The key of each blob doubles as its file ID. Note
+that the GridFS upload and download calls don't take a context, so only
+removals can be cancelled.
+*/
+type GridFSStore struct {
+	//The bucket that blobs are stored in.
+	bucket *gridfs.Bucket
+}
+
+var _ BlobStore = (*GridFSStore)(nil) // Type assertion check to ensure compliance with `BlobStore` interface.
+
+// Creates a GridFS store in the root database of the given client.
+func NewGridFSStore(client *qmgo.Client) (*GridFSStore, error) {
+	//GridFS works off the driver's database object, which qmgo only hands out via a collection
+	coll, err := client.Database(db.ROOT_DB).Collection(gridFSBucket + ".files").CloneCollection()
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := gridfs.NewBucket(coll.Database(), options.GridFSBucket().SetName(gridFSBucket))
+	if err != nil {
+		return nil, err
+	}
+	return &GridFSStore{bucket: bucket}, nil
+}
+
+func (s GridFSStore) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	if !validKey(key) {
+		return 0, ErrBadKey
+	}
+
+	//GridFS files can't be overwritten, so any existing one is removed first
+	if err := s.Delete(ctx, key); err != nil {
+		return 0, err
+	}
+
+	stream, err := s.bucket.OpenUploadStreamWithID(key, key)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(stream, r)
+	if err != nil {
+		stream.Abort()
+		return n, err
+	}
+	return n, stream.Close()
+}
+
+func (s GridFSStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if !validKey(key) {
+		return nil, ErrBadKey
+	}
+
+	stream, err := s.bucket.OpenDownloadStream(key)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (s GridFSStore) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return ErrBadKey
+	}
+
+	if err := s.bucket.DeleteContext(ctx, key); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"wraith.me/message_server/pkg/db"
+)
+
+var (
+	// Returned when there's no blob stored under a key.
+	ErrNotFound = errors.New("blob: no such blob exists")
+
+	// Returned when a key can't be used to store a blob.
+	ErrBadKey = errors.New("blob: keys must be non-empty and may not contain path separators or start with '.'")
+)
+
+/*
+Stores opaque blobs of data by key. Attachments are encrypted by the clients
+before they're uploaded, so stores never see their plaintext and don't need
+to know what they hold. Implementations must be safe for concurrent use.
+*/
+type BlobStore interface {
+	// Saves a blob under a key, replacing any blob already there. Returns the number of bytes written.
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+
+	// Opens the blob stored under a key. Returns `ErrNotFound` if there's no such blob.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Removes the blob stored under a key. Removing a blob that doesn't exist is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// Creates the blob store that the given config calls for. The GridFS store requires MongoDB to be connected.
+func NewStore(cfg *BConfig) (BlobStore, error) {
+	switch strings.ToLower(cfg.Backend) {
+	case "fs":
+		return NewFSStore(cfg.Path)
+	case "gridfs":
+		if !db.GetInstance().IsConnected() {
+			return nil, fmt.Errorf("blob: the gridfs backend requires a connection to MongoDB")
+		}
+		return NewGridFSStore(db.GetInstance().GetClient())
+	default:
+		return nil, fmt.Errorf("blob: unknown backend '%s'; expected 'fs' or 'gridfs'", cfg.Backend)
+	}
+}
+
+// Checks whether a key can be used to store a blob.
+func validKey(key string) bool {
+	return key != "" && !strings.ContainsAny(key, "/\\\x00") && !strings.HasPrefix(key, ".")
+}
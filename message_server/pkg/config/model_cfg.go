@@ -10,10 +10,12 @@ import (
 	"github.com/golobby/config/v3"
 	"github.com/golobby/config/v3/pkg/feeder"
 	"wraith.me/message_server/pkg/amqp"
+	"wraith.me/message_server/pkg/blob"
 	"wraith.me/message_server/pkg/db"
 	"wraith.me/message_server/pkg/email"
 	"wraith.me/message_server/pkg/obj/token"
 	"wraith.me/message_server/pkg/redis"
+	"wraith.me/message_server/pkg/ws/wschat"
 )
 
 //
@@ -59,6 +61,12 @@ type Config struct {
 
 	//Token configuration
 	Token token.TConfig `toml:"token"`
+
+	//Chat server configuration
+	Chat wschat.WSConfig `toml:"chat"`
+
+	//Attachment storage configuration
+	Attachments blob.BConfig `toml:"attachments"`
 }
 
 // Overrides the `defaultPathName()` method in `IConfig`.
@@ -3,8 +3,10 @@ package config
 import (
 	"encoding/base64"
 	"fmt"
+	"os"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	ccrypto "wraith.me/message_server/pkg/crypto"
@@ -26,8 +28,11 @@ type Env struct {
 	//The ID of the server.
 	ID util.UUID `env:"ID"`
 
-	//The server's private cryptographic key.
-	SK ccrypto.Privkey `env:"SK"`
+	//The server's keyring, which holds its current and past private keys by version.
+	Keys ccrypto.Keyring `env:"KEYS"`
+
+	//The server's private cryptographic key. This is the active key of the keyring and isn't written to the env file.
+	SK ccrypto.Privkey `env:"-"`
 }
 
 // Overrides the `defaultPathName()` method in `IConfig`.
@@ -37,76 +42,125 @@ func (Env) defaultPathName() string {
 
 // Configures a new env config object.
 func EnvInit(path string) (Env, error) {
-	//Define the marshalling and unmarshalling functions
-	marshaller := func(c *Env) ([]byte, error) {
-		//Create an output string
-		out := strings.Builder{}
-
-		//Loop over all fields of the struct and get the k/v pairs for marshalling
-		//See: https://stackoverflow.com/a/66511341
-		fields := reflect.VisibleFields(reflect.TypeOf(*c))
-		for i, field := range fields {
-			//Skip the first field
-			if i == 0 {
-				continue
-			}
+	//Create a new blank env object and set defaults
+	cfg := Env{}
+	cfg.ID = util.MustNewUUID7()
+	_, sk, err := ccrypto.NewKeypair(nil) //`PrivateKey`` contains the public key already
+	if err != nil {
+		panic(err)
+	}
+	cfg.Keys = ccrypto.NewKeyring(sk)
+	cfg.SK = sk
 
-			//Get the tag value and determine the name of the key
-			keyn := strings.Split(field.Tag.Get("env"), ",")[0]
+	//Call the helper and return the results
+	err = initHelper[Env](&cfg, path, marshalEnv, unmarshalEnv)
+	return cfg, err
+}
 
-			//Special case: encode byte arrays as base64
-			var vstr string
-			if field.Type == reflect.TypeOf(c.SK) {
-				//Get a byte slice of the crypto key
-				slice := reflect.ValueOf(*c).Field(i).Interface().(ccrypto.Privkey)
+/*
+Adds a new active key to the keyring of the env file at the given path, and
+sets the keys that were in use to be retired after the given grace period.
+This must be done while the server is stopped, since it reads the keyring at
+startup.
+*/
+func RotateEnvKeys(path string, grace time.Duration) (ccrypto.RingKey, error) {
+	//Load the existing env; it must exist, or there's nothing to rotate
+	if path == "" {
+		path = Env{}.defaultPathName()
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ccrypto.RingKey{}, err
+	}
+	env := Env{}
+	if err := unmarshalEnv(b, &env); err != nil {
+		return ccrypto.RingKey{}, err
+	}
 
-				//Convert the slice to base64
-				vstr = base64.RawStdEncoding.EncodeToString(slice[:])
-			} else {
-				//Get the value of the key as a string
-				vstr = fmt.Sprintf("%v", reflect.ValueOf(*c).Field(i))
-			}
+	//Rotate the keys and write the env back
+	active, err := env.Keys.Rotate(grace, time.Now())
+	if err != nil {
+		return ccrypto.RingKey{}, err
+	}
+	if b, err = marshalEnv(&env); err != nil {
+		return ccrypto.RingKey{}, err
+	}
+	return active, os.WriteFile(path, b, 0660)
+}
 
-			//Add the k/v pair to the output string
-			fmt.Fprintf(&out, "%s=%s\n", keyn, vstr)
+// Marshals an env object to the contents of an env file.
+func marshalEnv(c *Env) ([]byte, error) {
+	//Create an output string
+	out := strings.Builder{}
+
+	//Loop over all fields of the struct and get the k/v pairs for marshalling
+	//See: https://stackoverflow.com/a/66511341
+	fields := reflect.VisibleFields(reflect.TypeOf(*c))
+	for i, field := range fields {
+		//Skip the first field
+		if i == 0 {
+			continue
 		}
 
-		//Return the output string
-		return []byte(out.String()), nil
+		//Get the tag value and determine the name of the key; fields tagged with "-" aren't written
+		keyn := strings.Split(field.Tag.Get("env"), ",")[0]
+		if keyn == "-" {
+			continue
+		}
 
+		//Special case: encode the keyring via its text form
+		var vstr string
+		if field.Type == reflect.TypeOf(c.Keys) {
+			text, err := c.Keys.MarshalText()
+			if err != nil {
+				return nil, err
+			}
+			vstr = string(text)
+		} else {
+			//Get the value of the key as a string
+			vstr = fmt.Sprintf("%v", reflect.ValueOf(*c).Field(i))
+		}
+
+		//Add the k/v pair to the output string
+		fmt.Fprintf(&out, "%s=%s\n", keyn, vstr)
 	}
-	unmarshaller := func(b []byte, c *Env) error {
-		//Unmarshal the bytes to a map
-		//TODO: use `github.com/golobby/dotenv` isntead of `github.com/joho/godotenv`
-		em, err := godotenv.UnmarshalBytes(b)
-		if err != nil {
+
+	//Return the output string
+	return []byte(out.String()), nil
+}
+
+// Unmarshals an env object from the contents of an env file.
+func unmarshalEnv(b []byte, c *Env) error {
+	//Unmarshal the bytes to a map
+	//TODO: use `github.com/golobby/dotenv` isntead of `github.com/joho/godotenv`
+	em, err := godotenv.UnmarshalBytes(b)
+	if err != nil {
+		return err
+	}
+
+	//Decode the keyring; env files from before keys were versioned only have a single key, which becomes the first one
+	var keys ccrypto.Keyring
+	if em["KEYS"] != "" {
+		if keys, err = ccrypto.ParseKeyring(em["KEYS"]); err != nil {
 			return err
 		}
-
-		//Decode the private key
+	} else {
 		sks, err := base64.RawStdEncoding.DecodeString(em["SK"])
 		if err != nil {
 			return err
 		}
-
-		//Set the struct fields from the map and return no error
-		*c = Env{
-			ID: util.UUIDFromString(em["ID"]),
-			SK: ccrypto.Privkey(sks),
+		sk, err := ccrypto.PrivkeyFromBytes(sks)
+		if err != nil {
+			return err
 		}
-		return nil
+		keys = ccrypto.NewKeyring(sk)
 	}
 
-	//Create a new blank env object and set defaults
-	cfg := Env{}
-	cfg.ID = util.MustNewUUID7()
-	var err error = nil
-	_, cfg.SK, err = ccrypto.NewKeypair(nil) //`PrivateKey`` contains the public key already
-	if err != nil {
-		panic(err)
+	//Set the struct fields from the map and return no error
+	*c = Env{
+		ID:   util.UUIDFromString(em["ID"]),
+		Keys: keys,
+		SK:   keys.Active().Key,
 	}
-
-	//Call the helper and return the results
-	err = initHelper[Env](&cfg, path, marshaller, unmarshaller)
-	return cfg, err
+	return nil
 }
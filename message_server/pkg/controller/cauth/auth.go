@@ -1,13 +1,19 @@
 package cauth
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"wraith.me/message_server/pkg/config"
+	"wraith.me/message_server/pkg/globals"
 	"wraith.me/message_server/pkg/http_types/response"
 	"wraith.me/message_server/pkg/obj/ip_addr"
+	"wraith.me/message_server/pkg/obj/notification"
+	"wraith.me/message_server/pkg/obj/session"
 	"wraith.me/message_server/pkg/obj/token"
 	"wraith.me/message_server/pkg/schema/user"
 	"wraith.me/message_server/pkg/util"
@@ -16,6 +22,9 @@ import (
 var (
 	//The HTTP status code to emit when the refresh token wasn't found.
 	AuthNoRToken = http.StatusForbidden
+
+	//The error to emit when a refresh token was used again after it was replaced.
+	ErrSessionRevoked = fmt.Errorf("refresh token was already used; its session has been logged out")
 )
 
 /*
@@ -62,7 +71,7 @@ func AttemptRefreshAuth(w http.ResponseWriter, r *http.Request, env *config.Env,
 	}
 
 	//Decrypt the refresh token
-	rtoken, err := token.Decrypt(rcookie, env.SK, env.ID, token.TokenTypeREFRESH)
+	rtoken, err := token.Decrypt(rcookie, env.Keys, env.ID, token.TokenTypeREFRESH)
 	if err != nil {
 		return
 	}
@@ -75,6 +84,12 @@ func AttemptRefreshAuth(w http.ResponseWriter, r *http.Request, env *config.Env,
 
 	//Ensure the user actually owns the token
 	if !usr.HasTokenById(rtoken.ID.String()) {
+		//Tokens that were already replaced by newer ones mean that one of them was stolen
+		if family, ok := usr.RotatedFamily(rtoken.ID.String()); ok {
+			RevokeReusedToken(r, usr, ucoll, rtoken.ID, family)
+			err = ErrSessionRevoked
+			return
+		}
 		err = fmt.Errorf("none of the refresh tokens on-file match")
 		return
 	}
@@ -88,18 +103,20 @@ func AttemptRefreshAuth(w http.ResponseWriter, r *http.Request, env *config.Env,
 /*
 Issues new access and refresh tokens for the user. This function ought to
 run once the user has successfully authenticated either via a refresh token
-or by solving a public key challenge.
+or by solving a public key challenge. In the latter case, `device` is the
+fingerprint of the device key that solved it, so the session can be ended
+along with the key; refreshed sessions keep the device they started with.
 */
 func PostAuth(
 	w http.ResponseWriter, r *http.Request,
 	usr *user.User, ucoll *user.UserCollection,
 	cfg *token.TConfig, env *config.Env,
-	persistent bool, tid *util.UUID,
+	persistent bool, tid *util.UUID, device string,
 ) {
-	//Delete the token from the user's list if one exists
-	//The refresh token may also be "reused" at this step, but only a limited number of times
+	//Get the family of the token being replaced, if any, in case it turns out to have been reused
+	var family util.UUID
 	if tid != nil {
-		usr.RemoveToken(tid.String())
+		family = usr.Tokens[tid.String()].FamilyOf(tid.String())
 	}
 
 	//Update the last IP and login fields of the user
@@ -107,9 +124,21 @@ func PostAuth(
 	usr.LastLogin = util.NowMillis()
 
 	//Issue an access and refresh token; this also updates the user in the database
-	rtid, err := IssueRefreshToken(w, r, usr, ucoll, r.Context(), env, cfg, persistent)
+	//The refresh token replaces the one that was presented, which only one request can do
+	rtid, err := IssueRefreshToken(w, r, usr, ucoll, r.Context(), env, cfg, persistent, tid, device)
+	if errors.Is(err, user.ErrTokenReused) {
+		RevokeReusedToken(r, usr, ucoll, *tid, family)
+		util.ErrResponse(http.StatusUnauthorized, ErrSessionRevoked).Respond(w)
+		return
+	}
 	if err != nil {
 		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+	if tid != nil {
+		if err := ucoll.PruneRotated(r.Context(), usr.ID); err != nil {
+			fmt.Printf("auth: failed to prune the rotated tokens of user %s: %s\n", usr.ID, err)
+		}
 	}
 	IssueAccessToken(w, r, usr, env, cfg, &rtid, persistent) //This should happen second; might want to tie access and refresh tokens together
 
@@ -129,3 +158,47 @@ func PostAuth(
 		payload,
 	).Respond(w)
 }
+
+/*
+Handles a refresh token that was presented again after it was replaced by a
+newer one. Refresh tokens are only ever used once, so either the token was
+stolen, or its rightful holder lost the race against whoever stole it. The
+two can't be told apart, so every token in the family is revoked, logging
+both of them out. The user is notified so that they know to log back in and
+review their sessions. Failures are logged, not returned.
+*/
+func RevokeReusedToken(r *http.Request, usr *user.User, ucoll *user.UserCollection, tid util.UUID, family util.UUID) {
+	ip := ip_addr.HttpIP2NetIP(r.RemoteAddr).String()
+	fmt.Printf("auth: refresh token %s of user %s was reused from %s; revoking token family %s\n", tid, usr.ID, ip, family)
+
+	if err := EndSessions(r.Context(), ucoll, usr.ID, family); err != nil {
+		fmt.Printf("auth: failed to revoke token family %s of user %s: %s\n", family, usr.ID, err)
+		return
+	}
+	if err := notification.Dispatch(r.Context(), notification.SessionRevokedNotif(usr.ID, family, ip)); err != nil {
+		fmt.Printf("auth: failed to dispatch notification for revoked token family %s: %s\n", family, err)
+	}
+}
+
+/*
+Ends a user's sessions by their token families. Every token in the families is
+removed from the user, the access tokens issued under them are denied from
+then on, and the connections opened with them are closed, even those opened
+before the session was last refreshed. Only failing to remove the tokens is
+returned; the rest is logged, since the access tokens are refused once their
+refresh tokens are gone anyway, and only the connections would linger.
+*/
+func EndSessions(ctx context.Context, ucoll *user.UserCollection, uid util.UUID, families ...util.UUID) error {
+	if len(families) == 0 {
+		return nil
+	}
+
+	if _, err := ucoll.RevokeFamilies(ctx, uid, families); err != nil {
+		return err
+	}
+	ttl := time.Duration(globals.Cfg.Token.AccessLifetime) * time.Second
+	if err := session.Revoke(ctx, globals.Rcl, ttl, families...); err != nil {
+		fmt.Printf("auth: failed to announce the revoked sessions of user %s: %s\n", uid, err)
+	}
+	return nil
+}
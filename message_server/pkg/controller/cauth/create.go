@@ -37,11 +37,14 @@ func IssueAccessToken(w http.ResponseWriter, r *http.Request, usr *user.User, en
 	)
 	atoken.IPAddr = ip_addr.HttpIP2NetIP(r.RemoteAddr)
 	atoken.UserAgent = r.UserAgent()
+	if parent != nil {
+		atoken.Family = usr.Tokens[parent.String()].FamilyOf(parent.String())
+	}
 
 	//Encrypt the access token and generate the cookie strings
 	domain := cfg.Domain
 	atCookie := atoken.Cookie(
-		env.SK, cfg.AccessCookiePath,
+		env.Keys.Active(), cfg.AccessCookiePath,
 		domain, persistent,
 	)
 	ateCookie := atoken.ExprCookie(
@@ -57,9 +60,13 @@ func IssueAccessToken(w http.ResponseWriter, r *http.Request, usr *user.User, en
 /*
 Issues a refresh token for a user and writes it to the outgoing response
 cookies along with the user object in the database. It is assumed that the
-user in question already exists in the database.
+user in question already exists in the database. If `parent` isn't nil, the
+new token replaces that one as the next in its family; this fails with
+`user.ErrTokenReused` if the parent was already replaced, in which case no
+cookies are written. Otherwise, the new token starts a family that's tied to
+`device`, the fingerprint of the device key the user logged in with, if any.
 */
-func IssueRefreshToken(w http.ResponseWriter, r *http.Request, usr *user.User, ucoll *user.UserCollection, ctx context.Context, env *config.Env, cfg *token.TConfig, persistent bool) (util.UUID, error) {
+func IssueRefreshToken(w http.ResponseWriter, r *http.Request, usr *user.User, ucoll *user.UserCollection, ctx context.Context, env *config.Env, cfg *token.TConfig, persistent bool, parent *util.UUID, device string) (util.UUID, error) {
 	//Get the current and expiry times
 	now := time.Now()
 	exp := now.Add(time.Duration(cfg.RefreshLifetime) * time.Second)
@@ -70,16 +77,22 @@ func IssueRefreshToken(w http.ResponseWriter, r *http.Request, usr *user.User, u
 		env.ID,
 		token.TokenTypeREFRESH,
 		exp,
-		nil,
+		parent,
 		&now,
 	)
 	rtoken.IPAddr = ip_addr.HttpIP2NetIP(r.RemoteAddr)
 	rtoken.UserAgent = r.UserAgent()
 
+	//Tokens issued at login start a new family, while rotated ones stay in the family of the token they replace
+	rtoken.Family = rtoken.ID
+	if parent != nil {
+		rtoken.Family = usr.Tokens[parent.String()].FamilyOf(parent.String())
+	}
+
 	//Encrypt the refresh token and generate the cookie strings
 	domain := cfg.Domain
 	rte, rtCookie := rtoken.CryptAndCookie(
-		env.SK, cfg.RefreshCookiePath,
+		env.Keys.Active(), cfg.RefreshCookiePath,
 		domain, persistent,
 	)
 	rteCookie := rtoken.ExprCookie(
@@ -87,14 +100,20 @@ func IssueRefreshToken(w http.ResponseWriter, r *http.Request, usr *user.User, u
 		cfg.ExprMultiplier, persistent,
 	)
 
+	//Add the refresh token to the user's list of tokens, keyed by its ID, and persist it
+	var err error
+	if parent == nil {
+		usr.AddDeviceToken(rtoken.ID.String(), rte, rtoken.Expiry, device)
+		_, err = ucoll.UpsertId(ctx, usr.ID, usr)
+	} else {
+		err = ucoll.RotateToken(ctx, usr, parent.String(), rtoken.ID.String(), rte, rtoken.Expiry)
+	}
+	if err != nil {
+		return rtoken.ID, err
+	}
+
 	//Write the cookies to the outgoing response
 	http.SetCookie(w, &rtCookie)
 	http.SetCookie(w, &rteCookie)
-
-	//Add the refresh token to the user's list of tokens, keyed by its ID
-	usr.AddToken(rtoken.ID.String(), rte, rtoken.Expiry)
-
-	//Upsert the corresponding document in the database
-	_, err := ucoll.UpsertId(ctx, usr.ID, usr)
-	return rtoken.ID, err
+	return rtoken.ID, nil
 }
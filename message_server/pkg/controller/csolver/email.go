@@ -23,7 +23,7 @@ func IssueEmailChallenge(usr *user.User, cfg *config.Config, env *config.Env, r
 		challenge.CPurposeCONFIRM,
 		time.Now().Add(24*time.Hour),
 		usr.Email,
-	).Encrypt(env.SK)
+	).Encrypt(env.Keys.Active())
 
 	//Compose and send a challenge email to the user
 	emailer := reg_email.NewRegEmail(
@@ -54,7 +54,7 @@ func VerifyEmailChallenge(env *config.Env, ctext string, w http.ResponseWriter,
 
 	//Attempt to decrypt the challenge
 	//From this point on, it's safe to assume the user successfully passed the challenge
-	ctoken, err := challenge.Decrypt(ctext, env.SK, env.ID, challenge.CPurposeCONFIRM)
+	ctoken, err := challenge.Decrypt(ctext, env.Keys, env.ID, challenge.CPurposeCONFIRM)
 	if err != nil {
 		util.ErrResponse(http.StatusForbidden, err).Respond(w)
 		return nil
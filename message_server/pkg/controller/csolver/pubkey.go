@@ -28,15 +28,68 @@ const (
 	_PF_NO_USER = http.StatusNotFound
 )
 
-// Issues a public key challenge for a user. This is stage 1 of a login/pk challenge.
-func IssuePKChallenge(user user.User, env *config.Env) string {
+/*
+Issues a public key challenge for a user, to be signed by one of their device
+keys. This is stage 1 of a login/pk challenge.
+*/
+func IssuePKChallenge(user user.User, pk ccrypto.Pubkey, env *config.Env) string {
 	return c.NewPKChallenge(
 		env.ID,
 		user.ID,
 		c.CPurposeLOGIN,
 		time.Now().Add(10*time.Minute),
-		user.Pubkey,
-	).Encrypt(env.SK)
+		pk,
+	).Encrypt(env.Keys.Active())
+}
+
+/*
+Issues a challenge to add a device key to a user. This is stage 1 of adding a
+device; the challenge claims the new key and must be signed by one of the
+user's existing device keys.
+*/
+func IssueDeviceChallenge(user user.User, pk ccrypto.Pubkey, env *config.Env) string {
+	return c.NewPKChallenge(
+		env.ID,
+		user.ID,
+		c.CPurposeADDDEVICE,
+		time.Now().Add(10*time.Minute),
+		pk,
+	).Encrypt(env.Keys.Active())
+}
+
+/*
+Verifies that a challenge to add a device key is valid and was signed by one of
+the user's existing device keys. This is stage 2 of adding a device.
+*/
+func VerifyDeviceChallenge(user user.User, req DeviceAdd, env *config.Env, r *http.Request) (*c.CToken, error) {
+	//Only a key the user already has may vouch for the new one
+	if !user.HasDeviceKey(req.Signer) {
+		return nil, fmt.Errorf("PK %s is not one of the user's device keys", req.Signer.Fingerprint())
+	}
+
+	//Verify the signature against the token; this proves ownership of the existing private key
+	if !ccrypto.Verify(req.Signer, []byte(req.Token), req.Signature) {
+		return nil, fmt.Errorf("verification failure for PK %s against provided message and signature", req.Signer.Fingerprint())
+	}
+
+	//Decrypt and validate the challenge; it must have been issued to this user for this key
+	devTok, err := c.DecryptPKStrict(
+		req.Token,
+		env.Keys,
+		env.ID,
+		c.CPurposeADDDEVICE,
+		user.ID,
+		req.PK,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	//Check the token in Redis
+	if err := CheckRedis(devTok, r.Context()); err != nil {
+		return nil, err
+	}
+	return devTok, nil
 }
 
 // Verifies that a public key challenge is valid. This is stage 2 of a login/pk challenge.
@@ -51,7 +104,7 @@ func VerifyPKChallenge(vreq LoginVerifyUser, env *config.Env, r *http.Request) (
 	//After this point, the user is considered fully authenticated; a token may now be issued
 	loginTok, err := c.DecryptPKStrict(
 		vreq.Token,
-		env.SK,
+		env.Keys,
 		env.ID,
 		c.CPurposeLOGIN,
 		vreq.ID,
@@ -205,17 +258,24 @@ func ensureCorrectIdAndPKFmt(id string, pk string, sig string) error {
 	return nil
 }
 
-// Ensures that a user with the given UUID and public key exists.
+// Ensures that a user with the given UUID exists and that the public key is one of their device keys.
 func ensureExistantUser(coll *user.UserCollection, usr LoginUser, ctx context.Context) (*user.User, error) {
 	//Construct a Mongo aggregation pipeline to run the request; avoids making multiple round-trips to the database
 	//This aggregation was exported from MongoDB; do not edit if you don't know what you are doing!
 	agg := bson.A{
-		//Match any documents that have the same ID and public key
+		//Match any documents that have the same ID and hold the public key as a device key
+		//Users who registered before devices were tracked only have their identity key
 		bson.D{
 			{Key: "$match",
 				Value: bson.D{
 					{Key: "_id", Value: usr.ID},
-					{Key: "pubkey", Value: usr.PK},
+					{Key: "$or", Value: bson.A{
+						bson.D{{Key: "devices." + usr.PK.Fingerprint() + ".key", Value: usr.PK}},
+						bson.D{
+							{Key: "pubkey", Value: usr.PK},
+							{Key: "devices", Value: bson.D{{Key: "$exists", Value: false}}},
+						},
+					}},
 				},
 			},
 		},
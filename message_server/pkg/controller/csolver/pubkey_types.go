@@ -36,3 +36,32 @@ type LoginVerifyUser struct {
 	//The signature of the input token, signed by the user's private key.
 	Signature ccrypto.Signature `json:"signature" mapstructure:"signature"`
 }
+
+// Defines the structure of JSON form data sent in the 1st stage of adding a device key. This contains the new key.
+type DeviceChallenge struct {
+	//The public key of the new device.
+	PK ccrypto.Pubkey `json:"pk"`
+}
+
+/*
+Defines the structure of JSON form data sent in the 2nd stage of adding a
+device key. This contains the new key and its label, along with the token that
+was issued and its digital signature, made by one of the user's existing device
+keys.
+*/
+type DeviceAdd struct {
+	//The public key of the new device.
+	PK ccrypto.Pubkey `json:"pk"`
+
+	//The name to give the new device.
+	Label string `json:"label"`
+
+	//The device token that the user was given.
+	Token string `json:"token"`
+
+	//The existing device key that signed the token.
+	Signer ccrypto.Pubkey `json:"signer"`
+
+	//The signature of the input token, signed by the existing device key.
+	Signature ccrypto.Signature `json:"signature"`
+}
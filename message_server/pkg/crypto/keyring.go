@@ -0,0 +1,190 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The ID of the first key of a keyring, which is the one that servers used before keys were versioned.
+const LegacyKeyID = "1"
+
+// Holds the error messages.
+var (
+	ErrKeyUnknown = errors.New("no key with this ID is on the keyring")
+	ErrKeyRetired = errors.New("the key with this ID was retired")
+	ErrKeyringNil = errors.New("keyring has no keys")
+)
+
+//
+//-- CLASS: RingKey
+//
+
+// Represents a versioned key held by a `Keyring`.
+type RingKey struct {
+	//The version of the key. Newer keys have higher versions.
+	Version int
+
+	//The key itself.
+	Key Privkey
+
+	//The time after which the key can no longer be used. This is zero if the key isn't set to be retired.
+	Retires time.Time
+}
+
+// Gets the ID of the key, which is its version as a string.
+func (k RingKey) ID() string {
+	return strconv.Itoa(k.Version)
+}
+
+// Checks if the key was retired by a given time.
+func (k RingKey) RetiredAt(now time.Time) bool {
+	return !k.Retires.IsZero() && !now.Before(k.Retires)
+}
+
+//
+//-- CLASS: Keyring
+//
+
+/*
+Represents a set of versioned keys, which allows the key that the server uses
+to be rotated without invalidating everything that was encrypted with the old
+one. The newest key is the active one and is used for new tokens, while older
+keys are only used to decrypt until they're retired.
+*/
+type Keyring struct {
+	//The keys on the ring, sorted by increasing version.
+	keys []RingKey
+}
+
+// Creates a new keyring that holds a single key. This key is given the legacy key ID.
+func NewKeyring(key Privkey) Keyring {
+	return Keyring{keys: []RingKey{{Version: 1, Key: key}}}
+}
+
+// Parses a keyring from a string created by `MarshalText()`.
+func ParseKeyring(str string) (Keyring, error) {
+	kr := Keyring{}
+	for _, entry := range strings.Split(str, ",") {
+		//Each entry is of the form `version:key[:retires]`, where `retires` is a Unix timestamp
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return Keyring{}, fmt.Errorf("bad keyring entry '%s'", entry)
+		}
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || version < 1 {
+			return Keyring{}, fmt.Errorf("bad key version '%s'", parts[0])
+		}
+		key, err := ParsePrivkey(parts[1])
+		if err != nil {
+			return Keyring{}, fmt.Errorf("bad key for version %d: %w", version, err)
+		}
+		rk := RingKey{Version: version, Key: key}
+		if len(parts) == 3 && parts[2] != "" {
+			unix, err := strconv.ParseInt(parts[2], 10, 64)
+			if err != nil {
+				return Keyring{}, fmt.Errorf("bad retirement time for version %d: %w", version, err)
+			}
+			rk.Retires = time.Unix(unix, 0)
+		}
+		kr.keys = append(kr.keys, rk)
+	}
+
+	//Keep the keys in order of version, so the newest is last
+	slices.SortFunc(kr.keys, func(a, b RingKey) int { return a.Version - b.Version })
+	for i := 1; i < len(kr.keys); i++ {
+		if kr.keys[i].Version == kr.keys[i-1].Version {
+			return Keyring{}, fmt.Errorf("duplicate key version %d", kr.keys[i].Version)
+		}
+	}
+	return kr, nil
+}
+
+// Gets the active key of the keyring, which is the newest one.
+func (kr Keyring) Active() RingKey {
+	if len(kr.keys) == 0 {
+		panic(ErrKeyringNil)
+	}
+	return kr.keys[len(kr.keys)-1]
+}
+
+// Gets a key by its ID, so long as it wasn't retired by the given time.
+func (kr Keyring) Get(kid string, now time.Time) (RingKey, error) {
+	for _, k := range kr.keys {
+		if k.ID() != kid {
+			continue
+		}
+		if k.RetiredAt(now) {
+			return RingKey{}, fmt.Errorf("%w: %s", ErrKeyRetired, kid)
+		}
+		return k, nil
+	}
+	return RingKey{}, fmt.Errorf("%w: %s", ErrKeyUnknown, kid)
+}
+
+// Gets the keys on the ring, sorted by increasing version.
+func (kr Keyring) Keys() []RingKey {
+	return slices.Clone(kr.keys)
+}
+
+// Gets the number of keys on the ring.
+func (kr Keyring) Len() int {
+	return len(kr.keys)
+}
+
+/*
+Adds a new active key to the keyring. The keys that were in use are set to be
+retired after the given grace period, which should be at least as long as the
+longest lived token encrypted with them. Keys that were already retired are
+dropped from the ring.
+*/
+func (kr *Keyring) Rotate(grace time.Duration, now time.Time) (RingKey, error) {
+	//Generate the new key
+	_, key, err := NewKeypair(nil)
+	if err != nil {
+		return RingKey{}, err
+	}
+	version := 1
+	if len(kr.keys) > 0 {
+		version = kr.Active().Version + 1
+	}
+
+	//Retire the old keys, dropping the ones whose grace period is already over
+	keys := make([]RingKey, 0, len(kr.keys)+1)
+	for _, k := range kr.keys {
+		if k.RetiredAt(now) {
+			continue
+		}
+		if k.Retires.IsZero() {
+			k.Retires = now.Add(grace)
+		}
+		keys = append(keys, k)
+	}
+
+	//Add the new key to the end of the ring
+	active := RingKey{Version: version, Key: key}
+	kr.keys = append(keys, active)
+	return active, nil
+}
+
+// Marshals a `Keyring` object to a string.
+func (kr Keyring) MarshalText() ([]byte, error) {
+	entries := make([]string, len(kr.keys))
+	for i, k := range kr.keys {
+		entries[i] = k.ID() + ":" + k.Key.String()
+		if !k.Retires.IsZero() {
+			entries[i] += ":" + strconv.FormatInt(k.Retires.Unix(), 10)
+		}
+	}
+	return []byte(strings.Join(entries, ",")), nil
+}
+
+// Unmarshals a `Keyring` object from a string.
+func (kr *Keyring) UnmarshalText(text []byte) error {
+	var err error
+	*kr, err = ParseKeyring(string(text))
+	return err
+}
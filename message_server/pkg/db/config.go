@@ -8,8 +8,13 @@ page for more info on the available options.
 https://www.mongodb.com/docs/drivers/node/current/fundamentals/connection/connection-options/
 */
 type MConfig struct {
-	//The connection string to use when establishing a connection to the MongoDB server.
-	ConnStr string `toml:"conn_str" env:"MGO_CONN_STR" default:"mongodb://127.0.0.1:27017"`
+	/*
+		The connection string to use when establishing a connection to the MongoDB
+		server. The server must be a replica set member, since transactions need
+		one. The default connects straight to a local single node replica set,
+		without discovering the other members by the hostnames they advertise.
+	*/
+	ConnStr string `toml:"conn_str" env:"MGO_CONN_STR" default:"mongodb://127.0.0.1:27017/?directConnection=true"`
 
 	//The timeout (in seconds) to use for connections.
 	Timeout int64 `toml:"timeout" env:"MGO_TIMEOUT" default:"10"`
@@ -16,6 +16,21 @@ const (
 	//Denotes the collection that stores chat rooms.
 	CROOMS_COLLECTION = "rooms"
 
+	//Denotes the collection that stores the state of each member of a chat room.
+	MSTATES_COLLECTION = "member_states"
+
+	//Denotes the collection that stores the X3DH prekeys of users.
+	PREKEYS_COLLECTION = "prekeys"
+
+	//Denotes the collection that stores friend requests.
+	FRQ_COLLECTION = "friend_requests"
+
+	//Denotes the collection that stores notifications.
+	NOTIFS_COLLECTION = "notifications"
+
+	//Denotes the collection that stores the metadata of attachments.
+	ATTACHMENTS_COLLECTION = "attachments"
+
 	//Denotes the collection that stores tests.
 	TESTS_COLLECTION = "tests"
 )
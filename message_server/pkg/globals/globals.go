@@ -2,10 +2,17 @@ package globals
 
 import (
 	"github.com/redis/go-redis/v9"
+	"wraith.me/message_server/pkg/blob"
 	"wraith.me/message_server/pkg/config"
 	"wraith.me/message_server/pkg/email"
+	"wraith.me/message_server/pkg/obj/notification"
 	cred "wraith.me/message_server/pkg/redis"
+	"wraith.me/message_server/pkg/schema/attachment"
+	chatmessage "wraith.me/message_server/pkg/schema/chat_message"
 	chatroom "wraith.me/message_server/pkg/schema/chat_room"
+	friendrequest "wraith.me/message_server/pkg/schema/friend_request"
+	memberstate "wraith.me/message_server/pkg/schema/member_state"
+	"wraith.me/message_server/pkg/schema/prekey"
 	"wraith.me/message_server/pkg/schema/user"
 )
 
@@ -18,6 +25,24 @@ var (
 	// Shared room collection across the entire application.
 	RC *chatroom.RoomCollection
 
+	// Shared chat message collection across the entire application.
+	MC *chatmessage.MessageCollection
+
+	// Shared member state collection across the entire application.
+	MSC *memberstate.StateCollection
+
+	// Shared prekey collection across the entire application.
+	PKC *prekey.PrekeyCollection
+
+	// Shared friend request collection across the entire application.
+	FRC *friendrequest.FriendRequestCollection
+
+	// Shared notification collection across the entire application.
+	NC *notification.NotificationCollection
+
+	// Shared attachment collection across the entire application.
+	AC *attachment.AttachmentCollection
+
 	//-- Configs
 
 	// Shared config object across the entire application.
@@ -33,6 +58,9 @@ var (
 
 	// Shared SMTP client across the entire application.
 	Smtp *email.EClient
+
+	// Shared attachment store across the entire application. Set once storage is set up.
+	Blobs blob.BlobStore
 )
 
 // Initializes the shared globals
@@ -40,6 +68,12 @@ func Initialize(cfg *config.Config, env *config.Env) {
 	//Initialize MDB collections
 	UC = user.GetCollection()
 	RC = chatroom.GetCollection()
+	MC = chatmessage.GetCollection()
+	MSC = memberstate.GetCollection()
+	PKC = prekey.GetCollection()
+	FRC = friendrequest.GetCollection()
+	NC = notification.GetCollection()
+	AC = attachment.GetCollection()
 
 	//Initialize configs
 	Cfg = cfg
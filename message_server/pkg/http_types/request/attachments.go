@@ -0,0 +1,16 @@
+package request
+
+// Represents a request to start uploading a file to a room.
+type NewAttachment struct {
+	// The name of the file.
+	Name string `json:"name"`
+
+	// The MIME type of the file.
+	MIME string `json:"mime"`
+
+	// The size of the file once encrypted, in bytes.
+	Size int64 `json:"size"`
+
+	// The hex-encoded SHA-256 hash of the encrypted file.
+	Hash string `json:"hash"`
+}
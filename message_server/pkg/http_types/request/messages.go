@@ -0,0 +1,12 @@
+package request
+
+// Represents a request to change the content of a message.
+type EditMessage struct {
+	Content string `json:"content"`
+}
+
+// Represents a request to change how long messages sent to a room last before they disappear.
+type SetDisappearing struct {
+	// The number of seconds messages last. Zero turns disappearing messages off.
+	DisappearAfter int64 `json:"disappear_after"`
+}
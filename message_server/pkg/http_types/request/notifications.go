@@ -0,0 +1,9 @@
+package request
+
+import "wraith.me/message_server/pkg/util"
+
+// Represents a request to act on several notifications at once. Either the IDs or `all` must be given.
+type NotificationBatch struct {
+	IDs []util.UUID `json:"ids,omitempty"`
+	All bool        `json:"all,omitempty"`
+}
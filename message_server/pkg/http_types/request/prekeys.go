@@ -0,0 +1,15 @@
+package request
+
+import "wraith.me/message_server/pkg/crypto"
+
+// Represents an X25519 prekey and its signature, made by the uploader's identity key.
+type SignedPrekey struct {
+	Key       crypto.Pubkey    `json:"key"`
+	Signature crypto.Signature `json:"signature"`
+}
+
+// Represents a request to publish prekeys. Either field may be omitted.
+type PrekeyUpload struct {
+	SignedPrekey   *SignedPrekey  `json:"signed_prekey,omitempty"`
+	OneTimePrekeys []SignedPrekey `json:"one_time_prekeys,omitempty"`
+}
@@ -0,0 +1,12 @@
+package response
+
+import "time"
+
+// Represents a signed link that an attachment can be downloaded from without logging in.
+type AttachmentURL struct {
+	// The link to download the attachment from.
+	URL string `json:"url"`
+
+	// When the link stops working.
+	Expires time.Time `json:"expires"`
+}
@@ -0,0 +1,41 @@
+package response
+
+import (
+	"time"
+
+	"wraith.me/message_server/pkg/crypto"
+	"wraith.me/message_server/pkg/schema/user"
+)
+
+// Represents one of a user's device keys.
+type DeviceKey struct {
+	//The ID of the device, which is the fingerprint of its key.
+	ID string `json:"id"`
+
+	//The device's public key.
+	Key crypto.Pubkey `json:"key"`
+
+	//The name the user gave the device.
+	Label string `json:"label"`
+
+	//The time at which the key was added.
+	Created time.Time `json:"created"`
+
+	//The last time the key was used to log in. This is zero if it never was.
+	LastUsed time.Time `json:"last_used"`
+
+	//Whether the key is the user's identity key.
+	Identity bool `json:"identity"`
+}
+
+// Creates a device key response from a user's device key.
+func NewDeviceKey(u user.User, fp string, dev user.DeviceKey) DeviceKey {
+	return DeviceKey{
+		ID:       fp,
+		Key:      dev.Key,
+		Label:    dev.Label,
+		Created:  dev.Created,
+		LastUsed: dev.LastUsed,
+		Identity: dev.Key.Equal(u.Pubkey),
+	}
+}
@@ -0,0 +1,12 @@
+package response
+
+import friendrequest "wraith.me/message_server/pkg/schema/friend_request"
+
+// Represents the pending friend requests of the requestor.
+type FriendRequestList struct {
+	//The requests that others sent to the requestor.
+	Incoming []friendrequest.FriendRequest `json:"incoming"`
+
+	//The requests that the requestor sent to others.
+	Outgoing []friendrequest.FriendRequest `json:"outgoing"`
+}
@@ -0,0 +1,15 @@
+package response
+
+import (
+	"wraith.me/message_server/pkg/http_types/ws/chat"
+	chatroom "wraith.me/message_server/pkg/schema/chat_room"
+)
+
+// Represents a message request that the requestor hasn't answered yet.
+type MessageRequest struct {
+	//The direct conversation that the request would start.
+	Room chatroom.Room `json:"room"`
+
+	//The message that the request carries, if its sender sent it yet.
+	Message *chat.Message `json:"message,omitempty"`
+}
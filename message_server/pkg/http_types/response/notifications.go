@@ -0,0 +1,16 @@
+package response
+
+import "wraith.me/message_server/pkg/obj/notification"
+
+// Represents a page of the requestor's notifications.
+type NotificationList struct {
+	PaginatedData[notification.Notification]
+
+	//The number of unread notifications the requestor has in total.
+	Unread int64 `json:"unread"`
+}
+
+// Represents the number of notifications that an action applied to.
+type NotificationCount struct {
+	Count int64 `json:"count"`
+}
@@ -0,0 +1,31 @@
+package response
+
+import (
+	"wraith.me/message_server/pkg/crypto"
+	"wraith.me/message_server/pkg/schema/prekey"
+	"wraith.me/message_server/pkg/util"
+)
+
+// Represents the keys needed to start an X3DH session with a user.
+type PrekeyBundle struct {
+	//The ID of the user the bundle belongs to.
+	ID util.UUID `json:"id"`
+
+	//The user's Ed25519 identity key, which signed the prekeys.
+	IdentityKey crypto.Pubkey `json:"identity_key"`
+
+	//The user's current signed prekey.
+	SignedPrekey prekey.Prekey `json:"signed_prekey"`
+
+	//A one-time prekey reserved for the requestor. Absent if the user has run out.
+	OneTimePrekey *prekey.Prekey `json:"one_time_prekey,omitempty"`
+}
+
+// Represents the number of one-time prekeys a user has left on the server.
+type PrekeyCount struct {
+	//The number of one-time prekeys left.
+	OneTime int64 `json:"one_time"`
+
+	//Whether the user has a signed prekey on file.
+	HasSigned bool `json:"has_signed"`
+}
@@ -0,0 +1,27 @@
+package response
+
+import "wraith.me/message_server/pkg/util"
+
+// Represents how far along the requestor is in a chat room's history.
+type ReadState struct {
+	//The ID of the last message the requestor acknowledged receiving.
+	Delivered util.UUID `json:"delivered_id"`
+
+	//The ID of the last message the requestor read.
+	Read util.UUID `json:"read_id"`
+
+	//The number of messages sent by others since the last message the requestor read.
+	Unread int64 `json:"unread"`
+
+	//The read pointers of the other members who share their receipts with the requestor.
+	Members []ReadPointer `json:"members"`
+}
+
+// Represents the last message a member of a chat room read.
+type ReadPointer struct {
+	//The ID of the member.
+	ID util.UUID `json:"id"`
+
+	//The ID of the last message the member read.
+	Read util.UUID `json:"read_id"`
+}
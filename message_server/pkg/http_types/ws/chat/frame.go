@@ -0,0 +1,75 @@
+package chat
+
+import (
+	"encoding/json"
+
+	"wraith.me/message_server/pkg/util"
+)
+
+// The names of the user-scoped events that are sent over multiplexed connections.
+const (
+	/*
+		A notification was issued to the user. The payload is the notification.
+		Connections opened with `?notifications=false` don't get these, so
+		clients that also hold a notification socket don't get them twice.
+	*/
+	EventNOTIFICATION = "notification"
+
+	// A friend of the user came online, went away, or went offline. The payload is a `Presence`.
+	EventPRESENCE = "presence"
+)
+
+/*
+Represents a frame on a multiplexed connection, which carries the traffic of
+any number of rooms as well as events that are scoped to the user. Room
+traffic is wrapped rather than changed, so the messages inside are the same
+as those sent over a room's own connection.
+*/
+type Frame struct {
+	// What the frame is for.
+	Op FrameOp `json:"op"`
+
+	// The ID of the room the frame concerns. Absent for user-scoped events.
+	RoomID *util.UUID `json:"room_id,omitempty"`
+
+	// The chat message being carried, for `MESSAGE` frames.
+	Message json.RawMessage `json:"message,omitempty"`
+
+	// The name of the event being carried, for `EVENT` frames.
+	Event string `json:"event,omitempty"`
+
+	// The body of the event, the `ServerError` of an `ERROR` or `UNSUBSCRIBED` frame, or the status of a `PRESENCE` frame.
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Creates a frame that carries a chat message to or from a room.
+func NewMessageFrame(roomID util.UUID, msg []byte) Frame {
+	return Frame{Op: FrameOpMESSAGE, RoomID: &roomID, Message: msg}
+}
+
+// Creates a frame that carries a user-scoped event, marshalling the payload to JSON.
+func NewEventFrame(event string, payload any) (Frame, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return Frame{}, err
+	}
+	return Frame{Op: FrameOpEVENT, Event: event, Payload: raw}, nil
+}
+
+// Creates a frame that concerns a room's subscription, optionally carrying an error.
+func NewRoomFrame(op FrameOp, roomID util.UUID, serr *ServerError) Frame {
+	frame := Frame{Op: op, RoomID: &roomID}
+	if serr != nil {
+		frame.Payload = serr.JSON()
+	}
+	return frame
+}
+
+// Marshals the frame to JSON.
+func (f Frame) JSON() []byte {
+	jsons, err := json.Marshal(f)
+	if err != nil {
+		panic("Frame::JSON: " + err.Error())
+	}
+	return jsons
+}
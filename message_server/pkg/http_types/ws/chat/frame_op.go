@@ -0,0 +1,32 @@
+//go:generate go-enum --marshal --forceupper --mustparse --nocomments --names --values
+package chat
+
+//
+//-- ENUM: FrameOp
+//
+
+// Defines what a frame on a multiplexed connection is for.
+/*
+ENUM(
+	UNKNOWN			//Unknown frame op.
+	SUBSCRIBE		//Asks to start receiving a room's traffic.
+	UNSUBSCRIBE		//Asks to stop receiving a room's traffic.
+	MESSAGE			//Carries a chat message to or from a room.
+	EVENT			//Carries an event that's scoped to the user rather than a room.
+	SUBSCRIBED		//Confirms that the connection is subscribed to a room.
+	UNSUBSCRIBED	//Confirms that the connection is no longer subscribed to a room.
+	ERROR			//Reports that a frame was rejected.
+	PRESENCE		//Sets the presence of the user, eg: to mark them as away.
+)
+*/
+type FrameOp int8
+
+// Checks whether a client is allowed to send a frame with this op.
+func (o FrameOp) IsClientOriginable() bool {
+	switch o {
+	case FrameOpSUBSCRIBE, FrameOpUNSUBSCRIBE, FrameOpMESSAGE, FrameOpPRESENCE:
+		return true
+	default:
+		return false
+	}
+}
@@ -2,7 +2,9 @@ package chat
 
 import (
 	"encoding/json"
+	"time"
 
+	"wraith.me/message"
 	"wraith.me/message_server/pkg/util"
 )
 
@@ -14,25 +16,41 @@ var (
 // Represents an individual message within a chat room.
 type Message struct {
 	// Unique identifier for the message.
-	ID util.UUID `json:"id"`
+	ID util.UUID `json:"id" bson:"_id"`
 
 	// The type of the chat message, e.g., MESSAGE, EVENT.
-	Type Type `json:"type"`
+	Type Type `json:"type" bson:"type"`
 
 	// The ID of the user who sent the message.
-	Sender util.UUID `json:"sender_id"`
+	Sender util.UUID `json:"sender_id" bson:"sender_id"`
 
 	// The ID of the user who received the message.
-	Recipient util.UUID `json:"recipient_id"`
+	Recipient util.UUID `json:"recipient_id" bson:"recipient_id"`
 
 	// The content of the message.
-	Content string `json:"content"`
+	Content string `json:"content" bson:"content"`
+
+	// The ID of the message this one replies to, if any.
+	ReplyTo *util.UUID `json:"reply_to,omitempty" bson:"reply_to,omitempty"`
+
+	// The ID of the message that starts the thread this one belongs to, if any.
+	ThreadRoot *util.UUID `json:"thread_root,omitempty" bson:"thread_root,omitempty"`
+
+	/*
+		How many seconds the message should last before it disappears. Clients
+		may set this to override the room's timer, but only to make the message
+		disappear sooner. It's consumed by the server and not passed along.
+	*/
+	DisappearAfter int64 `json:"disappear_after,omitempty" bson:"-"`
+
+	// The time at which the message will disappear, if it's set to. Set by the server.
+	Expiry *time.Time `json:"expiry,omitempty" bson:"expiry,omitempty"`
 
 	// Status of the message (e.g., sent, delivered, read).
 	//Status string `json:"status" bson:"status"`
 
-	// Attachments within the message (if any).
-	//Attachments []obj.Attachment `json:"attachments" bson:"attachments"`
+	// The IDs of the files attached to the message, if any. They must be fully uploaded to the room first.
+	Attachments []util.UUID `json:"attachments,omitempty" bson:"attachments,omitempty"`
 }
 
 // Creates a new message, with type field.
@@ -65,6 +83,39 @@ func (m Message) GetCommand() string {
 	}
 }
 
+/*
+Gets the expiry of the message as an `ExpiringMessage`, so its checks can be
+shared with other message types. Returns `nil` if the message never expires.
+*/
+func (m Message) Expiring() *message.ExpiringMessage {
+	if m.Expiry == nil {
+		return nil
+	}
+	return &message.ExpiringMessage{
+		GenericMessage: message.GenericMessage{ID: m.ID.UUID},
+		Expiry:         *m.Expiry,
+	}
+}
+
+// Returns how long the message has left before it disappears. Returns a zero duration if it's expired or never will.
+func (m Message) DurationToExpiry() time.Duration {
+	if exp := m.Expiring(); exp != nil {
+		return exp.DurationToExpiry()
+	}
+	return time.Duration(0)
+}
+
+// Determines whether the message will be expired when a given time comes to pass.
+func (m Message) IsExpiredAt(at time.Time) bool {
+	exp := m.Expiring()
+	return exp != nil && exp.IsExpiredAt(at)
+}
+
+// Determines whether the message is expired at the current time.
+func (m Message) IsExpired() bool {
+	return m.IsExpiredAt(time.Now())
+}
+
 // Marshals the message to JSON.
 func (m Message) JSON() []byte {
 	jsons, err := json.Marshal(m)
@@ -0,0 +1,33 @@
+package chat
+
+import (
+	"encoding/json"
+	"time"
+
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+Represents whether a user is around. Presence is derived from the user's
+open connections across the entire cluster, so it's never stored alongside
+the user.
+*/
+type Presence struct {
+	//The ID of the user.
+	User util.UUID `json:"user"`
+
+	//Whether the user is online, away, or offline.
+	Status PresenceStatus `json:"status"`
+
+	//When the user was last connected. This is the zero time if they never were.
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Marshals the presence to JSON.
+func (p Presence) JSON() []byte {
+	jsons, err := json.Marshal(p)
+	if err != nil {
+		panic("Presence::JSON: " + err.Error())
+	}
+	return jsons
+}
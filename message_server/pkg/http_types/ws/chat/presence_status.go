@@ -0,0 +1,16 @@
+//go:generate go-enum --marshal --forceupper --mustparse --nocomments --names --values
+package chat
+
+//
+//-- ENUM: PresenceStatus
+//
+
+// Defines whether a user is around.
+/*
+ENUM(
+	OFFLINE		//The user has no open connections.
+	ONLINE		//The user has at least one active connection.
+	AWAY		//The user is connected, but has marked every connection as away.
+)
+*/
+type PresenceStatus int8
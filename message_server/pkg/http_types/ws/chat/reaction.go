@@ -0,0 +1,35 @@
+package chat
+
+import (
+	"encoding/json"
+
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+Represents the content of a `REACTION` message. Clients send the message ID
+and the emoji to toggle their reaction; the server fills in the rest when
+telling the room about it.
+*/
+type Reaction struct {
+	//The ID of the message being reacted to.
+	MessageID util.UUID `json:"message_id"`
+
+	//The emoji of the reaction.
+	Emoji string `json:"emoji"`
+
+	//Whether the reaction was added rather than removed.
+	Added bool `json:"added"`
+
+	//The IDs of the users with this reaction on the message after the toggle.
+	Users []util.UUID `json:"users"`
+}
+
+// Marshals the reaction to JSON.
+func (r Reaction) JSON() []byte {
+	jsons, err := json.Marshal(r)
+	if err != nil {
+		panic("Reaction::JSON: " + err.Error())
+	}
+	return jsons
+}
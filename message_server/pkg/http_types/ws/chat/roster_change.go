@@ -0,0 +1,43 @@
+package chat
+
+import (
+	"encoding/json"
+
+	chatroom "wraith.me/message_server/pkg/schema/chat_room"
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+Represents a change to the list of members of a room, as opposed to the
+users that are currently connected to it. Clients use these to keep their
+group encryption keys in line with who's in the room.
+*/
+type RosterChange struct {
+	//The members of the room after the change, along with their roles.
+	Members chatroom.MembershipList `json:"members"`
+
+	//The IDs of the users that were added to the room.
+	Added []util.UUID `json:"added"`
+
+	//The IDs of the users that were removed from the room.
+	Removed []util.UUID `json:"removed"`
+
+	/*
+		Whether members must discard their sender keys and distribute new ones.
+		This is set whenever someone is removed, so they can't read anything
+		sent after they left.
+	*/
+	Rekey bool `json:"rekey"`
+
+	//Whether the room was deleted. Everyone still connected is disconnected afterwards.
+	Deleted bool `json:"deleted"`
+}
+
+// Marshals the message to JSON.
+func (r RosterChange) JSON() []byte {
+	jsons, err := json.Marshal(r)
+	if err != nil {
+		panic("RosterChange::JSON: " + err.Error())
+	}
+	return jsons
+}
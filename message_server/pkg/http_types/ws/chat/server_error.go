@@ -0,0 +1,47 @@
+package chat
+
+import "encoding/json"
+
+const (
+	// The incoming frame could not be parsed as a chat message.
+	ErrCodeMALFORMED = "malformed"
+
+	// The incoming frame exceeded the maximum allowed size.
+	ErrCodeTOO_LARGE = "too_large"
+
+	// The client attempted to send a message type it may not originate.
+	ErrCodeFORBIDDEN_TYPE = "forbidden_type"
+
+	// The server failed to process the message.
+	ErrCodeINTERNAL = "internal"
+
+	// The user isn't a member of the room, or was removed from it.
+	ErrCodeNOT_MEMBER = "not_member"
+
+	// The user is already connected to the room elsewhere.
+	ErrCodeALREADY_JOINED = "already_joined"
+
+	// The message refers to another message that doesn't exist in the room or can't be referred to.
+	ErrCodeBAD_REFERENCE = "bad_reference"
+
+	// The room is a message request, and its sender must wait for it to be accepted before sending more.
+	ErrCodeAWAITING_ACCEPT = "awaiting_accept"
+)
+
+// Represents the content of a server error message sent in response to a rejected frame.
+type ServerError struct {
+	//A machine-readable code describing the error.
+	Code string `json:"code"`
+
+	//A human-readable description of the error.
+	Reason string `json:"reason"`
+}
+
+// Marshals the message to JSON.
+func (e ServerError) JSON() []byte {
+	jsons, err := json.Marshal(e)
+	if err != nil {
+		panic("ServerError::JSON: " + err.Error())
+	}
+	return jsons
+}
@@ -18,6 +18,25 @@ ENUM(
 	EK			//An encryption key sent by a user for the purpose of decrypting a group message.
 	KEX1		//Step 1 of an X3DH KEX operation.
 	KEX2		//Step 2 of an X3DH KEX operation.
+	ACK			//Acknowledges receipt of all messages up to and including a given one.
+	DELIVERED	//A receipt indicating that a message was delivered to a user.
+	READ		//A receipt indicating that a message was read by a user.
+	TYPING_START	//A user started typing in the room; never persisted.
+	TYPING_STOP	//A user stopped typing in the room; never persisted.
+	PRESENCE	//Sets the presence of the user, eg: to mark them as away.
+	EDITED		//A message was edited. Carries the ID of the original message and its new content.
+	DELETED		//A message was deleted. Carries the ID of the original message; also marks tombstones in the history.
+	REACTION	//Toggles an emoji reaction on a message, or announces that one was toggled.
 )
 */
 type Type int8
+
+// Checks whether a client is allowed to send a message of this type.
+func (t Type) IsClientOriginable() bool {
+	switch t {
+	case TypeUNKNOWN, TypeSMSG, TypeSERR, TypeJOINEVENT, TypeQUITEVENT, TypeMEMBERSHIP, TypeEDITED, TypeDELETED:
+		return false
+	default:
+		return true
+	}
+}
@@ -32,11 +32,29 @@ const (
 	TypeKEX1
 	// Step 2 of an X3DH KEX operation.
 	TypeKEX2
+	// Acknowledges receipt of all messages up to and including a given one.
+	TypeACK
+	// A receipt indicating that a message was delivered to a user.
+	TypeDELIVERED
+	// A receipt indicating that a message was read by a user.
+	TypeREAD
+	// A user started typing in the room; never persisted.
+	TypeTYPINGSTART
+	// A user stopped typing in the room; never persisted.
+	TypeTYPINGSTOP
+	// Sets the presence of the user, eg: to mark them as away.
+	TypePRESENCE
+	// A message was edited. Carries the ID of the original message and its new content.
+	TypeEDITED
+	// A message was deleted. Carries the ID of the original message; also marks tombstones in the history.
+	TypeDELETED
+	// Toggles an emoji reaction on a message, or announces that one was toggled.
+	TypeREACTION
 )
 
 var ErrInvalidType = fmt.Errorf("not a valid Type, try [%s]", strings.Join(_TypeNames, ", "))
 
-const _TypeName = "UNKNOWNU_MSGS_MSGS_ERRJOIN_EVENTQUIT_EVENTMEMBERSHIPEKKEX1KEX2"
+const _TypeName = "UNKNOWNU_MSGS_MSGS_ERRJOIN_EVENTQUIT_EVENTMEMBERSHIPEKKEX1KEX2ACKDELIVEREDREADTYPING_STARTTYPING_STOPPRESENCEEDITEDDELETEDREACTION"
 
 var _TypeNames = []string{
 	_TypeName[0:7],
@@ -49,6 +67,15 @@ var _TypeNames = []string{
 	_TypeName[52:54],
 	_TypeName[54:58],
 	_TypeName[58:62],
+	_TypeName[62:65],
+	_TypeName[65:74],
+	_TypeName[74:78],
+	_TypeName[78:90],
+	_TypeName[90:101],
+	_TypeName[101:109],
+	_TypeName[109:115],
+	_TypeName[115:122],
+	_TypeName[122:130],
 }
 
 // TypeNames returns a list of possible string values of Type.
@@ -71,20 +98,38 @@ func TypeValues() []Type {
 		TypeEK,
 		TypeKEX1,
 		TypeKEX2,
+		TypeACK,
+		TypeDELIVERED,
+		TypeREAD,
+		TypeTYPINGSTART,
+		TypeTYPINGSTOP,
+		TypePRESENCE,
+		TypeEDITED,
+		TypeDELETED,
+		TypeREACTION,
 	}
 }
 
 var _TypeMap = map[Type]string{
-	TypeUNKNOWN:    _TypeName[0:7],
-	TypeUMSG:       _TypeName[7:12],
-	TypeSMSG:       _TypeName[12:17],
-	TypeSERR:       _TypeName[17:22],
-	TypeJOINEVENT:  _TypeName[22:32],
-	TypeQUITEVENT:  _TypeName[32:42],
-	TypeMEMBERSHIP: _TypeName[42:52],
-	TypeEK:         _TypeName[52:54],
-	TypeKEX1:       _TypeName[54:58],
-	TypeKEX2:       _TypeName[58:62],
+	TypeUNKNOWN:     _TypeName[0:7],
+	TypeUMSG:        _TypeName[7:12],
+	TypeSMSG:        _TypeName[12:17],
+	TypeSERR:        _TypeName[17:22],
+	TypeJOINEVENT:   _TypeName[22:32],
+	TypeQUITEVENT:   _TypeName[32:42],
+	TypeMEMBERSHIP:  _TypeName[42:52],
+	TypeEK:          _TypeName[52:54],
+	TypeKEX1:        _TypeName[54:58],
+	TypeKEX2:        _TypeName[58:62],
+	TypeACK:         _TypeName[62:65],
+	TypeDELIVERED:   _TypeName[65:74],
+	TypeREAD:        _TypeName[74:78],
+	TypeTYPINGSTART: _TypeName[78:90],
+	TypeTYPINGSTOP:  _TypeName[90:101],
+	TypePRESENCE:    _TypeName[101:109],
+	TypeEDITED:      _TypeName[109:115],
+	TypeDELETED:     _TypeName[115:122],
+	TypeREACTION:    _TypeName[122:130],
 }
 
 // String implements the Stringer interface.
@@ -103,16 +148,25 @@ func (x Type) IsValid() bool {
 }
 
 var _TypeValue = map[string]Type{
-	_TypeName[0:7]:   TypeUNKNOWN,
-	_TypeName[7:12]:  TypeUMSG,
-	_TypeName[12:17]: TypeSMSG,
-	_TypeName[17:22]: TypeSERR,
-	_TypeName[22:32]: TypeJOINEVENT,
-	_TypeName[32:42]: TypeQUITEVENT,
-	_TypeName[42:52]: TypeMEMBERSHIP,
-	_TypeName[52:54]: TypeEK,
-	_TypeName[54:58]: TypeKEX1,
-	_TypeName[58:62]: TypeKEX2,
+	_TypeName[0:7]:     TypeUNKNOWN,
+	_TypeName[7:12]:    TypeUMSG,
+	_TypeName[12:17]:   TypeSMSG,
+	_TypeName[17:22]:   TypeSERR,
+	_TypeName[22:32]:   TypeJOINEVENT,
+	_TypeName[32:42]:   TypeQUITEVENT,
+	_TypeName[42:52]:   TypeMEMBERSHIP,
+	_TypeName[52:54]:   TypeEK,
+	_TypeName[54:58]:   TypeKEX1,
+	_TypeName[58:62]:   TypeKEX2,
+	_TypeName[62:65]:   TypeACK,
+	_TypeName[65:74]:   TypeDELIVERED,
+	_TypeName[74:78]:   TypeREAD,
+	_TypeName[78:90]:   TypeTYPINGSTART,
+	_TypeName[90:101]:  TypeTYPINGSTOP,
+	_TypeName[101:109]: TypePRESENCE,
+	_TypeName[109:115]: TypeEDITED,
+	_TypeName[115:122]: TypeDELETED,
+	_TypeName[122:130]: TypeREACTION,
 }
 
 // ParseType attempts to convert a string to a Type.
@@ -11,7 +11,9 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"wraith.me/message_server/pkg/config"
+	"wraith.me/message_server/pkg/globals"
 	"wraith.me/message_server/pkg/obj"
+	"wraith.me/message_server/pkg/obj/session"
 	"wraith.me/message_server/pkg/obj/token"
 	cr "wraith.me/message_server/pkg/redis"
 	"wraith.me/message_server/pkg/schema/user"
@@ -38,7 +40,7 @@ var (
 	AuthCtxUserKey = obj.CtxKey{S: "ReqUser"}
 
 	//The key of the access token object that's passed via `r.Context`.
-	AuthCtxAccessTokKey = obj.CtxKey{S: "ReqAccessTok"}
+	AuthCtxAccessTokKey = token.CtxKey
 )
 
 // Holds the error messages.
@@ -48,6 +50,7 @@ var (
 	ErrAuthGeneric      = errors.New("authentication error")
 	ErrAuthNotFound     = errors.New("user not found with ID %s")
 	ErrAuthNoOwnership  = errors.New("access token doesn't map to a known refresh token")
+	ErrAuthRevoked      = errors.New("the session this token belongs to was revoked")
 )
 
 type authMiddleware struct {
@@ -60,6 +63,10 @@ type authMiddleware struct {
 
 	//The secrets of the server including ID and encryption key.
 	secrets *config.Env
+
+	//How strictly access tokens are bound to the IP address and user agent they were issued to.
+	ipBinding token.BindingMode
+	uaBinding token.BindingMode
 }
 
 // Returns a new handler for the authentication middleware.
@@ -73,6 +80,15 @@ func NewAuthMiddleware(secrets *config.Env) func(next http.Handler) http.Handler
 		secrets: secrets,
 	}
 
+	//Get the token binding modes; these are off unless configured otherwise
+	if globals.Cfg != nil {
+		var err error
+		mw.ipBinding, mw.uaBinding, err = globals.Cfg.Token.BindingModes()
+		if err != nil {
+			panic(err)
+		}
+	}
+
 	//Return the instance
 	//slices.Sort(mw.allowedScopes)
 	return mw.authMWHandler
@@ -124,7 +140,7 @@ func (amw authMiddleware) authMWHandler(next http.Handler) http.Handler {
 		//Decrypt and validate the authentication token
 		tokObj, err := token.Decrypt(
 			tok,
-			amw.secrets.SK,
+			amw.secrets.Keys,
 			amw.secrets.ID,
 			token.TokenTypeACCESS,
 		)
@@ -151,6 +167,34 @@ func (amw authMiddleware) authMWHandler(next http.Handler) http.Handler {
 			}
 		*/
 
+		//Reject tokens whose session was revoked without going to the database
+		//Failing to check isn't fatal, since the token must still map to one of the user's refresh tokens below
+		sid := session.OfToken(*tokObj)
+		revoked, err := session.IsRevoked(r.Context(), amw.rclient, sid)
+		if err != nil {
+			fmt.Printf("auth: failed to check if session %s was revoked: %s\n", sid, err)
+		}
+		if revoked {
+			util.ErrResponse(
+				http.StatusUnauthorized,
+				fmt.Errorf("auth; %s", ErrAuthRevoked),
+			).Respond(w)
+			return
+		}
+
+		//Ensure the token is used from where it was issued to, if configured to do so
+		warnings, err := tokObj.CheckBinding(r, amw.ipBinding, amw.uaBinding)
+		for _, warning := range warnings {
+			fmt.Printf("auth: access token %s of user %s: %s\n", tokObj.ID, tokObj.Subject, warning)
+		}
+		if err != nil {
+			util.ErrResponse(
+				http.StatusUnauthorized,
+				fmt.Errorf("auth; %s", err),
+			).Respond(w)
+			return
+		}
+
 		//Get the subject of the token
 		tokSubject := tokObj.Subject
 
@@ -195,19 +239,25 @@ func (amw authMiddleware) authMWHandler(next http.Handler) http.Handler {
 		//
 
 		//Ensure the access token claims one of the user's refresh tokens as a parent
-		_, ok := user.Tokens[tokObj.Parent.String()]
+		parent, ok := user.Tokens[tokObj.Parent.String()]
 		if !ok {
 			util.ErrResponse(http.StatusUnauthorized, ErrAuthNoOwnership).Respond(w)
 			return
 		}
 
+		//Tokens issued before families were added to them get the family of their parent, so their session can be told apart
+		if tokObj.Family.IsNil() {
+			tokObj.Family = parent.FamilyOf(tokObj.Parent.String())
+		}
+
 		//TODO: count access token usages via Redis
 
 		//Add headers to the request (auth subject and token scope)
+		//These replace any that the client sent, so they can be trusted
 		//DEPRECATED: use access token obj instead
-		r.Header.Add(AuthHttpHeaderSubject, tokSubject.String())
-		r.Header.Add(AuthAccessTokID, tokObj.ID.String())
-		r.Header.Add(AuthAccessParentTokID, tokObj.Parent.String())
+		r.Header.Set(AuthHttpHeaderSubject, tokSubject.String())
+		r.Header.Set(AuthAccessTokID, tokObj.ID.String())
+		r.Header.Set(AuthAccessParentTokID, tokObj.Parent.String())
 
 		//Add the user and access token to the request context
 		//https://go.dev/blog/context#TOC_3.2.
@@ -15,6 +15,7 @@ ENUM(
 	LOGIN 		//The purpose of the challenge is to perform account login.
 	DELETE 		//The purpose of the challenge is to complete account deletion.
 	CONFIRM 	//The purpose of the challenge is to confirm a claimed identity.
+	ADDDEVICE 	//The purpose of the challenge is to add a device key to an account.
 )
 */
 type CPurpose int8
@@ -22,11 +22,13 @@ const (
 	CPurposeDELETE
 	// The purpose of the challenge is to confirm a claimed identity.
 	CPurposeCONFIRM
+	// The purpose of the challenge is to add a device key to an account.
+	CPurposeADDDEVICE
 )
 
 var ErrInvalidCPurpose = fmt.Errorf("not a valid CPurpose, try [%s]", strings.Join(_CPurposeNames, ", "))
 
-const _CPurposeName = "UNKNOWNREGISTERLOGINDELETECONFIRM"
+const _CPurposeName = "UNKNOWNREGISTERLOGINDELETECONFIRMADDDEVICE"
 
 var _CPurposeNames = []string{
 	_CPurposeName[0:7],
@@ -34,6 +36,7 @@ var _CPurposeNames = []string{
 	_CPurposeName[15:20],
 	_CPurposeName[20:26],
 	_CPurposeName[26:33],
+	_CPurposeName[33:42],
 }
 
 // CPurposeNames returns a list of possible string values of CPurpose.
@@ -51,15 +54,17 @@ func CPurposeValues() []CPurpose {
 		CPurposeLOGIN,
 		CPurposeDELETE,
 		CPurposeCONFIRM,
+		CPurposeADDDEVICE,
 	}
 }
 
 var _CPurposeMap = map[CPurpose]string{
-	CPurposeUNKNOWN:  _CPurposeName[0:7],
-	CPurposeREGISTER: _CPurposeName[7:15],
-	CPurposeLOGIN:    _CPurposeName[15:20],
-	CPurposeDELETE:   _CPurposeName[20:26],
-	CPurposeCONFIRM:  _CPurposeName[26:33],
+	CPurposeUNKNOWN:   _CPurposeName[0:7],
+	CPurposeREGISTER:  _CPurposeName[7:15],
+	CPurposeLOGIN:     _CPurposeName[15:20],
+	CPurposeDELETE:    _CPurposeName[20:26],
+	CPurposeCONFIRM:   _CPurposeName[26:33],
+	CPurposeADDDEVICE: _CPurposeName[33:42],
 }
 
 // String implements the Stringer interface.
@@ -83,6 +88,7 @@ var _CPurposeValue = map[string]CPurpose{
 	_CPurposeName[15:20]: CPurposeLOGIN,
 	_CPurposeName[20:26]: CPurposeDELETE,
 	_CPurposeName[26:33]: CPurposeCONFIRM,
+	_CPurposeName[33:42]: CPurposeADDDEVICE,
 }
 
 // ParseCPurpose attempts to convert a string to a CPurpose.
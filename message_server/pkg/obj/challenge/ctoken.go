@@ -7,6 +7,7 @@ import (
 
 	"aidanwoods.dev/go-paseto"
 	ccrypto "wraith.me/message_server/pkg/crypto"
+	"wraith.me/message_server/pkg/obj/token"
 	"wraith.me/message_server/pkg/util"
 )
 
@@ -82,13 +83,13 @@ func NewPKChallenge(issuer util.UUID, subjectID util.UUID, purpose CPurpose, exp
 
 // -- Methods
 
-// Decodes an encrypted v4 PASETO token into a challenge payload.
-func Decrypt(token string, key ccrypto.Privkey, issuer util.UUID, purpose CPurpose) (*CToken, error) {
-	return decryptBackend(token, key, issuer, purpose)
+// Decodes an encrypted v4 PASETO token into a challenge payload, using the key from the keyring that its footer names.
+func Decrypt(token string, keys ccrypto.Keyring, issuer util.UUID, purpose CPurpose) (*CToken, error) {
+	return decryptBackend(token, keys, issuer, purpose)
 }
 
 // Decodes an encrypted v4 PASETO token into a challenge payload, with stricter checks.
-func DecryptPKStrict(token string, key ccrypto.Privkey, issuer util.UUID, purpose CPurpose, subject util.UUID, pubkey ccrypto.Pubkey) (*CToken, error) {
+func DecryptPKStrict(token string, keys ccrypto.Keyring, issuer util.UUID, purpose CPurpose, subject util.UUID, pubkey ccrypto.Pubkey) (*CToken, error) {
 	//Create a list of additional rules
 	rules := []paseto.Rule{
 		paseto.Subject(subject.String()), //Token subject and input subject must match
@@ -96,23 +97,24 @@ func DecryptPKStrict(token string, key ccrypto.Privkey, issuer util.UUID, purpos
 	}
 
 	//Decrypt the token and add the extra rules
-	return decryptBackend(token, key, issuer, purpose, rules...)
+	return decryptBackend(token, keys, issuer, purpose, rules...)
 }
 
 /*
 Encodes a challenge payload into an encrypted v4 PASETO token. The expiry
 of the token is hard-coded as 10 minutes.
 */
-func (t CToken) Encrypt(key ccrypto.Privkey) string {
+func (t CToken) Encrypt(key ccrypto.RingKey) string {
 	return t.EncryptWithExpiry(key, time.Now().Add(10*time.Minute))
 }
 
 /*
 Encodes a challenge payload into an encrypted v4 PASETO token with a user
-provided expiry.
+provided expiry. The ID of the key is added to the footer.
 */
-func (t CToken) EncryptWithExpiry(key ccrypto.Privkey, exp time.Time) string {
+func (t CToken) EncryptWithExpiry(key ccrypto.RingKey, exp time.Time) string {
 	//Create a new token with expiration in x time
+	footer := token.NewFooter(key, nil)
 	token := paseto.NewToken()
 	token.SetIssuedAt(time.Now())  //Token "iat"
 	token.SetNotBefore(time.Now()) //Token "nbf"
@@ -125,15 +127,22 @@ func (t CToken) EncryptWithExpiry(key ccrypto.Privkey, exp time.Time) string {
 	token.SetString(_CHALL_CTYPE, t.CType.String())      //Challenge type
 	token.SetString(_CHALL_CPURPOSE, t.Purpose.String()) //Challenge purpose
 	token.SetString(_CHALL_CLAIM, t.Claim)               //Challenge claim
+	token.SetFooter(footer.Bytes())                      //Key ID
 
 	//Encrypt the token
-	return token.V4Encrypt(util.Edsk2PasetoSK(key), nil)
+	return token.V4Encrypt(util.Edsk2PasetoSK(key.Key), nil)
 }
 
 //-- Private utilities
 
 // Performs token decryption and ensures it matches against a rule-set.
-func decryptBackend(token string, key ccrypto.Privkey, issuer util.UUID, purpose CPurpose, rules ...paseto.Rule) (*CToken, error) {
+func decryptBackend(tok string, keys ccrypto.Keyring, issuer util.UUID, purpose CPurpose, rules ...paseto.Rule) (*CToken, error) {
+	//Get the key the token was encrypted with
+	key, err := token.KeyFor(tok, keys)
+	if err != nil {
+		return nil, err
+	}
+
 	//Create a new token parser and add basic rules
 	parser := paseto.NewParser()
 	parser.AddRule(
@@ -146,7 +155,7 @@ func decryptBackend(token string, key ccrypto.Privkey, issuer util.UUID, purpose
 	parser.AddRule(rules...)
 
 	//Decrypt the token and validate it; due to the "v4_local" construction, any tamper attempts will auto-fail this check
-	decrypted, err := parser.ParseV4Local(util.Edsk2PasetoSK(key), token, nil)
+	decrypted, err := parser.ParseV4Local(util.Edsk2PasetoSK(key.Key), tok, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1 +1,53 @@
 package notification
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"wraith.me/message_server/pkg/amqp"
+)
+
+const (
+	// The consumer group that stores the notifications published to the event bus.
+	storeGroup = "notification_store"
+
+	// How long to wait for a notification to be written to the database.
+	storeTimeout = 5 * time.Second
+)
+
+/*
+Hands a notification off to the event bus. Delivering it to the recipient is
+left to whichever consumers are listening on the notification exchange.
+*/
+func Dispatch(ctx context.Context, notif Notification) error {
+	return amqp.GetBus().Publish(ctx, amqp.ExchangeNOTIFICATION, amqp.KeyNOTIFICATION_CREATED, notif)
+}
+
+/*
+Stores the notifications that are published to the event bus, calling
+`onStored` with each one once it's saved; this is where live delivery to the
+recipient should happen. Notifications that expired in transit are dropped.
+*/
+func Subscribe(bus *amqp.Bus, onStored func(notif Notification)) error {
+	return bus.Subscribe(storeGroup, amqp.ExchangeNOTIFICATION, []string{amqp.KeyNOTIFICATION_CREATED},
+		amqp.Typed(func(ev amqp.Event, notif Notification) error {
+			if notif.ID.IsNil() || notif.Recipient.IsNil() {
+				return fmt.Errorf("notification %s is missing its ID or recipient", ev.ID)
+			}
+			if time.Now().After(notif.Expires) {
+				return nil
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+			defer cancel()
+			if err := GetCollection().Store(ctx, notif); err != nil {
+				return err
+			}
+			if onStored != nil {
+				onStored(notif)
+			}
+			return nil
+		}),
+	)
+}
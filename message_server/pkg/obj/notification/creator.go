@@ -36,6 +36,37 @@ func FRQRejectNotif(respondent user.User, recipient util.UUID) Notification {
 	return frqResponderBackend(respondent, recipient, false)
 }
 
+// Constructs a new friend request withdrawal notification.
+func FRQCancelNotif(sender user.User, recipient util.UUID) Notification {
+	id := util.MustNewUUID7()
+	content := fmt.Sprintf("%s <ID: %s> has withdrawn their friend request",
+		sender.Username, sender.ID.String(),
+	)
+	return newNotifBackend(id, recipient, content, TypeFRQCANCEL, sender.ID.String())
+}
+
+// Constructs a new message request notification.
+func MsgRequestNotif(sender user.User, recipient util.UUID, room chatroom.Room) Notification {
+	id := util.MustNewUUID7()
+	content := fmt.Sprintf("%s <ID: %s> would like to send you a message",
+		sender.Username, sender.ID.String(),
+	)
+	return newNotifBackend(id, recipient, content, TypeMSGREQUEST, room.ID.String())
+}
+
+/*
+Constructs a new notification telling a user that one of their sessions was
+logged out because its refresh token was used again after it was replaced,
+which means that someone else got hold of it.
+*/
+func SessionRevokedNotif(recipient util.UUID, family util.UUID, ip string) Notification {
+	id := util.MustNewUUID7()
+	content := fmt.Sprintf("a stale login token for your account was used from %s; the session it belonged to was logged out, so log in again and review your sessions",
+		ip,
+	)
+	return newNotifBackend(id, recipient, content, TypeSESSIONREVOKED, family.String())
+}
+
 // Handles creating response friend requests.
 func frqResponderBackend(respondent user.User, recipient util.UUID, accepted bool) Notification {
 	id := util.MustNewUUID7()
@@ -4,39 +4,39 @@ import (
 	"time"
 
 	"wraith.me/message_server/pkg/util"
+	"wraith.me/message_server/pkg/util/timex"
 )
 
 var (
 	//The default TTL of a notification (default: 1 week).
-	DefaultNotifTTL = "1w"
+	DefaultNotifTTL = timex.Week
 )
 
 // Represents a single notification message that is sent to a user when certain actions occur.
 type Notification struct {
 	//The ID of this notification.
-	ID util.UUID `json:"id"`
+	ID util.UUID `json:"id" bson:"_id"`
 
 	//The ID of the user to whom this notification belongs.
-	Recipient util.UUID `json:"recipient"`
+	Recipient util.UUID `json:"recipient" bson:"recipient_id"`
 
 	//The body of the notification.
-	Content string `json:"content"`
+	Content string `json:"content" bson:"content"`
 
 	//The type of notification this is.
-	Type Type `json:"type"`
+	Type Type `json:"type" bson:"type"`
 
 	//Extra information needed for the notification to function depending on the `Type`.
-	Context string `json:"context"`
+	Context string `json:"context" bson:"context"`
 
 	//Whether the notification was read by the user.
-	Read bool `json:"read"`
+	Read bool `json:"read" bson:"read"`
 
 	//The time at which the notification will expire and be auto-purged.
-	Expires time.Time `json:"expires"`
+	Expires time.Time `json:"expires" bson:"expires"`
 }
 
 // Gets an expiration time from the current time.
 func resolveExpiryTime(now time.Time) time.Time {
-	delta, _ := time.ParseDuration(DefaultNotifTTL)
-	return now.Add(delta)
+	return now.Add(DefaultNotifTTL.ToDur())
 }
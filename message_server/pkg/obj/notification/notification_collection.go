@@ -0,0 +1,121 @@
+package notification
+
+import (
+	"context"
+	"sync"
+
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	moptions "go.mongodb.org/mongo-driver/mongo/options"
+	"wraith.me/message_server/pkg/db"
+	"wraith.me/message_server/pkg/util"
+)
+
+var (
+	// Holds the shared instance of this collection.
+	notifCollectionInst *NotificationCollection
+
+	// Guard mutex to ensure that only one singleton object is created.
+	notifCollectionOnce sync.Once
+)
+
+/*
+Represents a single `Notification` object in a collection of objects in the
+database. This collection is managed by the `qmgo` Mongo ODM library.
+*/
+type NotificationCollection struct {
+	*db.QMgoBase
+}
+
+// This line enforces NotificationCollection to implement db.QMgoCollection.
+var _ db.QMgoCollection = (*NotificationCollection)(nil)
+
+func (nc NotificationCollection) ParentDB() string {
+	return db.ROOT_DB
+}
+
+func (nc NotificationCollection) CollectionName() string {
+	return db.NOTIFS_COLLECTION
+}
+
+/*
+Creates the indexes used by the collection. Notifications are purged by Mongo
+once they pass their expiry time, and are always looked up per recipient.
+*/
+func (nc NotificationCollection) SetupIndexes(ctx context.Context) error {
+	return nc.CreateIndexes(ctx, []options.IndexModel{
+		{Key: []string{"expires"}, IndexOptions: moptions.Index().SetExpireAfterSeconds(0)},
+		{Key: []string{"recipient_id", "read", "_id"}},
+	})
+}
+
+/*
+Stores a notification. Storing one that already exists is a no-op, since the
+event bus may deliver the same notification more than once.
+*/
+func (nc NotificationCollection) Store(ctx context.Context, notif Notification) error {
+	_, err := nc.InsertOne(ctx, notif)
+	if qmgo.IsDup(err) {
+		return nil
+	}
+	return err
+}
+
+// Gets the number of unread notifications a user has.
+func (nc NotificationCollection) CountUnread(ctx context.Context, uid util.UUID) (int64, error) {
+	return nc.Find(ctx, bson.D{
+		{Key: "recipient_id", Value: uid},
+		{Key: "read", Value: false},
+	}).Count()
+}
+
+/*
+Marks notifications of a user as read or unread, returning the number of
+notifications that matched. Every notification of the user is marked if no
+IDs are given.
+*/
+func (nc NotificationCollection) SetRead(ctx context.Context, uid util.UUID, ids []util.UUID, read bool) (int64, error) {
+	res, err := nc.UpdateAll(ctx, ownedBy(uid, ids), bson.D{
+		{Key: "$set", Value: bson.D{{Key: "read", Value: read}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return res.MatchedCount, nil
+}
+
+/*
+Deletes notifications of a user, returning the number that were deleted.
+Every notification of the user is deleted if no IDs are given.
+*/
+func (nc NotificationCollection) Remove(ctx context.Context, uid util.UUID, ids []util.UUID) (int64, error) {
+	res, err := nc.RemoveAll(ctx, ownedBy(uid, ids))
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+// Builds a filter that matches the given notifications of a user, or all of them if no IDs are given.
+func ownedBy(uid util.UUID, ids []util.UUID) bson.D {
+	filter := bson.D{{Key: "recipient_id", Value: uid}}
+	if len(ids) > 0 {
+		filter = append(filter, bson.E{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}})
+	}
+	return filter
+}
+
+/*
+Gets the currently active collection object instance or initializes it.
+This can be safely called multiple times in the program to ensure a
+non-nil instance of the collection due to the usage of `sync.Once` to
+initialize the singleton.
+*/
+func GetCollection() *NotificationCollection {
+	notifCollectionOnce.Do(func() {
+		c := db.GetCollectionManager().GetCollection(NotificationCollection{})
+		notifCollectionInst = &NotificationCollection{c}
+	})
+	return notifCollectionInst
+}
@@ -13,6 +13,9 @@ ENUM(
 	FRQ_ACCEPT	//A notification fired off when a friend request was accepted.
 	FRQ_REJECT	//A notification fired off when a friend request was rejected.
 	FRQ_NEW		//A notification fired off when a friend request has been received.
+	FRQ_CANCEL	//A notification fired off when a received friend request was withdrawn.
+	MSG_REQUEST	//A notification fired off when a message request has been received.
+	SESSION_REVOKED	//A notification fired off when a user's session was revoked because its refresh token was reused.
 )
 */
 type Type int8
@@ -22,11 +22,17 @@ const (
 	TypeFRQREJECT
 	// A notification fired off when a friend request has been received.
 	TypeFRQNEW
+	// A notification fired off when a received friend request was withdrawn.
+	TypeFRQCANCEL
+	// A notification fired off when a message request has been received.
+	TypeMSGREQUEST
+	// A notification fired off when a user's session was revoked because its refresh token was reused.
+	TypeSESSIONREVOKED
 )
 
 var ErrInvalidType = fmt.Errorf("not a valid Type, try [%s]", strings.Join(_TypeNames, ", "))
 
-const _TypeName = "UNKNOWNNEW_MSGFRQ_ACCEPTFRQ_REJECTFRQ_NEW"
+const _TypeName = "UNKNOWNNEW_MSGFRQ_ACCEPTFRQ_REJECTFRQ_NEWFRQ_CANCELMSG_REQUESTSESSION_REVOKED"
 
 var _TypeNames = []string{
 	_TypeName[0:7],
@@ -34,6 +40,9 @@ var _TypeNames = []string{
 	_TypeName[14:24],
 	_TypeName[24:34],
 	_TypeName[34:41],
+	_TypeName[41:51],
+	_TypeName[51:62],
+	_TypeName[62:77],
 }
 
 // TypeNames returns a list of possible string values of Type.
@@ -51,15 +60,21 @@ func TypeValues() []Type {
 		TypeFRQACCEPT,
 		TypeFRQREJECT,
 		TypeFRQNEW,
+		TypeFRQCANCEL,
+		TypeMSGREQUEST,
+		TypeSESSIONREVOKED,
 	}
 }
 
 var _TypeMap = map[Type]string{
-	TypeUNKNOWN:   _TypeName[0:7],
-	TypeNEWMSG:    _TypeName[7:14],
-	TypeFRQACCEPT: _TypeName[14:24],
-	TypeFRQREJECT: _TypeName[24:34],
-	TypeFRQNEW:    _TypeName[34:41],
+	TypeUNKNOWN:        _TypeName[0:7],
+	TypeNEWMSG:         _TypeName[7:14],
+	TypeFRQACCEPT:      _TypeName[14:24],
+	TypeFRQREJECT:      _TypeName[24:34],
+	TypeFRQNEW:         _TypeName[34:41],
+	TypeFRQCANCEL:      _TypeName[41:51],
+	TypeMSGREQUEST:     _TypeName[51:62],
+	TypeSESSIONREVOKED: _TypeName[62:77],
 }
 
 // String implements the Stringer interface.
@@ -83,6 +98,9 @@ var _TypeValue = map[string]Type{
 	_TypeName[14:24]: TypeFRQACCEPT,
 	_TypeName[24:34]: TypeFRQREJECT,
 	_TypeName[34:41]: TypeFRQNEW,
+	_TypeName[41:51]: TypeFRQCANCEL,
+	_TypeName[51:62]: TypeMSGREQUEST,
+	_TypeName[62:77]: TypeSESSIONREVOKED,
 }
 
 // ParseType attempts to convert a string to a Type.
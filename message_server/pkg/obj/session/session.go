@@ -0,0 +1,93 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"wraith.me/message_server/pkg/obj/token"
+	"wraith.me/message_server/pkg/util"
+)
+
+const (
+	//The pub/sub channel that announces revoked sessions, so servers can close the connections tied to them.
+	RevokedChannel = "auth:revoked"
+
+	//The prefix of the keys that mark sessions as revoked, keyed by their token family.
+	revokedKeyPrefix = "auth:revoked:"
+)
+
+/*
+Revokes sessions by their token families. Sessions are identified by family
+rather than by their current refresh token, since the latter changes every
+time the session is refreshed, while the access tokens and connections that
+were opened earlier keep the old one. The access tokens of the sessions are
+rejected by the auth middleware from then on, and every server is told to
+close the connections opened with them. The marks only need to outlast the
+access tokens, so `ttl` should be their lifetime. Removing the refresh tokens
+from the user is up to the caller.
+*/
+func Revoke(ctx context.Context, rclient *redis.Client, ttl time.Duration, families ...util.UUID) error {
+	if len(families) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(families)
+	if err != nil {
+		return err
+	}
+
+	_, err = rclient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, family := range families {
+			pipe.Set(ctx, revokedKeyPrefix+family.String(), 1, ttl)
+		}
+		pipe.Publish(ctx, RevokedChannel, payload)
+		return nil
+	})
+	return err
+}
+
+// Checks if a session was revoked by its token family.
+func IsRevoked(ctx context.Context, rclient *redis.Client, family util.UUID) (bool, error) {
+	n, err := rclient.Exists(ctx, revokedKeyPrefix+family.String()).Result()
+	return n > 0, err
+}
+
+// Parses an announcement of revoked sessions into the set of their token families.
+func ParseRevoked(payload string) (map[util.UUID]bool, error) {
+	var families []util.UUID
+	if err := json.Unmarshal([]byte(payload), &families); err != nil {
+		return nil, err
+	}
+	set := make(map[util.UUID]bool, len(families))
+	for _, family := range families {
+		set[family] = true
+	}
+	return set, nil
+}
+
+/*
+Gets the session that an access token belongs to, which is its token family.
+Tokens issued before families were added to them fall back to the refresh
+token they were issued under.
+*/
+func OfToken(tok token.Token) util.UUID {
+	if tok.Family.IsNil() {
+		return tok.Parent
+	}
+	return tok.Family
+}
+
+/*
+Gets the session that a request was authenticated with. See `OfToken()`. The
+second return value is false if the request didn't pass through the auth
+middleware.
+*/
+func Of(r *http.Request) (util.UUID, bool) {
+	tok, ok := r.Context().Value(token.CtxKey).(token.Token)
+	if !ok {
+		return util.NilUUID(), false
+	}
+	return OfToken(tok), true
+}
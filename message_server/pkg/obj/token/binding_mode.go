@@ -0,0 +1,16 @@
+//go:generate go-enum --marshal --forceupper --mustparse --nocomments --names --values
+package token
+
+//
+//-- ENUM: BindingMode
+//
+
+// Controls what happens when a token is used from somewhere other than where it was issued to.
+/*
+ENUM(
+	OFF		//Tokens may be used from anywhere.
+	WARN	//Tokens may be used from anywhere, but mismatches are logged.
+	ENFORCE	//Tokens are rejected if they're used from anywhere else.
+)
+*/
+type BindingMode int8
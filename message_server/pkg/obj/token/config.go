@@ -1,6 +1,11 @@
 package token
 
-import "github.com/creasty/defaults"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/creasty/defaults"
+)
 
 //TODO: add function using Redis to count refreshes
 
@@ -24,6 +29,12 @@ type TConfig struct {
 	//TODO: Add /api/auth for production
 	//The path at which refresh token cookies are valid.
 	RefreshCookiePath string `toml:"refresh_cookie_path" env:"TOK_REFRESH_COOKIE_PATH" default:"/api"`
+
+	//Whether access tokens must be used from the IP address they were issued to: `off`, `warn` or `enforce`. Default: off.
+	IPBinding string `toml:"ip_binding" env:"TOK_IP_BINDING" default:"off"`
+
+	//Whether access tokens must be used from the user agent they were issued to: `off`, `warn` or `enforce`. Default: off.
+	UABinding string `toml:"ua_binding" env:"TOK_UA_BINDING" default:"off"`
 }
 
 func DefaultTConfig() *TConfig {
@@ -33,3 +44,14 @@ func DefaultTConfig() *TConfig {
 	}
 	return obj
 }
+
+// Gets the modes with which access tokens are bound to the IP address and user agent they were issued to.
+func (c TConfig) BindingModes() (ip BindingMode, ua BindingMode, err error) {
+	if ip, err = ParseBindingMode(strings.ToUpper(c.IPBinding)); err != nil {
+		return ip, ua, fmt.Errorf("bad IP binding mode: %w", err)
+	}
+	if ua, err = ParseBindingMode(strings.ToUpper(c.UABinding)); err != nil {
+		return ip, ua, fmt.Errorf("bad user agent binding mode: %w", err)
+	}
+	return ip, ua, nil
+}
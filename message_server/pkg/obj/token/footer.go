@@ -0,0 +1,69 @@
+package token
+
+import (
+	"encoding/json"
+	"time"
+
+	"aidanwoods.dev/go-paseto"
+	ccrypto "wraith.me/message_server/pkg/crypto"
+)
+
+//
+//-- CLASS: Footer
+//
+
+/*
+Represents the footer of a PASETO token issued by the server. The footer isn't
+encrypted, but it is authenticated along with the rest of the token, so it
+can't be tampered with. It holds the ID of the key the token was encrypted
+with, and optionally its expiry so clients can read it.
+*/
+type Footer struct {
+	//The ID of the key on the server's keyring that encrypted the token.
+	KeyID string `json:"kid"`
+
+	//The time at which the token expires, if it was added to the footer.
+	Expiry string `json:"exp,omitempty"`
+}
+
+// Creates a new footer for a token encrypted with a given key.
+func NewFooter(key ccrypto.RingKey, exp *time.Time) Footer {
+	footer := Footer{KeyID: key.ID()}
+	if exp != nil {
+		footer.Expiry = exp.UTC().Format(TimeFmt)
+	}
+	return footer
+}
+
+/*
+Parses the footer of a PASETO token without decrypting it. Tokens issued before
+key IDs were added either have no footer or only the expiry in it. These were
+all encrypted with the server's original key, so they're given the legacy key
+ID.
+*/
+func ParseFooter(tok string) Footer {
+	footer := Footer{}
+	raw, err := paseto.NewParser().UnsafeParseFooter(paseto.V4Local, tok)
+	if err == nil && json.Unmarshal(raw, &footer) == nil && footer.KeyID != "" {
+		return footer
+	}
+	footer = Footer{KeyID: ccrypto.LegacyKeyID}
+	if _, err := time.Parse(TimeFmt, string(raw)); err == nil {
+		footer.Expiry = string(raw)
+	}
+	return footer
+}
+
+/*
+Gets the key that a PASETO token was encrypted with from a keyring, using the
+key ID in its footer. Retired keys are rejected.
+*/
+func KeyFor(tok string, keys ccrypto.Keyring) (ccrypto.RingKey, error) {
+	return keys.Get(ParseFooter(tok).KeyID, time.Now())
+}
+
+// Marshals the footer to the bytes that are added to a token.
+func (f Footer) Bytes() []byte {
+	b, _ := json.Marshal(f)
+	return b
+}
@@ -2,16 +2,16 @@ package token
 
 import (
 	"crypto/subtle"
-	"encoding/base64"
 	"fmt"
 	"math"
 	"net"
 	"net/http"
-	"strings"
 	"time"
 
 	"aidanwoods.dev/go-paseto"
 	ccrypto "wraith.me/message_server/pkg/crypto"
+	"wraith.me/message_server/pkg/obj"
+	"wraith.me/message_server/pkg/obj/ip_addr"
 	"wraith.me/message_server/pkg/util"
 	"wraith.me/message_server/pkg/util/try"
 )
@@ -21,6 +21,7 @@ const (
 	_TOK_IP   = "tipaddr"
 	_TOK_UA   = "tuagent"
 	_TOK_PAR  = "tparent"
+	_TOK_FAM  = "tfamily"
 )
 
 var (
@@ -41,9 +42,10 @@ var (
 
 	//The format of the times in the token.
 	TimeFmt = time.RFC3339
-)
 
-//TODO: add parent field for access tokens and validate that an access token actually pairs to a refresh token
+	//The key of the access token object that's passed via `r.Context` once a request is authenticated.
+	CtxKey = obj.CtxKey{S: "ReqAccessTok"}
+)
 
 //
 //-- CLASS: Token
@@ -58,9 +60,12 @@ type Token struct {
 	//The ID of the token. This is the `jti` field of the PASETO token. This is calculated from the `iat` field.
 	ID util.UUID `json:"id"`
 
-	//The ID of the parent token. This will be a nil UUID if this is a refresh token.
+	//The ID of the parent token. For access tokens, this is the refresh token they were issued with. For refresh tokens, this is the token they replaced, or a nil UUID if they were issued at login.
 	Parent util.UUID `json:"parent"`
 
+	//The ID of the refresh token that was issued at login, which every token of the session descends from. This stays the same when the refresh token is rotated, so it identifies the session. A nil UUID for tokens issued before it was added.
+	Family util.UUID `json:"family"`
+
 	//The ID of the entity that issued the token. This is the `iss` field of the PASETO token.
 	Issuer util.UUID `json:"issuer"`
 
@@ -121,7 +126,7 @@ func NewToken(subject util.UUID, issuer util.UUID, typ TokenType, exp time.Time,
 //-- Methods
 
 // Creates an HTTP cookie string to hold the PASETO token.
-func (t Token) Cookie(key ccrypto.Privkey, path, domain string, persistent bool) http.Cookie {
+func (t Token) Cookie(key ccrypto.RingKey, path, domain string, persistent bool) http.Cookie {
 	_, cookie := t.CryptAndCookie(key, path, domain, persistent)
 	return cookie
 }
@@ -130,7 +135,7 @@ func (t Token) Cookie(key ccrypto.Privkey, path, domain string, persistent bool)
 Encrypts the PASETO token and creates an HTTP cookie string to hold the
 PASETO token, all in one step.
 */
-func (t Token) CryptAndCookie(key ccrypto.Privkey, path, domain string, persistent bool) (token string, cookie http.Cookie) {
+func (t Token) CryptAndCookie(key ccrypto.RingKey, path, domain string, persistent bool) (token string, cookie http.Cookie) {
 	//Encrypt the token
 	token = t.Encrypt(key, ExprInFooter)
 
@@ -157,8 +162,17 @@ func (t Token) CryptAndCookie(key ccrypto.Privkey, path, domain string, persiste
 	return
 }
 
-// Decrypts a PASETO string using a given symmetric key, which creates a Token object.
-func Decrypt(token string, key ccrypto.Privkey, issuer util.UUID, typ TokenType) (*Token, error) {
+/*
+Decrypts a PASETO string using the key from the keyring that its footer names,
+which creates a Token object.
+*/
+func Decrypt(token string, keys ccrypto.Keyring, issuer util.UUID, typ TokenType) (*Token, error) {
+	//Get the key the token was encrypted with
+	key, err := KeyFor(token, keys)
+	if err != nil {
+		return nil, err
+	}
+
 	//Create a new token parser and add basic rules
 	parser := paseto.NewParser()
 	parser.AddRule(
@@ -168,7 +182,7 @@ func Decrypt(token string, key ccrypto.Privkey, issuer util.UUID, typ TokenType)
 	)
 
 	//Decrypt the token and validate it; due to the "v4_local" construction, any tamper attempts will auto-fail this check
-	decrypted, err := parser.ParseV4Local(util.Edsk2PasetoSK(key), token, nil)
+	decrypted, err := parser.ParseV4Local(util.Edsk2PasetoSK(key.Key), token, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -179,10 +193,10 @@ func Decrypt(token string, key ccrypto.Privkey, issuer util.UUID, typ TokenType)
 
 /*
 Encrypts this token using a given symmetric key, which creates a PASETO
-token string. Optionally, the token can include the expiration in the
-footer.
+token string. The ID of the key is added to the footer, and optionally, the
+token can include the expiration in the footer too.
 */
-func (t Token) Encrypt(key ccrypto.Privkey, expInFooter bool) string {
+func (t Token) Encrypt(key ccrypto.RingKey, expInFooter bool) string {
 	//Create a new token with expiration in x time
 	token := paseto.NewToken()
 	token.SetIssuedAt(t.Issued)   //Token "iat"
@@ -192,19 +206,22 @@ func (t Token) Encrypt(key ccrypto.Privkey, expInFooter bool) string {
 	//Add additional data to the token
 	token.SetJti(t.ID.String())                  //Token ID
 	token.SetString(_TOK_PAR, t.Parent.String()) //Parent ID
+	token.SetString(_TOK_FAM, t.Family.String()) //Family ID
 	token.SetIssuer(t.Issuer.String())           //Issuer ID (server)
 	token.SetSubject(t.Subject.String())         //User ID (client)
 	token.SetString(_TOK_TYPE, t.Type.String())  //Token type
 	token.SetString(_TOK_IP, t.IPAddr.String())  //Subject IP
 	token.SetString(_TOK_UA, t.UserAgent)        //Subject UA
 
-	//Check if the expiration footer should be added
+	//Add the key ID to the footer, and the expiration too if it should be added
+	var exp *time.Time
 	if expInFooter {
-		token.SetFooter([]byte(t.Expiry.UTC().Format(TimeFmt)))
+		exp = &t.Expiry
 	}
+	token.SetFooter(NewFooter(key, exp).Bytes())
 
 	//Encrypt the token
-	return token.V4Encrypt(util.Edsk2PasetoSK(key), nil)
+	return token.V4Encrypt(util.Edsk2PasetoSK(key.Key), nil)
 }
 
 // Creates an HTTP cookie string to hold the expiration of this token.
@@ -241,34 +258,49 @@ func (t Token) MaxAge(persistent bool) int {
 	return timeDelta
 }
 
+/*
+Checks that the token is being used from where it was issued to, according to
+the given binding modes for its IP address and user agent. Mismatches under
+`WARN` are returned as warnings, while one under `ENFORCE` is returned as an
+error.
+*/
+func (t Token) CheckBinding(r *http.Request, ipMode BindingMode, uaMode BindingMode) (warnings []error, err error) {
+	type mismatch struct {
+		mode BindingMode
+		err  error
+	}
+	mismatches := make([]mismatch, 0, 2)
+	if ipMode != BindingModeOFF {
+		if ip := ip_addr.HttpIP2NetIP(r.RemoteAddr); !t.IPAddr.Equal(ip) {
+			mismatches = append(mismatches, mismatch{ipMode, fmt.Errorf("token was issued to IP address %s, but was used from %s", t.IPAddr, ip)})
+		}
+	}
+	if uaMode != BindingModeOFF && t.UserAgent != r.UserAgent() {
+		mismatches = append(mismatches, mismatch{uaMode, fmt.Errorf("token was issued to user agent '%s', but was used from '%s'", t.UserAgent, r.UserAgent())})
+	}
+
+	for _, m := range mismatches {
+		if m.mode == BindingModeENFORCE {
+			err = m.err
+		} else {
+			warnings = append(warnings, m.err)
+		}
+	}
+	return warnings, err
+}
+
 //-- Public utilities
 
 // Gets the expiration from a token that has it in the footer
 func GetExprFromFooter(tok string) (time.Time, error) {
-	//Split the token at every period and get the last piece
-	pieces := strings.Split(tok, ".")
-	exprB64 := pieces[len(pieces)-1]
-
-	/*
-		Ensure this token has a timestamp; expected size of the split pieces is 4:
-		- v4
-		- local
-		- <token>
-		- <footer>
-	*/
-	const expectedSize = 4
-	if len(pieces) != expectedSize {
-		return time.Time{}, fmt.Errorf("token doesn't have a valid footer; got size %d expected %d", len(pieces), expectedSize)
-	}
-
-	//Decode the expiration from base64
-	exprBytes, err := base64.RawURLEncoding.DecodeString(exprB64)
-	if err != nil {
-		return time.Time{}, err
+	//Get the expiration from the footer
+	footer := ParseFooter(tok)
+	if footer.Expiry == "" {
+		return time.Time{}, fmt.Errorf("token doesn't have an expiration in its footer")
 	}
 
 	//Parse the timestamp to a `time.Time`
-	return time.Parse(TimeFmt, string(exprBytes))
+	return time.Parse(TimeFmt, footer.Expiry)
 }
 
 //-- Private utilities
@@ -278,6 +310,7 @@ func pasetoDecode(tok *paseto.Token, issuer util.UUID) (*Token, error) {
 	//Get the fields of the token
 	var id string
 	var parent string
+	var family string
 	//var issuer string
 	var subject string
 	var expiry time.Time
@@ -302,10 +335,17 @@ func pasetoDecode(tok *paseto.Token, issuer util.UUID) (*Token, error) {
 		return nil, perr
 	}
 
+	//Tokens issued before families were added don't have one
+	family, ferr := tok.GetString(_TOK_FAM)
+	if ferr != nil {
+		family = util.NilUUID().String()
+	}
+
 	//Create a new struct and return it
 	return &Token{
 		ID:        util.UUIDFromString(id),
 		Parent:    util.UUIDFromString(parent),
+		Family:    util.UUIDFromString(family),
 		Issuer:    issuer,
 		Subject:   util.UUIDFromString(subject),
 		Expiry:    expiry.UTC(),
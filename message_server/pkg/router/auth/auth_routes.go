@@ -45,6 +45,9 @@ func AuthRoutes() chi.Router {
 		r.Use(mw.NewAuthMiddleware(env))
 		r.Get("/current", CurrentSeshRoute)
 		r.Get("/sessions", SessionsRoute)
+		r.Delete("/sessions", RevokeAllSessionsRoute)
+		r.Delete("/sessions/others", RevokeOtherSessionsRoute)
+		r.Delete("/sessions/{tid}", RevokeSessionRoute)
 	})
 
 	//Return the router
@@ -23,7 +23,7 @@ func CurrentSeshRoute(w http.ResponseWriter, r *http.Request) {
 
 	//Decrypt the refresh token
 	rtok, err := token.Decrypt(
-		ertok.Token, env.SK, env.ID, token.TokenTypeREFRESH,
+		ertok.Token, env.Keys, env.ID, token.TokenTypeREFRESH,
 	)
 	if err != nil {
 		util.ErrResponse(http.StatusInternalServerError,
@@ -1,8 +1,10 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"wraith.me/message_server/pkg/controller/cauth"
 	"wraith.me/message_server/pkg/controller/csolver"
@@ -20,7 +22,7 @@ func RequestLoginUserRoute(w http.ResponseWriter, r *http.Request) {
 	if user, tid, err :=
 		cauth.AttemptRefreshAuth(w, r, env, uc, true); user != nil && err == nil {
 		fmt.Println("post auth in stage1")
-		cauth.PostAuth(w, r, user, uc, &cfg.Token, env, true, tid)
+		cauth.PostAuth(w, r, user, uc, &cfg.Token, env, true, tid, "")
 		return
 	}
 
@@ -44,7 +46,7 @@ func RequestLoginUserRoute(w http.ResponseWriter, r *http.Request) {
 	}
 
 	//Create a public key challenge using the user's info
-	loginTok := csolver.IssuePKChallenge(user, env)
+	loginTok := csolver.IssuePKChallenge(user, loginReq.PK, env)
 
 	//Send the token to the user
 	util.PayloadOkResponse(
@@ -62,16 +64,16 @@ func VerifyLoginUserRoute(w http.ResponseWriter, r *http.Request) {
 	if user, tid, err :=
 		cauth.AttemptRefreshAuth(w, r, env, uc, true); user != nil && err == nil {
 		fmt.Println("post auth in stage2")
-		cauth.PostAuth(w, r, user, uc, &cfg.Token, env, true, tid)
+		cauth.PostAuth(w, r, user, uc, &cfg.Token, env, true, tid, "")
 		return
 	}
 
 	//Create a new stage 2 object plus database result
 	loginVReq := csolver.LoginVerifyUser{}
-	user := user.User{}
+	usr := user.User{}
 
 	//Run pre-flight checks
-	if !csolver.PreFlight(&loginVReq, &user, uc, w, r) {
+	if !csolver.PreFlight(&loginVReq, &usr, uc, w, r) {
 		return
 	}
 
@@ -83,7 +85,17 @@ func VerifyLoginUserRoute(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	//Record that the device key was used; keys that were revoked since the challenge was issued can't log in
+	fp := loginVReq.PK.Fingerprint()
+	if err := uc.TouchDevice(r.Context(), &usr, loginVReq.PK, time.Now()); errors.Is(err, user.ErrDeviceNotFound) {
+		util.ErrResponse(http.StatusForbidden, fmt.Errorf("key %s is not one of your device keys", fp)).Respond(w)
+		return
+	} else if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+
 	//Mark the user as PK verified and run post-login stuff
-	user.MarkPKVerified()
-	cauth.PostAuth(w, r, &user, uc, &cfg.Token, env, true, nil)
+	usr.MarkPKVerified()
+	cauth.PostAuth(w, r, &usr, uc, &cfg.Token, env, true, nil, fp)
 }
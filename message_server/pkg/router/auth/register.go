@@ -92,9 +92,14 @@ func RegisterUserRoute(w http.ResponseWriter, r *http.Request) {
 
 	//Fill in the rest of the details
 	uuid := util.MustNewUUID7()
+	pubkey, err := crypto.PubkeyFromBytes(decodedPK)
+	if err != nil {
+		util.ErrResponse(http.StatusBadRequest, err).Respond(w)
+		return
+	}
 	user := user.NewUser(
 		uuid,
-		crypto.NilPubkey(),
+		pubkey,
 		strings.ToLower(iuser.Username),
 		iuser.Username,
 		strings.ToLower(iuser.Email),
@@ -103,7 +108,6 @@ func RegisterUserRoute(w http.ResponseWriter, r *http.Request) {
 		user.DefaultUserFlags(),
 		user.DefaultUserOptions(),
 	)
-	copy(user.Pubkey[:], decodedPK[:])
 
 	//Complete the post-signup steps, including challenge generation and issuance of a temporary token
 	if err := postSignup(w, r, user); err != nil {
@@ -134,6 +138,7 @@ func ensureNonexistantUser(coll *user.UserCollection, usr request.RegisteringUse
 							bson.D{{Key: "username", Value: usr.Username}},
 							bson.D{{Key: "email", Value: usr.Email}},
 							bson.D{{Key: "pubkey", Value: pubkey}},
+							bson.D{{Key: "devices." + pubkey.Fingerprint(), Value: bson.D{{Key: "$exists", Value: true}}}},
 						},
 					},
 				},
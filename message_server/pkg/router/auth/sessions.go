@@ -1,12 +1,14 @@
 package auth
 
 import (
-	"crypto/subtle"
 	"fmt"
 	"net/http"
 
+	"github.com/go-chi/chi/v5"
+	"wraith.me/message_server/pkg/controller/cauth"
 	"wraith.me/message_server/pkg/http_types/response"
 	"wraith.me/message_server/pkg/mw"
+	"wraith.me/message_server/pkg/obj/session"
 	"wraith.me/message_server/pkg/obj/token"
 	"wraith.me/message_server/pkg/schema/user"
 	"wraith.me/message_server/pkg/util"
@@ -14,16 +16,16 @@ import (
 
 // Handles incoming requests made to `GET /api/auth/sessions`.
 func SessionsRoute(w http.ResponseWriter, r *http.Request) {
-	//Get the user from the auth middleware and the auth token ID
+	//Get the user and the current session from the auth middleware
 	user := r.Context().Value(mw.AuthCtxUserKey).(user.User)
-	ptid := r.Header.Get(mw.AuthAccessParentTokID)
+	current, _ := session.Of(r)
 
 	//Collect the tokens into a map; select attributes are added, but not the whole token
 	sessions := make(response.SessionsList)
 	for rtid, tok := range user.Tokens {
 		//Decrypt the current refresh token
 		dtok, err := token.Decrypt(
-			tok.Token, env.SK, env.ID, token.TokenTypeREFRESH,
+			tok.Token, env.Keys, env.ID, token.TokenTypeREFRESH,
 		)
 		if err != nil {
 			/*
@@ -39,7 +41,7 @@ func SessionsRoute(w http.ResponseWriter, r *http.Request) {
 		//Add the session
 		sessions[rtid] = response.Session{
 			ID:        dtok.ID.String(),
-			IsCurrent: subtle.ConstantTimeCompare([]byte(ptid), []byte(rtid)) == 1,
+			IsCurrent: tok.FamilyOf(rtid) == current,
 			Created:   dtok.Issued,
 			Expires:   dtok.Expiry,
 			IP:        dtok.IPAddr.String(),
@@ -57,3 +59,92 @@ func SessionsRoute(w http.ResponseWriter, r *http.Request) {
 		sessions,
 	).Respond(w)
 }
+
+/*
+Handles incoming requests made to `DELETE /api/auth/sessions/{tid}`. Logs out
+one of the requestor's sessions by the ID of its refresh token, as listed by
+`SessionsRoute`. This may be the current session.
+*/
+func RevokeSessionRoute(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+	current, _ := session.Of(r)
+	tid := chi.URLParam(r, "tid")
+	if !user.HasTokenById(tid) {
+		util.ErrResponse(http.StatusNotFound, fmt.Errorf("no session exists by ID %s", tid)).Respond(w)
+		return
+	}
+	if !revokeSessions(w, r, user, []string{tid}) {
+		return
+	}
+	if user.Tokens[tid].FamilyOf(tid) == current {
+		clearAuthCookies(w)
+	}
+	util.OkResponse(fmt.Sprintf("revoked session %s", tid)).Respond(w)
+}
+
+// Handles incoming requests made to `DELETE /api/auth/sessions/others`. Logs out every session but the current one.
+func RevokeOtherSessionsRoute(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+	current, _ := session.Of(r)
+	tids := make([]string, 0, len(user.Tokens))
+	for tid, tok := range user.Tokens {
+		if tok.FamilyOf(tid) != current {
+			tids = append(tids, tid)
+		}
+	}
+	if !revokeSessions(w, r, user, tids) {
+		return
+	}
+	util.OkResponse(fmt.Sprintf("revoked %d other session%s", len(tids), util.If(len(tids) != 1, "s", ""))).Respond(w)
+}
+
+// Handles incoming requests made to `DELETE /api/auth/sessions`. Logs out every session, including the current one.
+func RevokeAllSessionsRoute(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+	tids := make([]string, 0, len(user.Tokens))
+	for tid := range user.Tokens {
+		tids = append(tids, tid)
+	}
+	if !revokeSessions(w, r, user, tids) {
+		return
+	}
+	clearAuthCookies(w)
+	util.OkResponse(fmt.Sprintf("revoked all %d session%s", len(tids), util.If(len(tids) != 1, "s", ""))).Respond(w)
+}
+
+/*
+Revokes a user's sessions by the IDs of their refresh tokens. Each session's
+whole token family is removed from the user, its outstanding access tokens
+are denied from then on, and the connections opened with it are closed, even
+if they were opened before the session was last refreshed. Responds with an
+error and returns false if the sessions couldn't be revoked.
+*/
+func revokeSessions(w http.ResponseWriter, r *http.Request, usr user.User, tids []string) bool {
+	if len(tids) == 0 {
+		return true
+	}
+	families := make([]util.UUID, len(tids))
+	for i, tid := range tids {
+		families[i] = usr.Tokens[tid].FamilyOf(tid)
+	}
+	if err := cauth.EndSessions(r.Context(), uc, usr.ID, families...); err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return false
+	}
+	return true
+}
+
+/*
+"Deletes" the auth cookies from the client by replacing them with empty &
+expired copies. The web browser will vacuum up these cookies automatically,
+but only if the domain and path match exactly. Go has no in-built function to
+do this, so each cookie is replaced instead. Research into Express's method
+reveals a similar approach, so this way is most likely the correct way to
+handle deletion of cookies.
+*/
+func clearAuthCookies(w http.ResponseWriter) {
+	util.DeleteCookie(w, token.AccessTokenName, cfg.Token.Domain, cfg.Token.AccessCookiePath)
+	util.DeleteCookie(w, token.AccessTokenExprName, cfg.Token.Domain, cauth.ExprCookiePath)
+	util.DeleteCookie(w, token.RefreshTokenName, cfg.Token.Domain, cfg.Token.RefreshCookiePath)
+	util.DeleteCookie(w, token.RefreshTokenExprName, cfg.Token.Domain, cauth.ExprCookiePath)
+}
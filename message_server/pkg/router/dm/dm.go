@@ -0,0 +1,112 @@
+package dm
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/mongo"
+	"wraith.me/message_server/pkg/mw"
+	"wraith.me/message_server/pkg/obj/notification"
+	chatroom "wraith.me/message_server/pkg/schema/chat_room"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+Handles incoming requests made to `POST /api/chat/dm/{userID}`. Gets the
+direct conversation between the requestor and another user, starting one if
+there isn't one yet. Friends can always start one another. Other users can
+only be messaged if they accept unsolicited messages, in which case the
+conversation starts out as a message request that they can accept or decline.
+Users who blocked the requestor are reported as missing.
+*/
+func DirectRoomRoute(w http.ResponseWriter, r *http.Request) {
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+	tid, err := util.ParseUUIDv7(chi.URLParam(r, "userID"))
+	if err != nil {
+		util.ErrResponse(http.StatusBadRequest, fmt.Errorf("bad user ID format; it must be a UUIDv7")).Respond(w)
+		return
+	}
+	if tid == requestor.ID {
+		util.ErrResponse(http.StatusBadRequest, fmt.Errorf("you cannot start a conversation with yourself")).Respond(w)
+		return
+	}
+
+	//Ensure the other user exists; users who blocked the requestor are reported as missing,
+	//even if a conversation with them already exists
+	var target user.User
+	err = uc.FindID(r.Context(), tid).One(&target)
+	if err == nil && target.HasBlocked(requestor.ID) {
+		err = mongo.ErrNoDocuments
+	}
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			code = http.StatusNotFound
+			err = fmt.Errorf("no such user exists by UUID %s", tid)
+		}
+		util.ErrResponse(code, err).Respond(w)
+		return
+	}
+
+	//Hand back the conversation if there already is one
+	existing, err := rc.GetDirect(r.Context(), requestor.ID, tid)
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+	if existing != nil {
+		respondExisting(w, *existing, requestor.ID)
+		return
+	}
+
+	//Users can't start conversations with those they blocked
+	if requestor.HasBlocked(target.ID) {
+		util.ErrResponse(http.StatusForbidden, fmt.Errorf("you blocked user %s; unblock them first", target.ID)).Respond(w)
+		return
+	}
+
+	//Users who aren't friends need the other user's consent
+	request := !requestor.IsFriend(target.ID)
+	if request && !target.Options.UnsolicitedMessages {
+		util.ErrResponse(
+			http.StatusForbidden,
+			fmt.Errorf("user %s only accepts messages from their friends", target.ID),
+		).Respond(w)
+		return
+	}
+
+	//Store the conversation; a concurrent call may have beaten this one to it
+	room, created, err := rc.CreateDirect(r.Context(), chatroom.NewDirectRoom(requestor.ID, target.ID, request))
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+	if !created {
+		respondExisting(w, room, requestor.ID)
+		return
+	}
+
+	if !request {
+		util.PayloadOkResponse(fmt.Sprintf("started a conversation with user %s", target.ID), room).Respond(w)
+		return
+	}
+	if err := notification.Dispatch(r.Context(), notification.MsgRequestNotif(requestor, target.ID, room)); err != nil {
+		fmt.Printf("dm: failed to dispatch notification for request %s: %s\n", room.ID, err)
+	}
+	util.PayloadOkResponse(fmt.Sprintf("sent a message request to user %s", target.ID), room).Respond(w)
+}
+
+// Responds with a direct conversation that already existed, unless it's a request that the requestor has yet to answer.
+func respondExisting(w http.ResponseWriter, room chatroom.Room, requestor util.UUID) {
+	if room.IsRequest() && room.Request.To == requestor {
+		util.ErrResponse(
+			http.StatusConflict,
+			fmt.Errorf("user %s already sent you a message request (%s); accept it instead", room.Request.From, room.ID),
+		).Respond(w)
+		return
+	}
+	util.PayloadOkResponse("", room).Respond(w)
+}
@@ -0,0 +1,68 @@
+package dm
+
+import (
+	"github.com/go-chi/chi/v5"
+	"wraith.me/message_server/pkg/blob"
+	"wraith.me/message_server/pkg/config"
+	"wraith.me/message_server/pkg/globals"
+	"wraith.me/message_server/pkg/mw"
+	chatmessage "wraith.me/message_server/pkg/schema/chat_message"
+	chatroom "wraith.me/message_server/pkg/schema/chat_room"
+	memberstate "wraith.me/message_server/pkg/schema/member_state"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/ws/wschat"
+)
+
+var (
+	// Shared user collection across the entire package.
+	uc *user.UserCollection
+
+	// Shared room collection across the entire package.
+	rc *chatroom.RoomCollection
+
+	// Shared chat message collection across the entire package.
+	mc *chatmessage.MessageCollection
+
+	// Shared member state collection across the entire package.
+	msc *memberstate.StateCollection
+
+	// Shared attachment store across the entire package.
+	blobs blob.BlobStore
+
+	// Shared env object across the entire package.
+	env *config.Env
+
+	// Shared Melody WS handler for the entire package.
+	mel *wschat.Server
+)
+
+// Sets up routes for the `/api/chat/dm` endpoint.
+func DMRoutes() chi.Router {
+	//Create the router
+	r := chi.NewRouter()
+
+	//Set the singletons for the entire package
+	uc = globals.UC
+	rc = globals.RC
+	mc = globals.MC
+	msc = globals.MSC
+	blobs = globals.Blobs
+	env = globals.Env
+	mel = wschat.GetInstance()
+
+	//Add routes (authenticated)
+	r.Group(func(r chi.Router) {
+		r.Use(mw.NewAuthMiddleware(env))
+
+		//Message requests
+		r.Get("/requests", ListRequestsRoute)
+		r.Post("/requests/{roomID}/accept", AcceptRequestRoute)
+		r.Post("/requests/{roomID}/decline", DeclineRequestRoute)
+
+		//Direct conversations
+		r.Post("/{userID}", DirectRoomRoute)
+	})
+
+	//Return the router
+	return r
+}
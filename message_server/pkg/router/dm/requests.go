@@ -0,0 +1,113 @@
+package dm
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"wraith.me/message_server/pkg/http_types/response"
+	"wraith.me/message_server/pkg/mw"
+	chatmessage "wraith.me/message_server/pkg/schema/chat_message"
+	chatroom "wraith.me/message_server/pkg/schema/chat_room"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+)
+
+// Handles incoming requests made to `GET /api/chat/dm/requests`. Lists the message requests the requestor hasn't answered yet.
+func ListRequestsRoute(w http.ResponseWriter, r *http.Request) {
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+	rooms, err := rc.ListRequests(r.Context(), requestor.ID)
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+
+	//Attach the message each request carries, if it was sent yet; requests from blocked users are left out
+	requests := make([]response.MessageRequest, 0, len(rooms))
+	for _, room := range rooms {
+		if requestor.HasBlocked(room.Request.From) {
+			continue
+		}
+		req := response.MessageRequest{Room: room}
+		msg, err := mc.GetFirst(r.Context(), room.ID)
+		if err != nil {
+			util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+			return
+		}
+		if msg != nil {
+			req.Message = &msg.Message
+		}
+		requests = append(requests, req)
+	}
+	util.PayloadOkResponse("", requests...).Respond(w)
+}
+
+/*
+Handles incoming requests made to `POST /api/chat/dm/requests/{roomID}/accept`.
+Only the recipient of a message request may accept it, which adds them to the
+room as an ordinary direct conversation.
+*/
+func AcceptRequestRoute(w http.ResponseWriter, r *http.Request) {
+	rid, requestor, ok := getRequestCtx(w, r)
+	if !ok {
+		return
+	}
+	room, err := rc.AcceptRequest(r.Context(), rid, requestor.ID)
+	if err != nil {
+		respondRequestErr(w, err, rid)
+		return
+	}
+
+	mel.AnnounceRoster(*room, []util.UUID{requestor.ID}, nil)
+	util.PayloadOkResponse(fmt.Sprintf("accepted the message request %s", room.ID), *room).Respond(w)
+}
+
+/*
+Handles incoming requests made to `POST /api/chat/dm/requests/{roomID}/decline`.
+Only the recipient of a message request may decline it. The room goes away
+along with the message it carried, and its sender is disconnected from it.
+*/
+func DeclineRequestRoute(w http.ResponseWriter, r *http.Request) {
+	rid, requestor, ok := getRequestCtx(w, r)
+	if !ok {
+		return
+	}
+	room, err := rc.DeclineRequest(r.Context(), rid, requestor.ID)
+	if err != nil {
+		respondRequestErr(w, err, rid)
+		return
+	}
+
+	//Remove everything that belongs to the room
+	if err := chatmessage.RemoveRoomMessages(r.Context(), blobs, room.ID); err != nil {
+		fmt.Printf("dm: failed to remove the history of room %s: %s\n", room.ID, err)
+	}
+	if _, err := msc.RemoveAll(r.Context(), bson.D{{Key: "room_id", Value: room.ID}}); err != nil {
+		fmt.Printf("dm: failed to remove the member states of room %s: %s\n", room.ID, err)
+	}
+
+	mel.AnnounceDeletion(*room)
+	util.OkResponse(fmt.Sprintf("declined the message request from user %s", room.Request.From)).Respond(w)
+}
+
+// Gets the ID of the message request that a request concerns, along with the requestor.
+func getRequestCtx(w http.ResponseWriter, r *http.Request) (util.UUID, user.User, bool) {
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+	rid, err := util.ParseUUIDv7(chi.URLParam(r, "roomID"))
+	if err != nil {
+		util.ErrResponse(http.StatusBadRequest, fmt.Errorf("bad room ID format; it must be a UUIDv7")).Respond(w)
+		return rid, requestor, false
+	}
+	return rid, requestor, true
+}
+
+// Responds with the error from answering a message request. Others' requests are reported as missing.
+func respondRequestErr(w http.ResponseWriter, err error, rid util.UUID) {
+	if errors.Is(err, chatroom.ErrNoSuchRequest) {
+		util.ErrResponse(http.StatusNotFound, fmt.Errorf("no pending message request exists by UUID %s", rid)).Respond(w)
+		return
+	}
+	util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+}
@@ -0,0 +1,65 @@
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/mongo"
+	"wraith.me/message_server/pkg/http_types/response"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+Handles incoming requests made to `GET /api/keys/bundle/{uid}`. Returns the
+keys needed to start an X3DH session with a user. Each call consumes one of
+the user's one-time prekeys, which is never handed out again; once they run
+out, bundles only contain the signed prekey.
+*/
+func GetBundleRoute(w http.ResponseWriter, r *http.Request) {
+	//Get the ID of the user
+	uid, err := util.ParseUUIDv7(chi.URLParam(r, "uid"))
+	if err != nil {
+		util.ErrResponse(http.StatusBadRequest, fmt.Errorf("bad user ID format; it must be a UUIDv7")).Respond(w)
+		return
+	}
+
+	//Get the user's identity key
+	var target user.User
+	if err := uc.FindID(r.Context(), uid).One(&target); err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			code = http.StatusNotFound
+			err = fmt.Errorf("no such user exists by UUID %s", uid)
+		}
+		util.ErrResponse(code, err).Respond(w)
+		return
+	}
+
+	//Get the user's signed prekey; sessions can't be started without one
+	signed, err := pkc.GetSigned(r.Context(), uid)
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+	if signed == nil {
+		util.ErrResponse(http.StatusNotFound, fmt.Errorf("user %s has not published any prekeys", uid)).Respond(w)
+		return
+	}
+
+	//Claim one of the user's one-time prekeys, if there are any left
+	onetime, err := pkc.PopOneTime(r.Context(), uid)
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+
+	util.PayloadOkResponse("", response.PrekeyBundle{
+		ID:            uid,
+		IdentityKey:   target.Pubkey,
+		SignedPrekey:  *signed,
+		OneTimePrekey: onetime,
+	}).Respond(w)
+}
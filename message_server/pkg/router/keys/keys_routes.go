@@ -0,0 +1,44 @@
+package keys
+
+import (
+	"github.com/go-chi/chi/v5"
+	"wraith.me/message_server/pkg/config"
+	"wraith.me/message_server/pkg/globals"
+	"wraith.me/message_server/pkg/mw"
+	"wraith.me/message_server/pkg/schema/prekey"
+	"wraith.me/message_server/pkg/schema/user"
+)
+
+var (
+	// Shared user collection across the entire package.
+	uc *user.UserCollection
+
+	// Shared prekey collection across the entire package.
+	pkc *prekey.PrekeyCollection
+
+	// Shared env object across the entire package.
+	env *config.Env
+)
+
+// Sets up routes for the `/api/keys` endpoint.
+func KeysRoutes() chi.Router {
+	//Create the router
+	r := chi.NewRouter()
+
+	//Set the singletons for the entire package
+	uc = globals.UC
+	pkc = globals.PKC
+	env = globals.Env
+
+	//Add routes (authenticated)
+	r.Group(func(r chi.Router) {
+		r.Use(mw.NewAuthMiddleware(env))
+
+		r.Post("/prekeys", UploadPrekeysRoute)
+		r.Get("/prekeys/count", CountPrekeysRoute)
+		r.Get("/bundle/{uid}", GetBundleRoute)
+	})
+
+	//Return the router
+	return r
+}
@@ -0,0 +1,126 @@
+package keys
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"wraith.me/message_server/pkg/http_types/request"
+	"wraith.me/message_server/pkg/http_types/response"
+	"wraith.me/message_server/pkg/mw"
+	"wraith.me/message_server/pkg/schema/prekey"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+)
+
+const (
+	//The maximum number of one-time prekeys that can be uploaded at once.
+	maxOneTimeBatch = 100
+
+	//The maximum number of one-time prekeys a user can have on the server.
+	maxOneTimeStored = 500
+)
+
+/*
+Handles incoming requests made to `POST /api/keys/prekeys`. Publishes a new
+signed prekey, a batch of one-time prekeys, or both. Every key must be signed
+by the requestor's identity key; if any signature is bad, nothing is stored.
+*/
+func UploadPrekeysRoute(w http.ResponseWriter, r *http.Request) {
+	//Get the requestor's info
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+
+	//Parse the request body
+	var req request.PrekeyUpload
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.ErrResponse(http.StatusBadRequest, err).Respond(w)
+		return
+	}
+	if req.SignedPrekey == nil && len(req.OneTimePrekeys) == 0 {
+		util.ErrResponse(http.StatusBadRequest, fmt.Errorf("no prekeys were provided")).Respond(w)
+		return
+	}
+	if len(req.OneTimePrekeys) > maxOneTimeBatch {
+		util.ErrResponse(
+			http.StatusBadRequest,
+			fmt.Errorf("too many one-time prekeys (%d); at most %d may be uploaded at once", len(req.OneTimePrekeys), maxOneTimeBatch),
+		).Respond(w)
+		return
+	}
+
+	//Ensure that there's room for the one-time prekeys
+	if len(req.OneTimePrekeys) > 0 {
+		count, err := pkc.CountOneTime(r.Context(), requestor.ID)
+		if err != nil {
+			util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+			return
+		}
+		if count+int64(len(req.OneTimePrekeys)) > maxOneTimeStored {
+			util.ErrResponse(
+				http.StatusConflict,
+				fmt.Errorf("too many one-time prekeys; %d are stored and at most %d are allowed", count, maxOneTimeStored),
+			).Respond(w)
+			return
+		}
+	}
+
+	//Verify the signed prekey
+	var signed *prekey.Prekey
+	if req.SignedPrekey != nil {
+		pk := prekey.NewPrekey(requestor.ID, prekey.KindSIGNED, req.SignedPrekey.Key, req.SignedPrekey.Signature)
+		if !pk.VerifyWith(requestor.Pubkey) {
+			util.ErrResponse(http.StatusBadRequest, fmt.Errorf("the signed prekey has a bad signature")).Respond(w)
+			return
+		}
+		signed = &pk
+	}
+
+	//Verify the one-time prekeys
+	onetime := make([]prekey.Prekey, len(req.OneTimePrekeys))
+	for i, spk := range req.OneTimePrekeys {
+		onetime[i] = prekey.NewPrekey(requestor.ID, prekey.KindONETIME, spk.Key, spk.Signature)
+		if !onetime[i].VerifyWith(requestor.Pubkey) {
+			util.ErrResponse(http.StatusBadRequest, fmt.Errorf("one-time prekey #%d has a bad signature", i)).Respond(w)
+			return
+		}
+	}
+
+	//Store the keys
+	if signed != nil {
+		if err := pkc.SetSigned(r.Context(), *signed); err != nil {
+			util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+			return
+		}
+	}
+	if len(onetime) > 0 {
+		if _, err := pkc.InsertMany(r.Context(), onetime); err != nil {
+			util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+			return
+		}
+	}
+
+	util.OkResponse(fmt.Sprintf("stored %d prekey(s)", len(onetime)+util.If(signed != nil, 1, 0))).Respond(w)
+}
+
+// Handles incoming requests made to `GET /api/keys/prekeys/count`.
+func CountPrekeysRoute(w http.ResponseWriter, r *http.Request) {
+	//Get the requestor's info
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+
+	//Count the requestor's keys
+	count, err := pkc.CountOneTime(r.Context(), requestor.ID)
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+	signed, err := pkc.GetSigned(r.Context(), requestor.ID)
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+
+	util.PayloadOkResponse("", response.PrekeyCount{
+		OneTime:   count,
+		HasSigned: signed != nil,
+	}).Respond(w)
+}
@@ -0,0 +1,162 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"wraith.me/message_server/pkg/db/qpage"
+	"wraith.me/message_server/pkg/http_types/request"
+	"wraith.me/message_server/pkg/http_types/response"
+	"wraith.me/message_server/pkg/mw"
+	"wraith.me/message_server/pkg/obj/notification"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+	"wraith.me/message_server/pkg/ws/wsnotif"
+)
+
+/*
+Handles incoming requests made to `GET /api/notifications/get`. Notifications
+are returned newest first, alongside the requestor's total unread count. Set
+the `unread` query param to `true` to only get unread notifications.
+*/
+func GetNotificationsRoute(w http.ResponseWriter, r *http.Request) {
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+
+	//Construct the search query
+	query := bson.D{{Key: "recipient_id", Value: requestor.ID}}
+	if r.URL.Query().Get("unread") == "true" {
+		query = append(query, bson.E{Key: "read", Value: false})
+	}
+
+	//Construct the pager object, sorting newest first
+	pager, err := qpage.NewQPage(nc.Collection)
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+	pager.Sort("_id", -1)
+
+	//Perform the paging query
+	notifs := make([]notification.Notification, 0)
+	pagination, err := pager.Find(&notifs, r.Context(), query, qpage.ParseQuery(r))
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+
+	//Get the unread count
+	unread, err := nc.CountUnread(r.Context(), requestor.ID)
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+
+	out := response.NotificationList{
+		PaginatedData: response.NewPaginatedData(notifs, *pagination),
+		Unread:        unread,
+	}
+	util.PayloadOkResponse(out.Desc(), out).Respond(w)
+}
+
+// Handles incoming requests made to `PATCH /api/notifications/read/{nid}`.
+func ReadNotificationRoute(w http.ResponseWriter, r *http.Request) {
+	actOnOne(w, r, "marked notification %s as read", func(ctx context.Context, uid util.UUID, ids []util.UUID) (int64, error) {
+		return nc.SetRead(ctx, uid, ids, true)
+	})
+}
+
+// Handles incoming requests made to `PATCH /api/notifications/unread/{nid}`.
+func UnreadNotificationRoute(w http.ResponseWriter, r *http.Request) {
+	actOnOne(w, r, "marked notification %s as unread", func(ctx context.Context, uid util.UUID, ids []util.UUID) (int64, error) {
+		return nc.SetRead(ctx, uid, ids, false)
+	})
+}
+
+// Handles incoming requests made to `DELETE /api/notifications/remove/{nid}`.
+func RemoveNotificationRoute(w http.ResponseWriter, r *http.Request) {
+	actOnOne(w, r, "removed notification %s", nc.Remove)
+}
+
+// Handles incoming requests made to `PATCH /api/notifications/read`. Marks several notifications as read.
+func ReadNotificationsRoute(w http.ResponseWriter, r *http.Request) {
+	actOnBatch(w, r, "marked %d notification(s) as read", func(ctx context.Context, uid util.UUID, ids []util.UUID) (int64, error) {
+		return nc.SetRead(ctx, uid, ids, true)
+	})
+}
+
+// Handles incoming requests made to `PATCH /api/notifications/unread`. Marks several notifications as unread.
+func UnreadNotificationsRoute(w http.ResponseWriter, r *http.Request) {
+	actOnBatch(w, r, "marked %d notification(s) as unread", func(ctx context.Context, uid util.UUID, ids []util.UUID) (int64, error) {
+		return nc.SetRead(ctx, uid, ids, false)
+	})
+}
+
+// Handles incoming requests made to `DELETE /api/notifications/remove`. Removes several notifications.
+func RemoveNotificationsRoute(w http.ResponseWriter, r *http.Request) {
+	actOnBatch(w, r, "removed %d notification(s)", nc.Remove)
+}
+
+/*
+Handles incoming requests made to `GET /api/notifications/live`. Upgrades the
+connection to a WebSocket, over which the requestor's new notifications are
+pushed as they're created.
+*/
+func LiveNotificationsRoute(w http.ResponseWriter, r *http.Request) {
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+	r = r.WithContext(context.WithValue(r.Context(), wsnotif.WSNotifCtxObjKey, requestor.ID))
+	wsn.GetMelody().HandleRequest(w, r)
+}
+
+// Represents an action taken on some of a user's notifications, returning the number affected.
+type notifAction func(ctx context.Context, uid util.UUID, ids []util.UUID) (int64, error)
+
+// Applies an action to the notification whose ID is in the URL, responding with a 404 if the requestor has no such notification.
+func actOnOne(w http.ResponseWriter, r *http.Request, desc string, action notifAction) {
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+	nid, err := util.ParseUUIDv7(chi.URLParam(r, "nid"))
+	if err != nil {
+		util.ErrResponse(http.StatusBadRequest, fmt.Errorf("bad notification ID format; it must be a UUIDv7")).Respond(w)
+		return
+	}
+
+	n, err := action(r.Context(), requestor.ID, []util.UUID{nid})
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+	if n == 0 {
+		util.ErrResponse(http.StatusNotFound, fmt.Errorf("no such notification exists by UUID %s", nid)).Respond(w)
+		return
+	}
+	util.OkResponse(fmt.Sprintf(desc, nid)).Respond(w)
+}
+
+// Applies an action to the notifications listed in the request body, or all of the requestor's notifications.
+func actOnBatch(w http.ResponseWriter, r *http.Request, desc string, action notifAction) {
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+	var req request.NotificationBatch
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (len(req.IDs) == 0 && !req.All) {
+		util.ErrResponse(
+			http.StatusBadRequest,
+			fmt.Errorf("a list of notification IDs or `all` must be provided"),
+		).Respond(w)
+		return
+	}
+
+	//Ignore the list if everything was asked for
+	ids := req.IDs
+	if req.All {
+		ids = nil
+	}
+
+	n, err := action(r.Context(), requestor.ID, ids)
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+	util.PayloadOkResponse(fmt.Sprintf(desc, n), response.NotificationCount{Count: n}).Respond(w)
+}
@@ -5,13 +5,21 @@ import (
 	"wraith.me/message_server/pkg/config"
 	"wraith.me/message_server/pkg/globals"
 	"wraith.me/message_server/pkg/mw"
+	"wraith.me/message_server/pkg/obj/notification"
 	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/ws/wsnotif"
 )
 
 var (
 	// Shared user collection across the entire package.
 	uc *user.UserCollection
 
+	// Shared notification collection across the entire package.
+	nc *notification.NotificationCollection
+
+	// Shared notification push server across the entire package.
+	wsn *wsnotif.Server
+
 	// Shared config object across the entire package.
 	cfg *config.Config
 
@@ -26,16 +34,26 @@ func NotificationsRoutes() chi.Router {
 
 	//Set the singletons for the entire package
 	uc = globals.UC
+	nc = globals.NC
+	wsn = wsnotif.GetInstance()
 	cfg = globals.Cfg
 	env = globals.Env
 
 	//Add routes (authenticated)
 	r.Group(func(r chi.Router) {
 		r.Use(mw.NewAuthMiddleware(env))
-		//r.Get("/get", e)
-		//r.Patch("/read/{nid}", e)
-		//r.Patch("/unread/{nid}", e)
-		//r.Delete("/remove/{nid}", e)
+		r.Get("/get", GetNotificationsRoute)
+		r.Get("/live", LiveNotificationsRoute)
+
+		//Single notifications
+		r.Patch("/read/{nid}", ReadNotificationRoute)
+		r.Patch("/unread/{nid}", UnreadNotificationRoute)
+		r.Delete("/remove/{nid}", RemoveNotificationRoute)
+
+		//Several notifications at once
+		r.Patch("/read", ReadNotificationsRoute)
+		r.Patch("/unread", UnreadNotificationsRoute)
+		r.Delete("/remove", RemoveNotificationsRoute)
 	})
 
 	//Return the router
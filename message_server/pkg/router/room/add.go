@@ -9,7 +9,11 @@ import (
 	"wraith.me/message_server/pkg/util"
 )
 
-// Handles incoming requests made to `PATCH /api/chat/room/{roomID}/add`.
+/*
+Handles incoming requests made to `GET /api/chat/room/{roomID}/add`. Users
+can't add themselves to rooms; they must be invited by a moderator via
+`POST /api/chat/room/{roomID}/invite`. Members get the room back.
+*/
 func AddRoomRoute(w http.ResponseWriter, r *http.Request) {
 	//Get the room from the request params
 	room := getRoomFromQuery(w, r)
@@ -20,22 +24,14 @@ func AddRoomRoute(w http.ResponseWriter, r *http.Request) {
 	//Get the requestor's info
 	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
 
-	//Ensure the current user is allowed to join the room
-	//TODO: handle perms for this later; let them anywayy
+	//Ensure the current user is allowed into the room
 	if !room.HasMember(requestor.ID) {
-		//Allow entry into the room
-		room.AddMember(requestor.ID)
-
-		//Save the chat room in the database
-		_, err := rc.UpsertId(r.Context(), room.ID, room)
-		if err != nil {
-			util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
-			return
-		}
+		util.ErrResponse(
+			http.StatusForbidden,
+			fmt.Errorf("you must be invited to this room by one of its moderators"),
+		).Respond(w)
+		return
 	}
 
-	fmt.Printf("user %s attempted to add room %s\n", requestor.ID, room.ID)
-
-	//Respond with the created chat room using PayloadResponse
-	util.PayloadOkResponse("Chat room created successfully", room).Respond(w)
+	util.PayloadOkResponse("", *room).Respond(w)
 }
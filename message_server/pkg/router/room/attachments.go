@@ -0,0 +1,356 @@
+package room
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"wraith.me/message_server/pkg/blob"
+	"wraith.me/message_server/pkg/crypto"
+	"wraith.me/message_server/pkg/http_types/request"
+	"wraith.me/message_server/pkg/http_types/response"
+	"wraith.me/message_server/pkg/schema/attachment"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+)
+
+const (
+	// The longest an attachment's name may be, in bytes.
+	maxAttachmentName = 255
+
+	// How long the chunks of an upload may take to be joined before someone else may try.
+	assemblyLease = 5 * time.Minute
+)
+
+/*
+Handles incoming requests made to `POST /api/chat/room/{roomID}/attachments`.
+Members of the room may start uploading a file, which is then sent in chunks
+of the size given in the response.
+*/
+func CreateAttachmentRoute(w http.ResponseWriter, r *http.Request) {
+	room, requestor := getMemberCtx(w, r)
+	if room == nil {
+		return
+	}
+
+	//Get the details of the file and ensure they're within limits
+	var req request.NewAttachment
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.ErrResponse(http.StatusBadRequest, fmt.Errorf("the details of the file must be provided")).Respond(w)
+		return
+	}
+	req.Hash = strings.ToLower(req.Hash)
+	if hash, err := hex.DecodeString(req.Hash); err != nil || len(hash) != 32 {
+		util.ErrResponse(http.StatusBadRequest, fmt.Errorf("the hash must be a hex-encoded SHA-256 hash")).Respond(w)
+		return
+	}
+	if req.Name == "" || len(req.Name) > maxAttachmentName {
+		util.ErrResponse(http.StatusBadRequest, fmt.Errorf("the name must be between 1 and %d bytes", maxAttachmentName)).Respond(w)
+		return
+	}
+	if req.Size <= 0 || req.Size > acfg.MaxFileSize {
+		util.ErrResponse(
+			http.StatusRequestEntityTooLarge,
+			fmt.Errorf("files must be between 1 and %d bytes", acfg.MaxFileSize),
+		).Respond(w)
+		return
+	}
+	if !acfg.AllowsType(req.MIME) {
+		util.ErrResponse(http.StatusUnsupportedMediaType, fmt.Errorf("files of type '%s' are not allowed", req.MIME)).Respond(w)
+		return
+	}
+
+	//Save the attachment so its chunks can be sent
+	att := attachment.NewAttachment(room.ID, requestor.ID, req.Name, req.MIME, req.Size, req.Hash, acfg.ChunkSize)
+	if _, err := ac.InsertOne(r.Context(), att); err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+	util.PayloadOkResponse("started upload", att).Respond(w)
+}
+
+/*
+Handles incoming requests made to `GET /api/chat/room/{roomID}/attachments/{attID}`.
+Members of the room may see the details of its attachments. Unfinished
+uploads list the chunks received so far, so they can be picked up again.
+*/
+func GetAttachmentRoute(w http.ResponseWriter, r *http.Request) {
+	att, _ := getAttachmentCtx(w, r, false)
+	if att == nil {
+		return
+	}
+	util.PayloadOkResponse("", *att).Respond(w)
+}
+
+/*
+Handles incoming requests made to `PUT /api/chat/room/{roomID}/attachments/{attID}/chunks/{index}`.
+The body is the raw content of the chunk. Chunks may be sent in any order
+and sent again, but only by whoever started the upload.
+*/
+func UploadChunkRoute(w http.ResponseWriter, r *http.Request) {
+	att, _ := getAttachmentCtx(w, r, true)
+	if att == nil {
+		return
+	}
+
+	//Get the chunk's index and the size it must have
+	index, err := strconv.Atoi(chi.URLParam(r, "index"))
+	want := att.ChunkLen(index)
+	if err != nil || want < 0 {
+		util.ErrResponse(http.StatusBadRequest, fmt.Errorf("chunks are numbered from 0 to %d", att.Chunks()-1)).Respond(w)
+		return
+	}
+
+	//Store the chunk, reading no more than it may hold
+	body := http.MaxBytesReader(w, r.Body, want)
+	n, err := blobs.Put(r.Context(), att.ChunkKey(index), body)
+	if err == nil && n != want {
+		err = fmt.Errorf("chunk %d must be %d bytes; got %d", index, want, n)
+	}
+	if err != nil {
+		blobs.Delete(r.Context(), att.ChunkKey(index))
+		code := http.StatusBadRequest
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			code = http.StatusRequestEntityTooLarge
+			err = fmt.Errorf("chunk %d must be %d bytes", index, want)
+		}
+		util.ErrResponse(code, err).Respond(w)
+		return
+	}
+
+	//Record the chunk as received
+	updated, err := ac.MarkReceived(r.Context(), att.ID, index)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, attachment.ErrNoSuchAttachment) {
+			code = http.StatusConflict
+			err = fmt.Errorf("attachment %s was already completed", att.ID)
+		}
+		util.ErrResponse(code, err).Respond(w)
+		return
+	}
+	util.PayloadOkResponse("received chunk", *updated).Respond(w)
+}
+
+/*
+Handles incoming requests made to `POST /api/chat/room/{roomID}/attachments/{attID}/complete`.
+Joins the chunks of an upload once they're all in and checks the file against
+its hash. If the hash doesn't match, every chunk has to be sent again.
+*/
+func CompleteAttachmentRoute(w http.ResponseWriter, r *http.Request) {
+	pending, _ := getAttachmentCtx(w, r, true)
+	if pending == nil {
+		return
+	}
+
+	//Claim the upload so its chunks aren't joined twice at once
+	att, err := ac.ClaimAssembly(r.Context(), pending.ID, assemblyLease)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, attachment.ErrNoSuchAttachment) {
+			code = http.StatusConflict
+			err = fmt.Errorf("attachment %s is already being completed", pending.ID)
+		}
+		util.ErrResponse(code, err).Respond(w)
+		return
+	}
+	if missing := att.Missing(); len(missing) > 0 {
+		ac.ReleaseAssembly(r.Context(), att.ID)
+		util.ErrResponse(http.StatusConflict, fmt.Errorf("chunks %v have not been received", missing)).Respond(w)
+		return
+	}
+
+	//Join the chunks, starting over if the file doesn't match its hash
+	if err := attachment.Assemble(r.Context(), blobs, *att); err != nil {
+		if errors.Is(err, attachment.ErrHashMismatch) {
+			if _, rerr := ac.ResetReceived(r.Context(), att.ID); rerr != nil {
+				fmt.Printf("failed to reset the chunks of attachment %s: %s\n", att.ID, rerr)
+			}
+			util.ErrResponse(http.StatusUnprocessableEntity, err).Respond(w)
+			return
+		}
+		ac.ReleaseAssembly(r.Context(), att.ID)
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+
+	updated, err := ac.MarkComplete(r.Context(), att.ID)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, attachment.ErrNoSuchAttachment) {
+			code = http.StatusConflict
+			err = fmt.Errorf("attachment %s was already completed", att.ID)
+		}
+		util.ErrResponse(code, err).Respond(w)
+		return
+	}
+	util.PayloadOkResponse("completed upload", *updated).Respond(w)
+}
+
+/*
+Handles incoming requests made to `GET /api/chat/room/{roomID}/attachments/{attID}/url`.
+Members of the room get a signed link that the file can be downloaded from for
+a short while, without needing to send their credentials along.
+*/
+func AttachmentURLRoute(w http.ResponseWriter, r *http.Request) {
+	att, _ := getAttachmentCtx(w, r, false)
+	if att == nil {
+		return
+	}
+	if !att.Complete {
+		util.ErrResponse(http.StatusConflict, fmt.Errorf("attachment %s has not finished uploading", att.ID)).Respond(w)
+		return
+	}
+
+	expires := time.Now().Add(time.Duration(acfg.URLLifetime) * time.Second).Truncate(time.Second)
+	query := url.Values{}
+	sig, kid := signDownload(att.Room, att.ID, expires.Unix())
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("kid", kid)
+	query.Set("sig", sig)
+	link := fmt.Sprintf("%s/chat/room/%s/attachments/%s/download?%s", cfg.Server.BaseUrl, att.Room, att.ID, query.Encode())
+	util.PayloadOkResponse("", response.AttachmentURL{URL: link, Expires: expires}).Respond(w)
+}
+
+/*
+Handles incoming requests made to `GET /api/chat/room/{roomID}/attachments/{attID}/download`.
+No login is needed; the link must instead carry a signature from
+`AttachmentURLRoute` that hasn't expired yet.
+*/
+func DownloadAttachmentRoute(w http.ResponseWriter, r *http.Request) {
+	roomID, rerr := util.ParseUUIDv7(chi.URLParam(r, "roomID"))
+	attID, aerr := util.ParseUUIDv7(chi.URLParam(r, "attID"))
+	expires, eerr := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	kid, sig := r.URL.Query().Get("kid"), r.URL.Query().Get("sig")
+	if rerr != nil || aerr != nil || eerr != nil || !verifyDownload(roomID, attID, expires, kid, sig) {
+		util.ErrResponse(http.StatusForbidden, fmt.Errorf("this link is not valid")).Respond(w)
+		return
+	}
+	if time.Now().Unix() > expires {
+		util.ErrResponse(http.StatusForbidden, fmt.Errorf("this link has expired")).Respond(w)
+		return
+	}
+
+	//Get the attachment; it may have been removed since the link was made
+	att, err := ac.GetInRoom(r.Context(), roomID, attID)
+	if err == nil && !att.Complete {
+		err = attachment.ErrNoSuchAttachment
+	}
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, attachment.ErrNoSuchAttachment) {
+			code = http.StatusNotFound
+		}
+		util.ErrResponse(code, err).Respond(w)
+		return
+	}
+	content, err := blobs.Get(r.Context(), att.BlobKey())
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, blob.ErrNotFound) {
+			code = http.StatusNotFound
+		}
+		util.ErrResponse(code, err).Respond(w)
+		return
+	}
+	defer content.Close()
+
+	//Stream the file; it's encrypted, so it's always sent as a download
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(att.Size, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", att.ID.String()))
+	w.Header().Set("Cache-Control", "private, no-store")
+	if _, err := io.Copy(w, content); err != nil {
+		fmt.Printf("failed to send attachment %s: %s\n", att.ID, err)
+	}
+}
+
+/*
+Gets the attachment that a request concerns, along with the requestor. Only
+members of the room may access its attachments, and only the uploader may
+change an upload, which must still be unfinished. Responds with an error and
+returns `nil` otherwise.
+*/
+func getAttachmentCtx(w http.ResponseWriter, r *http.Request, uploading bool) (*attachment.Attachment, user.User) {
+	room, requestor := getMemberCtx(w, r)
+	if room == nil {
+		return nil, requestor
+	}
+	attID, err := util.ParseUUIDv7(chi.URLParam(r, "attID"))
+	if err != nil {
+		util.ErrResponse(
+			http.StatusBadRequest,
+			fmt.Errorf("bad attachment ID format; it must be a UUIDv7"),
+		).Respond(w)
+		return nil, requestor
+	}
+
+	att, err := ac.GetInRoom(r.Context(), room.ID, attID)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, attachment.ErrNoSuchAttachment) {
+			code = http.StatusNotFound
+			err = fmt.Errorf("no attachment %s exists in this room", attID)
+		}
+		util.ErrResponse(code, err).Respond(w)
+		return nil, requestor
+	}
+
+	if uploading {
+		if att.Uploader != requestor.ID {
+			util.ErrResponse(http.StatusForbidden, fmt.Errorf("only the uploader of an attachment may send it")).Respond(w)
+			return nil, requestor
+		}
+		if att.Complete {
+			util.ErrResponse(http.StatusConflict, fmt.Errorf("attachment %s was already completed", att.ID)).Respond(w)
+			return nil, requestor
+		}
+	}
+	return att, requestor
+}
+
+// Gets the payload that's signed to make a download link.
+func downloadPayload(roomID util.UUID, attID util.UUID, expires int64) []byte {
+	return []byte(fmt.Sprintf("attachment:%s:%s:%d", roomID, attID, expires))
+}
+
+// Signs a download link with the server's active key. Returns the signature and the ID of the key.
+func signDownload(roomID util.UUID, attID util.UUID, expires int64) (string, string) {
+	key := env.Keys.Active()
+	sig := crypto.Sign(key.Key, downloadPayload(roomID, attID, expires))
+	return base64.RawURLEncoding.EncodeToString(sig[:]), key.ID()
+}
+
+/*
+Checks the signature of a download link with the key it names, so links stay
+valid across a key rotation until the old key retires. Links from before key
+IDs were added to them were signed with the legacy key.
+*/
+func verifyDownload(roomID util.UUID, attID util.UUID, expires int64, kid string, sigStr string) bool {
+	if kid == "" {
+		kid = crypto.LegacyKeyID
+	}
+	key, err := env.Keys.Get(kid, time.Now())
+	if err != nil {
+		return false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(sigStr)
+	if err != nil {
+		return false
+	}
+	sig, err := crypto.SignatureFromBytes(raw)
+	if err != nil {
+		return false
+	}
+	return crypto.Verify(key.Key.Public(), downloadPayload(roomID, attID, expires), sig)
+}
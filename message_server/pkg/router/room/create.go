@@ -47,11 +47,22 @@ func CreateRoomRoute(w http.ResponseWriter, r *http.Request) {
 	//Get the requestor's info
 	owner := r.Context().Value(mw.AuthCtxUserKey).(user.User) //This assert is safe
 
+	//Users who blocked the owner can't be put in the room; they're reported as missing
+	blockers, err := uc.BlockersOf(r.Context(), owner.ID, req.Participants)
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+	if len(blockers) > 0 {
+		util.ErrResponse(http.StatusNotFound, fmt.Errorf("no such user exists by UUID %s", blockers[0])).Respond(w)
+		return
+	}
+
 	//Create a new chat room
 	room := chatroom.NewRoom(owner.ID, req.Participants...)
 
 	//Save the chat room in the database
-	_, err := rc.InsertOne(r.Context(), room)
+	_, err = rc.InsertOne(r.Context(), room)
 	if err != nil {
 		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
 		return
@@ -0,0 +1,56 @@
+package room
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"wraith.me/message_server/pkg/http_types/request"
+	chatroom "wraith.me/message_server/pkg/schema/chat_room"
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+Handles incoming requests made to `POST /api/chat/room/{roomID}/disappearing`.
+Moderators and up may set how long messages sent to the room last before they
+disappear. Messages that were already sent keep the timer they were sent with.
+*/
+func SetDisappearingRoute(w http.ResponseWriter, r *http.Request) {
+	c := getManageCtx(w, r, chatroom.RoleMODERATOR, false)
+	if c == nil {
+		return
+	}
+
+	//Get the new timer
+	var req request.SetDisappearing
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !chatroom.ValidDisappearAfter(req.DisappearAfter) {
+		util.ErrResponse(
+			http.StatusBadRequest,
+			fmt.Errorf("the timer must be between 0 and %d seconds", int64(chatroom.MaxDisappearAfter.Seconds())),
+		).Respond(w)
+		return
+	}
+
+	//Nothing to do if the timer didn't change
+	if req.DisappearAfter == c.room.DisappearAfter {
+		util.PayloadOkResponse("disappearing messages timer unchanged", *c.room).Respond(w)
+		return
+	}
+
+	//Save the timer without touching the rest of the room
+	c.room.DisappearAfter = req.DisappearAfter
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "disappear_after", Value: c.room.DisappearAfter},
+		{Key: "updated_at", Value: time.Now()},
+	}}}
+	if err := rc.UpdateId(r.Context(), c.room.ID, update); err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+
+	//Let everyone in the room know and respond with the updated room
+	mel.AnnounceTimer(*c.room, c.caller.ID)
+	util.PayloadOkResponse("set disappearing messages timer", *c.room).Respond(w)
+}
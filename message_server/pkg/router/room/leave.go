@@ -44,6 +44,12 @@ func LeaveRoomRoute(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	//Direct conversations can't be left; they can only be deleted
+	if room.Direct {
+		util.ErrResponse(http.StatusForbidden, fmt.Errorf("direct conversations can't be left; delete it instead")).Respond(w)
+		return
+	}
+
 	//Remove the user from the participants list
 	room.RemoveMember(requestor.ID)
 
@@ -62,6 +68,12 @@ func LeaveRoomRoute(w http.ResponseWriter, r *http.Request) {
 		util.ErrResponse(http.StatusInternalServerError,
 			fmt.Errorf("failed to leave room with ID %s: %w", roomID, err),
 		).Respond(w)
+		return
+	}
+
+	//Let the remaining members know so they can rekey
+	if room.Size() > 0 {
+		mel.AnnounceRoster(room, nil, []util.UUID{requestor.ID})
 	}
 
 	//Respond back with the ID of the room that was left
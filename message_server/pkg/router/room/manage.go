@@ -1 +1,236 @@
 package room
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"wraith.me/message_server/pkg/mw"
+	chatmessage "wraith.me/message_server/pkg/schema/chat_message"
+	chatroom "wraith.me/message_server/pkg/schema/chat_room"
+	memberstate "wraith.me/message_server/pkg/schema/member_state"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+)
+
+// Represents the context of a management action on a room.
+type manageCtx struct {
+	//The room being managed.
+	room *chatroom.Room
+
+	//The user performing the action.
+	caller user.User
+
+	//The role of the user performing the action.
+	role chatroom.Role
+
+	//The ID of the user the action targets.
+	target util.UUID
+}
+
+/*
+Gets the room, the caller's role in it, and the target user of a management
+action. Only members of the room may manage it, and only members holding at
+least the given role. Responds with an error and returns `nil` otherwise.
+*/
+func getManageCtx(w http.ResponseWriter, r *http.Request, minRole chatroom.Role, needsTarget bool) *manageCtx {
+	//Get the room from the request params
+	room := getRoomFromQuery(w, r)
+	if room == nil {
+		return nil
+	}
+
+	//Get the requestor's role in the room
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+	role, ok := room.RoleOf(requestor.ID)
+	if !ok {
+		util.ErrResponse(http.StatusForbidden, fmt.Errorf("you are not a member of this room")).Respond(w)
+		return nil
+	}
+	if minRole.Outranks(role) {
+		util.ErrResponse(
+			http.StatusForbidden,
+			fmt.Errorf("you must be at least a %s of this room to do this", minRole),
+		).Respond(w)
+		return nil
+	}
+
+	//Get the target of the action; direct conversations always have the same two members
+	ctx := &manageCtx{room: room, caller: requestor, role: role}
+	if needsTarget {
+		if room.Direct {
+			util.ErrResponse(http.StatusForbidden, fmt.Errorf("the members of direct conversations can't be changed")).Respond(w)
+			return nil
+		}
+		var req struct {
+			UserID util.UUID `json:"user_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID.IsNil() {
+			util.ErrResponse(http.StatusBadRequest, fmt.Errorf("a valid user ID must be provided")).Respond(w)
+			return nil
+		}
+		if req.UserID == requestor.ID {
+			util.ErrResponse(http.StatusBadRequest, fmt.Errorf("you cannot do this to yourself")).Respond(w)
+			return nil
+		}
+		ctx.target = req.UserID
+	}
+	return ctx
+}
+
+// Gets the role of the target of a management action, responding with an error if they're not a member.
+func (c manageCtx) targetRole(w http.ResponseWriter) (chatroom.Role, bool) {
+	role, ok := c.room.RoleOf(c.target)
+	if !ok {
+		util.ErrResponse(http.StatusNotFound, fmt.Errorf("user %s is not a member of this room", c.target)).Respond(w)
+	}
+	return role, ok
+}
+
+/*
+Saves the room after a management action, lets everyone connected to it know
+what changed, and responds with the updated room.
+*/
+func (c manageCtx) save(w http.ResponseWriter, r *http.Request, added []util.UUID, removed []util.UUID, desc string) {
+	if _, err := rc.UpsertId(r.Context(), c.room.ID, c.room); err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+	mel.AnnounceRoster(*c.room, added, removed)
+	util.PayloadOkResponse(desc, *c.room).Respond(w)
+}
+
+// Handles incoming requests made to `POST /api/chat/room/{roomID}/invite`. Moderators and up may invite users.
+func InviteMemberRoute(w http.ResponseWriter, r *http.Request) {
+	c := getManageCtx(w, r, chatroom.RoleMODERATOR, true)
+	if c == nil {
+		return
+	}
+	if c.room.HasMember(c.target) {
+		util.ErrResponse(http.StatusConflict, fmt.Errorf("user %s is already a member of this room", c.target)).Respond(w)
+		return
+	}
+
+	//Ensure the user exists; users who blocked the caller are reported as missing
+	var target user.User
+	err := uc.FindID(r.Context(), c.target).One(&target)
+	if err == nil && target.HasBlocked(c.caller.ID) {
+		err = mongo.ErrNoDocuments
+	}
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			code = http.StatusNotFound
+			err = fmt.Errorf("no such user exists by UUID %s", c.target)
+		}
+		util.ErrResponse(code, err).Respond(w)
+		return
+	}
+
+	c.room.AddMember(c.target)
+	c.save(w, r, []util.UUID{c.target}, nil, fmt.Sprintf("added user %s to the room", c.target))
+}
+
+/*
+Handles incoming requests made to `POST /api/chat/room/{roomID}/kick`.
+Moderators may kick members, and owners may kick anyone. Kicked users are
+disconnected from the room immediately.
+*/
+func KickMemberRoute(w http.ResponseWriter, r *http.Request) {
+	c := getManageCtx(w, r, chatroom.RoleMODERATOR, true)
+	if c == nil {
+		return
+	}
+	trole, ok := c.targetRole(w)
+	if !ok {
+		return
+	}
+	if !c.role.Outranks(trole) {
+		util.ErrResponse(http.StatusForbidden, fmt.Errorf("you cannot kick a %s", trole)).Respond(w)
+		return
+	}
+
+	c.room.RemoveMember(c.target)
+	c.save(w, r, nil, []util.UUID{c.target}, fmt.Sprintf("kicked user %s from the room", c.target))
+}
+
+// Handles incoming requests made to `POST /api/chat/room/{roomID}/promote`. Only owners may promote members.
+func PromoteMemberRoute(w http.ResponseWriter, r *http.Request) {
+	changeRole(w, r, chatroom.RoleMEMBER, chatroom.RoleMODERATOR)
+}
+
+// Handles incoming requests made to `POST /api/chat/room/{roomID}/demote`. Only owners may demote moderators.
+func DemoteMemberRoute(w http.ResponseWriter, r *http.Request) {
+	changeRole(w, r, chatroom.RoleMODERATOR, chatroom.RoleMEMBER)
+}
+
+// Moves a member of a room from one role to another. Only owners may do this.
+func changeRole(w http.ResponseWriter, r *http.Request, from chatroom.Role, to chatroom.Role) {
+	c := getManageCtx(w, r, chatroom.RoleOWNER, true)
+	if c == nil {
+		return
+	}
+	trole, ok := c.targetRole(w)
+	if !ok {
+		return
+	}
+	if trole != from {
+		util.ErrResponse(
+			http.StatusConflict,
+			fmt.Errorf("user %s is a %s of this room; expected a %s", c.target, trole, from),
+		).Respond(w)
+		return
+	}
+
+	c.room.SetRole(c.target, to)
+	c.save(w, r, nil, nil, fmt.Sprintf("user %s is now a %s of the room", c.target, to))
+}
+
+/*
+Handles incoming requests made to `POST /api/chat/room/{roomID}/transfer`.
+Hands ownership of the room to another member; the previous owner becomes a
+moderator.
+*/
+func TransferOwnershipRoute(w http.ResponseWriter, r *http.Request) {
+	c := getManageCtx(w, r, chatroom.RoleOWNER, true)
+	if c == nil {
+		return
+	}
+	if _, ok := c.targetRole(w); !ok {
+		return
+	}
+
+	c.room.SetRole(c.target, chatroom.RoleOWNER)
+	c.room.SetRole(c.caller.ID, chatroom.RoleMODERATOR)
+	c.save(w, r, nil, nil, fmt.Sprintf("user %s is now the owner of the room", c.target))
+}
+
+/*
+Handles incoming requests made to `DELETE /api/chat/room/{roomID}`. Only
+owners may delete rooms. The room's history goes with it, and everyone that's
+connected to it is disconnected.
+*/
+func DeleteRoomRoute(w http.ResponseWriter, r *http.Request) {
+	c := getManageCtx(w, r, chatroom.RoleOWNER, false)
+	if c == nil {
+		return
+	}
+
+	//Remove the room, then everything that belongs to it
+	if err := rc.RemoveId(r.Context(), c.room.ID); err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+	if err := chatmessage.RemoveRoomMessages(r.Context(), blobs, c.room.ID); err != nil {
+		fmt.Printf("room: failed to remove the history of room %s: %s\n", c.room.ID, err)
+	}
+	if _, err := memberstate.GetCollection().RemoveAll(r.Context(), bson.D{{Key: "room_id", Value: c.room.ID}}); err != nil {
+		fmt.Printf("room: failed to remove the member states of room %s: %s\n", c.room.ID, err)
+	}
+
+	mel.AnnounceDeletion(*c.room)
+	util.OkResponse(fmt.Sprintf("deleted the chat room with ID %s", c.room.ID)).Respond(w)
+}
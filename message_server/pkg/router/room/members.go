@@ -10,7 +10,6 @@ import (
 	"wraith.me/message_server/pkg/mw"
 	"wraith.me/message_server/pkg/schema/user"
 	"wraith.me/message_server/pkg/util"
-	"wraith.me/message_server/pkg/ws/wschat"
 )
 
 // Handles incoming requests made to `GET /api/chat/room/{roomID}/members`.
@@ -41,18 +40,20 @@ func RoomMembersRoute(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	//Get the room info from the websocket server
-	wsRoom := wschat.GetInstance().GetRoom(room.ID)
+	//Get the members that are connected to the room on any node
+	online, err := mel.OnlineMembers(r.Context(), room.ID)
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+	onlineSet := make(map[util.UUID]bool, len(online))
+	for _, id := range online {
+		onlineSet[id] = true
+	}
 
 	//Construct the output room membership array
 	membershipInfo := make([]response.RoomMember, len(userInfo))
 	for i, uinfo := range userInfo {
-		//Get the online status for the current user
-		isOnline := false
-		if wsRoom != nil {
-			isOnline = wsRoom.HasUser(uinfo.ID)
-		}
-
 		//Construct the membership object
 		membershipInfo[i] = response.RoomMember{
 			ID:          uinfo.ID,
@@ -60,7 +61,7 @@ func RoomMembersRoute(w http.ResponseWriter, r *http.Request) {
 			DisplayName: uinfo.DisplayName,
 			IsMe:        uinfo.ID == requestor.ID,
 			Role:        room.Participants[uinfo.ID],
-			IsOnline:    isOnline,
+			IsOnline:    onlineSet[uinfo.ID],
 		}
 	}
 
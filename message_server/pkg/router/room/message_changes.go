@@ -0,0 +1,131 @@
+package room
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/qiniu/qmgo"
+	"wraith.me/message_server/pkg/http_types/request"
+	chatmessage "wraith.me/message_server/pkg/schema/chat_message"
+	chatroom "wraith.me/message_server/pkg/schema/chat_room"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+)
+
+// Handles incoming requests made to `PATCH /api/chat/room/{roomID}/messages/{msgID}`.
+func EditMessageRoute(w http.ResponseWriter, r *http.Request) {
+	//Get the room and message from the request params
+	room, msgID, requestor := getMessageCtx(w, r)
+	if room == nil {
+		return
+	}
+
+	//Get the new content of the message
+	var req request.EditMessage
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Content == "" {
+		util.ErrResponse(http.StatusBadRequest, fmt.Errorf("the new content of the message must be provided")).Respond(w)
+		return
+	}
+	if maxSize := cfg.Chat.MaxMessageSize; len(req.Content) > maxSize {
+		util.ErrResponse(
+			http.StatusRequestEntityTooLarge,
+			fmt.Errorf("message is %d bytes; the maximum is %d", len(req.Content), maxSize),
+		).Respond(w)
+		return
+	}
+
+	//Edit the message; only the sender may do so
+	msg, err := mc.Edit(r.Context(), room.ID, msgID, requestor.ID, req.Content)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, chatmessage.ErrNoSuchMessage) {
+			code = http.StatusNotFound
+			err = fmt.Errorf("no message %s exists that you can edit", msgID)
+		}
+		util.ErrResponse(code, err).Respond(w)
+		return
+	}
+
+	//Let everyone in the room know and respond with the edited message
+	mel.AnnounceMessageChange(*msg)
+	util.PayloadOkResponse("edited message", *msg).Respond(w)
+}
+
+// Handles incoming requests made to `DELETE /api/chat/room/{roomID}/messages/{msgID}`.
+func DeleteMessageRoute(w http.ResponseWriter, r *http.Request) {
+	//Get the room and message from the request params
+	room, msgID, requestor := getMessageCtx(w, r)
+	if room == nil {
+		return
+	}
+
+	//Get the message to see who sent it
+	var existing chatmessage.Message
+	err := mc.FindID(r.Context(), msgID).One(&existing)
+	if qmgo.IsErrNoDocuments(err) || (err == nil && existing.Room != room.ID) {
+		util.ErrResponse(http.StatusNotFound, fmt.Errorf("no message %s exists in this room", msgID)).Respond(w)
+		return
+	}
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+
+	//Users may delete their own messages; moderators and up may delete anyone's
+	role, _ := room.RoleOf(requestor.ID)
+	if existing.Sender != requestor.ID && chatroom.RoleMODERATOR.Outranks(role) {
+		util.ErrResponse(
+			http.StatusForbidden,
+			fmt.Errorf("you must be at least a %s of this room to delete others' messages", chatroom.RoleMODERATOR),
+		).Respond(w)
+		return
+	}
+
+	//Replace the message with a tombstone
+	msg, err := mc.Delete(r.Context(), room.ID, msgID, requestor.ID)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, chatmessage.ErrNoSuchMessage) {
+			code = http.StatusNotFound
+			err = fmt.Errorf("message %s was already deleted", msgID)
+		}
+		util.ErrResponse(code, err).Respond(w)
+		return
+	}
+
+	//Remove the files that were attached, unless they were passed along in other messages
+	if err := chatmessage.ReleaseAttachments(r.Context(), blobs, existing.Attachments); err != nil {
+		fmt.Printf("failed to remove the attachments of message %s: %s\n", msgID, err)
+	}
+
+	//Let everyone in the room know and respond with the tombstone
+	mel.AnnounceMessageChange(*msg)
+	util.PayloadOkResponse("deleted message", *msg).Respond(w)
+}
+
+/*
+Gets the room and the ID of the message that a request concerns, along with
+the requestor, who must be a member of the room. Responds with an error and
+returns a `nil` room otherwise.
+*/
+func getMessageCtx(w http.ResponseWriter, r *http.Request) (*chatroom.Room, util.UUID, user.User) {
+	//Only members of the room may see or change its messages
+	room, requestor := getMemberCtx(w, r)
+	if room == nil {
+		return nil, util.NilUUID(), requestor
+	}
+
+	//Get the ID of the message
+	msgID, err := util.ParseUUIDv7(chi.URLParam(r, "msgID"))
+	if err != nil {
+		util.ErrResponse(
+			http.StatusBadRequest,
+			fmt.Errorf("bad message ID format; it must be a UUIDv7"),
+		).Respond(w)
+		return nil, util.NilUUID(), requestor
+	}
+	return room, msgID, requestor
+}
@@ -0,0 +1,139 @@
+package room
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"wraith.me/message_server/pkg/db/qpage"
+	"wraith.me/message_server/pkg/http_types/response"
+	"wraith.me/message_server/pkg/mw"
+	chatmessage "wraith.me/message_server/pkg/schema/chat_message"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+Handles incoming requests made to `GET /api/chat/room/{roomID}/messages`.
+Messages are returned newest first. The optional `before` query param holds
+the ID of a message; only messages older than it are returned, which lets
+clients keep scrolling back through the history without pages shifting as
+new messages arrive.
+*/
+func RoomMessagesRoute(w http.ResponseWriter, r *http.Request) {
+	//Get the room from the request params
+	room := getRoomFromQuery(w, r)
+	if room == nil {
+		return
+	}
+
+	//Only members of the room may read its history
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+	if !room.HasMember(requestor.ID) {
+		util.ErrResponse(http.StatusForbidden, fmt.Errorf("you are not a member of this room")).Respond(w)
+		return
+	}
+
+	//Construct the search query
+	query := bson.D{{Key: "room_id", Value: room.ID}, chatmessage.Unexpired(time.Now())}
+	if blocked := requestor.BlockedIDs(); len(blocked) > 0 {
+		query = append(query, chatmessage.NotFrom(blocked))
+	}
+
+	//Add the cursor to the query if one was provided
+	if before := r.URL.Query().Get("before"); before != "" {
+		bid, err := util.ParseUUIDv7(before)
+		if err != nil {
+			util.ErrResponse(
+				http.StatusBadRequest,
+				fmt.Errorf("bad message ID format; it must be a UUIDv7"),
+			).Respond(w)
+			return
+		}
+		query = append(query, bson.E{Key: "_id", Value: bson.D{{Key: "$lt", Value: bid}}})
+	}
+
+	//Construct the pager object, sorting newest first
+	pager, err := qpage.NewQPage(mc.Collection)
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+	pager.Sort("_id", -1)
+
+	//Perform the paging query
+	messages := make([]chatmessage.Message, 0)
+	pagination, err := pager.Find(&messages, r.Context(), query, qpage.ParseQuery(r))
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+
+	//Wrap the messages and pagination and return the pagination data
+	out := response.NewPaginatedData(messages, *pagination)
+	util.PayloadOkResponse(out.Desc(), out).Respond(w)
+}
+
+/*
+Handles incoming requests made to `GET /api/chat/room/{roomID}/messages/{msgID}/thread`.
+Replies in the thread started by the message are returned oldest first. The
+optional `after` query param holds the ID of a reply; only replies newer
+than it are returned.
+*/
+func ThreadMessagesRoute(w http.ResponseWriter, r *http.Request) {
+	//Get the room and the root of the thread from the request params
+	room, rootID, requestor := getMessageCtx(w, r)
+	if room == nil {
+		return
+	}
+	if _, err := mc.GetInRoom(r.Context(), room.ID, rootID); err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, chatmessage.ErrNoSuchMessage) {
+			code = http.StatusNotFound
+			err = fmt.Errorf("no message %s exists in this room", rootID)
+		}
+		util.ErrResponse(code, err).Respond(w)
+		return
+	}
+
+	//Construct the search query
+	query := bson.D{{Key: "thread_root", Value: rootID}, chatmessage.Unexpired(time.Now())}
+	if blocked := requestor.BlockedIDs(); len(blocked) > 0 {
+		query = append(query, chatmessage.NotFrom(blocked))
+	}
+
+	//Add the cursor to the query if one was provided
+	if after := r.URL.Query().Get("after"); after != "" {
+		aid, err := util.ParseUUIDv7(after)
+		if err != nil {
+			util.ErrResponse(
+				http.StatusBadRequest,
+				fmt.Errorf("bad message ID format; it must be a UUIDv7"),
+			).Respond(w)
+			return
+		}
+		query = append(query, bson.E{Key: "_id", Value: bson.D{{Key: "$gt", Value: aid}}})
+	}
+
+	//Construct the pager object, sorting oldest first
+	pager, err := qpage.NewQPage(mc.Collection)
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+	pager.Sort("_id", 1)
+
+	//Perform the paging query
+	messages := make([]chatmessage.Message, 0)
+	pagination, err := pager.Find(&messages, r.Context(), query, qpage.ParseQuery(r))
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+
+	//Wrap the messages and pagination and return the pagination data
+	out := response.NewPaginatedData(messages, *pagination)
+	util.PayloadOkResponse(out.Desc(), out).Respond(w)
+}
@@ -0,0 +1,91 @@
+package room
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"wraith.me/message_server/pkg/http_types/response"
+	"wraith.me/message_server/pkg/mw"
+	memberstate "wraith.me/message_server/pkg/schema/member_state"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+Handles incoming requests made to `GET /api/chat/room/{roomID}/read_state`.
+Returns the requestor's delivery and read pointers, the number of unread
+messages, and the read pointers of the other members whose `ReadReceiptsScope`
+allows the requestor to see them.
+*/
+func RoomReadStateRoute(w http.ResponseWriter, r *http.Request) {
+	//Get the room from the request params
+	room := getRoomFromQuery(w, r)
+	if room == nil {
+		return
+	}
+
+	//Only members of the room may see its read state
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+	if !room.HasMember(requestor.ID) {
+		util.ErrResponse(http.StatusForbidden, fmt.Errorf("you are not a member of this room")).Respond(w)
+		return
+	}
+
+	//Get the states of all members of the room
+	states, err := memberstate.GetCollection().GetAll(r.Context(), room.ID)
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+
+	//Split the requestor's state from everyone else's
+	out := response.ReadState{Members: make([]response.ReadPointer, 0)}
+	others := make(map[util.UUID]memberstate.State)
+	for _, state := range states {
+		if state.User == requestor.ID {
+			out.Delivered = state.Delivered
+			out.Read = state.Read
+		} else if room.HasMember(state.User) {
+			others[state.User] = state
+		}
+	}
+
+	//Count the messages sent by others since the requestor's read pointer
+	out.Unread, err = mc.Find(r.Context(), bson.D{
+		{Key: "room_id", Value: room.ID},
+		{Key: "_id", Value: bson.D{{Key: "$gt", Value: out.Read}}},
+		{Key: "sender_id", Value: bson.D{{Key: "$ne", Value: requestor.ID}}},
+	}).Count()
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+
+	//Get the read receipt settings of the other members
+	if len(others) > 0 {
+		ids := make(bson.A, 0, len(others))
+		for id := range others {
+			ids = append(ids, id)
+		}
+		var members []user.User
+		err := uc.Find(r.Context(), bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}}).All(&members)
+		if err != nil {
+			util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+			return
+		}
+
+		//Only include the pointers of those who share their receipts with the requestor
+		for _, member := range members {
+			if member.SendsReadReceiptsTo(requestor.ID) {
+				out.Members = append(out.Members, response.ReadPointer{
+					ID:   member.ID,
+					Read: others[member.ID].Read,
+				})
+			}
+		}
+	}
+
+	//Return the read state
+	util.PayloadOkResponse("", out).Respond(w)
+}
@@ -2,9 +2,12 @@ package room
 
 import (
 	"github.com/go-chi/chi/v5"
+	"wraith.me/message_server/pkg/blob"
 	"wraith.me/message_server/pkg/config"
 	"wraith.me/message_server/pkg/globals"
 	"wraith.me/message_server/pkg/mw"
+	"wraith.me/message_server/pkg/schema/attachment"
+	chatmessage "wraith.me/message_server/pkg/schema/chat_message"
 	chatroom "wraith.me/message_server/pkg/schema/chat_room"
 	"wraith.me/message_server/pkg/schema/user"
 	"wraith.me/message_server/pkg/ws/wschat"
@@ -16,9 +19,21 @@ var (
 
 	rc *chatroom.RoomCollection
 
+	// Shared chat message collection across the entire package.
+	mc *chatmessage.MessageCollection
+
+	// Shared attachment collection across the entire package.
+	ac *attachment.AttachmentCollection
+
+	// Shared attachment store across the entire package.
+	blobs blob.BlobStore
+
 	// Shared config object across the entire package.
 	cfg *config.Config
 
+	// Shared attachment config across the entire package.
+	acfg *blob.BConfig
+
 	// Shared env object across the entire package.
 	env *config.Env
 
@@ -34,14 +49,19 @@ func RoomRoutes() chi.Router {
 	//Set the singletons for the entire package
 	uc = globals.UC
 	rc = globals.RC
+	mc = globals.MC
+	ac = globals.AC
+	blobs = globals.Blobs
 	cfg = globals.Cfg
+	acfg = &cfg.Attachments
 	env = globals.Env
 
 	//Start up Melody
 	mel = wschat.GetInstance()
+	mel.Configure(&cfg.Chat)
 
-	//Add routes (unauthenticated)
-	// (nada)
+	//Add routes (unauthenticated); downloads are authorized by their signed links instead
+	r.Get("/{roomID}/attachments/{attID}/download", DownloadAttachmentRoute)
 
 	//Add routes (authenticated)
 	r.Group(func(r chi.Router) {
@@ -52,9 +72,28 @@ func RoomRoutes() chi.Router {
 		r.Post("/create", CreateRoomRoute)
 		r.Get("/list", GetRoomsRoute)
 		r.Get("/{roomID}/members", RoomMembersRoute)
+		r.Get("/{roomID}/messages", RoomMessagesRoute)
+		r.Patch("/{roomID}/messages/{msgID}", EditMessageRoute)
+		r.Delete("/{roomID}/messages/{msgID}", DeleteMessageRoute)
+		r.Get("/{roomID}/messages/{msgID}/thread", ThreadMessagesRoute)
+		r.Get("/{roomID}/read_state", RoomReadStateRoute)
+		r.Post("/{roomID}/attachments", CreateAttachmentRoute)
+		r.Get("/{roomID}/attachments/{attID}", GetAttachmentRoute)
+		r.Put("/{roomID}/attachments/{attID}/chunks/{index}", UploadChunkRoute)
+		r.Post("/{roomID}/attachments/{attID}/complete", CompleteAttachmentRoute)
+		r.Get("/{roomID}/attachments/{attID}/url", AttachmentURLRoute)
 		r.Get("/{roomID}", JoinRoomRoute) //TODO: add `/join`
 		r.Post("/{roomID}/leave", LeaveRoomRoute)
 		r.Get("/{roomID}/add", AddRoomRoute)
+
+		//Management routes; each checks the caller's role in the room
+		r.Post("/{roomID}/invite", InviteMemberRoute)
+		r.Post("/{roomID}/kick", KickMemberRoute)
+		r.Post("/{roomID}/promote", PromoteMemberRoute)
+		r.Post("/{roomID}/demote", DemoteMemberRoute)
+		r.Post("/{roomID}/transfer", TransferOwnershipRoute)
+		r.Post("/{roomID}/disappearing", SetDisappearingRoute)
+		r.Delete("/{roomID}", DeleteRoomRoute)
 	})
 
 	//Return the router
@@ -6,7 +6,9 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/qiniu/qmgo"
+	"wraith.me/message_server/pkg/mw"
 	chatroom "wraith.me/message_server/pkg/schema/chat_room"
+	"wraith.me/message_server/pkg/schema/user"
 	"wraith.me/message_server/pkg/util"
 )
 
@@ -42,3 +44,20 @@ func getRoomFromQuery(w http.ResponseWriter, r *http.Request) *chatroom.Room {
 	//Return the room object
 	return &room
 }
+
+/*
+Gets the room that a request concerns, along with the requestor, who must be
+a member of it. Responds with an error and returns a `nil` room otherwise.
+*/
+func getMemberCtx(w http.ResponseWriter, r *http.Request) (*chatroom.Room, user.User) {
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+	room := getRoomFromQuery(w, r)
+	if room == nil {
+		return nil, requestor
+	}
+	if !room.HasMember(requestor.ID) {
+		util.ErrResponse(http.StatusForbidden, fmt.Errorf("you are not a member of this room")).Respond(w)
+		return nil, requestor
+	}
+	return room, requestor
+}
@@ -0,0 +1,121 @@
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"wraith.me/message_server/pkg/db/mongoutil"
+	"wraith.me/message_server/pkg/http_types/response"
+	"wraith.me/message_server/pkg/mw"
+	friendrequest "wraith.me/message_server/pkg/schema/friend_request"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+	"wraith.me/message_server/pkg/ws/wschat"
+)
+
+/*
+Handles incoming requests made to `POST /api/user/block`. Blocks another
+user, which ends any friendship between the two and drops the friend request
+between them, if one is pending. Blocked users can't befriend, message or
+invite the requestor, can't find them, and their messages are hidden from
+the requestor in the rooms they share. They aren't told about the block.
+*/
+func BlockUserRoute(w http.ResponseWriter, r *http.Request) {
+	requestor, target := getBlockTarget(w, r)
+	if target.IsNil() {
+		return
+	}
+
+	//Ensure the user exists
+	if n, err := uc.Find(r.Context(), bson.D{{Key: "_id", Value: target}}).Count(); err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	} else if n == 0 {
+		util.ErrResponse(http.StatusNotFound, fmt.Errorf("no such user exists by UUID %s", target)).Respond(w)
+		return
+	}
+
+	if err := uc.Block(r.Context(), requestor.ID, target); err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+
+	//Drop the pending friend request between the two, whoever sent it
+	frq, err := frc.GetPending(r.Context(), requestor.ID, target)
+	if err != nil {
+		fmt.Printf("block: failed to get the friend request between %s and %s: %s\n", requestor.ID, target, err)
+	} else if frq != nil {
+		to := util.If(frq.Sender == requestor.ID, friendrequest.StateCANCELLED, friendrequest.StateREJECTED)
+		if _, err := frc.Transition(r.Context(), frq.ID, friendrequest.StatePENDING, to); err != nil {
+			fmt.Printf("block: failed to drop friend request %s: %s\n", frq.ID, err)
+		}
+	}
+
+	announceBlock(requestor.ID, target, true)
+	util.OkResponse(fmt.Sprintf("blocked user %s", target)).Respond(w)
+}
+
+// Handles incoming requests made to `POST /api/user/unblock`. Lifts a block the requestor placed on another user.
+func UnblockUserRoute(w http.ResponseWriter, r *http.Request) {
+	requestor, target := getBlockTarget(w, r)
+	if target.IsNil() {
+		return
+	}
+	if !requestor.HasBlocked(target) {
+		util.ErrResponse(http.StatusNotFound, fmt.Errorf("you haven't blocked user %s", target)).Respond(w)
+		return
+	}
+
+	if err := uc.Unblock(r.Context(), requestor.ID, target); err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+
+	announceBlock(requestor.ID, target, false)
+	util.OkResponse(fmt.Sprintf("unblocked user %s", target)).Respond(w)
+}
+
+// Handles incoming requests made to `GET /api/user/blocks`. Lists the users the requestor blocked.
+func ListBlocksRoute(w http.ResponseWriter, r *http.Request) {
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+	blocked := make([]response.UInfo, 0)
+	if len(requestor.Blocked) > 0 {
+		aggregation := bson.A{
+			bson.D{{Key: "$match", Value: bson.D{
+				{Key: "_id", Value: bson.D{{Key: "$in", Value: mongoutil.Slice2BsonA(requestor.BlockedIDs())}}},
+			}}},
+			user.PublicQuery,
+		}
+		if err := uc.Aggregate(r.Context(), aggregation).All(&blocked); err != nil {
+			util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+			return
+		}
+	}
+	util.PayloadOkResponse("", blocked...).Respond(w)
+}
+
+// Gets the user whose ID is in the request body, responding with an error and returning a nil ID if it's missing.
+func getBlockTarget(w http.ResponseWriter, r *http.Request) (user.User, util.UUID) {
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+	var req struct {
+		UserID util.UUID `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID.IsNil() {
+		util.ErrResponse(http.StatusBadRequest, fmt.Errorf("a valid user ID must be provided")).Respond(w)
+		return requestor, util.UUID{}
+	}
+	if req.UserID == requestor.ID {
+		util.ErrResponse(http.StatusBadRequest, fmt.Errorf("you cannot block yourself")).Respond(w)
+		return requestor, util.UUID{}
+	}
+	return requestor, req.UserID
+}
+
+// Tells the chat servers about a block, so it applies to connections that are already open. Failures are logged, not returned.
+func announceBlock(blocker util.UUID, blocked util.UUID, block bool) {
+	if err := wschat.GetInstance().AnnounceBlock(blocker, blocked, block); err != nil {
+		fmt.Printf("block: failed to announce block of %s by %s: %s\n", blocked, blocker, err)
+	}
+}
@@ -0,0 +1,149 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"wraith.me/message_server/pkg/controller/cauth"
+	"wraith.me/message_server/pkg/controller/csolver"
+	"wraith.me/message_server/pkg/crypto"
+	"wraith.me/message_server/pkg/http_types/response"
+	"wraith.me/message_server/pkg/mw"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+)
+
+// The maximum length of a device label, in characters.
+const maxDeviceLabelLen = 32
+
+// Handles incoming requests made to `GET /api/user/devices`. Lists the requestor's device keys, oldest first.
+func ListDevicesRoute(w http.ResponseWriter, r *http.Request) {
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+	devices := make([]response.DeviceKey, 0, len(requestor.DeviceKeys()))
+	for fp, dev := range requestor.DeviceKeys() {
+		devices = append(devices, response.NewDeviceKey(requestor, fp, dev))
+	}
+	slices.SortFunc(devices, func(a, b response.DeviceKey) int { return a.Created.Compare(b.Created) })
+	util.PayloadOkResponse("", devices...).Respond(w)
+}
+
+/*
+Handles incoming requests made to `POST /api/user/devices/challenge`. This is
+stage 1 of adding a device key. Issues a challenge for the new key, which must
+be signed by one of the requestor's existing device keys.
+*/
+func DeviceChallengeRoute(w http.ResponseWriter, r *http.Request) {
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+	var req csolver.DeviceChallenge
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.ErrResponse(http.StatusBadRequest, err).Respond(w)
+		return
+	}
+	if req.PK.Equal(crypto.NilPubkey()) {
+		util.ErrResponse(http.StatusBadRequest, fmt.Errorf("a valid public key must be provided")).Respond(w)
+		return
+	}
+	if requestor.HasDeviceKey(req.PK) {
+		util.ErrResponse(http.StatusConflict, fmt.Errorf("key %s is already one of your device keys", req.PK.Fingerprint())).Respond(w)
+		return
+	}
+
+	util.PayloadOkResponse(
+		"",
+		response.LoginReq{Token: csolver.IssueDeviceChallenge(requestor, req.PK, env)},
+	).Respond(w)
+}
+
+/*
+Handles incoming requests made to `POST /api/user/devices`. This is stage 2 of
+adding a device key. The challenge from stage 1 must be signed by one of the
+requestor's existing device keys, which vouches for the new one.
+*/
+func AddDeviceRoute(w http.ResponseWriter, r *http.Request) {
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+	var req csolver.DeviceAdd
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.ErrResponse(http.StatusBadRequest, err).Respond(w)
+		return
+	}
+	req.Label = strings.TrimSpace(req.Label)
+	if req.Label == "" || utf8.RuneCountInString(req.Label) > maxDeviceLabelLen {
+		util.ErrResponse(http.StatusBadRequest, fmt.Errorf("the device label must be 1-%d characters long", maxDeviceLabelLen)).Respond(w)
+		return
+	}
+
+	//Verify the challenge
+	//After this point, the new key is considered vouched for by the user
+	if _, err := csolver.VerifyDeviceChallenge(requestor, req, env, r); err != nil {
+		util.ErrResponse(http.StatusForbidden, err).Respond(w)
+		return
+	}
+
+	dev := user.DeviceKey{Key: req.PK, Label: req.Label, Created: util.NowMillis()}
+	if err := uc.AddDevice(r.Context(), &requestor, dev); errors.Is(err, user.ErrDeviceExists) {
+		util.ErrResponse(http.StatusConflict, err).Respond(w)
+		return
+	} else if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+
+	fp := req.PK.Fingerprint()
+	util.PayloadResponse(
+		http.StatusCreated,
+		fmt.Sprintf("added device key %s", fp),
+		response.NewDeviceKey(requestor, fp, dev),
+	).Respond(w)
+}
+
+/*
+Handles incoming requests made to `DELETE /api/user/devices/{fid}`. Revokes one
+of the requestor's device keys by its fingerprint, so it can no longer be used
+to log in. If it was the requestor's identity key, their most recently used
+remaining key becomes their identity key, and their prekeys are dropped, since
+they were signed by the old one. Sessions that were opened with the revoked key
+are ended too, along with their connections.
+*/
+func RevokeDeviceRoute(w http.ResponseWriter, r *http.Request) {
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+	fp := chi.URLParam(r, "fid")
+
+	rotated, err := uc.RevokeDevice(r.Context(), &requestor, fp)
+	switch {
+	case errors.Is(err, user.ErrDeviceNotFound):
+		util.ErrResponse(http.StatusNotFound, err).Respond(w)
+		return
+	case errors.Is(err, user.ErrLastDevice):
+		util.ErrResponse(http.StatusConflict, err).Respond(w)
+		return
+	case err != nil:
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+
+	//End the sessions that were opened with the key; the user is re-read since their tokens may have been rotated
+	var current user.User
+	if err := uc.FindID(r.Context(), requestor.ID).One(&current); err != nil {
+		fmt.Printf("devices: failed to get the sessions of revoked device key %s of user %s: %s\n", fp, requestor.ID, err)
+	} else if err := cauth.EndSessions(r.Context(), uc, requestor.ID, current.DeviceFamilies(fp)...); err != nil {
+		fmt.Printf("devices: failed to end the sessions of revoked device key %s of user %s: %s\n", fp, requestor.ID, err)
+	}
+
+	if rotated {
+		if err := pkc.RemoveAllOf(r.Context(), requestor.ID); err != nil {
+			fmt.Printf("devices: failed to drop the prekeys of user %s after their identity key changed: %s\n", requestor.ID, err)
+		}
+		util.OkResponse(fmt.Sprintf(
+			"revoked device key %s; your identity key is now %s, so your prekeys must be uploaded again",
+			fp, requestor.Pubkey.Fingerprint(),
+		)).Respond(w)
+		return
+	}
+	util.OkResponse(fmt.Sprintf("revoked device key %s", fp)).Respond(w)
+}
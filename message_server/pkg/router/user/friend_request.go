@@ -1 +1,239 @@
 package user
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"wraith.me/message_server/pkg/amqp"
+	"wraith.me/message_server/pkg/http_types/response"
+	"wraith.me/message_server/pkg/mw"
+	"wraith.me/message_server/pkg/obj/notification"
+	friendrequest "wraith.me/message_server/pkg/schema/friend_request"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+Handles incoming requests made to `POST /api/user/friend_request/new`. Sends a
+friend request to another user, unless the two are already friends or a
+request between them is already pending.
+*/
+func NewFriendRequestRoute(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID util.UUID `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID.IsNil() {
+		util.ErrResponse(http.StatusBadRequest, fmt.Errorf("a valid user ID must be provided")).Respond(w)
+		return
+	}
+
+	//Users can't befriend themselves or their existing friends
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+	if req.UserID == requestor.ID {
+		util.ErrResponse(http.StatusBadRequest, fmt.Errorf("you cannot send a friend request to yourself")).Respond(w)
+		return
+	}
+	if requestor.IsFriend(req.UserID) {
+		util.ErrResponse(http.StatusConflict, fmt.Errorf("you are already friends with user %s", req.UserID)).Respond(w)
+		return
+	}
+
+	//Ensure the recipient exists; users who blocked the requestor are reported as missing
+	var recipient user.User
+	err := uc.FindID(r.Context(), req.UserID).One(&recipient)
+	if err == nil && recipient.HasBlocked(requestor.ID) {
+		err = mongo.ErrNoDocuments
+	}
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			code = http.StatusNotFound
+			err = fmt.Errorf("no such user exists by UUID %s", req.UserID)
+		}
+		util.ErrResponse(code, err).Respond(w)
+		return
+	}
+	if requestor.HasBlocked(recipient.ID) {
+		util.ErrResponse(http.StatusForbidden, fmt.Errorf("you blocked user %s; unblock them first", recipient.ID)).Respond(w)
+		return
+	}
+
+	//Clear out stale requests so they don't count as duplicates
+	if _, err := frc.ExpireStale(r.Context(), requestor.ID); err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+
+	//Point the user at the other side's request if there is one
+	existing, err := frc.GetPending(r.Context(), requestor.ID, recipient.ID)
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+	if existing != nil && existing.Sender == recipient.ID {
+		util.ErrResponse(
+			http.StatusConflict,
+			fmt.Errorf("user %s already sent you a friend request (%s); accept it instead", recipient.ID, existing.ID),
+		).Respond(w)
+		return
+	}
+
+	//Store the request
+	frq := friendrequest.NewFriendRequest(requestor.ID, recipient.ID)
+	if err := frc.Create(r.Context(), frq); err != nil {
+		code := util.If(errors.Is(err, friendrequest.ErrDuplicateRequest), http.StatusConflict, http.StatusInternalServerError)
+		util.ErrResponse(code, err).Respond(w)
+		return
+	}
+
+	emitFRQEvent(r.Context(), amqp.KeyFRQ_CREATED, frq, notification.OutgoingFRQNotif(requestor, recipient.ID))
+	util.PayloadOkResponse(fmt.Sprintf("sent a friend request to user %s", recipient.ID), frq).Respond(w)
+}
+
+/*
+Handles incoming requests made to `POST /api/user/friend_request/accept`. Only
+the recipient of a request may accept it, which makes both users friends.
+*/
+func AcceptFriendRequestRoute(w http.ResponseWriter, r *http.Request) {
+	frq, requestor := getFRQFromBody(w, r, true)
+	if frq == nil {
+		return
+	}
+	if !transitionFRQ(w, r, frq, friendrequest.StateACCEPTED) {
+		return
+	}
+
+	//Befriend both users, putting the request back if that fails
+	if err := uc.AddFriendship(r.Context(), frq.Sender, frq.Recipient); err != nil {
+		if _, rerr := frc.Transition(r.Context(), frq.ID, friendrequest.StateACCEPTED, friendrequest.StatePENDING); rerr != nil {
+			fmt.Printf("frq: failed to restore request %s: %s\n", frq.ID, rerr)
+		}
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+
+	emitFRQEvent(r.Context(), amqp.KeyFRQ_ACCEPTED, *frq, notification.FRQAcceptNotif(requestor, frq.Sender))
+	util.PayloadOkResponse(fmt.Sprintf("you are now friends with user %s", frq.Sender), *frq).Respond(w)
+}
+
+// Handles incoming requests made to `POST /api/user/friend_request/deny`. Only the recipient of a request may deny it.
+func DenyFriendRequestRoute(w http.ResponseWriter, r *http.Request) {
+	frq, requestor := getFRQFromBody(w, r, true)
+	if frq == nil {
+		return
+	}
+	if !transitionFRQ(w, r, frq, friendrequest.StateREJECTED) {
+		return
+	}
+
+	emitFRQEvent(r.Context(), amqp.KeyFRQ_REJECTED, *frq, notification.FRQRejectNotif(requestor, frq.Sender))
+	util.PayloadOkResponse(fmt.Sprintf("denied the friend request from user %s", frq.Sender), *frq).Respond(w)
+}
+
+// Handles incoming requests made to `POST /api/user/friend_request/cancel`. Only the sender of a request may cancel it.
+func CancelFriendRequestRoute(w http.ResponseWriter, r *http.Request) {
+	frq, requestor := getFRQFromBody(w, r, false)
+	if frq == nil {
+		return
+	}
+	if !transitionFRQ(w, r, frq, friendrequest.StateCANCELLED) {
+		return
+	}
+
+	emitFRQEvent(r.Context(), amqp.KeyFRQ_CANCELLED, *frq, notification.FRQCancelNotif(requestor, frq.Recipient))
+	util.PayloadOkResponse(fmt.Sprintf("cancelled the friend request to user %s", frq.Recipient), *frq).Respond(w)
+}
+
+// Handles incoming requests made to `GET /api/user/friend_request/list`. Lists the requestor's pending requests.
+func ListFriendRequestsRoute(w http.ResponseWriter, r *http.Request) {
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+	if _, err := frc.ExpireStale(r.Context(), requestor.ID); err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+	frqs, err := frc.ListPending(r.Context(), requestor.ID)
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+
+	//Split the requests by direction
+	list := response.FriendRequestList{
+		Incoming: make([]friendrequest.FriendRequest, 0),
+		Outgoing: make([]friendrequest.FriendRequest, 0),
+	}
+	for _, frq := range frqs {
+		if frq.Recipient == requestor.ID {
+			list.Incoming = append(list.Incoming, frq)
+		} else {
+			list.Outgoing = append(list.Outgoing, frq)
+		}
+	}
+	util.PayloadOkResponse("", list).Respond(w)
+}
+
+/*
+Gets the friend request whose ID is in the request body, ensuring that the
+requestor is its recipient (or its sender if `asRecipient` is false).
+Responds with an error and returns `nil` otherwise.
+*/
+func getFRQFromBody(w http.ResponseWriter, r *http.Request, asRecipient bool) (*friendrequest.FriendRequest, user.User) {
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+	var req struct {
+		ID util.UUID `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID.IsNil() {
+		util.ErrResponse(http.StatusBadRequest, fmt.Errorf("a valid friend request ID must be provided")).Respond(w)
+		return nil, requestor
+	}
+
+	//Get the request; others' requests are reported as missing
+	frq, err := frc.Get(r.Context(), req.ID)
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return nil, requestor
+	}
+	if frq == nil || !frq.Involves(requestor.ID) {
+		util.ErrResponse(http.StatusNotFound, fmt.Errorf("no such friend request exists by UUID %s", req.ID)).Respond(w)
+		return nil, requestor
+	}
+
+	//Ensure the requestor is on the right side of the request
+	if (frq.Recipient == requestor.ID) != asRecipient {
+		util.ErrResponse(
+			http.StatusForbidden,
+			fmt.Errorf("only the %s of a friend request can do this", util.If(asRecipient, "recipient", "sender")),
+		).Respond(w)
+		return nil, requestor
+	}
+	return frq, requestor
+}
+
+// Moves a pending friend request to a new state, responding with an error if it's no longer pending.
+func transitionFRQ(w http.ResponseWriter, r *http.Request, frq *friendrequest.FriendRequest, to friendrequest.State) bool {
+	ok, err := frc.Transition(r.Context(), frq.ID, friendrequest.StatePENDING, to)
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return false
+	}
+	if !ok {
+		util.ErrResponse(http.StatusConflict, fmt.Errorf("friend request %s is no longer pending", frq.ID)).Respond(w)
+		return false
+	}
+	frq.State = to
+	return true
+}
+
+// Publishes a friend request event and its accompanying notification. Failures are logged, not returned.
+func emitFRQEvent(ctx context.Context, key string, frq friendrequest.FriendRequest, notif notification.Notification) {
+	if err := amqp.GetBus().Publish(ctx, amqp.ExchangeFRIEND_REQUEST, key, frq); err != nil {
+		fmt.Printf("frq: failed to publish %s event: %s\n", key, err)
+	}
+	if err := notification.Dispatch(ctx, notif); err != nil {
+		fmt.Printf("frq: failed to dispatch notification for %s: %s\n", frq.ID, err)
+	}
+}
@@ -31,9 +31,13 @@ func HandleInfoRoute(w http.ResponseWriter, r *http.Request) {
 		query = bson.E{Key: "_id", Value: uid}
 	}
 
-	//Run the query
+	//Run the query; users who blocked the requestor are reported as missing
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
 	var user user.User
 	err = uc.Find(r.Context(), bson.D{query}).One(&user)
+	if err == nil && user.HasBlocked(requestor.ID) {
+		err = mongo.ErrNoDocuments
+	}
 
 	//Check if something went wrong during the query
 	if err != nil {
@@ -0,0 +1,50 @@
+package user
+
+import (
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/exp/maps"
+	"wraith.me/message_server/pkg/db/mongoutil"
+	"wraith.me/message_server/pkg/http_types/ws/chat"
+	"wraith.me/message_server/pkg/mw"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+	"wraith.me/message_server/pkg/ws/wschat"
+)
+
+// Handles incoming requests made to `GET /api/user/presence`.
+func FriendsPresenceRoute(w http.ResponseWriter, r *http.Request) {
+	//Get the requestor's info
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+	if len(requestor.Friends) == 0 {
+		util.PayloadOkResponse("", []chat.Presence{}...).Respond(w)
+		return
+	}
+
+	//Get the friends of the requestor that haven't hidden their presence
+	var visible []struct {
+		ID util.UUID `bson:"_id"`
+	}
+	filter := bson.D{
+		{Key: "_id", Value: bson.D{{Key: "$in", Value: mongoutil.Slice2BsonA(maps.Keys(requestor.Friends))}}},
+		{Key: "options.hide_presence", Value: bson.D{{Key: "$ne", Value: true}}},
+	}
+	err := uc.Find(r.Context(), filter).Select(bson.M{"_id": 1}).All(&visible)
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+	ids := make([]util.UUID, len(visible))
+	for i, friend := range visible {
+		ids[i] = friend.ID
+	}
+
+	//Get the presence of those friends from across the cluster
+	presence, err := wschat.GetInstance().GetPresence(r.Context(), ids...)
+	if err != nil {
+		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
+		return
+	}
+	util.PayloadOkResponse("", presence...).Respond(w)
+}
@@ -5,6 +5,8 @@ import (
 	"wraith.me/message_server/pkg/config"
 	"wraith.me/message_server/pkg/globals"
 	"wraith.me/message_server/pkg/mw"
+	friendrequest "wraith.me/message_server/pkg/schema/friend_request"
+	"wraith.me/message_server/pkg/schema/prekey"
 	"wraith.me/message_server/pkg/schema/user"
 )
 
@@ -12,6 +14,12 @@ var (
 	// Shared user collection across the entire package.
 	uc *user.UserCollection
 
+	// Shared friend request collection across the entire package.
+	frc *friendrequest.FriendRequestCollection
+
+	// Shared prekey collection across the entire package.
+	pkc *prekey.PrekeyCollection
+
 	// Shared config object across the entire package.
 	cfg *config.Config
 
@@ -26,6 +34,8 @@ func UserRoutes() chi.Router {
 
 	//Set the singletons for the entire package
 	uc = globals.UC
+	frc = globals.FRC
+	pkc = globals.PKC
 	cfg = globals.Cfg
 	env = globals.Env
 
@@ -37,6 +47,7 @@ func UserRoutes() chi.Router {
 		r.Use(mw.NewAuthMiddleware(env))
 
 		//Info
+		r.Get("/presence", FriendsPresenceRoute)
 		r.Get("/{uid}", HandleInfoRoute)
 		r.Get("/me", HandleMyInfoRoute)
 		r.Get("/", HandleMyInfoRoute)
@@ -44,17 +55,26 @@ func UserRoutes() chi.Router {
 		//Settings editing
 		r.Patch("/username", ChangeUnameRoute)
 
+		//Blocking
+		r.Post("/block", BlockUserRoute)
+		r.Post("/unblock", UnblockUserRoute)
+		r.Get("/blocks", ListBlocksRoute)
+
+		//Device keys
+		r.Get("/devices", ListDevicesRoute)
+		r.Post("/devices/challenge", DeviceChallengeRoute)
+		r.Post("/devices", AddDeviceRoute)
+		r.Delete("/devices/{fid}", RevokeDeviceRoute)
+
 		//Add friend request routes (authenticated)
 		frr := chi.NewRouter()
 		frr.Group(func(r chi.Router) {
 			r.Group(func(r chi.Router) {
-				//TODO: impl
-				/*
-					r.Post("/new", e)
-					r.Post("/accept", e)
-					r.Post("/deny", e)
-					r.Get("/list", e)
-				*/
+				r.Post("/new", NewFriendRequestRoute)
+				r.Post("/accept", AcceptFriendRequestRoute)
+				r.Post("/deny", DenyFriendRequestRoute)
+				r.Post("/cancel", CancelFriendRequestRoute)
+				r.Get("/list", ListFriendRequestsRoute)
 			})
 		})
 		r.Mount("/friend_request", frr)
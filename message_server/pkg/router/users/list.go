@@ -6,6 +6,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"wraith.me/message_server/pkg/db/qpage"
 	"wraith.me/message_server/pkg/http_types/response"
+	"wraith.me/message_server/pkg/mw"
 	"wraith.me/message_server/pkg/schema/user"
 	"wraith.me/message_server/pkg/util"
 )
@@ -22,9 +23,13 @@ func UserListRoute(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	//Construct the aggregate query and perform the paging query
+	//Construct the aggregate query and perform the paging query; users who blocked the requestor are left out
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
 	users := make([]response.UInfo, 0)
-	query := bson.A{user.PublicQuery}
+	query := bson.A{
+		bson.D{{Key: "$match", Value: bson.D{user.NotBlocking(requestor.ID)}}},
+		user.PublicQuery,
+	}
 	pagination, err := pager.Aggregate(&users, r.Context(), query, pagingParams)
 	if err != nil {
 		util.ErrResponse(http.StatusInternalServerError, err).Respond(w)
@@ -0,0 +1,23 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+
+	"wraith.me/message_server/pkg/mw"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/ws/wschat"
+)
+
+/*
+Handles incoming requests made to `GET /api/ws`. Upgrades the connection to a
+multiplexed WebSocket, over which the requestor can subscribe to any number
+of their rooms and receives events that are scoped to them, such as new
+notifications. Clients that get notifications from `/api/notifications/live`
+instead may pass `?notifications=false` so they don't get them twice.
+*/
+func ConnectRoute(w http.ResponseWriter, r *http.Request) {
+	requestor := r.Context().Value(mw.AuthCtxUserKey).(user.User)
+	r = r.WithContext(context.WithValue(r.Context(), wschat.WSMuxCtxObjKey, requestor.ID))
+	mel.GetMuxMelody().HandleRequest(w, r)
+}
@@ -0,0 +1,36 @@
+package ws
+
+import (
+	"github.com/go-chi/chi/v5"
+	"wraith.me/message_server/pkg/config"
+	"wraith.me/message_server/pkg/globals"
+	"wraith.me/message_server/pkg/mw"
+	"wraith.me/message_server/pkg/ws/wschat"
+)
+
+var (
+	// Shared env object across the entire package.
+	env *config.Env
+
+	// Shared chat server across the entire package.
+	mel *wschat.Server
+)
+
+// Sets up routes for the `/api/ws` endpoint.
+func WSRoutes() chi.Router {
+	//Create the router
+	r := chi.NewRouter()
+
+	//Set the singletons for the entire package
+	env = globals.Env
+	mel = wschat.GetInstance()
+
+	//Add routes (authenticated)
+	r.Group(func(r chi.Router) {
+		r.Use(mw.NewAuthMiddleware(env))
+		r.Get("/", ConnectRoute)
+	})
+
+	//Return the router
+	return r
+}
@@ -0,0 +1,153 @@
+package attachment
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"wraith.me/message_server/pkg/blob"
+	"wraith.me/message_server/pkg/util"
+)
+
+// Returned when the assembled file doesn't match the hash it was uploaded with.
+var ErrHashMismatch = errors.New("the content of the file doesn't match its hash")
+
+/*
+Joins the chunks of an upload into a single blob, checking the result against
+the file's hash as it's written. The chunks are removed once they've been
+joined; if the hash doesn't match, the blob is removed as well, and the file
+has to be sent again. Other failures leave the chunks be, so joining them can
+be retried.
+*/
+func Assemble(ctx context.Context, store blob.BlobStore, att Attachment) error {
+	chunks := &chunkReader{ctx: ctx, store: store, att: att}
+	defer chunks.Close()
+
+	hash := sha256.New()
+	n, err := store.Put(ctx, att.BlobKey(), io.TeeReader(chunks, hash))
+	if err == nil && (n != att.Size || hex.EncodeToString(hash.Sum(nil)) != att.Hash) {
+		err = ErrHashMismatch
+	}
+	if err != nil {
+		store.Delete(ctx, att.BlobKey())
+		if !errors.Is(err, ErrHashMismatch) {
+			return err
+		}
+	}
+
+	if derr := DiscardChunks(ctx, store, att); derr != nil {
+		fmt.Printf("attachment: failed to discard the chunks of %s: %s\n", att.ID, derr)
+	}
+	return err
+}
+
+// Removes the chunks of an upload from a store.
+func DiscardChunks(ctx context.Context, store blob.BlobStore, att Attachment) error {
+	for i := 0; i < att.Chunks(); i++ {
+		if err := store.Delete(ctx, att.ChunkKey(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Removes the uploads that were started before the given time and never
+finished, along with their chunks. Returns how many were removed.
+*/
+func PurgeStale(ctx context.Context, store blob.BlobStore, before time.Time) (int, error) {
+	ac := GetCollection()
+	stale, err := ac.FindStale(ctx, before, purgeBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, att := range stale {
+		if err := DiscardChunks(ctx, store, att); err != nil {
+			return removed, err
+		}
+		if err := ac.RemoveId(ctx, att.ID); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+/*
+Removes finished files from a store along with their records, such as once
+the messages they were attached to are gone. IDs that don't belong to any
+file are skipped.
+*/
+func Discard(ctx context.Context, store blob.BlobStore, ids []util.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	ac := GetCollection()
+	atts := make([]Attachment, 0, len(ids))
+	if err := ac.Find(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}}).All(&atts); err != nil {
+		return err
+	}
+
+	for _, att := range atts {
+		//Remove the file before its record, so a failure doesn't leave a file that nothing points to
+		if err := store.Delete(ctx, att.BlobKey()); err != nil {
+			return err
+		}
+		if err := ac.RemoveId(ctx, att.ID); err != nil && !qmgo.IsErrNoDocuments(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads the chunks of an upload back to back, opening each one only once it's needed.
+type chunkReader struct {
+	ctx   context.Context
+	store blob.BlobStore
+	att   Attachment
+	next  int
+	cur   io.ReadCloser
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		//Open the next chunk once the current one runs out
+		if r.cur == nil {
+			if r.next >= r.att.Chunks() {
+				return 0, io.EOF
+			}
+			rc, err := r.store.Get(r.ctx, r.att.ChunkKey(r.next))
+			if err != nil {
+				return 0, fmt.Errorf("chunk %d: %w", r.next, err)
+			}
+			r.cur = rc
+			r.next++
+		}
+
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			r.cur.Close()
+			r.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.cur == nil {
+		return nil
+	}
+	return r.cur.Close()
+}
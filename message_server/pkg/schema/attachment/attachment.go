@@ -0,0 +1,108 @@
+package attachment
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"wraith.me/message_server/pkg/db"
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+Represents a file that was uploaded to a chat room. The file is encrypted by
+the client before it's uploaded, so the name and type are whatever the client
+declares them to be, and the hash covers the encrypted content. Uploads are
+sent in fixed-size chunks, which lets them be resumed; the file can't be
+downloaded or attached to messages until every chunk is in and the hash has
+been checked.
+*/
+type Attachment struct {
+	db.DBObj `bson:",inline"`
+
+	// Unique identifier for the attachment.
+	ID util.UUID `json:"id" bson:"_id"`
+
+	// The ID of the room that the attachment was uploaded to.
+	Room util.UUID `json:"room_id" bson:"room_id"`
+
+	// The ID of the user that uploaded the attachment.
+	Uploader util.UUID `json:"uploader_id" bson:"uploader_id"`
+
+	// The name of the file.
+	Name string `json:"name" bson:"name"`
+
+	// The MIME type of the file.
+	MIME string `json:"mime" bson:"mime"`
+
+	// The size of the file, in bytes.
+	Size int64 `json:"size" bson:"size"`
+
+	// The hex-encoded SHA-256 hash of the file's content.
+	Hash string `json:"hash" bson:"hash"`
+
+	// The size of each chunk of the upload, in bytes. Only the last one may be smaller.
+	ChunkSize int `json:"chunk_size" bson:"chunk_size"`
+
+	// The indexes of the chunks that were received so far.
+	Received []int `json:"received" bson:"received"`
+
+	// Whether every chunk was received and the file's hash checked out.
+	Complete bool `json:"complete" bson:"complete"`
+
+	// When the claim on joining the upload's chunks lapses, if someone's joining them.
+	AssemblingUntil *time.Time `json:"-" bson:"assembling_until,omitempty"`
+}
+
+// Creates a new attachment that's waiting for its chunks.
+func NewAttachment(room util.UUID, uploader util.UUID, name string, mime string, size int64, hash string, chunkSize int) Attachment {
+	return Attachment{
+		DBObj:     db.NewDBObj(),
+		ID:        util.MustNewUUID7(),
+		Room:      room,
+		Uploader:  uploader,
+		Name:      name,
+		MIME:      mime,
+		Size:      size,
+		Hash:      hash,
+		ChunkSize: chunkSize,
+		Received:  []int{},
+	}
+}
+
+// Gets the number of chunks that the file is split into.
+func (a Attachment) Chunks() int {
+	return int((a.Size + int64(a.ChunkSize) - 1) / int64(a.ChunkSize))
+}
+
+// Gets the size that a chunk must have, or -1 if there's no such chunk.
+func (a Attachment) ChunkLen(index int) int64 {
+	if index < 0 || index >= a.Chunks() {
+		return -1
+	}
+	if index == a.Chunks()-1 {
+		return a.Size - int64(index)*int64(a.ChunkSize)
+	}
+	return int64(a.ChunkSize)
+}
+
+// Gets the indexes of the chunks that are yet to be received, in order.
+func (a Attachment) Missing() []int {
+	missing := make([]int, 0)
+	for i := 0; i < a.Chunks(); i++ {
+		if !slices.Contains(a.Received, i) {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// Gets the key under which the assembled file is stored.
+func (a Attachment) BlobKey() string {
+	return a.ID.String()
+}
+
+// Gets the key under which a chunk of the upload is stored until the file is assembled.
+func (a Attachment) ChunkKey(index int) string {
+	return fmt.Sprintf("%s.%d", a.ID, index)
+}
@@ -0,0 +1,181 @@
+package attachment
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	"wraith.me/message_server/pkg/db"
+	"wraith.me/message_server/pkg/util"
+)
+
+// How many stale uploads are removed at a time.
+const purgeBatchSize = 100
+
+var (
+	// Holds the shared instance of this collection.
+	attachmentCollectionInst *AttachmentCollection
+
+	// Guard mutex to ensure that only one singleton object is created.
+	attachmentCollectionOnce sync.Once
+
+	// Returned when an attachment doesn't exist, or isn't in the state an operation needs.
+	ErrNoSuchAttachment = errors.New("no such attachment exists, or it cannot be changed")
+)
+
+/*
+Represents a single `Attachment` object in a collection of objects in the
+database. This collection is managed by the `qmgo` Mongo ODM library.
+*/
+type AttachmentCollection struct {
+	*db.QMgoBase
+}
+
+// This line enforces AttachmentCollection to implement db.QMgoCollection.
+var _ db.QMgoCollection = (*AttachmentCollection)(nil)
+
+func (ac AttachmentCollection) ParentDB() string {
+	return db.ROOT_DB
+}
+
+func (ac AttachmentCollection) CollectionName() string {
+	return db.ATTACHMENTS_COLLECTION
+}
+
+/*
+Creates the indexes used by the collection. Attachments are looked up by room,
+and unfinished uploads are swept up by age.
+*/
+func (ac AttachmentCollection) SetupIndexes(ctx context.Context) error {
+	return ac.CreateIndexes(ctx, []options.IndexModel{
+		{Key: []string{"room_id", "_id"}},
+		{Key: []string{"complete", "created_at"}},
+	})
+}
+
+/*
+Gets an attachment that was uploaded to the given room. Returns
+`ErrNoSuchAttachment` if there's no such attachment, or if it was uploaded
+elsewhere.
+*/
+func (ac AttachmentCollection) GetInRoom(ctx context.Context, roomID util.UUID, id util.UUID) (*Attachment, error) {
+	var att Attachment
+	err := ac.Find(ctx, bson.D{{Key: "_id", Value: id}, {Key: "room_id", Value: roomID}}).One(&att)
+	if qmgo.IsErrNoDocuments(err) {
+		return nil, ErrNoSuchAttachment
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &att, nil
+}
+
+// Counts how many of the given attachments were fully uploaded to the given room.
+func (ac AttachmentCollection) CountComplete(ctx context.Context, roomID util.UUID, ids []util.UUID) (int64, error) {
+	return ac.Find(ctx, bson.D{
+		{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}},
+		{Key: "room_id", Value: roomID},
+		{Key: "complete", Value: true},
+	}).Count()
+}
+
+// Records that a chunk of an unfinished upload was received, returning the updated attachment.
+func (ac AttachmentCollection) MarkReceived(ctx context.Context, id util.UUID, index int) (*Attachment, error) {
+	return ac.applyUnfinished(ctx, id, bson.D{
+		{Key: "$addToSet", Value: bson.D{{Key: "received", Value: index}}},
+		{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now()}}},
+	})
+}
+
+/*
+Claims an unfinished upload so its chunks can be joined, returning the
+attachment as it stands. Only one caller can hold the claim at a time; the
+rest get `ErrNoSuchAttachment`. The claim lapses after the given lease, in
+case whoever holds it goes away.
+*/
+func (ac AttachmentCollection) ClaimAssembly(ctx context.Context, id util.UUID, lease time.Duration) (*Attachment, error) {
+	now := time.Now()
+	var att Attachment
+	err := ac.Find(ctx, bson.D{
+		{Key: "_id", Value: id},
+		{Key: "complete", Value: false},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "assembling_until", Value: bson.D{{Key: "$exists", Value: false}}}},
+			bson.D{{Key: "assembling_until", Value: bson.D{{Key: "$lte", Value: now}}}},
+		}},
+	}).Apply(qmgo.Change{
+		Update:    bson.D{{Key: "$set", Value: bson.D{{Key: "assembling_until", Value: now.Add(lease)}}}},
+		ReturnNew: true,
+	}, &att)
+	if qmgo.IsErrNoDocuments(err) {
+		return nil, ErrNoSuchAttachment
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &att, nil
+}
+
+// Gives up the claim on joining the chunks of an upload.
+func (ac AttachmentCollection) ReleaseAssembly(ctx context.Context, id util.UUID) error {
+	_, err := ac.applyUnfinished(ctx, id, bson.D{{Key: "$unset", Value: bson.D{{Key: "assembling_until", Value: ""}}}})
+	return err
+}
+
+// Forgets the chunks of an unfinished upload so they can be sent again, giving up any claim on joining them.
+func (ac AttachmentCollection) ResetReceived(ctx context.Context, id util.UUID) (*Attachment, error) {
+	return ac.applyUnfinished(ctx, id, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "received", Value: bson.A{}}, {Key: "updated_at", Value: time.Now()}}},
+		{Key: "$unset", Value: bson.D{{Key: "assembling_until", Value: ""}}},
+	})
+}
+
+// Marks an upload as complete once its chunks were joined, returning the updated attachment.
+func (ac AttachmentCollection) MarkComplete(ctx context.Context, id util.UUID) (*Attachment, error) {
+	return ac.applyUnfinished(ctx, id, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "complete", Value: true}, {Key: "updated_at", Value: time.Now()}}},
+		{Key: "$unset", Value: bson.D{{Key: "assembling_until", Value: ""}}},
+	})
+}
+
+// Gets up to `limit` unfinished uploads that were started before the given time.
+func (ac AttachmentCollection) FindStale(ctx context.Context, before time.Time, limit int) ([]Attachment, error) {
+	stale := make([]Attachment, 0)
+	err := ac.Find(ctx, bson.D{
+		{Key: "complete", Value: false},
+		{Key: "created_at", Value: bson.D{{Key: "$lt", Value: before}}},
+	}).Limit(int64(limit)).All(&stale)
+	return stale, err
+}
+
+// Applies an update to an unfinished upload, returning the updated attachment.
+func (ac AttachmentCollection) applyUnfinished(ctx context.Context, id util.UUID, update bson.D) (*Attachment, error) {
+	var att Attachment
+	err := ac.Find(ctx, bson.D{{Key: "_id", Value: id}, {Key: "complete", Value: false}}).
+		Apply(qmgo.Change{Update: update, ReturnNew: true}, &att)
+	if qmgo.IsErrNoDocuments(err) {
+		return nil, ErrNoSuchAttachment
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &att, nil
+}
+
+/*
+Gets the currently active collection object instance or initializes it.
+This can be safely called multiple times in the program to ensure a
+non-nil instance of the collection due to the usage of `sync.Once` to
+initialize the singleton.
+*/
+func GetCollection() *AttachmentCollection {
+	attachmentCollectionOnce.Do(func() {
+		c := db.GetCollectionManager().GetCollection(AttachmentCollection{})
+		attachmentCollectionInst = &AttachmentCollection{c}
+	})
+	return attachmentCollectionInst
+}
@@ -0,0 +1,45 @@
+package chatmessage
+
+import (
+	"context"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"wraith.me/message_server/pkg/blob"
+	"wraith.me/message_server/pkg/schema/attachment"
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+Removes the files that were attached to a message that was deleted or
+expired, along with their records. Files that another message still refers
+to are kept.
+*/
+func ReleaseAttachments(ctx context.Context, store blob.BlobStore, ids []util.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	referenced, err := GetCollection().ReferencedAttachments(ctx, ids)
+	if err != nil {
+		return err
+	}
+	unused := slices.DeleteFunc(slices.Clone(ids), func(id util.UUID) bool { return referenced[id] })
+	return attachment.Discard(ctx, store, unused)
+}
+
+/*
+Removes every message in a room, such as when the room is deleted, and then
+the files that were attached to them. The attachments are gathered first,
+since they can't be found once the messages are gone.
+*/
+func RemoveRoomMessages(ctx context.Context, store blob.BlobStore, roomID util.UUID) error {
+	mc := GetCollection()
+	ids, err := mc.RoomAttachments(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if _, err := mc.RemoveAll(ctx, bson.D{{Key: "room_id", Value: roomID}}); err != nil {
+		return err
+	}
+	return ReleaseAttachments(ctx, store, ids)
+}
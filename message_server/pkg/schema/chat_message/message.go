@@ -0,0 +1,83 @@
+package chatmessage
+
+import (
+	"time"
+
+	"wraith.me/message_server/pkg/db"
+	"wraith.me/message_server/pkg/http_types/ws/chat"
+	"wraith.me/message_server/pkg/util"
+)
+
+// Represents a chat message that has been persisted to the database.
+type Message struct {
+	db.DBObj `bson:",inline"`
+
+	// The chat message itself. The ID of the message doubles as the document ID.
+	chat.Message `bson:",inline"`
+
+	// The ID of the room that the message was sent in.
+	Room util.UUID `json:"room_id" bson:"room_id"`
+
+	// The earlier versions of the message's content, oldest first. Empty if it was never edited.
+	Revisions []Revision `json:"revisions,omitempty" bson:"revisions,omitempty"`
+
+	// When the message was last edited, if ever.
+	EditedAt *time.Time `json:"edited_at,omitempty" bson:"edited_at,omitempty"`
+
+	// The ID of the user who deleted the message, if it was deleted.
+	DeletedBy *util.UUID `json:"deleted_by,omitempty" bson:"deleted_by,omitempty"`
+
+	// When the message was deleted, if it was.
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+
+	// The reactions to the message, mapping each emoji to the IDs of the users who reacted with it.
+	Reactions map[string][]util.UUID `json:"reactions,omitempty" bson:"reactions,omitempty"`
+
+	// The number of replies in the thread this message starts, if it starts one.
+	ReplyCount int `json:"reply_count,omitempty" bson:"reply_count,omitempty"`
+
+	// When the last reply was added to the thread this message starts, if it starts one.
+	LastReplyAt *time.Time `json:"last_reply_at,omitempty" bson:"last_reply_at,omitempty"`
+}
+
+// Represents an earlier version of a message's content.
+type Revision struct {
+	// The content of the message before it was edited.
+	Content string `json:"content" bson:"content"`
+
+	// When this content was replaced.
+	ReplacedAt time.Time `json:"replaced_at" bson:"replaced_at"`
+}
+
+// Creates a new persistable message from a chat message and a room ID.
+func NewMessage(msg chat.Message, room util.UUID) Message {
+	return Message{
+		DBObj:   db.NewDBObj(),
+		Message: msg,
+		Room:    room,
+	}
+}
+
+/*
+Checks whether the message was deleted. Deleted messages are kept as
+tombstones so that the history stays in order and references to them
+still resolve, but their content is gone.
+*/
+func (m Message) IsDeleted() bool {
+	return m.Type == chat.TypeDELETED
+}
+
+/*
+Gets the event that tells live sessions about the current state of the
+message. Edits carry the new content, while deletions carry nothing but
+the ID. The event has the same ID as the message it concerns.
+*/
+func (m Message) ChangeEvent() chat.Message {
+	evt := m.Message
+	if m.IsDeleted() {
+		evt.Content = ""
+	} else {
+		evt.Type = chat.TypeEDITED
+	}
+	return evt
+}
@@ -0,0 +1,355 @@
+package chatmessage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	moptions "go.mongodb.org/mongo-driver/mongo/options"
+	"wraith.me/message_server/pkg/db"
+	"wraith.me/message_server/pkg/http_types/ws/chat"
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+How long expired messages are kept if they weren't cleaned up on time. The
+cleanup task announces each removal, so MongoDB only steps in as a backstop.
+*/
+const expiryGrace = time.Hour
+
+// The longest a reaction may be, in bytes. This fits any emoji, including ones built from several code points.
+const maxEmojiSize = 32
+
+var (
+	// Returned when a message doesn't exist, or can't be edited or deleted by the caller.
+	ErrNoSuchMessage = errors.New("no such message exists, or it cannot be changed")
+
+	// Returned when a reaction isn't a valid emoji, or couldn't be stored as one.
+	ErrBadEmoji = fmt.Errorf("reactions must be between 1 and %d bytes of text, without '.' or a leading '$'", maxEmojiSize)
+
+	// Holds the shared instance of this collection.
+	messageCollectionInst *MessageCollection
+
+	// Guard mutex to ensure that only one singleton object is created.
+	messageCollectionOnce sync.Once
+)
+
+/*
+Represents a single `Message` object in a collection of objects in the database.
+This collection is managed by the `qmgo` Mongo ODM library.
+*/
+type MessageCollection struct {
+	*db.QMgoBase
+}
+
+// This line enforces MessageCollection to implement db.QMgoCollection.
+var _ db.QMgoCollection = (*MessageCollection)(nil)
+
+func (mc MessageCollection) ParentDB() string {
+	return db.ROOT_DB
+}
+
+func (mc MessageCollection) CollectionName() string {
+	return db.MSG_COLLECTION
+}
+
+/*
+Creates the indexes used by the collection. History is always queried by room
+and walked backwards by ID, so a compound index on both keeps those scans cheap.
+Threads are walked forwards from their root, and most messages aren't in one.
+Expired messages are looked up by expiry and are dropped by MongoDB as a last resort.
+*/
+func (mc MessageCollection) SetupIndexes(ctx context.Context) error {
+	return mc.CreateIndexes(ctx, []options.IndexModel{
+		{Key: []string{"room_id", "-_id"}},
+		{Key: []string{"thread_root", "_id"}, IndexOptions: moptions.Index().SetSparse(true)},
+		{Key: []string{"expiry"}, IndexOptions: moptions.Index().SetSparse(true).SetExpireAfterSeconds(int32(expiryGrace.Seconds()))},
+	})
+}
+
+/*
+Gets a message that was sent in the given room. Returns `ErrNoSuchMessage` if
+there's no such message, if it was sent elsewhere, or if it expired.
+*/
+func (mc MessageCollection) GetInRoom(ctx context.Context, roomID util.UUID, msgID util.UUID) (*Message, error) {
+	var msg Message
+	err := mc.Find(ctx, bson.D{
+		{Key: "_id", Value: msgID},
+		{Key: "room_id", Value: roomID},
+		Unexpired(time.Now()),
+	}).One(&msg)
+	if qmgo.IsErrNoDocuments(err) {
+		return nil, ErrNoSuchMessage
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// Gets the oldest user message in a room that hasn't expired. Returns `nil` if there isn't one.
+func (mc MessageCollection) GetFirst(ctx context.Context, roomID util.UUID) (*Message, error) {
+	var msg Message
+	err := mc.Find(ctx, bson.D{
+		{Key: "room_id", Value: roomID},
+		{Key: "type", Value: chat.TypeUMSG},
+		Unexpired(time.Now()),
+	}).Sort("_id").One(&msg)
+	if qmgo.IsErrNoDocuments(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// Records that a reply was added to the thread started by a message.
+func (mc MessageCollection) AddReply(ctx context.Context, rootID util.UUID, at time.Time) error {
+	return mc.UpdateOne(ctx, bson.D{{Key: "_id", Value: rootID}}, bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "reply_count", Value: 1}}},
+		{Key: "$max", Value: bson.D{{Key: "last_reply_at", Value: at}}},
+	})
+}
+
+/*
+Toggles a user's reaction to a message, removing it if it's there and adding
+it otherwise. Messages that were deleted can't be reacted to. Returns whether
+the reaction was added, along with the IDs of the users who have the reaction
+afterwards.
+*/
+func (mc MessageCollection) ToggleReaction(ctx context.Context, roomID util.UUID, msgID util.UUID, userID util.UUID, emoji string) (bool, []util.UUID, error) {
+	if !validEmoji(emoji) {
+		return false, nil, ErrBadEmoji
+	}
+	field := "reactions." + emoji
+	filter := bson.D{
+		{Key: "_id", Value: msgID},
+		{Key: "room_id", Value: roomID},
+		{Key: "type", Value: bson.D{{Key: "$ne", Value: chat.TypeDELETED}}},
+	}
+	change := qmgo.Change{ReturnNew: true}
+
+	//Remove the reaction if the user already has it
+	var msg Message
+	change.Update = bson.D{{Key: "$pull", Value: bson.D{{Key: field, Value: userID}}}}
+	err := mc.Find(ctx, append(filter, bson.E{Key: field, Value: userID})).Apply(change, &msg)
+	if err == nil {
+		//Drop the emoji altogether once nobody has it
+		if len(msg.Reactions[emoji]) == 0 {
+			err := mc.UpdateOne(ctx,
+				append(filter, bson.E{Key: field, Value: bson.D{{Key: "$size", Value: 0}}}),
+				bson.D{{Key: "$unset", Value: bson.D{{Key: field, Value: ""}}}},
+			)
+			if err != nil && !errors.Is(err, qmgo.ErrNoSuchDocuments) {
+				return false, nil, err
+			}
+		}
+		return false, msg.Reactions[emoji], nil
+	}
+	if !qmgo.IsErrNoDocuments(err) {
+		return false, nil, err
+	}
+
+	//Add the reaction otherwise
+	change.Update = bson.D{{Key: "$addToSet", Value: bson.D{{Key: field, Value: userID}}}}
+	err = mc.Find(ctx, filter).Apply(change, &msg)
+	if qmgo.IsErrNoDocuments(err) {
+		return false, nil, ErrNoSuchMessage
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	return true, msg.Reactions[emoji], nil
+}
+
+/*
+Edits the content of a message, keeping the old content in its revision
+history. Only user messages that haven't been deleted can be edited, and
+only by whoever sent them. Returns the edited message, or `ErrNoSuchMessage`
+if there's no message that fits the bill.
+*/
+func (mc MessageCollection) Edit(ctx context.Context, roomID util.UUID, msgID util.UUID, editor util.UUID, content string) (*Message, error) {
+	filter := bson.D{
+		{Key: "_id", Value: msgID},
+		{Key: "room_id", Value: roomID},
+		{Key: "sender_id", Value: editor},
+		{Key: "type", Value: chat.TypeUMSG},
+	}
+
+	//Push the old content onto the history and swap in the new one in a single step
+	now := time.Now()
+	update := bson.A{bson.D{{Key: "$set", Value: bson.D{
+		{Key: "revisions", Value: bson.D{{Key: "$concatArrays", Value: bson.A{
+			bson.D{{Key: "$ifNull", Value: bson.A{"$revisions", bson.A{}}}},
+			bson.A{bson.D{{Key: "content", Value: "$content"}, {Key: "replaced_at", Value: now}}},
+		}}}},
+		{Key: "content", Value: bson.D{{Key: "$literal", Value: content}}},
+		{Key: "edited_at", Value: now},
+		{Key: "updated_at", Value: now},
+	}}}}
+
+	var msg Message
+	err := mc.Find(ctx, filter).Apply(qmgo.Change{Update: update, ReturnNew: true}, &msg)
+	if qmgo.IsErrNoDocuments(err) {
+		return nil, ErrNoSuchMessage
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+/*
+Deletes a message, leaving a tombstone in its place so the history stays in
+order. The content, revision history, reactions and attachments of the
+message are dropped, but its place in any thread and what it replied to are
+kept. The files that were attached are left alone; `ReleaseAttachments()`
+removes them once the caller is done. Whether the caller may delete the
+message is up to them to check. Returns the tombstone, or `ErrNoSuchMessage`
+if the message doesn't exist or was already deleted.
+*/
+func (mc MessageCollection) Delete(ctx context.Context, roomID util.UUID, msgID util.UUID, deleter util.UUID) (*Message, error) {
+	filter := bson.D{
+		{Key: "_id", Value: msgID},
+		{Key: "room_id", Value: roomID},
+		{Key: "type", Value: bson.D{{Key: "$ne", Value: chat.TypeDELETED}}},
+	}
+
+	now := time.Now()
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "type", Value: chat.TypeDELETED},
+			{Key: "content", Value: ""},
+			{Key: "deleted_by", Value: deleter},
+			{Key: "deleted_at", Value: now},
+			{Key: "updated_at", Value: now},
+		}},
+		{Key: "$unset", Value: bson.D{
+			{Key: "revisions", Value: ""},
+			{Key: "edited_at", Value: ""},
+			{Key: "reactions", Value: ""},
+			{Key: "attachments", Value: ""},
+		}},
+	}
+
+	var msg Message
+	err := mc.Find(ctx, filter).Apply(qmgo.Change{Update: update, ReturnNew: true}, &msg)
+	if qmgo.IsErrNoDocuments(err) {
+		return nil, ErrNoSuchMessage
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// Gets up to `limit` messages that expired by the given time, soonest expiry first.
+func (mc MessageCollection) FindExpired(ctx context.Context, at time.Time, limit int) ([]Message, error) {
+	expired := make([]Message, 0)
+	err := mc.Find(ctx, bson.D{{Key: "expiry", Value: bson.D{{Key: "$lte", Value: at}}}}).
+		Sort("expiry").
+		Limit(int64(limit)).
+		All(&expired)
+	return expired, err
+}
+
+/*
+Gets which of the given attachments are still attached to a message. Files
+can be passed along in more than one message, so they may only be removed
+once none of them refer to it anymore.
+*/
+func (mc MessageCollection) ReferencedAttachments(ctx context.Context, ids []util.UUID) (map[util.UUID]bool, error) {
+	referenced := make(map[util.UUID]bool)
+	if len(ids) == 0 {
+		return referenced, nil
+	}
+
+	//Distinct gives every attachment of the matching messages, not just the ones asked about
+	var found []util.UUID
+	err := mc.Find(ctx, bson.D{{Key: "attachments", Value: bson.D{{Key: "$in", Value: ids}}}}).
+		Distinct("attachments", &found)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range found {
+		if slices.Contains(ids, id) {
+			referenced[id] = true
+		}
+	}
+	return referenced, nil
+}
+
+// Gets the IDs of every attachment that's attached to a message in a room.
+func (mc MessageCollection) RoomAttachments(ctx context.Context, roomID util.UUID) ([]util.UUID, error) {
+	ids := make([]util.UUID, 0)
+	err := mc.Find(ctx, bson.D{
+		{Key: "room_id", Value: roomID},
+		{Key: "attachments.0", Value: bson.D{{Key: "$exists", Value: true}}},
+	}).Distinct("attachments", &ids)
+	return ids, err
+}
+
+/*
+Removes a message that expired by the given time. Returns whether this call
+removed it, so that only one caller announces the removal when several are
+cleaning up at once.
+*/
+func (mc MessageCollection) RemoveExpired(ctx context.Context, msgID util.UUID, at time.Time) (bool, error) {
+	err := mc.Remove(ctx, bson.D{
+		{Key: "_id", Value: msgID},
+		{Key: "expiry", Value: bson.D{{Key: "$lte", Value: at}}},
+	})
+	if errors.Is(err, qmgo.ErrNoSuchDocuments) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+/*
+Gets a filter that leaves out messages that expired by the given time. These
+are only around until they're cleaned up, so queries for history should
+include it.
+*/
+func Unexpired(at time.Time) bson.E {
+	return bson.E{Key: "expiry", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$lte", Value: at}}}}}
+}
+
+/*
+Gets a filter that leaves out the messages sent by the given users, such as
+the ones that the reader blocked.
+*/
+func NotFrom(senders []util.UUID) bson.E {
+	return bson.E{Key: "sender_id", Value: bson.D{{Key: "$nin", Value: senders}}}
+}
+
+/*
+Gets the currently active collection object instance or initializes it.
+This can be safely called multiple times in the program to ensure a
+non-nil instance of the collection due to the usage of `sync.Once` to
+initialize the singleton.
+*/
+func GetCollection() *MessageCollection {
+	messageCollectionOnce.Do(func() {
+		c := db.GetCollectionManager().GetCollection(MessageCollection{})
+		messageCollectionInst = &MessageCollection{c}
+	})
+	return messageCollectionInst
+}
+
+// Checks whether a reaction can be stored as a key of a message's reactions.
+func validEmoji(emoji string) bool {
+	return emoji != "" &&
+		len(emoji) <= maxEmojiSize &&
+		utf8.ValidString(emoji) &&
+		!strings.ContainsAny(emoji, ".\x00") &&
+		!strings.HasPrefix(emoji, "$")
+}
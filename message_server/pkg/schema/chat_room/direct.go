@@ -0,0 +1,57 @@
+package chatroom
+
+import (
+	"wraith.me/message_server/pkg/db"
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+Represents a direct conversation that was started with a user who isn't
+friends with its sender. The recipient isn't a member of the room until they
+accept it, and the sender may only send a single message until then.
+*/
+type MessageRequest struct {
+	// The ID of the user that started the conversation.
+	From util.UUID `json:"from" bson:"from"`
+
+	// The ID of the user the conversation was started with.
+	To util.UUID `json:"to" bson:"to"`
+
+	// Whether the sender already sent the message that the request carries.
+	Sent bool `json:"sent" bson:"sent"`
+}
+
+/*
+Creates a new direct conversation between two users. Both users own the room,
+so neither can be kicked out of it by the other. If `request` is true, only
+the sender is added, and the recipient must accept the request to join.
+*/
+func NewDirectRoom(from util.UUID, to util.UUID, request bool) Room {
+	room := Room{
+		DBObj:        db.NewDBObj(),
+		ID:           util.MustNewUUID7(),
+		Participants: MembershipList{from: RoleOWNER},
+		Direct:       true,
+		DMKey:        DMKey(from, to),
+	}
+	if request {
+		room.Request = &MessageRequest{From: from, To: to}
+	} else {
+		room.Participants[to] = RoleOWNER
+	}
+	return room
+}
+
+// Gets the key that identifies the direct conversation between two users, regardless of their order.
+func DMKey(a, b util.UUID) string {
+	as, bs := a.String(), b.String()
+	if as > bs {
+		as, bs = bs, as
+	}
+	return as + ":" + bs
+}
+
+// Checks if the room is a direct conversation whose recipient hasn't answered its request yet.
+func (r Room) IsRequest() bool {
+	return r.Request != nil
+}
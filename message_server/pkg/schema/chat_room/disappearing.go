@@ -0,0 +1,11 @@
+package chatroom
+
+import "wraith.me/message_server/pkg/util/timex"
+
+// The longest that messages can be set to last before they disappear.
+const MaxDisappearAfter = timex.Year
+
+// Checks whether a disappearing messages timer, in seconds, is in range. Zero turns the timer off.
+func ValidDisappearAfter(secs int64) bool {
+	return secs >= 0 && secs <= int64(MaxDisappearAfter/timex.Second)
+}
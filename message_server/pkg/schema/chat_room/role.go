@@ -15,3 +15,8 @@ ENUM(
 )
 */
 type Role int8
+
+// Checks whether this role ranks above another; ie: whether its holder can manage holders of the other.
+func (r Role) Outranks(other Role) bool {
+	return r > other
+}
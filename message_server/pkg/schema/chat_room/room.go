@@ -3,6 +3,7 @@ package chatroom
 import (
 	"fmt"
 	"math/big"
+	"time"
 
 	"crypto/rand"
 
@@ -24,6 +25,18 @@ type Room struct {
 	// The list of participants in the chat room, represented by their UUIDs.
 	Participants MembershipList `json:"participants" bson:"participants"`
 
+	// How many seconds messages sent to the room last before they disappear. Zero if they never do.
+	DisappearAfter int64 `json:"disappear_after,omitempty" bson:"disappear_after,omitempty"`
+
+	// Whether the room is a direct conversation between two users.
+	Direct bool `json:"direct,omitempty" bson:"direct,omitempty"`
+
+	// Identifies the pair of users that a direct conversation is between; only one may exist per pair.
+	DMKey string `json:"-" bson:"dm_key,omitempty"`
+
+	// The request that a direct conversation was started with, if its recipient hasn't answered it yet.
+	Request *MessageRequest `json:"request,omitempty" bson:"request,omitempty"`
+
 	// The list of messages in the chat room.
 	//Messages []chat.ChatMessage `json:"messages" bson:"-"`
 
@@ -56,6 +69,24 @@ func (r *Room) AddMember(participant util.UUID) {
 	r.Participants[participant] = RoleMEMBER
 }
 
+// Gets the role of a user in the chat room, and whether they're in it at all.
+func (r Room) RoleOf(participant util.UUID) (Role, bool) {
+	role, ok := r.Participants[participant]
+	return role, ok
+}
+
+// Changes the role of a member of the chat room. Users that aren't members are ignored.
+func (r *Room) SetRole(participant util.UUID, role Role) {
+	if r.HasMember(participant) {
+		r.Participants[participant] = role
+	}
+}
+
+// Gets how long messages sent to the room last before they disappear. Zero if they never do.
+func (r Room) DisappearTimer() time.Duration {
+	return time.Duration(r.DisappearAfter) * time.Second
+}
+
 // Checks if a user is in the chat room.
 func (r *Room) HasMember(participant util.UUID) bool {
 	_, ok := r.Participants[participant]
@@ -1,9 +1,16 @@
 package chatroom
 
 import (
+	"context"
+	"errors"
 	"sync"
 
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	moptions "go.mongodb.org/mongo-driver/mongo/options"
 	"wraith.me/message_server/pkg/db"
+	"wraith.me/message_server/pkg/util"
 )
 
 var (
@@ -12,6 +19,9 @@ var (
 
 	// Guard mutex to ensure that only one singleton object is created.
 	roomCollectionOnce sync.Once
+
+	// Returned when a message request doesn't exist or was already answered.
+	ErrNoSuchRequest = errors.New("no such message request exists")
 )
 
 /*
@@ -33,6 +43,127 @@ func (uc RoomCollection) CollectionName() string {
 	return db.CROOMS_COLLECTION
 }
 
+/*
+Creates the indexes used by the collection. Only one direct conversation may
+exist between any two users, and message requests are looked up by their
+recipients.
+*/
+func (rc RoomCollection) SetupIndexes(ctx context.Context) error {
+	return rc.CreateIndexes(ctx, []options.IndexModel{
+		{
+			Key: []string{"dm_key"},
+			IndexOptions: moptions.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.D{{Key: "dm_key", Value: bson.D{{Key: "$exists", Value: true}}}}),
+		},
+		{Key: []string{"request.to"}, IndexOptions: moptions.Index().SetSparse(true)},
+	})
+}
+
+// Gets the direct conversation between two users. Returns `nil` if there isn't one.
+func (rc RoomCollection) GetDirect(ctx context.Context, a, b util.UUID) (*Room, error) {
+	var room Room
+	err := rc.Find(ctx, bson.D{{Key: "dm_key", Value: DMKey(a, b)}}).One(&room)
+	if qmgo.IsErrNoDocuments(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+/*
+Stores a new direct conversation, unless one already exists between the same
+two users, in which case the existing one is returned instead. Returns whether
+the room that's returned was created by this call.
+*/
+func (rc RoomCollection) CreateDirect(ctx context.Context, room Room) (Room, bool, error) {
+	_, err := rc.InsertOne(ctx, room)
+	if err == nil {
+		return room, true, nil
+	}
+	if !qmgo.IsDup(err) {
+		return room, false, err
+	}
+
+	//Someone else got there first
+	var existing Room
+	err = rc.Find(ctx, bson.D{{Key: "dm_key", Value: room.DMKey}}).One(&existing)
+	return existing, false, err
+}
+
+// Gets the message requests that a user hasn't answered yet, oldest first.
+func (rc RoomCollection) ListRequests(ctx context.Context, to util.UUID) ([]Room, error) {
+	rooms := make([]Room, 0)
+	err := rc.Find(ctx, bson.D{{Key: "request.to", Value: to}}).Sort("_id").All(&rooms)
+	return rooms, err
+}
+
+/*
+Accepts a message request on behalf of its recipient, who joins the room as
+one of its owners. Returns the updated room, or `ErrNoSuchRequest` if the
+request doesn't exist, isn't addressed to the user, or was already answered.
+*/
+func (rc RoomCollection) AcceptRequest(ctx context.Context, roomID util.UUID, to util.UUID) (*Room, error) {
+	var room Room
+	err := rc.Find(ctx, bson.D{
+		{Key: "_id", Value: roomID},
+		{Key: "request.to", Value: to},
+	}).Apply(qmgo.Change{
+		Update: bson.D{
+			{Key: "$set", Value: bson.D{{Key: "participants." + to.String(), Value: RoleOWNER}}},
+			{Key: "$unset", Value: bson.D{{Key: "request", Value: ""}}},
+		},
+		ReturnNew: true,
+	}, &room)
+	if qmgo.IsErrNoDocuments(err) {
+		return nil, ErrNoSuchRequest
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+/*
+Declines a message request on behalf of its recipient, which removes the room.
+Returns the room that was removed, or `ErrNoSuchRequest` if the request
+doesn't exist, isn't addressed to the user, or was already answered.
+*/
+func (rc RoomCollection) DeclineRequest(ctx context.Context, roomID util.UUID, to util.UUID) (*Room, error) {
+	var room Room
+	err := rc.Find(ctx, bson.D{
+		{Key: "_id", Value: roomID},
+		{Key: "request.to", Value: to},
+	}).Apply(qmgo.Change{Remove: true}, &room)
+	if qmgo.IsErrNoDocuments(err) {
+		return nil, ErrNoSuchRequest
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+/*
+Atomically marks whether the sender of a pending message request has sent
+its message. Returns false if the room isn't a pending request from the user,
+or the flag was already set that way, such as when another message got there
+first.
+*/
+func (rc RoomCollection) MarkRequestSent(ctx context.Context, roomID util.UUID, from util.UUID, sent bool) (bool, error) {
+	err := rc.UpdateOne(ctx, bson.D{
+		{Key: "_id", Value: roomID},
+		{Key: "request.from", Value: from},
+		{Key: "request.sent", Value: !sent},
+	}, bson.D{{Key: "$set", Value: bson.D{{Key: "request.sent", Value: sent}}}})
+	if errors.Is(err, qmgo.ErrNoSuchDocuments) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 /*
 Gets the currently active collection object instance or initializes it.
 This can be safely called multiple times in the program to ensure a
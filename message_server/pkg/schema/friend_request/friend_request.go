@@ -0,0 +1,72 @@
+package friendrequest
+
+import (
+	"time"
+
+	"wraith.me/message_server/pkg/db"
+	"wraith.me/message_server/pkg/util"
+)
+
+var (
+	//How long a friend request may go unanswered before it expires (default: 30 days).
+	DefaultFRQTTL = time.Hour * 24 * 30
+)
+
+// Represents a request from one user to become friends with another.
+type FriendRequest struct {
+	db.DBObj `bson:",inline"`
+
+	// Unique identifier for the friend request.
+	ID util.UUID `json:"id" bson:"_id"`
+
+	// The ID of the user that sent the request.
+	Sender util.UUID `json:"sender" bson:"sender_id"`
+
+	// The ID of the user that the request was sent to.
+	Recipient util.UUID `json:"recipient" bson:"recipient_id"`
+
+	// The state of the request.
+	State State `json:"state" bson:"state"`
+
+	// The time at which the request expires if it's still pending.
+	Expires time.Time `json:"expires" bson:"expires"`
+
+	/*
+		The IDs of both users, ordered so that the key is the same no matter
+		who sent the request. Only one pending request may exist per pair.
+	*/
+	Pair string `json:"-" bson:"pair"`
+}
+
+// Creates a new pending friend request.
+func NewFriendRequest(sender, recipient util.UUID) FriendRequest {
+	id := util.MustNewUUID7()
+	return FriendRequest{
+		DBObj:     db.NewDBObj(),
+		ID:        id,
+		Sender:    sender,
+		Recipient: recipient,
+		State:     StatePENDING,
+		Expires:   id.Time().Add(DefaultFRQTTL),
+		Pair:      PairKey(sender, recipient),
+	}
+}
+
+// Gets the key that identifies a pair of users, regardless of their order.
+func PairKey(a, b util.UUID) string {
+	as, bs := a.String(), b.String()
+	if as > bs {
+		as, bs = bs, as
+	}
+	return as + ":" + bs
+}
+
+// Checks if the request is still pending and hasn't expired.
+func (f FriendRequest) IsPending() bool {
+	return f.State == StatePENDING && time.Now().Before(f.Expires)
+}
+
+// Checks if a user is either the sender or the recipient of the request.
+func (f FriendRequest) Involves(uid util.UUID) bool {
+	return f.Sender == uid || f.Recipient == uid
+}
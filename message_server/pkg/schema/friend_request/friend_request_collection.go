@@ -0,0 +1,175 @@
+package friendrequest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	moptions "go.mongodb.org/mongo-driver/mongo/options"
+	"wraith.me/message_server/pkg/db"
+	"wraith.me/message_server/pkg/util"
+)
+
+var (
+	// Holds the shared instance of this collection.
+	frqCollectionInst *FriendRequestCollection
+
+	// Guard mutex to ensure that only one singleton object is created.
+	frqCollectionOnce sync.Once
+
+	// Returned when a pending friend request already exists between two users.
+	ErrDuplicateRequest = errors.New("a friend request between these users is already pending")
+)
+
+/*
+Represents a single `FriendRequest` object in a collection of objects in the
+database. This collection is managed by the `qmgo` Mongo ODM library.
+*/
+type FriendRequestCollection struct {
+	*db.QMgoBase
+}
+
+// This line enforces FriendRequestCollection to implement db.QMgoCollection.
+var _ db.QMgoCollection = (*FriendRequestCollection)(nil)
+
+func (fc FriendRequestCollection) ParentDB() string {
+	return db.ROOT_DB
+}
+
+func (fc FriendRequestCollection) CollectionName() string {
+	return db.FRQ_COLLECTION
+}
+
+/*
+Creates the indexes used by the collection. Requests are looked up by either
+party, and only one pending request may exist between any two users.
+*/
+func (fc FriendRequestCollection) SetupIndexes(ctx context.Context) error {
+	return fc.CreateIndexes(ctx, []options.IndexModel{
+		{
+			Key: []string{"pair"},
+			IndexOptions: moptions.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.D{{Key: "state", Value: StatePENDING}}),
+		},
+		{Key: []string{"sender_id", "state"}},
+		{Key: []string{"recipient_id", "state"}},
+	})
+}
+
+// Stores a new friend request. Returns `ErrDuplicateRequest` if one is already pending between the two users.
+func (fc FriendRequestCollection) Create(ctx context.Context, frq FriendRequest) error {
+	_, err := fc.InsertOne(ctx, frq)
+	if qmgo.IsDup(err) {
+		return ErrDuplicateRequest
+	}
+	return err
+}
+
+// Gets a friend request by its ID. Returns `nil` if no such request exists.
+func (fc FriendRequestCollection) Get(ctx context.Context, id util.UUID) (*FriendRequest, error) {
+	var frq FriendRequest
+	err := fc.Find(ctx, bson.D{{Key: "_id", Value: id}}).One(&frq)
+	if qmgo.IsErrNoDocuments(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &frq, nil
+}
+
+// Gets the pending friend request between two users, if there is one.
+func (fc FriendRequestCollection) GetPending(ctx context.Context, a, b util.UUID) (*FriendRequest, error) {
+	var frq FriendRequest
+	err := fc.Find(ctx, bson.D{
+		{Key: "pair", Value: PairKey(a, b)},
+		{Key: "state", Value: StatePENDING},
+		{Key: "expires", Value: bson.D{{Key: "$gt", Value: time.Now()}}},
+	}).One(&frq)
+	if qmgo.IsErrNoDocuments(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &frq, nil
+}
+
+// Gets the pending friend requests that a user has sent or received, oldest first.
+func (fc FriendRequestCollection) ListPending(ctx context.Context, uid util.UUID) ([]FriendRequest, error) {
+	frqs := make([]FriendRequest, 0)
+	err := fc.Find(ctx, bson.D{
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "sender_id", Value: uid}},
+			bson.D{{Key: "recipient_id", Value: uid}},
+		}},
+		{Key: "state", Value: StatePENDING},
+		{Key: "expires", Value: bson.D{{Key: "$gt", Value: time.Now()}}},
+	}).Sort("_id").All(&frqs)
+	return frqs, err
+}
+
+/*
+Atomically moves a friend request from one state to another. Pending requests
+that have expired can't be moved. Returns false if the request wasn't in the
+expected state, such as when the other party got to it first.
+*/
+func (fc FriendRequestCollection) Transition(ctx context.Context, id util.UUID, from State, to State) (bool, error) {
+	filter := bson.D{
+		{Key: "_id", Value: id},
+		{Key: "state", Value: from},
+	}
+	if from == StatePENDING {
+		filter = append(filter, bson.E{Key: "expires", Value: bson.D{{Key: "$gt", Value: time.Now()}}})
+	}
+	err := fc.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: bson.D{
+		{Key: "state", Value: to},
+		{Key: "updated_at", Value: time.Now()},
+	}}})
+	if errors.Is(err, qmgo.ErrNoSuchDocuments) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+/*
+Marks the pending friend requests of a user that have gone unanswered for too
+long as expired. This frees the pair up for a new request.
+*/
+func (fc FriendRequestCollection) ExpireStale(ctx context.Context, uid util.UUID) (int64, error) {
+	now := time.Now()
+	res, err := fc.UpdateAll(ctx, bson.D{
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "sender_id", Value: uid}},
+			bson.D{{Key: "recipient_id", Value: uid}},
+		}},
+		{Key: "state", Value: StatePENDING},
+		{Key: "expires", Value: bson.D{{Key: "$lte", Value: now}}},
+	}, bson.D{{Key: "$set", Value: bson.D{
+		{Key: "state", Value: StateEXPIRED},
+		{Key: "updated_at", Value: now},
+	}}})
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+/*
+Gets the currently active collection object instance or initializes it.
+This can be safely called multiple times in the program to ensure a
+non-nil instance of the collection due to the usage of `sync.Once` to
+initialize the singleton.
+*/
+func GetCollection() *FriendRequestCollection {
+	frqCollectionOnce.Do(func() {
+		c := db.GetCollectionManager().GetCollection(FriendRequestCollection{})
+		frqCollectionInst = &FriendRequestCollection{c}
+	})
+	return frqCollectionInst
+}
@@ -0,0 +1,19 @@
+//go:generate go-enum --marshal --forceupper --mustparse --nocomments --names --values
+
+package friendrequest
+
+//
+//-- ENUM: State
+//
+
+// Sets the states that a friend request can be in.
+/*
+ENUM(
+	PENDING 	//The request was sent and is awaiting a response from the recipient.
+	ACCEPTED 	//The recipient accepted the request, making both users friends.
+	REJECTED 	//The recipient turned the request down.
+	CANCELLED 	//The sender withdrew the request before it was answered.
+	EXPIRED 	//The request went unanswered for too long.
+)
+*/
+type State int8
@@ -0,0 +1,30 @@
+package memberstate
+
+import (
+	"wraith.me/message_server/pkg/db"
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+Tracks how far along a member of a chat room is in the room's history. Since
+message IDs are UUIDv7s, everything in the room with an ID greater than the
+member's delivery cursor is what's still queued for them.
+*/
+type State struct {
+	db.DBObj `bson:",inline"`
+
+	// Unique identifier for the state object.
+	ID util.UUID `json:"-" bson:"_id"`
+
+	// The ID of the room the state pertains to.
+	Room util.UUID `json:"room_id" bson:"room_id"`
+
+	// The ID of the member the state pertains to.
+	User util.UUID `json:"user_id" bson:"user_id"`
+
+	// The ID of the last message the member acknowledged receiving.
+	Delivered util.UUID `json:"delivered_id" bson:"delivered_id"`
+
+	// The ID of the last message the member read.
+	Read util.UUID `json:"read_id" bson:"read_id"`
+}
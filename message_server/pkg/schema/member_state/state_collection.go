@@ -0,0 +1,121 @@
+package memberstate
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	moptions "go.mongodb.org/mongo-driver/mongo/options"
+	"wraith.me/message_server/pkg/db"
+	"wraith.me/message_server/pkg/util"
+)
+
+var (
+	// Holds the shared instance of this collection.
+	stateCollectionInst *StateCollection
+
+	// Guard mutex to ensure that only one singleton object is created.
+	stateCollectionOnce sync.Once
+)
+
+/*
+Represents a single `State` object in a collection of objects in the database.
+This collection is managed by the `qmgo` Mongo ODM library.
+*/
+type StateCollection struct {
+	*db.QMgoBase
+}
+
+// This line enforces StateCollection to implement db.QMgoCollection.
+var _ db.QMgoCollection = (*StateCollection)(nil)
+
+func (sc StateCollection) ParentDB() string {
+	return db.ROOT_DB
+}
+
+func (sc StateCollection) CollectionName() string {
+	return db.MSTATES_COLLECTION
+}
+
+// The names of the cursor fields that can be advanced.
+const (
+	DeliveredCursor = "delivered_id"
+	ReadCursor      = "read_id"
+)
+
+// Creates the indexes used by the collection. Each member has exactly one state object per room.
+func (sc StateCollection) SetupIndexes(ctx context.Context) error {
+	return sc.CreateIndexes(ctx, []options.IndexModel{
+		{Key: []string{"room_id", "user_id"}, IndexOptions: moptions.Index().SetUnique(true)},
+	})
+}
+
+// Gets the states of all members of a room that have one.
+func (sc StateCollection) GetAll(ctx context.Context, room util.UUID) ([]State, error) {
+	states := make([]State, 0)
+	err := sc.Find(ctx, bson.D{{Key: "room_id", Value: room}}).All(&states)
+	return states, err
+}
+
+// Gets the state of a member of a room. Returns `nil` if the member has no state yet.
+func (sc StateCollection) Get(ctx context.Context, room util.UUID, user util.UUID) (*State, error) {
+	var state State
+	err := sc.Find(ctx, bson.D{
+		{Key: "room_id", Value: room},
+		{Key: "user_id", Value: user},
+	}).One(&state)
+	if qmgo.IsErrNoDocuments(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+/*
+Moves one or more cursors of a member's state forward to the given message ID,
+creating the state if it doesn't exist. Cursors never move backwards, so
+acknowledgements that arrive out of order are harmless.
+*/
+func (sc StateCollection) Advance(ctx context.Context, room util.UUID, user util.UUID, msgID util.UUID, fields ...string) error {
+	//Construct the cursor updates
+	cursors := bson.D{}
+	for _, field := range fields {
+		cursors = append(cursors, bson.E{Key: field, Value: msgID})
+	}
+
+	now := time.Now()
+	return sc.UpdateOne(ctx,
+		bson.D{
+			{Key: "room_id", Value: room},
+			{Key: "user_id", Value: user},
+		},
+		bson.D{
+			{Key: "$max", Value: cursors},
+			{Key: "$set", Value: bson.D{{Key: "updated_at", Value: now}}},
+			{Key: "$setOnInsert", Value: bson.D{
+				{Key: "_id", Value: util.MustNewUUID7()},
+				{Key: "created_at", Value: now},
+			}},
+		},
+		options.UpdateOptions{UpdateOptions: moptions.Update().SetUpsert(true)},
+	)
+}
+
+/*
+Gets the currently active collection object instance or initializes it.
+This can be safely called multiple times in the program to ensure a
+non-nil instance of the collection due to the usage of `sync.Once` to
+initialize the singleton.
+*/
+func GetCollection() *StateCollection {
+	stateCollectionOnce.Do(func() {
+		c := db.GetCollectionManager().GetCollection(StateCollection{})
+		stateCollectionInst = &StateCollection{c}
+	})
+	return stateCollectionInst
+}
@@ -0,0 +1,16 @@
+//go:generate go-enum --marshal --forceupper --mustparse --nocomments --names --values
+
+package prekey
+
+//
+//-- ENUM: Kind
+//
+
+// Sets the kinds of prekeys that users can publish for X3DH.
+/*
+ENUM(
+	SIGNED 		//A medium-term prekey, signed by the owner's identity key. Each user has at most one.
+	ONETIME 	//A prekey that's handed out to exactly one initiator and then discarded.
+)
+*/
+type Kind int8
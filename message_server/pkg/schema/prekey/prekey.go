@@ -0,0 +1,49 @@
+package prekey
+
+import (
+	"wraith.me/message_server/pkg/crypto"
+	"wraith.me/message_server/pkg/db"
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+Represents an X25519 prekey that a user published so others can start X3DH
+sessions with them while they're offline. Every prekey is signed by the
+owner's Ed25519 identity key, which lets initiators detect tampering by the
+server.
+*/
+type Prekey struct {
+	db.DBObj `bson:",inline"`
+
+	// Unique identifier for the prekey. Initiators echo this back in KEX1 so the owner knows which key was used.
+	ID util.UUID `json:"id" bson:"_id"`
+
+	// The ID of the user that owns the prekey.
+	Owner util.UUID `json:"-" bson:"user_id"`
+
+	// The kind of the prekey.
+	Kind Kind `json:"-" bson:"kind"`
+
+	// The public half of the prekey.
+	Key crypto.Pubkey `json:"key" bson:"key"`
+
+	// The signature of the key's bytes, made by the owner's identity key.
+	Signature crypto.Signature `json:"signature" bson:"signature"`
+}
+
+// Creates a new prekey object.
+func NewPrekey(owner util.UUID, kind Kind, key crypto.Pubkey, sig crypto.Signature) Prekey {
+	return Prekey{
+		DBObj:     db.NewDBObj(),
+		ID:        util.MustNewUUID7(),
+		Owner:     owner,
+		Kind:      kind,
+		Key:       key,
+		Signature: sig,
+	}
+}
+
+// Checks whether the prekey was signed by the given identity key.
+func (p Prekey) VerifyWith(identity crypto.Pubkey) bool {
+	return identity.Verify(p.Key[:], p.Signature)
+}
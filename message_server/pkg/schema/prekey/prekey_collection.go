@@ -0,0 +1,131 @@
+package prekey
+
+import (
+	"context"
+	"sync"
+
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	"wraith.me/message_server/pkg/db"
+	"wraith.me/message_server/pkg/util"
+)
+
+var (
+	// Holds the shared instance of this collection.
+	prekeyCollectionInst *PrekeyCollection
+
+	// Guard mutex to ensure that only one singleton object is created.
+	prekeyCollectionOnce sync.Once
+)
+
+/*
+Represents a single `Prekey` object in a collection of objects in the database.
+This collection is managed by the `qmgo` Mongo ODM library.
+*/
+type PrekeyCollection struct {
+	*db.QMgoBase
+}
+
+// This line enforces PrekeyCollection to implement db.QMgoCollection.
+var _ db.QMgoCollection = (*PrekeyCollection)(nil)
+
+func (pc PrekeyCollection) ParentDB() string {
+	return db.ROOT_DB
+}
+
+func (pc PrekeyCollection) CollectionName() string {
+	return db.PREKEYS_COLLECTION
+}
+
+// Creates the indexes used by the collection. Prekeys are always looked up per user, by kind, and in order of age.
+func (pc PrekeyCollection) SetupIndexes(ctx context.Context) error {
+	return pc.CreateIndexes(ctx, []options.IndexModel{
+		{Key: []string{"user_id", "kind", "_id"}},
+	})
+}
+
+/*
+Sets the signed prekey of a user, replacing the existing one if there is
+one. The new key is stored before the old ones are removed, so there's never
+a moment where the user has no signed prekey at all.
+*/
+func (pc PrekeyCollection) SetSigned(ctx context.Context, pk Prekey) error {
+	if _, err := pc.InsertOne(ctx, pk); err != nil {
+		return err
+	}
+	_, err := pc.RemoveAll(ctx, bson.D{
+		{Key: "user_id", Value: pk.Owner},
+		{Key: "kind", Value: KindSIGNED},
+		{Key: "_id", Value: bson.D{{Key: "$lt", Value: pk.ID}}},
+	})
+	return err
+}
+
+// Gets the signed prekey of a user. Returns `nil` if the user hasn't published one.
+func (pc PrekeyCollection) GetSigned(ctx context.Context, user util.UUID) (*Prekey, error) {
+	var pk Prekey
+	err := pc.Find(ctx, bson.D{
+		{Key: "user_id", Value: user},
+		{Key: "kind", Value: KindSIGNED},
+	}).Sort("-_id").One(&pk)
+	if qmgo.IsErrNoDocuments(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pk, nil
+}
+
+/*
+Atomically removes and returns the oldest one-time prekey of a user, so no
+key is ever handed to more than one initiator. Returns `nil` if the user has
+run out of one-time prekeys.
+*/
+func (pc PrekeyCollection) PopOneTime(ctx context.Context, user util.UUID) (*Prekey, error) {
+	var pk Prekey
+	err := pc.Find(ctx, bson.D{
+		{Key: "user_id", Value: user},
+		{Key: "kind", Value: KindONETIME},
+	}).Sort("_id").Apply(qmgo.Change{Remove: true}, &pk)
+	if qmgo.IsErrNoDocuments(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pk, nil
+}
+
+// Gets the number of one-time prekeys a user has left.
+func (pc PrekeyCollection) CountOneTime(ctx context.Context, user util.UUID) (int64, error) {
+	return pc.Find(ctx, bson.D{
+		{Key: "user_id", Value: user},
+		{Key: "kind", Value: KindONETIME},
+	}).Count()
+}
+
+/*
+Removes every prekey of a user. This is done when the user's identity key
+changes, since their prekeys were signed with the old one and can no longer
+be verified.
+*/
+func (pc PrekeyCollection) RemoveAllOf(ctx context.Context, user util.UUID) error {
+	_, err := pc.RemoveAll(ctx, bson.D{{Key: "user_id", Value: user}})
+	return err
+}
+
+/*
+Gets the currently active collection object instance or initializes it.
+This can be safely called multiple times in the program to ensure a
+non-nil instance of the collection due to the usage of `sync.Once` to
+initialize the singleton.
+*/
+func GetCollection() *PrekeyCollection {
+	prekeyCollectionOnce.Do(func() {
+		c := db.GetCollectionManager().GetCollection(PrekeyCollection{})
+		prekeyCollectionInst = &PrekeyCollection{c}
+	})
+	return prekeyCollectionInst
+}
@@ -4,9 +4,11 @@ import (
 	"crypto/subtle"
 	"fmt"
 	"net"
+	"slices"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/exp/maps"
 	"wraith.me/message_server/pkg/crypto"
 	"wraith.me/message_server/pkg/obj"
 	"wraith.me/message_server/pkg/obj/ip_addr"
@@ -23,6 +25,9 @@ var (
 	}}}
 )
 
+// The label given to the device key that a user registered with.
+const DefaultDeviceLabel = "Primary device"
+
 //TODO: add an equal function
 
 //
@@ -63,9 +68,24 @@ type User struct {
 	//The user's refresh tokens, keyed by their IDs in string form.
 	Tokens map[string]UserToken `json:"tokens" bson:"tokens"`
 
+	//The refresh tokens that were replaced by newer ones but haven't expired yet, keyed by their IDs in string form.
+	Rotated map[string]RotatedToken `json:"rotated" bson:"rotated,omitempty"`
+
 	//The user's friends.
 	Friends map[util.UUID]bool `json:"friends" bson:"friends"`
 
+	//The users that this user blocked.
+	Blocked map[util.UUID]bool `json:"blocked" bson:"blocked,omitempty"`
+
+	/*
+		The public keys of the user's devices, keyed by their fingerprints. Any
+		of these can be used to log in. The user's `Pubkey` is their identity
+		key and is always one of these. Users who registered before devices
+		were tracked have none, in which case their `Pubkey` is their only
+		device key.
+	*/
+	Devices map[string]DeviceKey `json:"devices" bson:"devices,omitempty"`
+
 	//Profile picture url
 	//ProfilePictureURL string `json:"profile_picture_url" bson:"profile_picture_url"`
 }
@@ -102,6 +122,10 @@ func NewUser(
 		Options:     options,
 		Tokens:      make(map[string]UserToken, 0),
 		Friends:     make(map[util.UUID]bool),
+		Blocked:     make(map[util.UUID]bool),
+		Devices: map[string]DeviceKey{
+			pubkey.Fingerprint(): {Key: pubkey, Label: DefaultDeviceLabel, Created: lastLogin},
+		},
 		//ProfilePictureURL: profilePictureURL,
 	}
 }
@@ -126,18 +150,38 @@ func NewUserSimple(username string, email string) *User {
 
 //-- Methods
 
-// Adds a new refresh token to this user object.
+// Adds a new refresh token to this user object. The token starts a new family.
 func (u *User) AddToken(tid, token string, exp time.Time) {
+	u.AddDeviceToken(tid, token, exp, "")
+}
+
+/*
+Adds a new refresh token to this user object, issued to the device whose key
+has the given fingerprint. The token starts a new family, which is tied to the
+device for as long as it lives.
+*/
+func (u *User) AddDeviceToken(tid, token string, exp time.Time, device string) {
 	//Create the token map if it doesn't already exist
 	if u.Tokens == nil {
 		u.Tokens = make(map[string]UserToken)
 	}
 
 	//Add the token to the list of the user's tokens
-	tok := UserToken{Token: token, Expiry: exp}
+	tok := UserToken{Token: token, Expiry: exp, Family: util.UUIDFromString(tid), Device: device}
 	(*u).Tokens[tid] = tok
 }
 
+// Gets the token families that were started by logging in with the device key that has the given fingerprint.
+func (u User) DeviceFamilies(fp string) []util.UUID {
+	families := make([]util.UUID, 0)
+	for tid, tok := range u.Tokens {
+		if tok.Device == fp && !slices.Contains(families, tok.FamilyOf(tid)) {
+			families = append(families, tok.FamilyOf(tid))
+		}
+	}
+	return families
+}
+
 // Checks if a user has a particular token.
 func (u User) HasToken(tok string) bool {
 	for _, token := range u.Tokens {
@@ -154,6 +198,39 @@ func (u User) HasTokenById(tokId string) bool {
 	return ok
 }
 
+/*
+Gets the family of a refresh token that was replaced by a newer one. The
+second return value is false if the token was never rotated out, or if it
+expired and is no longer tracked.
+*/
+func (u User) RotatedFamily(tid string) (util.UUID, bool) {
+	rtok, ok := u.Rotated[tid]
+	if !ok {
+		return util.NilUUID(), false
+	}
+	return rtok.FamilyOf(tid), true
+}
+
+/*
+Gets the public keys of the user's devices, keyed by their fingerprints. For
+users who registered before devices were tracked, this is their `Pubkey`
+alone.
+*/
+func (u User) DeviceKeys() map[string]DeviceKey {
+	if len(u.Devices) > 0 {
+		return u.Devices
+	}
+	return map[string]DeviceKey{
+		u.Pubkey.Fingerprint(): {Key: u.Pubkey, Label: DefaultDeviceLabel},
+	}
+}
+
+// Checks if a public key belongs to one of the user's devices.
+func (u User) HasDeviceKey(pk crypto.Pubkey) bool {
+	dev, ok := u.DeviceKeys()[pk.Fingerprint()]
+	return ok && dev.Key.Equal(pk)
+}
+
 // Marks a user's email as verified.
 func (u *User) MarkEmailVerified() {
 	u.Flags.EmailVerified = true
@@ -200,6 +277,31 @@ func (u User) IsFriend(friendID util.UUID) bool {
 	return exists
 }
 
+// Checks if this user blocked another user.
+func (u User) HasBlocked(otherID util.UUID) bool {
+	return u.Blocked[otherID]
+}
+
+// Gets the IDs of the users that this user blocked.
+func (u User) BlockedIDs() []util.UUID {
+	return maps.Keys(u.Blocked)
+}
+
+/*
+Checks if this user's read receipts may be sent to another user, according
+to the user's `ReadReceiptsScope` option.
+*/
+func (u User) SendsReadReceiptsTo(other util.UUID) bool {
+	switch u.Options.ReadReceipts {
+	case ReadReceiptsScopeEVERYONE:
+		return true
+	case ReadReceiptsScopeFRIENDS:
+		return u.IsFriend(other)
+	default:
+		return false
+	}
+}
+
 // Add friend mapping.
 func (u *User) AddFriend(friend *User) error {
 	if u.ID == friend.ID {
@@ -276,6 +378,9 @@ type UserOptions struct {
 
 	//Indicates if the user can receive messages from non-friended users.
 	UnsolicitedMessages bool `json:"unsolicited_messages" bson:"unsolicited_messages"`
+
+	//Indicates if the user's presence should be hidden from their friends.
+	HidePresence bool `json:"hide_presence" bson:"hide_presence"`
 }
 
 // Controls the default flag options for new users.
@@ -284,6 +389,7 @@ func DefaultUserOptions() UserOptions {
 		FindByUName:         true,                     //Users should be discoverable by their username by default.
 		ReadReceipts:        ReadReceiptsScopeFRIENDS, //Users should send read receipts only to their friends by default.
 		UnsolicitedMessages: false,                    //Users should not be able to be messaged without their consent by random, non-friends.
+		HidePresence:        false,                    //Users' friends should be able to see when they're online by default.
 	}
 }
 
@@ -298,4 +404,68 @@ type UserToken struct {
 
 	//The expiry of the token.
 	Expiry time.Time `json:"expiry" bson:"expiry"`
+
+	//The ID of the token that this one replaced. This is a nil UUID if the token was issued at login.
+	Parent util.UUID `json:"parent" bson:"parent,omitempty"`
+
+	//The ID of the token that was issued at login, which every token in its lineage descends from.
+	Family util.UUID `json:"family" bson:"family,omitempty"`
+
+	//The fingerprint of the device key that was used to log in, which carries over when the token is rotated. Empty for tokens issued before this was tracked.
+	Device string `json:"device,omitempty" bson:"device,omitempty"`
+}
+
+// Gets the family of this token, given its ID. Tokens issued before families were tracked are their own family.
+func (t UserToken) FamilyOf(tid string) util.UUID {
+	if t.Family.IsNil() {
+		return util.UUIDFromString(tid)
+	}
+	return t.Family
+}
+
+//
+//-- CLASS: DeviceKey
+//
+
+// Represents the public key of one of a user's devices.
+type DeviceKey struct {
+	//The public key itself.
+	Key crypto.Pubkey `json:"key" bson:"key"`
+
+	//The name the user gave the device.
+	Label string `json:"label" bson:"label"`
+
+	//The time at which the key was added.
+	Created time.Time `json:"created" bson:"created"`
+
+	//The last time the key was used to log in. This is zero if it never was.
+	LastUsed time.Time `json:"last_used" bson:"last_used"`
+}
+
+//
+//-- CLASS: RotatedToken
+//
+
+/*
+Represents a refresh token that was replaced by a newer one. The token itself
+isn't kept, since it may never be used again; presenting it is a sign that it
+was stolen, so only what's needed to revoke its lineage is.
+*/
+type RotatedToken struct {
+	//The ID of the token that this one replaced. This is a nil UUID if the token was issued at login.
+	Parent util.UUID `json:"parent" bson:"parent,omitempty"`
+
+	//The ID of the token that was issued at login, which every token in its lineage descends from.
+	Family util.UUID `json:"family" bson:"family,omitempty"`
+
+	//The expiry of the token. Once it passes, the token is rejected outright, so it's no longer tracked.
+	Expiry time.Time `json:"expiry" bson:"expiry"`
+}
+
+// Gets the family of this token, given its ID. Tokens issued before families were tracked are their own family.
+func (t RotatedToken) FamilyOf(tid string) util.UUID {
+	if t.Family.IsNil() {
+		return util.UUIDFromString(tid)
+	}
+	return t.Family
 }
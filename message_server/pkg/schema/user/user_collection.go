@@ -1,9 +1,17 @@
 package user
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"time"
 
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"wraith.me/message_server/pkg/crypto"
 	"wraith.me/message_server/pkg/db"
+	"wraith.me/message_server/pkg/util"
 )
 
 var (
@@ -12,6 +20,18 @@ var (
 
 	// Guard mutex to ensure that only one singleton object is created.
 	userCollectionOnce sync.Once
+
+	// Returned when a refresh token is presented again after it was already replaced by a newer one.
+	ErrTokenReused = errors.New("refresh token was already used")
+
+	// Returned when a device key is added that already belongs to a user.
+	ErrDeviceExists = errors.New("this key already belongs to a user")
+
+	// Returned when a device key doesn't belong to the user, or was revoked in the meantime.
+	ErrDeviceNotFound = errors.New("no such device key")
+
+	// Returned when the only device key of a user is revoked, which would lock them out.
+	ErrLastDevice = errors.New("the last device key of a user cannot be revoked")
 )
 
 /*
@@ -33,6 +53,383 @@ func (uc UserCollection) CollectionName() string {
 	return db.USERS_COLLECTION
 }
 
+/*
+Marks two users as friends of one another. Both documents are changed in a
+single transaction, so the friendship is never left one-sided, and is only
+added if both users exist. Transactions require MongoDB to be running as a
+replica set. The `User.AddFriend()` method should be used for users that are
+held in memory.
+*/
+func (uc UserCollection) AddFriendship(ctx context.Context, a, b util.UUID) error {
+	if a == b {
+		return fmt.Errorf("user cannot be friends with themselves")
+	}
+
+	_, err := db.GetInstance().GetClient().DoTransaction(ctx, func(sctx context.Context) (interface{}, error) {
+		for _, pair := range [][2]util.UUID{{a, b}, {b, a}} {
+			//Merge the other user's ID into the user's friends map, which may be null
+			pipeline := bson.A{bson.D{{Key: "$set", Value: bson.D{
+				{Key: "friends", Value: bson.D{{Key: "$mergeObjects", Value: bson.A{
+					bson.D{{Key: "$ifNull", Value: bson.A{"$friends", bson.D{}}}},
+					bson.D{{Key: pair[1].String(), Value: true}},
+				}}}},
+			}}}}
+			if err := uc.UpdateId(sctx, pair[0], pipeline); errors.Is(err, qmgo.ErrNoSuchDocuments) {
+				return nil, fmt.Errorf("one or both users do not exist")
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	return err
+}
+
+/*
+Ends the friendship between two users, if they're friends. Both documents are
+changed by the same update; neither user can be friends with themselves, so
+removing both keys from both users only removes the friendship.
+*/
+func (uc UserCollection) RemoveFriendship(ctx context.Context, a, b util.UUID) error {
+	_, err := uc.UpdateAll(ctx,
+		bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: bson.A{a, b}}}}},
+		bson.D{{Key: "$unset", Value: bson.D{
+			{Key: "friends." + a.String(), Value: ""},
+			{Key: "friends." + b.String(), Value: ""},
+		}}},
+	)
+	return err
+}
+
+/*
+Makes one user block another, which also ends their friendship. Blocks only
+go one way; the blocked user isn't told about it. Users saved before blocks
+were added may have a null blocklist, which `$set` can't add a key to, so the
+blocked user is merged in instead.
+*/
+func (uc UserCollection) Block(ctx context.Context, blocker, blocked util.UUID) error {
+	if blocker == blocked {
+		return fmt.Errorf("user cannot block themselves")
+	}
+	err := uc.UpdateId(ctx, blocker, bson.A{bson.D{{Key: "$set", Value: bson.D{
+		{Key: "blocked", Value: bson.D{{Key: "$mergeObjects", Value: bson.A{
+			bson.D{{Key: "$ifNull", Value: bson.A{"$blocked", bson.D{}}}},
+			bson.D{{Key: blocked.String(), Value: true}},
+		}}}},
+	}}}})
+	if err != nil {
+		return err
+	}
+	return uc.RemoveFriendship(ctx, blocker, blocked)
+}
+
+// Lifts a block that one user placed on another.
+func (uc UserCollection) Unblock(ctx context.Context, blocker, blocked util.UUID) error {
+	return uc.UpdateId(ctx, blocker, bson.D{
+		{Key: "$unset", Value: bson.D{{Key: "blocked." + blocked.String(), Value: ""}}},
+	})
+}
+
+// Gets the users among the given ones that blocked a user.
+func (uc UserCollection) BlockersOf(ctx context.Context, blocked util.UUID, among []util.UUID) ([]util.UUID, error) {
+	var found []struct {
+		ID util.UUID `bson:"_id"`
+	}
+	err := uc.Find(ctx, bson.D{
+		{Key: "_id", Value: bson.D{{Key: "$in", Value: among}}},
+		{Key: "blocked." + blocked.String(), Value: true},
+	}).Select(bson.D{{Key: "_id", Value: 1}}).All(&found)
+	if err != nil {
+		return nil, err
+	}
+
+	blockers := make([]util.UUID, 0, len(found))
+	for _, f := range found {
+		blockers = append(blockers, f.ID)
+	}
+	return blockers, nil
+}
+
+/*
+Gets a filter that leaves out the users who blocked a user, so they can't be
+found by that user.
+*/
+func NotBlocking(uid util.UUID) bson.E {
+	return bson.E{Key: "blocked." + uid.String(), Value: bson.D{{Key: "$ne", Value: true}}}
+}
+
+/*
+Replaces one of a user's refresh tokens with its successor, which joins the
+same family. The update only goes through if the old token is still on file,
+so a token can't be rotated twice even if two requests race to present it;
+the loser gets `ErrTokenReused`. The old token is moved to the user's rotated
+tokens so that it can be recognized if it's ever presented again. The user's
+last login fields are stored alongside, and the user object is updated to
+match.
+*/
+func (uc UserCollection) RotateToken(ctx context.Context, usr *User, old string, next string, token string, exp time.Time) error {
+	prev, ok := usr.Tokens[old]
+	if !ok {
+		return ErrTokenReused
+	}
+	succ := UserToken{Token: token, Expiry: exp, Parent: util.UUIDFromString(old), Family: prev.FamilyOf(old), Device: prev.Device}
+	retired := RotatedToken{Parent: prev.Parent, Family: succ.Family, Expiry: prev.Expiry}
+
+	err := uc.UpdateOne(ctx,
+		bson.D{
+			{Key: "_id", Value: usr.ID},
+			{Key: "tokens." + old, Value: bson.D{{Key: "$exists", Value: true}}},
+		},
+		bson.D{
+			{Key: "$unset", Value: bson.D{{Key: "tokens." + old, Value: ""}}},
+			{Key: "$set", Value: bson.D{
+				{Key: "tokens." + next, Value: succ},
+				{Key: "rotated." + old, Value: retired},
+				{Key: "last_ip", Value: usr.LastIP},
+				{Key: "last_login", Value: usr.LastLogin},
+			}},
+		},
+	)
+	if qmgo.IsErrNoDocuments(err) {
+		return ErrTokenReused
+	}
+	if err != nil {
+		return err
+	}
+
+	//Mirror the update on the user object
+	delete(usr.Tokens, old)
+	usr.Tokens[next] = succ
+	if usr.Rotated == nil {
+		usr.Rotated = make(map[string]RotatedToken)
+	}
+	usr.Rotated[old] = retired
+	return nil
+}
+
+/*
+Revokes every refresh token in a family, both the ones that are still in use
+and the ones that were rotated out, which logs out the session that they
+belong to. Other families are left alone. This is done by a single update, so
+a rotation that races it can't leave a descendant behind. The IDs of the
+tokens that were still in use are returned, so that their sessions can be
+cut off.
+*/
+func (uc UserCollection) RevokeFamily(ctx context.Context, uid util.UUID, family util.UUID) ([]util.UUID, error) {
+	return uc.RevokeFamilies(ctx, uid, []util.UUID{family})
+}
+
+// Revokes every refresh token in several families at once. See `RevokeFamily()`.
+func (uc UserCollection) RevokeFamilies(ctx context.Context, uid util.UUID, families []util.UUID) ([]util.UUID, error) {
+	//Tokens issued before families were tracked are their own family, so they're matched by ID as well
+	fids := make(bson.A, len(families))
+	fkeys := make(bson.A, len(families))
+	inFamily := make(map[util.UUID]bool, len(families))
+	for i, family := range families {
+		fids[i] = family
+		fkeys[i] = family.String()
+		inFamily[family] = true
+	}
+	outside := bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "$not", Value: bson.A{bson.D{{Key: "$in", Value: bson.A{"$$tok.v.family", fids}}}}}},
+		bson.D{{Key: "$not", Value: bson.A{bson.D{{Key: "$in", Value: bson.A{"$$tok.k", fkeys}}}}}},
+	}}}
+	pipeline := bson.A{bson.D{{Key: "$set", Value: bson.D{
+		{Key: "tokens", Value: filterTokens("$tokens", outside)},
+		{Key: "rotated", Value: filterTokens("$rotated", outside)},
+	}}}}
+
+	//Get the tokens as they were before the update to find the ones that were removed
+	var prev User
+	err := uc.Find(ctx, bson.D{{Key: "_id", Value: uid}}).
+		Select(bson.D{{Key: "tokens", Value: 1}}).
+		Apply(qmgo.Change{Update: pipeline}, &prev)
+	if err != nil {
+		return nil, err
+	}
+	revoked := make([]util.UUID, 0, len(families))
+	for tid, tok := range prev.Tokens {
+		if inFamily[tok.FamilyOf(tid)] {
+			revoked = append(revoked, util.UUIDFromString(tid))
+		}
+	}
+	return revoked, nil
+}
+
+// Stops tracking a user's rotated refresh tokens once they expire, since they'd be rejected outright by then.
+func (uc UserCollection) PruneRotated(ctx context.Context, uid util.UUID) error {
+	unexpired := bson.D{{Key: "$gt", Value: bson.A{"$$tok.v.expiry", "$$NOW"}}}
+	pipeline := bson.A{bson.D{{Key: "$set", Value: bson.D{
+		{Key: "rotated", Value: filterTokens("$rotated", unexpired)},
+	}}}}
+	return uc.UpdateId(ctx, uid, pipeline)
+}
+
+/*
+Builds a pipeline expression that keeps the entries of a token map that meet
+a condition. Each entry is bound to `$$tok` as a key-value pair. Missing maps
+come back empty rather than null, so that single tokens can still be set on
+them afterwards.
+*/
+func filterTokens(field string, cond bson.D) bson.D {
+	return bson.D{{Key: "$arrayToObject", Value: bson.D{{Key: "$filter", Value: bson.D{
+		{Key: "input", Value: bson.D{{Key: "$objectToArray", Value: bson.D{{Key: "$ifNull", Value: bson.A{field, bson.D{}}}}}}},
+		{Key: "as", Value: "tok"},
+		{Key: "cond", Value: cond},
+	}}}}}
+}
+
+/*
+Adds a device key to a user. The key mustn't belong to any user already,
+whether as a device key or an identity key. Users who registered before
+devices were tracked get their identity key stored as a device key too. The
+user object is updated to match.
+*/
+func (uc UserCollection) AddDevice(ctx context.Context, usr *User, dev DeviceKey) error {
+	fp := dev.Key.Fingerprint()
+	taken := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "pubkey", Value: dev.Key}},
+		bson.D{{Key: "devices." + fp, Value: bson.D{{Key: "$exists", Value: true}}}},
+	}}}
+	if n, err := uc.Find(ctx, taken).Count(); err != nil {
+		return err
+	} else if n > 0 {
+		return ErrDeviceExists
+	}
+
+	devices := usr.DeviceKeys()
+	set := bson.D{{Key: "devices." + fp, Value: dev}}
+	if len(usr.Devices) == 0 {
+		for kfp, kdev := range devices {
+			set = append(set, bson.E{Key: "devices." + kfp, Value: kdev})
+		}
+	}
+	err := uc.UpdateOne(ctx,
+		bson.D{
+			{Key: "_id", Value: usr.ID},
+			{Key: "devices." + fp, Value: bson.D{{Key: "$exists", Value: false}}},
+		},
+		bson.D{{Key: "$set", Value: set}},
+	)
+	if qmgo.IsErrNoDocuments(err) {
+		return ErrDeviceExists
+	}
+	if err != nil {
+		return err
+	}
+
+	//Mirror the update on the user object
+	usr.Devices = make(map[string]DeviceKey, len(devices)+1)
+	for kfp, kdev := range devices {
+		usr.Devices[kfp] = kdev
+	}
+	usr.Devices[fp] = dev
+	return nil
+}
+
+/*
+Records that one of a user's device keys was used to log in. Users who
+registered before devices were tracked get their identity key stored as a
+device key. The user object is updated to match.
+*/
+func (uc UserCollection) TouchDevice(ctx context.Context, usr *User, pk crypto.Pubkey, now time.Time) error {
+	fp := pk.Fingerprint()
+	dev, ok := usr.DeviceKeys()[fp]
+	if !ok {
+		return ErrDeviceNotFound
+	}
+	dev.LastUsed = now
+
+	filter := bson.D{{Key: "_id", Value: usr.ID}}
+	update := bson.D{{Key: "devices." + fp + ".last_used", Value: now}}
+	if len(usr.Devices) == 0 {
+		filter = append(filter, bson.E{Key: "devices", Value: bson.D{{Key: "$exists", Value: false}}})
+		update = bson.D{{Key: "devices." + fp, Value: dev}}
+	} else {
+		filter = append(filter, bson.E{Key: "devices." + fp, Value: bson.D{{Key: "$exists", Value: true}}})
+	}
+	err := uc.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: update}})
+	if qmgo.IsErrNoDocuments(err) {
+		return ErrDeviceNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	//Mirror the update on the user object
+	if len(usr.Devices) == 0 {
+		usr.Devices = make(map[string]DeviceKey, 1)
+	}
+	usr.Devices[fp] = dev
+	return nil
+}
+
+/*
+Revokes one of a user's device keys by its fingerprint, so it can no longer be
+used to log in. A user's last device key can't be revoked. If the key was the
+user's identity key, the most recently used of the remaining keys takes its
+place, and true is returned so that anything signed by the old identity key
+can be dropped. The update only goes through if the user's keys didn't change
+in the meantime, so two revocations can't race to remove every key. The user
+object is updated to match.
+*/
+func (uc UserCollection) RevokeDevice(ctx context.Context, usr *User, fp string) (bool, error) {
+	devices := usr.DeviceKeys()
+	dev, ok := devices[fp]
+	if !ok {
+		return false, ErrDeviceNotFound
+	}
+	if len(devices) == 1 {
+		return false, ErrLastDevice
+	}
+
+	filter := bson.D{
+		{Key: "_id", Value: usr.ID},
+		{Key: "pubkey", Value: usr.Pubkey},
+		{Key: "devices." + fp, Value: bson.D{{Key: "$exists", Value: true}}},
+	}
+	update := bson.D{{Key: "$unset", Value: bson.D{{Key: "devices." + fp, Value: ""}}}}
+
+	//Pick a new identity key if the current one is being revoked
+	identity := usr.Pubkey
+	rotated := dev.Key.Equal(usr.Pubkey)
+	if rotated {
+		var succ string
+		for kfp, kdev := range devices {
+			if kfp == fp {
+				continue
+			}
+			if succ == "" || lastActive(kdev).After(lastActive(devices[succ])) {
+				succ = kfp
+			}
+		}
+		identity = devices[succ].Key
+		filter = append(filter, bson.E{Key: "devices." + succ, Value: bson.D{{Key: "$exists", Value: true}}})
+		update = append(update, bson.E{Key: "$set", Value: bson.D{{Key: "pubkey", Value: identity}}})
+	}
+
+	err := uc.UpdateOne(ctx, filter, update)
+	if qmgo.IsErrNoDocuments(err) {
+		return false, ErrDeviceNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+
+	//Mirror the update on the user object
+	delete(usr.Devices, fp)
+	usr.Pubkey = identity
+	return rotated, nil
+}
+
+// Gets the last time a device key was active, which is when it was last used to log in or when it was added.
+func lastActive(dev DeviceKey) time.Time {
+	if dev.LastUsed.After(dev.Created) {
+		return dev.LastUsed
+	}
+	return dev.Created
+}
+
 /*
 Gets the currently active collection object instance or initializes it.
 This can be safely called multiple times in the program to ensure a
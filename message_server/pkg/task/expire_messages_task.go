@@ -0,0 +1,43 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"wraith.me/message_server/pkg/ws/wschat"
+)
+
+// Removes disappearing messages once they expire and tells their rooms; implements `Task`.
+type ExpireMessagesTask struct {
+	//Defines the duration between runs.
+	TQ time.Duration
+
+	//The chat server that tells live clients about the removals.
+	Server *wschat.Server
+
+	//The context to run the operations in.
+	CTX context.Context
+}
+
+var _ Task = (*ExpireMessagesTask)(nil) // Type assertion check to ensure compliance with `Task` interface.
+
+func (emt ExpireMessagesTask) periodicDuration() time.Duration {
+	return emt.TQ
+}
+
+func (emt ExpireMessagesTask) runOnStart() {
+	emt.runPeriodically()
+}
+
+func (emt ExpireMessagesTask) runOnStop() {}
+
+func (emt ExpireMessagesTask) runPeriodically() {
+	removed, err := emt.Server.ExpireMessages(emt.CTX)
+	if err != nil {
+		fmt.Printf("Error removing expired messages: %v\n", err)
+	}
+	if removed > 0 {
+		fmt.Printf("Removed %d expired messages.\n", removed)
+	}
+}
@@ -0,0 +1,47 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"wraith.me/message_server/pkg/blob"
+	"wraith.me/message_server/pkg/schema/attachment"
+)
+
+// Removes attachment uploads that were never finished; implements `Task`.
+type PurgeUploadsTask struct {
+	//Defines the duration between runs.
+	TQ time.Duration
+
+	//The store that holds the chunks of the uploads.
+	Store blob.BlobStore
+
+	//How long uploads may go unfinished before they're removed.
+	Lifetime time.Duration
+
+	//The context to run the operations in.
+	CTX context.Context
+}
+
+var _ Task = (*PurgeUploadsTask)(nil) // Type assertion check to ensure compliance with `Task` interface.
+
+func (put PurgeUploadsTask) periodicDuration() time.Duration {
+	return put.TQ
+}
+
+func (put PurgeUploadsTask) runOnStart() {
+	put.runPeriodically()
+}
+
+func (put PurgeUploadsTask) runOnStop() {}
+
+func (put PurgeUploadsTask) runPeriodically() {
+	removed, err := attachment.PurgeStale(put.CTX, put.Store, time.Now().Add(-put.Lifetime))
+	if err != nil {
+		fmt.Printf("Error removing unfinished uploads: %v\n", err)
+	}
+	if removed > 0 {
+		fmt.Printf("Removed %d unfinished uploads.\n", removed)
+	}
+}
@@ -0,0 +1,78 @@
+package wschat
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"wraith.me/message_server/pkg/blob"
+	"wraith.me/message_server/pkg/http_types/ws/chat"
+	"wraith.me/message_server/pkg/schema/attachment"
+	chatmessage "wraith.me/message_server/pkg/schema/chat_message"
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+Ensures that the files attached to a message were fully uploaded to the same
+room. Any member of the room may attach them, so files can be passed along
+without being uploaded again.
+*/
+func checkAttachments(cmsg *chat.Message, room *WSRoom) *chat.ServerError {
+	if len(cmsg.Attachments) == 0 {
+		cmsg.Attachments = nil
+		return nil
+	}
+
+	//Drop duplicates, keeping the order the client gave
+	seen := make(map[util.UUID]bool, len(cmsg.Attachments))
+	cmsg.Attachments = slices.DeleteFunc(cmsg.Attachments, func(id util.UUID) bool {
+		dup := seen[id]
+		seen[id] = true
+		return dup
+	})
+	if len(cmsg.Attachments) > maxAttachments {
+		return &chat.ServerError{
+			Code:   chat.ErrCodeBAD_REFERENCE,
+			Reason: fmt.Sprintf("messages can have at most %d attachments", maxAttachments),
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+
+	n, err := attachment.GetCollection().CountComplete(ctx, room.ID, cmsg.Attachments)
+	if err != nil {
+		fmt.Printf("wschat: failed to check the attachments of message %s: %s\n", cmsg.ID, err)
+		return &chat.ServerError{Code: chat.ErrCodeINTERNAL, Reason: "failed to send message"}
+	}
+	if n != int64(len(cmsg.Attachments)) {
+		return &chat.ServerError{
+			Code:   chat.ErrCodeBAD_REFERENCE,
+			Reason: "attachments must be fully uploaded to this room before they're sent",
+		}
+	}
+	return nil
+}
+
+/*
+Attaches the store that holds the files sent in rooms to the server. Files
+attached to expired messages are only removed if one is attached.
+*/
+func (w *Server) SetBlobStore(store blob.BlobStore) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.blobs = store
+}
+
+// Removes the files attached to a message that's gone, if a store is attached.
+func (w *Server) releaseAttachments(ctx context.Context, msg chatmessage.Message) {
+	w.mutex.Lock()
+	store := w.blobs
+	w.mutex.Unlock()
+	if store == nil || len(msg.Attachments) == 0 {
+		return
+	}
+	if err := chatmessage.ReleaseAttachments(ctx, store, msg.Attachments); err != nil {
+		fmt.Printf("wschat: failed to remove the attachments of message %s: %s\n", msg.ID, err)
+	}
+}
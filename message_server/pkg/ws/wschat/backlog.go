@@ -0,0 +1,111 @@
+package wschat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/olahol/melody"
+	"go.mongodb.org/mongo-driver/bson"
+	"wraith.me/message_server/pkg/http_types/ws/chat"
+	chatmessage "wraith.me/message_server/pkg/schema/chat_message"
+	memberstate "wraith.me/message_server/pkg/schema/member_state"
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+Delivers the messages that a user hasn't acknowledged yet, oldest first, and
+then switches them over to live traffic. Live messages that arrived while the
+backlog was being sent are held back until it's done, so ordering is kept.
+*/
+func (w *Server) deliverBacklog(s *melody.Session, room *WSRoom, uinfo *UserData) {
+	//Get the messages the user is owed
+	backlog, err := loadBacklog(room.ID, uinfo.ID, uinfo.blockedIDs(), w.getConfig().MaxBacklog)
+	if err != nil {
+		fmt.Printf("wschat: failed to load backlog of user %s in room %s: %s\n", uinfo.ID, room.ID, err)
+		sendError(s, uinfo, chat.ServerError{
+			Code:   chat.ErrCodeINTERNAL,
+			Reason: "failed to load missed messages",
+		})
+	}
+
+	//Send the backlog
+	sent := make(map[util.UUID]bool, len(backlog))
+	for _, msg := range backlog {
+		uinfo.write(s, msg.Message.JSON())
+		sent[msg.ID] = true
+	}
+
+	//Go live, skipping anything that was already part of the backlog; edits and deletions reuse the IDs of the messages they change
+	uinfo.release(s, func(raw []byte) bool {
+		var msg chat.Message
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			return true
+		}
+		return !sent[msg.ID] || msg.Type == chat.TypeEDITED || msg.Type == chat.TypeDELETED
+	})
+}
+
+/*
+Moves a user's delivery cursor forward in response to an acknowledgement.
+The content of the message is the ID of the last message the client received.
+*/
+func (w *Server) handleAck(s *melody.Session, room *WSRoom, sender *UserData, ack chat.Message) {
+	//Get the ID of the acknowledged message
+	msgID, err := util.ParseUUIDv7(ack.Content)
+	if err != nil {
+		sendError(s, sender, chat.ServerError{
+			Code:   chat.ErrCodeMALFORMED,
+			Reason: "acknowledgements must contain the ID of a message",
+		})
+		return
+	}
+
+	//Advance the cursor
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+	err = memberstate.GetCollection().Advance(ctx, room.ID, sender.ID, msgID, memberstate.DeliveredCursor)
+	if err != nil {
+		fmt.Printf("wschat: failed to record ack of user %s in room %s: %s\n", sender.ID, room.ID, err)
+	}
+}
+
+/*
+Gets the messages in a room that a user hasn't acknowledged yet, oldest first,
+leaving out those sent by users they blocked. If more than `limit` are queued,
+only the most recent ones are returned; the rest can still be had via the
+room's history.
+*/
+func loadBacklog(roomID util.UUID, userID util.UUID, blocked []util.UUID, limit int) ([]chatmessage.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+
+	//Get the user's delivery cursor
+	state, err := memberstate.GetCollection().Get(ctx, roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	//Construct the search query
+	query := bson.D{{Key: "room_id", Value: roomID}, chatmessage.Unexpired(time.Now())}
+	if len(blocked) > 0 {
+		query = append(query, chatmessage.NotFrom(blocked))
+	}
+	if state != nil && !state.Delivered.IsNil() {
+		query = append(query, bson.E{Key: "_id", Value: bson.D{{Key: "$gt", Value: state.Delivered}}})
+	}
+
+	//Get the newest messages past the cursor
+	backlog := make([]chatmessage.Message, 0)
+	err = chatmessage.GetCollection().Find(ctx, query).Sort("-_id").Limit(int64(limit)).All(&backlog)
+	if err != nil {
+		return nil, err
+	}
+
+	//Flip them so they're oldest first
+	for i, j := 0, len(backlog)-1; i < j; i, j = i+1, j-1 {
+		backlog[i], backlog[j] = backlog[j], backlog[i]
+	}
+	return backlog, nil
+}
@@ -0,0 +1,67 @@
+package wschat
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/qiniu/qmgo"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+)
+
+// Represents a change to the users that a user blocked, relayed between the nodes of the cluster.
+type blockChange struct {
+	//The ID of the user that was blocked or unblocked.
+	Other util.UUID `json:"other"`
+
+	//Whether the user is now blocked.
+	Blocked bool `json:"blocked"`
+}
+
+// Gets the pub/sub channel that carries changes to the users a user blocked.
+func blocksChannel(id util.UUID) string {
+	return blocksChannelPrefix + id.String()
+}
+
+// Gets the IDs of the users that a user blocked from the database.
+func loadBlocked(userID util.UUID) ([]util.UUID, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+
+	var usr user.User
+	err := user.GetCollection().FindID(ctx, userID).One(&usr)
+	if qmgo.IsErrNoDocuments(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return usr.BlockedIDs(), nil
+}
+
+/*
+Tells every node that a user blocked or unblocked another user, so the
+sessions the user has open stop or start receiving the other user's messages
+right away.
+*/
+func (w *Server) AnnounceBlock(userID util.UUID, other util.UUID, blocked bool) error {
+	payload, err := json.Marshal(blockChange{Other: other, Blocked: blocked})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return w.rclient.Publish(ctx, blocksChannel(userID), payload).Err()
+}
+
+// Applies a change to the users that a user blocked to the sessions the user has on this node.
+func (w *Server) applyBlock(userID util.UUID, change blockChange) {
+	for _, room := range w.getRooms() {
+		for _, uinfo := range room.GetAllUserData() {
+			if uinfo.ID == userID {
+				uinfo.block(change.Other, change.Blocked)
+			}
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package wschat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"wraith.me/message_server/pkg/obj/session"
+	chatroom "wraith.me/message_server/pkg/schema/chat_room"
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+Represents a message relayed between the nodes of the chat cluster. Every
+node, including the one the message originated on, delivers the payload to
+its local sessions upon receiving the envelope.
+*/
+type envelope struct {
+	//The ID of the node that published the envelope.
+	Origin util.UUID `json:"origin"`
+
+	//The ID of the room the payload is destined for.
+	Room util.UUID `json:"room"`
+
+	//The raw message to deliver to the room's sessions.
+	Payload []byte `json:"payload"`
+
+	//The IDs of the users who should receive the payload. Everyone in the room receives it if empty.
+	To []util.UUID `json:"to,omitempty"`
+
+	//The IDs of users who should not receive the payload.
+	Excludes []util.UUID `json:"excludes,omitempty"`
+
+	//The new membership list of the room, if it changed.
+	Participants chatroom.MembershipList `json:"participants,omitempty"`
+
+	//The room's new disappearing messages timer, in seconds, if it changed.
+	DisappearAfter *int64 `json:"disappear_after,omitempty"`
+
+	//The IDs of users whose sessions should be closed once the payload is delivered.
+	Kick []util.UUID `json:"kick,omitempty"`
+
+	//Whether every session in the room should be closed once the payload is delivered.
+	Close bool `json:"close,omitempty"`
+}
+
+var (
+	/*
+		Atomically adds a user to a room's membership set, returning whether
+		the user was added and the new size of the set. Members whose leases
+		have lapsed are purged first, so a crashed node can't lock users out.
+		KEYS[1]: the membership set; ARGV: now, lease expiry, user ID, key TTL.
+	*/
+	joinScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+local added = redis.call('ZADD', KEYS[1], 'NX', ARGV[2], ARGV[3])
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return {added, redis.call('ZCARD', KEYS[1])}
+`)
+
+	/*
+		Atomically removes a user from a room's membership set, returning
+		whether the user was removed and the new size of the set.
+		KEYS[1]: the membership set; ARGV: now, user ID.
+	*/
+	leaveScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+local removed = redis.call('ZREM', KEYS[1], ARGV[2])
+return {removed, redis.call('ZCARD', KEYS[1])}
+`)
+)
+
+// Gets the pub/sub channel for a room.
+func roomChannel(id util.UUID) string {
+	return roomChannelPrefix + id.String()
+}
+
+// Gets the key of the cluster-wide membership set for a room.
+func membersKey(id util.UUID) string {
+	return membersKeyPrefix + id.String()
+}
+
+// Gets the current time as a Unix millisecond timestamp.
+func nowMs() int64 {
+	return time.Now().UnixMilli()
+}
+
+// Gets the pub/sub channel for a user.
+func userChannel(id util.UUID) string {
+	return userChannelPrefix + id.String()
+}
+
+// Subscribes to the channels of all rooms and users.
+func (w *Server) subscribe(ctx context.Context) error {
+	//Subscribe to the channels
+	patterns := []string{roomChannelPrefix + "*", userChannelPrefix + "*", blocksChannelPrefix + "*", session.RevokedChannel}
+	w.pubsub = w.rclient.PSubscribe(ctx, patterns...)
+
+	//Wait for the subscriptions to be confirmed so no messages are missed
+	for range patterns {
+		if _, err := w.pubsub.Receive(ctx); err != nil {
+			w.pubsub.Close()
+			return fmt.Errorf("failed to subscribe to room channels: %w", err)
+		}
+	}
+	return nil
+}
+
+// Listens for envelopes from the cluster and delivers them to local sessions.
+func (w *Server) listen(ctx context.Context) {
+	ch := w.pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			//User-scoped events are delivered as-is
+			if strings.HasPrefix(msg.Channel, userChannelPrefix) {
+				if uid, err := util.ParseUUIDv7(strings.TrimPrefix(msg.Channel, userChannelPrefix)); err == nil {
+					w.deliverToUser(uid, []byte(msg.Payload))
+				}
+				continue
+			}
+
+			//Connections opened with revoked sessions are closed
+			if msg.Channel == session.RevokedChannel {
+				if families, err := session.ParseRevoked(msg.Payload); err == nil {
+					w.closeRevoked(families)
+				}
+				continue
+			}
+
+			//Blocks are applied to the sessions of the user that changed them
+			if strings.HasPrefix(msg.Channel, blocksChannelPrefix) {
+				uid, err := util.ParseUUIDv7(strings.TrimPrefix(msg.Channel, blocksChannelPrefix))
+				var change blockChange
+				if err == nil && json.Unmarshal([]byte(msg.Payload), &change) == nil {
+					w.applyBlock(uid, change)
+				}
+				continue
+			}
+
+			//Parse the envelope
+			var env envelope
+			if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
+				fmt.Printf("wschat: dropping bad envelope on %s: %s\n", msg.Channel, err)
+				continue
+			}
+
+			//Deliver the payload if the room has sessions on this node
+			if strings.HasPrefix(msg.Channel, roomChannelPrefix) {
+				if room := w.GetRoom(env.Room); room != nil {
+					if env.Participants != nil {
+						room.setParticipants(env.Participants)
+					}
+					if env.DisappearAfter != nil {
+						room.setTimer(time.Duration(*env.DisappearAfter) * time.Second)
+					}
+					room.deliver(env.Payload, env.To, env.Excludes)
+					if env.Close || len(env.Kick) > 0 {
+						room.disconnect(env.Kick, env.Close)
+					}
+				}
+			}
+		}
+	}
+}
+
+/*
+Publishes a message to every node that has sessions in a room. If `to` is
+non-empty, only those users receive the message.
+*/
+func (w *Server) publish(roomID util.UUID, payload []byte, to []util.UUID, excludes []util.UUID) error {
+	return w.publishEnvelope(envelope{
+		Origin:   w.nodeID,
+		Room:     roomID,
+		Payload:  payload,
+		To:       to,
+		Excludes: excludes,
+	})
+}
+
+// Publishes an envelope to every node that has sessions in its room.
+func (w *Server) publishEnvelope(env envelope) error {
+	envs, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return w.rclient.Publish(ctx, roomChannel(env.Room), envs).Err()
+}
+
+/*
+Marks a user as present in a room across the cluster. Returns whether the
+user was added, which is false if they're already connected to the room on
+any node, as well as the number of users in the room after the operation.
+*/
+func (w *Server) claimMembership(roomID util.UUID, userID util.UUID) (bool, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	now := nowMs()
+	res, err := joinScript.Run(ctx, w.rclient, []string{membersKey(roomID)},
+		now, now+memberLease.Milliseconds(), userID.String(), memberLease.Milliseconds(),
+	).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return res[0] == 1, int(res[1]), nil
+}
+
+/*
+Marks a user as no longer present in a room across the cluster. Returns
+whether the user was removed and the number of users left in the room.
+*/
+func (w *Server) releaseMembership(roomID util.UUID, userID util.UUID) (bool, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	res, err := leaveScript.Run(ctx, w.rclient, []string{membersKey(roomID)},
+		nowMs(), userID.String(),
+	).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return res[0] == 1, int(res[1]), nil
+}
+
+// Gets the IDs of the users that are connected to a room on any node.
+func (w *Server) OnlineMembers(ctx context.Context, roomID util.UUID) ([]util.UUID, error) {
+	//Get the members whose leases haven't lapsed
+	ids, err := w.rclient.ZRangeByScore(ctx, membersKey(roomID), &redis.ZRangeBy{
+		Min: fmt.Sprintf("(%d", nowMs()),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	//Parse the IDs
+	out := make([]util.UUID, 0, len(ids))
+	for _, id := range ids {
+		var uid util.UUID
+		if err := uid.UnmarshalText([]byte(id)); err == nil {
+			out = append(out, uid)
+		}
+	}
+	return out, nil
+}
+
+/*
+Periodically renews the membership and presence leases of the users
+connected to this node. Should the node die, its users drop out of the
+cluster-wide counts and go offline once their leases lapse.
+*/
+func (w *Server) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(memberLease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			//Renew the leases of every local member in a single round trip
+			expiry := float64(nowMs() + memberLease.Milliseconds())
+			pipe := w.rclient.Pipeline()
+			for _, room := range w.getRooms() {
+				key := membersKey(room.ID)
+				for _, udata := range room.GetAllUserData() {
+					pipe.ZAddXX(ctx, key, redis.Z{Score: expiry, Member: udata.ID.String()})
+				}
+				pipe.PExpire(ctx, key, memberLease)
+			}
+			w.renewPresence(ctx, pipe, expiry)
+			if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+				fmt.Printf("wschat: failed to renew membership and presence leases: %s\n", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package wschat
+
+import "github.com/creasty/defaults"
+
+// Configuration object for the chat server.
+type WSConfig struct {
+	//The maximum size of an incoming chat message (in bytes). Larger messages are rejected. Default: 65536 (64 KiB).
+	MaxMessageSize int `toml:"max_message_size" env:"CHAT_MAX_MESSAGE_SIZE" default:"65536"`
+
+	//The maximum number of missed messages to send to a user when they connect to a room. Default: 500.
+	MaxBacklog int `toml:"max_backlog" env:"CHAT_MAX_BACKLOG" default:"500"`
+}
+
+func DefaultWSConfig() *WSConfig {
+	obj := &WSConfig{}
+	if err := defaults.Set(obj); err != nil {
+		panic(err)
+	}
+	return obj
+}
+
+/*
+Gets the read limit to apply to the underlying WebSocket connections. Frames
+a little over the message limit are still read in so they can be rejected
+with a proper error; anything past this drops the connection outright.
+*/
+func (c WSConfig) readLimit() int64 {
+	return int64(c.MaxMessageSize) * 2
+}
+
+/*
+Gets the read limit to apply to multiplexed connections. Frames wrap a chat
+message, so they're allowed a little room on top of the usual limit.
+*/
+func (c WSConfig) muxReadLimit() int64 {
+	return c.readLimit() + 1024
+}
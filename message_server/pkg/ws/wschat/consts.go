@@ -1,8 +1,74 @@
 package wschat
 
-import "wraith.me/message_server/pkg/obj"
+import (
+	"time"
+
+	"wraith.me/message_server/pkg/obj"
+)
 
 var (
 	// The context key name for a ws chat room ID.
 	WSChatCtxObjKey = obj.CtxKey{S: "roomID"}
+
+	// The context key name for the ID of the user opening a multiplexed connection.
+	WSMuxCtxObjKey = obj.CtxKey{S: "muxUserID"}
+)
+
+const (
+	// How long to wait for a message to be written to the database.
+	persistTimeout = 5 * time.Second
+
+	// The most files that can be attached to a single message.
+	maxAttachments = 10
+
+	// How many expired messages are removed from the database at a time.
+	expireBatchSize = 100
+
+	// How long to wait for the event bus to confirm a published event.
+	eventTimeout = 5 * time.Second
+
+	// The consumer group that sends presence changes to the friends of the users they concern.
+	presenceGroup = "presence_fanout"
+
+	// How long to wait for a Redis operation to complete.
+	redisTimeout = 5 * time.Second
+
+	// How long a user's presence in a room lasts before the node hosting them must renew it.
+	memberLease = 30 * time.Second
+
+	// How often a user's typing indicator may be relayed to the room while they keep typing.
+	typingThrottle = 3 * time.Second
+
+	// The WebSocket close code sent to users who were removed from a room.
+	closeCodeREMOVED = 4001
+
+	// The WebSocket close code sent to users whose session was revoked.
+	closeCodeREVOKED = 4002
+
+	// The prefix of the pub/sub channels that carry room traffic between nodes.
+	roomChannelPrefix = "wschat:room:"
+
+	// The prefix of the pub/sub channels that carry user-scoped events between nodes.
+	userChannelPrefix = "wschat:user:"
+
+	// The prefix of the pub/sub channels that carry changes to the users each user blocked.
+	blocksChannelPrefix = "wschat:blocks:"
+
+	// The session key under which the state of a multiplexed connection is kept.
+	muxConnKey = "mux"
+
+	// The prefix of the keys that hold the cluster-wide membership of each room.
+	membersKeyPrefix = "wschat:members:"
+
+	// The session key under which the presence of a connection is kept.
+	presenceConnKey = "presence"
+
+	// The prefix of the keys that hold the connections of each user, for working out their presence.
+	presenceKeyPrefix = "wschat:presence:"
+
+	// The prefix of the keys that hold the connections of each user that are marked as away.
+	awayKeyPrefix = "wschat:away:"
+
+	// The prefix of the keys that hold when each user was last connected.
+	lastSeenKeyPrefix = "wschat:lastseen:"
 )
@@ -0,0 +1,139 @@
+package wschat
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/qiniu/qmgo"
+	"wraith.me/message_server/pkg/amqp"
+	"wraith.me/message_server/pkg/http_types/ws/chat"
+	chatmessage "wraith.me/message_server/pkg/schema/chat_message"
+	chatroom "wraith.me/message_server/pkg/schema/chat_room"
+	"wraith.me/message_server/pkg/util"
+	"wraith.me/message_server/pkg/util/timex"
+)
+
+/*
+Works out when a message should disappear from the room's timer and the
+sender's override, and stamps it on the message. The override may only make
+the message disappear sooner than the room would have it, so members can't
+opt out of the room's timer.
+*/
+func stampExpiry(cmsg *chat.Message, room *WSRoom) *chat.ServerError {
+	//The override is consumed here; it isn't passed along to anyone
+	override := cmsg.DisappearAfter
+	cmsg.DisappearAfter = 0
+	cmsg.Expiry = nil
+	if !chatroom.ValidDisappearAfter(override) {
+		return &chat.ServerError{
+			Code:   chat.ErrCodeMALFORMED,
+			Reason: fmt.Sprintf("messages can disappear after at most %s", chatroom.MaxDisappearAfter.String()),
+		}
+	}
+
+	timer, err := roomTimer(room)
+	if err != nil {
+		fmt.Printf("wschat: failed to load the disappearing messages timer of room %s: %s\n", room.ID, err)
+		return &chat.ServerError{Code: chat.ErrCodeINTERNAL, Reason: "failed to send message"}
+	}
+
+	//Go with whichever of the two is shorter
+	if after := time.Duration(override) * time.Second; after > 0 && (timer == 0 || after < timer) {
+		timer = after
+	}
+	if timer > 0 {
+		expiry := time.Now().Add(timer).UTC()
+		cmsg.Expiry = &expiry
+	}
+	return nil
+}
+
+// Gets a room's disappearing messages timer, loading it from the database the first time it's needed.
+func roomTimer(room *WSRoom) (time.Duration, error) {
+	if timer, ok := room.getTimer(); ok {
+		return timer, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+
+	var croom chatroom.Room
+	if err := chatroom.GetCollection().FindID(ctx, room.ID).One(&croom); err != nil && !qmgo.IsErrNoDocuments(err) {
+		return 0, err
+	}
+	return room.initTimer(croom.DisappearTimer()), nil
+}
+
+/*
+Tells everyone connected to a room, on any node, that its disappearing
+messages timer changed. The change is kept in the room's history as a server
+message, so members who weren't connected find out about it too. Each node
+also picks up the new timer for the messages that are sent from then on.
+*/
+func (w *Server) AnnounceTimer(room chatroom.Room, by util.UUID) {
+	content := "Disappearing messages were turned off"
+	if room.DisappearAfter > 0 {
+		content = fmt.Sprintf("Messages now disappear %s after they're sent", timex.DurationX(room.DisappearTimer()).String())
+	}
+	msg := chat.NewMessage(content, by, room.ID)
+	if err := persistMessage(msg, room.ID); err != nil {
+		fmt.Printf("wschat: failed to persist message %s: %s\n", msg.ID, err)
+	}
+
+	secs := room.DisappearAfter
+	if err := w.publishEnvelope(envelope{
+		Origin:         w.nodeID,
+		Room:           room.ID,
+		Payload:        msg.JSON(),
+		DisappearAfter: &secs,
+	}); err != nil {
+		fmt.Printf("wschat: failed to announce the disappearing messages timer of room %s: %s\n", room.ID, err)
+	}
+
+	//Let the rest of the system know about the message
+	w.publishEvent(amqp.ExchangeCHAT, amqp.KeyMESSAGE_CREATED, chatmessage.NewMessage(msg, room.ID))
+}
+
+/*
+Removes the messages that have expired, along with the files attached to
+them, and tells the rooms they were in, same as if they'd been deleted. Any number of nodes can do this at once; each
+removal is only announced by the node that made it. Returns how many
+messages were removed by this call.
+*/
+func (w *Server) ExpireMessages(ctx context.Context) (int, error) {
+	mc := chatmessage.GetCollection()
+	now := time.Now()
+	removed := 0
+	for {
+		expired, err := mc.FindExpired(ctx, now, expireBatchSize)
+		if err != nil {
+			return removed, err
+		}
+
+		for _, msg := range expired {
+			ok, err := mc.RemoveExpired(ctx, msg.ID, now)
+			if err != nil {
+				return removed, err
+			}
+			if !ok {
+				continue
+			}
+
+			//Nothing of the message is kept, so the event carries only what identifies it
+			w.releaseAttachments(ctx, msg)
+			msg.Type = chat.TypeDELETED
+			msg.Content = ""
+			msg.Revisions = nil
+			msg.Reactions = nil
+			msg.Attachments = nil
+			msg.DeletedAt = &now
+			w.AnnounceMessageChange(msg)
+			removed++
+		}
+
+		if len(expired) < expireBatchSize {
+			return removed, nil
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package wschat
+
+import (
+	"context"
+	"fmt"
+
+	"wraith.me/message_server/pkg/amqp"
+	"wraith.me/message_server/pkg/util"
+)
+
+// The payload of the events published when users join or leave a room.
+type MembershipEvent struct {
+	//The ID of the room whose membership changed.
+	RoomID util.UUID `json:"room_id"`
+
+	//The ID of the user who joined or left.
+	UserID util.UUID `json:"user_id"`
+
+	//The number of users in the room before the change, across the cluster.
+	Old int `json:"old"`
+
+	//The number of users in the room after the change, across the cluster.
+	New int `json:"new"`
+}
+
+// Attaches an event bus to the server. Domain events are only published if one is attached.
+func (w *Server) SetEventBus(bus *amqp.Bus) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.events = bus
+}
+
+// Publishes a domain event to the attached event bus, if there is one.
+func (w *Server) publishEvent(exchange amqp.Exchange, key string, payload any) {
+	w.mutex.Lock()
+	bus := w.events
+	w.mutex.Unlock()
+	if bus == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), eventTimeout)
+	defer cancel()
+	if err := bus.Publish(ctx, exchange, key, payload); err != nil {
+		fmt.Printf("wschat: failed to publish %s event: %s\n", key, err)
+	}
+}
@@ -0,0 +1,25 @@
+package wschat
+
+import (
+	"github.com/olahol/melody"
+	"wraith.me/message_server/pkg/http_types/ws/chat"
+)
+
+/*
+Relays key material to the member it's addressed to. This covers both steps
+of an X3DH key exchange and the sender keys that members hand each other for
+group encryption. Such frames are meant for a single user, so they're neither
+broadcast to the rest of the room nor persisted in its history.
+*/
+func (w *Server) handleKeyExchange(s *melody.Session, room *WSRoom, sender *UserData, kex chat.Message) {
+	//The recipient must be another member of the room
+	if kex.Recipient == room.ID || kex.Recipient == sender.ID || !room.HasMember(kex.Recipient) {
+		sendError(s, sender, chat.ServerError{
+			Code:   chat.ErrCodeMALFORMED,
+			Reason: "key exchange messages must be addressed to another member of the room",
+		})
+		return
+	}
+
+	room.SendTo(kex.JSON(), kex.Recipient)
+}
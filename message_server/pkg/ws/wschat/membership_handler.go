@@ -1,8 +1,12 @@
 package wschat
 
 import (
+	"fmt"
+
 	"github.com/olahol/melody"
+	"wraith.me/message_server/pkg/amqp"
 	"wraith.me/message_server/pkg/http_types/ws/chat"
+	chatroom "wraith.me/message_server/pkg/schema/chat_room"
 	"wraith.me/message_server/pkg/util"
 )
 
@@ -23,63 +27,122 @@ func (w *Server) handleConnect(s *melody.Session) {
 		return
 	}
 
-	//Create the user data object for the room
-	uinfo := &UserData{
-		ID: *userID,
-	}
-
-	//Get an existing room or create a new one
-	room := w.getOrCreateRoom(*roomID, getParticipants(s))
-
-	//Reject the session if the user is already in the room
-	if room.HasUser(uinfo.ID) {
-		s.Write([]byte("You are already in the room"))
+	//Put the user in the room, ejecting them if that fails
+	uinfo := newHeldUserData(*userID, *roomID, false)
+	if serr := w.enterRoom(s, getParticipants(s), uinfo); serr != nil {
+		s.Write([]byte(serr.Reason))
 		s.Close()
 		return
 	}
 
-	//Get the number of users currently in the room
-	currentUsers := room.Size()
-
-	//Add the user to the room
-	room.AddSession(s, uinfo)
-
-	//Announce the membership change
-	announceMembershipChange(s, room, currentUsers)
+	//Count the connection towards the user's presence
+	w.goOnline(s, *userID)
 }
 
 // Handles disconnections from the chat server.
 func (w *Server) handleDisconnect(s *melody.Session) {
+	//The connection no longer counts towards the user's presence
+	w.goOffline(s)
+
 	//Get the room's ID
 	roomUUID := getRoomID(s)
 	if roomUUID == nil {
 		return
 	}
 
-	//Get the room instance and eject the session if it's non-null
+	//Get the room instance; sessions that were rejected on connect won't be in it
 	room := w.GetRoom(*roomUUID)
-	if room != nil {
-		//Get the number of users currently in the room
-		currentUsers := room.Size()
+	if room == nil {
+		return
+	}
+	w.exitRoom(room, s)
+}
 
-		//Remove the current session handler for the user
-		room.RemoveSession(s)
+/*
+Puts a user's session in a room, announcing their arrival and catching them
+up on what they missed. Each user may only be in a room once across the
+entire cluster, whether via a room connection or a multiplexed one.
+*/
+func (w *Server) enterRoom(s *melody.Session, participants chatroom.MembershipList, uinfo *UserData) *chat.ServerError {
+	//Claim the user's spot in the room across the entire cluster
+	added, newSize, err := w.claimMembership(uinfo.room, uinfo.ID)
+	if err != nil {
+		fmt.Printf("wschat: failed to claim membership in room %s: %s\n", uinfo.room, err)
+		return &chat.ServerError{Code: chat.ErrCodeINTERNAL, Reason: "Failed to join the room"}
+	}
 
-		//Broadcast the membership change event
-		announceMembershipChange(s, room, currentUsers)
+	//Reject the session if the user is already in the room, possibly on another node
+	if !added {
+		return &chat.ServerError{Code: chat.ErrCodeALREADY_JOINED, Reason: "You are already in the room"}
+	}
 
-		//Eject the room from the handler if the last person left
-		if room.IsEmpty() {
-			w.removeRoom(*roomUUID)
+	//Load who the user blocked, so their messages are held back from the start
+	blocked, err := loadBlocked(uinfo.ID)
+	if err != nil {
+		fmt.Printf("wschat: failed to load the blocks of user %s: %s\n", uinfo.ID, err)
+		if _, _, rerr := w.releaseMembership(uinfo.room, uinfo.ID); rerr != nil {
+			fmt.Printf("wschat: failed to release membership in room %s: %s\n", uinfo.room, rerr)
 		}
+		return &chat.ServerError{Code: chat.ErrCodeINTERNAL, Reason: "Failed to join the room"}
 	}
+	uinfo.setBlocked(blocked)
+
+	//Get an existing room or create a new one, and add the user to it
+	room := w.joinRoom(uinfo.room, participants, s, uinfo)
+
+	//Confirm multiplexed subscriptions before anything from the room arrives; live messages are still held
+	if uinfo.mux {
+		s.Write(chat.NewRoomFrame(chat.FrameOpSUBSCRIBED, room.ID, nil).JSON())
+	}
+
+	//Announce the membership change
+	announceMembershipChange(room, newSize-1, newSize)
+
+	//Catch the user up on what they missed before going live
+	w.deliverBacklog(s, room, uinfo)
+
+	//Let the rest of the system know about the membership change
+	w.publishEvent(amqp.ExchangeMEMBERSHIP, amqp.KeyMEMBER_JOINED, MembershipEvent{
+		RoomID: room.ID,
+		UserID: uinfo.ID,
+		Old:    newSize - 1,
+		New:    newSize,
+	})
+	return nil
 }
 
-// Announces the membership info to the room.
-func announceMembershipChange(_ *melody.Session, room *WSRoom, oldSize int) {
-	//Get the numbers of members in the room after the change
-	newSize := room.Size()
+// Takes a user's session out of a room and announces their departure.
+func (w *Server) exitRoom(room *WSRoom, s *melody.Session) {
+	uinfo, ok := room.GetUserData(s)
+	if !ok {
+		return
+	}
+
+	//Let the room know the user stopped typing while they can still be told apart from everyone else
+	stopTyping(s, room, uinfo)
 
+	//Remove the current session handler for the user, ejecting the room if the last local person left
+	w.leaveRoom(room, s)
+
+	//Release the user's spot in the room and broadcast the membership change event
+	removed, newSize, err := w.releaseMembership(room.ID, uinfo.ID)
+	if err != nil {
+		fmt.Printf("wschat: failed to release membership in room %s: %s\n", room.ID, err)
+		return
+	}
+	if removed {
+		announceMembershipChange(room, newSize+1, newSize)
+		w.publishEvent(amqp.ExchangeMEMBERSHIP, amqp.KeyMEMBER_LEFT, MembershipEvent{
+			RoomID: room.ID,
+			UserID: uinfo.ID,
+			Old:    newSize + 1,
+			New:    newSize,
+		})
+	}
+}
+
+// Announces the membership info to the room.
+func announceMembershipChange(room *WSRoom, oldSize int, newSize int) {
 	//Get the type of membership change event
 	typ := util.If(newSize > oldSize, chat.TypeJOINEVENT, chat.TypeQUITEVENT)
 
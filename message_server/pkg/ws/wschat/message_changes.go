@@ -0,0 +1,29 @@
+package wschat
+
+import (
+	"fmt"
+
+	"wraith.me/message_server/pkg/amqp"
+	"wraith.me/message_server/pkg/http_types/ws/chat"
+	chatmessage "wraith.me/message_server/pkg/schema/chat_message"
+)
+
+/*
+Tells everyone connected to a room, on any node, that one of its messages
+was edited or deleted. The event carries the ID of the message, so clients
+can update the copy they already have; those that weren't connected pick up
+the final state from the room's history instead.
+*/
+func (w *Server) AnnounceMessageChange(msg chatmessage.Message) {
+	evt := msg.ChangeEvent()
+	if err := w.publish(msg.Room, evt.JSON(), nil, nil); err != nil {
+		fmt.Printf("wschat: failed to announce change to message %s in room %s: %s\n", msg.ID, msg.Room, err)
+	}
+
+	//Let the rest of the system know about the change
+	key := amqp.KeyMESSAGE_EDITED
+	if evt.Type == chat.TypeDELETED {
+		key = amqp.KeyMESSAGE_DELETED
+	}
+	w.publishEvent(amqp.ExchangeCHAT, key, msg)
+}
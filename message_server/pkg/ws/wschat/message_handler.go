@@ -1,6 +1,16 @@
 package wschat
 
-import "github.com/olahol/melody"
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/olahol/melody"
+	"wraith.me/message_server/pkg/amqp"
+	"wraith.me/message_server/pkg/http_types/ws/chat"
+	chatmessage "wraith.me/message_server/pkg/schema/chat_message"
+	"wraith.me/message_server/pkg/util"
+)
 
 // Handles messages sent to the chat server by participants.
 func (w *Server) handleMessage(s *melody.Session, msg []byte) {
@@ -11,15 +21,164 @@ func (w *Server) handleMessage(s *melody.Session, msg []byte) {
 	}
 
 	room := w.GetRoom(*roomUUID)
-	if room != nil && room.HasSession(s) {
-		if isValidMessage(msg) {
-			room.Broadcast(msg, nil)
-		} else {
-			s.Write([]byte("Invalid message"))
+	if room == nil {
+		return
+	}
+
+	//Get the info of the sender; sessions not in the room may not send
+	sender, ok := room.GetUserData(s)
+	if !ok {
+		return
+	}
+	w.handleRoomMessage(s, room, sender, msg)
+}
+
+// Handles a message sent to a room by one of its participants, over either kind of connection.
+func (w *Server) handleRoomMessage(s *melody.Session, room *WSRoom, sender *UserData, msg []byte) {
+	//Validate the incoming message
+	cmsg, serr := w.validateMessage(msg, room, sender)
+	if serr != nil {
+		sendError(s, sender, *serr)
+		return
+	}
+
+	//Acknowledgements and receipts are handled separately; they're not part of the room's history
+	switch cmsg.Type {
+	case chat.TypeACK:
+		w.handleAck(s, room, sender, cmsg)
+		return
+	case chat.TypeDELIVERED, chat.TypeREAD:
+		w.handleReceipt(s, room, sender, cmsg)
+		return
+	case chat.TypeKEX1, chat.TypeKEX2, chat.TypeEK:
+		w.handleKeyExchange(s, room, sender, cmsg)
+		return
+	case chat.TypeTYPINGSTART, chat.TypeTYPINGSTOP:
+		w.handleTyping(s, room, sender, cmsg)
+		return
+	case chat.TypePRESENCE:
+		w.handlePresence(s, sender, cmsg)
+		return
+	case chat.TypeREACTION:
+		w.handleReaction(s, room, sender, cmsg)
+		return
+	}
+
+	//Ensure that replies and threads refer to messages that are in the room
+	if serr := checkReferences(&cmsg, room); serr != nil {
+		sendError(s, sender, *serr)
+		return
+	}
+
+	//Ensure that attachments were uploaded to the room in full
+	if serr := checkAttachments(&cmsg, room); serr != nil {
+		sendError(s, sender, *serr)
+		return
+	}
+
+	//Work out when the message disappears, if it's set to
+	if serr := stampExpiry(&cmsg, room); serr != nil {
+		sendError(s, sender, *serr)
+		return
+	}
+
+	//Message requests may only carry a single message until they're accepted
+	claimed, serr := checkRequest(&cmsg, room)
+	if serr != nil {
+		sendError(s, sender, *serr)
+		return
+	}
+
+	//Sending a message implies that the user stopped typing; recipients clear the indicator on their own
+	sender.setTyping(false)
+
+	//Persist the message so it shows up in the room's history
+	if err := persistMessage(cmsg, room.ID); err != nil {
+		fmt.Printf("wschat: failed to persist message %s: %s\n", cmsg.ID, err)
+		if claimed {
+			releaseRequest(room.ID, cmsg.Sender)
+		}
+		sendError(s, sender, chat.ServerError{
+			Code:   chat.ErrCodeINTERNAL,
+			Reason: "failed to send message",
+		})
+		return
+	}
+
+	//Bump the reply count of the thread the message was added to, if any
+	if cmsg.ThreadRoot != nil {
+		if err := countReply(*cmsg.ThreadRoot); err != nil {
+			fmt.Printf("wschat: failed to count reply %s in thread %s: %s\n", cmsg.ID, *cmsg.ThreadRoot, err)
+		}
+	}
+
+	//Broadcast the message to everyone in the room
+	room.Broadcast(cmsg.JSON(), nil)
+
+	//Let the rest of the system know about the message
+	w.publishEvent(amqp.ExchangeCHAT, amqp.KeyMESSAGE_CREATED, chatmessage.NewMessage(cmsg, room.ID))
+}
+
+/*
+Parses an incoming frame into a chat message and ensures that it's fit to be
+relayed. Fields that the server is the authority on, such as the sender and
+the ID, are overwritten rather than trusted.
+*/
+func (w *Server) validateMessage(msg []byte, room *WSRoom, sender *UserData) (chat.Message, *chat.ServerError) {
+	var cmsg chat.Message
+
+	//Ensure the message isn't too large
+	if maxSize := w.getConfig().MaxMessageSize; len(msg) > maxSize {
+		return cmsg, &chat.ServerError{
+			Code:   chat.ErrCodeTOO_LARGE,
+			Reason: fmt.Sprintf("message is %d bytes; the maximum is %d", len(msg), maxSize),
 		}
 	}
+
+	//Parse the message
+	if err := json.Unmarshal(msg, &cmsg); err != nil {
+		return cmsg, &chat.ServerError{
+			Code:   chat.ErrCodeMALFORMED,
+			Reason: fmt.Sprintf("message could not be parsed: %s", err),
+		}
+	}
+
+	//Ensure the client is allowed to send this type of message
+	if !cmsg.Type.IsClientOriginable() {
+		return cmsg, &chat.ServerError{
+			Code:   chat.ErrCodeFORBIDDEN_TYPE,
+			Reason: fmt.Sprintf("clients may not send messages of type %s", cmsg.Type),
+		}
+	}
+
+	//Fill in the authoritative fields
+	cmsg.ID = util.MustNewUUID7()
+	cmsg.Sender = sender.ID
+	if cmsg.Recipient.IsNil() {
+		cmsg.Recipient = room.ID
+	}
+
+	//Only user messages can be replies, be part of threads or carry attachments
+	if cmsg.Type != chat.TypeUMSG {
+		cmsg.ReplyTo = nil
+		cmsg.ThreadRoot = nil
+		cmsg.Attachments = nil
+	}
+
+	return cmsg, nil
 }
 
-func isValidMessage(msg []byte) bool {
-	return len(msg) > 0
+// Sends an error message to a single session of a user in a room.
+func sendError(s *melody.Session, u *UserData, serr chat.ServerError) {
+	msg := chat.NewMessageTyp(string(serr.JSON()), u.room, u.ID, chat.TypeSERR)
+	u.write(s, msg.JSON())
+}
+
+// Saves a message to the database.
+func persistMessage(msg chat.Message, roomID util.UUID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+
+	_, err := chatmessage.GetCollection().InsertOne(ctx, chatmessage.NewMessage(msg, roomID))
+	return err
 }
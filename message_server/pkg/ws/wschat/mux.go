@@ -0,0 +1,271 @@
+package wschat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/olahol/melody"
+	"github.com/qiniu/qmgo"
+	"wraith.me/message_server/pkg/http_types/ws/chat"
+	chatroom "wraith.me/message_server/pkg/schema/chat_room"
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+Tracks the rooms that a multiplexed connection is subscribed to. A single
+multiplexed connection can be in any number of the user's rooms at once, and
+also receives the events that are scoped to the user. Notifications are
+optional, since clients may get them from the notification socket instead.
+*/
+type muxConn struct {
+	userID        util.UUID
+	rooms         map[util.UUID]bool
+	notifications bool
+	mu            sync.Mutex
+}
+
+// Marks the connection as subscribed to a room or not.
+func (c *muxConn) setSubscribed(roomID util.UUID, subscribed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if subscribed {
+		c.rooms[roomID] = true
+	} else {
+		delete(c.rooms, roomID)
+	}
+}
+
+// Checks if the connection is subscribed to a room.
+func (c *muxConn) isSubscribed(roomID util.UUID) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rooms[roomID]
+}
+
+// Gets the IDs of the rooms the connection is subscribed to.
+func (c *muxConn) roomIDs() []util.UUID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ids := make([]util.UUID, 0, len(c.rooms))
+	for id := range c.rooms {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Handles new multiplexed connections to the chat server.
+func (w *Server) handleMuxConnect(s *melody.Session) {
+	uid, ok := s.Request.Context().Value(WSMuxCtxObjKey).(util.UUID)
+	if !ok {
+		s.Close()
+		return
+	}
+	s.Set(muxConnKey, &muxConn{
+		userID:        uid,
+		rooms:         make(map[util.UUID]bool),
+		notifications: s.Request.URL.Query().Get("notifications") != "false",
+	})
+
+	//Index the session by user so user-scoped events can find it
+	w.muxMu.Lock()
+	if w.muxUsers[uid] == nil {
+		w.muxUsers[uid] = make(map[*melody.Session]bool)
+	}
+	w.muxUsers[uid][s] = true
+	w.muxMu.Unlock()
+
+	//Count the connection towards the user's presence
+	w.goOnline(s, uid)
+}
+
+// Handles disconnections of multiplexed connections, leaving every room the connection was subscribed to.
+func (w *Server) handleMuxDisconnect(s *melody.Session) {
+	conn := getMuxConn(s)
+	if conn == nil {
+		return
+	}
+
+	w.muxMu.Lock()
+	delete(w.muxUsers[conn.userID], s)
+	if len(w.muxUsers[conn.userID]) == 0 {
+		delete(w.muxUsers, conn.userID)
+	}
+	w.muxMu.Unlock()
+
+	for _, roomID := range conn.roomIDs() {
+		if room := w.GetRoom(roomID); room != nil {
+			w.exitRoom(room, s)
+		}
+	}
+
+	//The connection no longer counts towards the user's presence
+	w.goOffline(s)
+}
+
+// Handles frames sent over multiplexed connections.
+func (w *Server) handleMuxMessage(s *melody.Session, msg []byte) {
+	conn := getMuxConn(s)
+	if conn == nil {
+		return
+	}
+
+	//Parse the frame; every frame a client may send concerns a room, save for presence updates
+	var frame chat.Frame
+	if err := json.Unmarshal(msg, &frame); err != nil {
+		sendFrameError(s, nil, chat.ErrCodeMALFORMED, fmt.Sprintf("frame could not be parsed: %s", err))
+		return
+	}
+	if !frame.Op.IsClientOriginable() {
+		sendFrameError(s, frame.RoomID, chat.ErrCodeFORBIDDEN_TYPE, fmt.Sprintf("clients may not send frames with op %s", frame.Op))
+		return
+	}
+	if frame.Op == chat.FrameOpPRESENCE {
+		w.handleMuxPresence(s, frame)
+		return
+	}
+	if frame.RoomID == nil || frame.RoomID.IsNil() {
+		sendFrameError(s, nil, chat.ErrCodeMALFORMED, "frames must carry a room ID")
+		return
+	}
+
+	switch frame.Op {
+	case chat.FrameOpSUBSCRIBE:
+		w.subscribeRoom(s, conn, *frame.RoomID)
+	case chat.FrameOpUNSUBSCRIBE:
+		w.unsubscribeRoom(s, conn, *frame.RoomID, nil)
+	case chat.FrameOpMESSAGE:
+		//Only rooms the connection is subscribed to may be sent to
+		room := w.GetRoom(*frame.RoomID)
+		if room == nil || !conn.isSubscribed(room.ID) {
+			sendFrameError(s, frame.RoomID, chat.ErrCodeNOT_MEMBER, "you are not subscribed to this room")
+			return
+		}
+		sender, ok := room.GetUserData(s)
+		if !ok {
+			sendFrameError(s, frame.RoomID, chat.ErrCodeNOT_MEMBER, "you are not subscribed to this room")
+			return
+		}
+		w.handleRoomMessage(s, room, sender, frame.Message)
+	}
+}
+
+// Handles a multiplexed connection marking itself as away or back. The frame's payload is the new status.
+func (w *Server) handleMuxPresence(s *melody.Session, frame chat.Frame) {
+	var status chat.PresenceStatus
+	if err := json.Unmarshal(frame.Payload, &status); err != nil {
+		sendFrameError(s, nil, chat.ErrCodeMALFORMED, fmt.Sprintf("presence could not be parsed: %s", err))
+		return
+	}
+	if serr := w.setAway(s, status); serr != nil {
+		sendFrameError(s, nil, serr.Code, serr.Reason)
+	}
+}
+
+/*
+Subscribes a multiplexed connection to a room that its user is a member of.
+The connection gets a `SUBSCRIBED` frame, followed by the messages it missed
+and then the room's live traffic.
+*/
+func (w *Server) subscribeRoom(s *melody.Session, conn *muxConn, roomID util.UUID) {
+	//Subscribing twice is harmless
+	if conn.isSubscribed(roomID) {
+		s.Write(chat.NewRoomFrame(chat.FrameOpSUBSCRIBED, roomID, nil).JSON())
+		return
+	}
+
+	//Only members of the room may subscribe to it
+	participants, err := loadParticipants(roomID)
+	if err != nil {
+		fmt.Printf("wschat: failed to load room %s: %s\n", roomID, err)
+		sendFrameError(s, &roomID, chat.ErrCodeINTERNAL, "Failed to join the room")
+		return
+	}
+	if _, ok := participants[conn.userID]; !ok {
+		sendFrameError(s, &roomID, chat.ErrCodeNOT_MEMBER, "you are not a member of this room")
+		return
+	}
+
+	//Put the user in the room
+	uinfo := newHeldUserData(conn.userID, roomID, true)
+	if serr := w.enterRoom(s, participants, uinfo); serr != nil {
+		sendFrameError(s, &roomID, serr.Code, serr.Reason)
+		return
+	}
+	conn.setSubscribed(roomID, true)
+}
+
+// Unsubscribes a multiplexed connection from a room, optionally telling it why.
+func (w *Server) unsubscribeRoom(s *melody.Session, conn *muxConn, roomID util.UUID, reason *chat.ServerError) {
+	if room := w.GetRoom(roomID); room != nil {
+		w.exitRoom(room, s)
+	}
+	conn.setSubscribed(roomID, false)
+	s.Write(chat.NewRoomFrame(chat.FrameOpUNSUBSCRIBED, roomID, reason).JSON())
+}
+
+/*
+Sends an event to every multiplexed connection a user has open, on any node.
+Users who aren't connected miss the event, so it shouldn't be the only way
+they find out about something.
+*/
+func (w *Server) SendToUser(userID util.UUID, event string, payload any) error {
+	frame, err := chat.NewEventFrame(event, payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return w.rclient.Publish(ctx, userChannel(userID), frame.JSON()).Err()
+}
+
+/*
+Writes a frame to the multiplexed connections of a user that are held by this
+node. Notifications skip the connections that opted out of them.
+*/
+func (w *Server) deliverToUser(userID util.UUID, frame []byte) {
+	var parsed chat.Frame
+	json.Unmarshal(frame, &parsed)
+	notif := parsed.Event == chat.EventNOTIFICATION
+
+	w.muxMu.RLock()
+	defer w.muxMu.RUnlock()
+	for s := range w.muxUsers[userID] {
+		if conn := getMuxConn(s); notif && conn != nil && !conn.notifications {
+			continue
+		}
+		s.Write(frame)
+	}
+}
+
+// Sends an `ERROR` frame to a multiplexed connection.
+func sendFrameError(s *melody.Session, roomID *util.UUID, code string, reason string) {
+	frame := chat.Frame{Op: chat.FrameOpERROR, RoomID: roomID}
+	frame.Payload = chat.ServerError{Code: code, Reason: reason}.JSON()
+	s.Write(frame.JSON())
+}
+
+// Gets the state of a multiplexed connection from its session.
+func getMuxConn(s *melody.Session) *muxConn {
+	v, ok := s.Get(muxConnKey)
+	if !ok {
+		return nil
+	}
+	conn, _ := v.(*muxConn)
+	return conn
+}
+
+// Gets the current membership list of a room from the database.
+func loadParticipants(roomID util.UUID) (chatroom.MembershipList, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+
+	var room chatroom.Room
+	err := chatroom.GetCollection().FindID(ctx, roomID).One(&room)
+	if qmgo.IsErrNoDocuments(err) {
+		return chatroom.MembershipList{}, nil
+	}
+	return room.Participants, err
+}
@@ -0,0 +1,268 @@
+package wschat
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/olahol/melody"
+	"github.com/qiniu/qmgo"
+	"github.com/redis/go-redis/v9"
+	"wraith.me/message_server/pkg/amqp"
+	"wraith.me/message_server/pkg/http_types/ws/chat"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+)
+
+// The changes that can be made to the presence of a connection.
+const (
+	presenceOpONLINE  = "online"
+	presenceOpAWAY    = "away"
+	presenceOpBACK    = "back"
+	presenceOpOFFLINE = "offline"
+)
+
+/*
+Atomically applies a change to the presence of one of a user's connections,
+returning the user's status before and after the change. Statuses are
+returned as `chat.PresenceStatus` values.
+KEYS[1]: the connection set; KEYS[2]: the away set; KEYS[3]: the last seen time
+ARGV: now, op, connection ID, lease expiry, lease duration.
+*/
+var presenceScript = redis.NewScript(`
+local function status()
+	local conns = redis.call('ZRANGE', KEYS[1], 0, -1)
+	if #conns == 0 then
+		return 0
+	end
+	for _, conn in ipairs(conns) do
+		if redis.call('SISMEMBER', KEYS[2], conn) == 0 then
+			return 1
+		end
+	end
+	return 2
+end
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+local before = status()
+if ARGV[2] == 'online' then
+	redis.call('ZADD', KEYS[1], ARGV[4], ARGV[3])
+	redis.call('SREM', KEYS[2], ARGV[3])
+elseif ARGV[2] == 'away' then
+	redis.call('SADD', KEYS[2], ARGV[3])
+elseif ARGV[2] == 'back' then
+	redis.call('SREM', KEYS[2], ARGV[3])
+else
+	redis.call('ZREM', KEYS[1], ARGV[3])
+	redis.call('SREM', KEYS[2], ARGV[3])
+end
+redis.call('PEXPIRE', KEYS[1], ARGV[5])
+redis.call('PEXPIRE', KEYS[2], ARGV[5])
+redis.call('SET', KEYS[3], ARGV[1])
+return {before, status()}
+`)
+
+/*
+Tracks the presence of a single connection, be it a room connection or a
+multiplexed one. A user is online if any of their connections are, away if
+all of them are marked as away, and offline if they have none.
+*/
+type presenceConn struct {
+	id     string
+	userID util.UUID
+}
+
+// Gets the key of the set of a user's connections, scored by when their leases lapse.
+func presenceKey(id util.UUID) string {
+	return presenceKeyPrefix + id.String()
+}
+
+// Gets the key of the set of a user's connections that are marked as away.
+func awayKey(id util.UUID) string {
+	return awayKeyPrefix + id.String()
+}
+
+// Gets the key of the time a user was last connected.
+func lastSeenKey(id util.UUID) string {
+	return lastSeenKeyPrefix + id.String()
+}
+
+// Marks a session as one of its user's connections, bringing them online if they weren't already.
+func (w *Server) goOnline(s *melody.Session, userID util.UUID) {
+	conn := &presenceConn{id: util.MustNewUUID4().String(), userID: userID}
+	s.Set(presenceConnKey, conn)
+	w.updatePresence(conn, presenceOpONLINE)
+}
+
+// Removes a session from its user's connections, taking them offline if it was their last.
+func (w *Server) goOffline(s *melody.Session) {
+	if conn := getPresenceConn(s); conn != nil {
+		w.updatePresence(conn, presenceOpOFFLINE)
+	}
+}
+
+// Marks a session as away or back, at the request of its user.
+func (w *Server) setAway(s *melody.Session, status chat.PresenceStatus) *chat.ServerError {
+	conn := getPresenceConn(s)
+	if conn == nil {
+		return &chat.ServerError{Code: chat.ErrCodeINTERNAL, Reason: "presence is not tracked for this connection"}
+	}
+
+	switch status {
+	case chat.PresenceStatusAWAY:
+		w.updatePresence(conn, presenceOpAWAY)
+	case chat.PresenceStatusONLINE:
+		w.updatePresence(conn, presenceOpBACK)
+	default:
+		return &chat.ServerError{
+			Code:   chat.ErrCodeMALFORMED,
+			Reason: fmt.Sprintf("presence can only be set to %s or %s", chat.PresenceStatusONLINE, chat.PresenceStatusAWAY),
+		}
+	}
+	return nil
+}
+
+// Handles a user marking their connection as away or back. The message's content is the new status.
+func (w *Server) handlePresence(s *melody.Session, sender *UserData, cmsg chat.Message) {
+	status, err := chat.ParsePresenceStatus(cmsg.Content)
+	if err != nil {
+		sendError(s, sender, chat.ServerError{Code: chat.ErrCodeMALFORMED, Reason: err.Error()})
+		return
+	}
+	if serr := w.setAway(s, status); serr != nil {
+		sendError(s, sender, *serr)
+	}
+}
+
+// Applies a change to the presence of a connection, publishing an event if the user's status changed.
+func (w *Server) updatePresence(conn *presenceConn, op string) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	now := nowMs()
+	keys := []string{presenceKey(conn.userID), awayKey(conn.userID), lastSeenKey(conn.userID)}
+	res, err := presenceScript.Run(ctx, w.rclient, keys,
+		now, op, conn.id, now+memberLease.Milliseconds(), memberLease.Milliseconds(),
+	).Int64Slice()
+	if err != nil {
+		fmt.Printf("wschat: failed to update presence of user %s: %s\n", conn.userID, err)
+		return
+	}
+
+	//Statuses that lapse when a node dies aren't announced; they're picked up when next asked for
+	if res[0] != res[1] {
+		w.publishEvent(amqp.ExchangePRESENCE, amqp.KeyPRESENCE_CHANGED, chat.Presence{
+			User:     conn.userID,
+			Status:   chat.PresenceStatus(res[1]),
+			LastSeen: time.UnixMilli(now).UTC(),
+		})
+	}
+}
+
+// Renews the presence leases of every connection held by this node as part of a heartbeat.
+func (w *Server) renewPresence(ctx context.Context, pipe redis.Pipeliner, expiry float64) {
+	now := strconv.FormatInt(nowMs(), 10)
+	for _, mel := range []*melody.Melody{w.melody, w.mux} {
+		sessions, err := mel.Sessions()
+		if err != nil {
+			continue
+		}
+		for _, s := range sessions {
+			conn := getPresenceConn(s)
+			if conn == nil {
+				continue
+			}
+			pipe.ZAddXX(ctx, presenceKey(conn.userID), redis.Z{Score: expiry, Member: conn.id})
+			pipe.PExpire(ctx, presenceKey(conn.userID), memberLease)
+			pipe.PExpire(ctx, awayKey(conn.userID), memberLease)
+			pipe.Set(ctx, lastSeenKey(conn.userID), now, 0)
+		}
+	}
+}
+
+/*
+Gets the presence of the given users from across the cluster. This doesn't
+check whether the users want their presence to be seen; that's up to the
+caller.
+*/
+func (w *Server) GetPresence(ctx context.Context, ids ...util.UUID) ([]chat.Presence, error) {
+	//Fetch the connections and last seen times of every user in one round trip
+	live := fmt.Sprintf("(%d", nowMs())
+	pipe := w.rclient.Pipeline()
+	conns := make([]*redis.StringSliceCmd, len(ids))
+	aways := make([]*redis.StringSliceCmd, len(ids))
+	seens := make([]*redis.StringCmd, len(ids))
+	for i, id := range ids {
+		conns[i] = pipe.ZRangeByScore(ctx, presenceKey(id), &redis.ZRangeBy{Min: live, Max: "+inf"})
+		aways[i] = pipe.SMembers(ctx, awayKey(id))
+		seens[i] = pipe.Get(ctx, lastSeenKey(id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	//Work out the status of each user
+	out := make([]chat.Presence, len(ids))
+	for i, id := range ids {
+		out[i] = chat.Presence{User: id, Status: chat.PresenceStatusOFFLINE}
+		if ms, err := seens[i].Int64(); err == nil {
+			out[i].LastSeen = time.UnixMilli(ms).UTC()
+		}
+
+		away := make(map[string]bool)
+		for _, conn := range aways[i].Val() {
+			away[conn] = true
+		}
+		for _, conn := range conns[i].Val() {
+			if !away[conn] {
+				out[i].Status = chat.PresenceStatusONLINE
+				break
+			}
+			out[i].Status = chat.PresenceStatusAWAY
+		}
+	}
+	return out, nil
+}
+
+/*
+Sends the presence changes that are published to the event bus to the
+friends of the users they concern, unless those users chose to hide their
+presence. Only one node in the cluster handles each change.
+*/
+func (w *Server) SubscribePresence(bus *amqp.Bus) error {
+	return bus.Subscribe(presenceGroup, amqp.ExchangePRESENCE, []string{amqp.KeyPRESENCE_CHANGED},
+		amqp.Typed(func(ev amqp.Event, presence chat.Presence) error {
+			ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+			defer cancel()
+
+			var usr user.User
+			if err := user.GetCollection().FindID(ctx, presence.User).One(&usr); err != nil {
+				if qmgo.IsErrNoDocuments(err) {
+					return nil
+				}
+				return err
+			}
+			if usr.Options.HidePresence {
+				return nil
+			}
+
+			for friend := range usr.Friends {
+				if err := w.SendToUser(friend, chat.EventPRESENCE, presence); err != nil {
+					return err
+				}
+			}
+			return nil
+		}),
+	)
+}
+
+// Gets the presence state of a connection from its session.
+func getPresenceConn(s *melody.Session) *presenceConn {
+	v, ok := s.Get(presenceConnKey)
+	if !ok {
+		return nil
+	}
+	conn, _ := v.(*presenceConn)
+	return conn
+}
@@ -0,0 +1,58 @@
+package wschat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/olahol/melody"
+	"wraith.me/message_server/pkg/http_types/ws/chat"
+	chatmessage "wraith.me/message_server/pkg/schema/chat_message"
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+Toggles a member's reaction to a message in the room. The content of the
+message is a `Reaction` naming the message and the emoji. The reaction is
+stored with the message, and everyone in the room is told who has the
+reaction afterwards.
+*/
+func (w *Server) handleReaction(s *melody.Session, room *WSRoom, sender *UserData, cmsg chat.Message) {
+	//Parse the reaction
+	var reaction chat.Reaction
+	if err := json.Unmarshal([]byte(cmsg.Content), &reaction); err != nil || reaction.MessageID.IsNil() {
+		sendError(s, sender, chat.ServerError{
+			Code:   chat.ErrCodeMALFORMED,
+			Reason: "reactions must contain the ID of a message and an emoji",
+		})
+		return
+	}
+
+	//Toggle the reaction
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+	added, users, err := chatmessage.GetCollection().ToggleReaction(ctx, room.ID, reaction.MessageID, sender.ID, reaction.Emoji)
+	if err != nil {
+		serr := chat.ServerError{Code: chat.ErrCodeINTERNAL, Reason: "failed to react to the message"}
+		switch {
+		case errors.Is(err, chatmessage.ErrBadEmoji):
+			serr = chat.ServerError{Code: chat.ErrCodeMALFORMED, Reason: err.Error()}
+		case errors.Is(err, chatmessage.ErrNoSuchMessage):
+			serr = chat.ServerError{
+				Code:   chat.ErrCodeBAD_REFERENCE,
+				Reason: fmt.Sprintf("no message with ID %s exists in this room", reaction.MessageID),
+			}
+		default:
+			fmt.Printf("wschat: failed to toggle reaction of user %s on message %s: %s\n", sender.ID, reaction.MessageID, err)
+		}
+		sendError(s, sender, serr)
+		return
+	}
+
+	//Tell everyone in the room, including the sender, where the reaction stands
+	reaction.Added = added
+	reaction.Users = util.If(users != nil, users, []util.UUID{})
+	out := chat.NewMessageTyp(string(reaction.JSON()), sender.ID, room.ID, chat.TypeREACTION)
+	room.Broadcast(out.JSON())
+}
@@ -0,0 +1,73 @@
+package wschat
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/olahol/melody"
+	"wraith.me/message_server/pkg/http_types/ws/chat"
+	chatmessage "wraith.me/message_server/pkg/schema/chat_message"
+	memberstate "wraith.me/message_server/pkg/schema/member_state"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+Handles a delivery or read receipt sent by a member of a room. The content of
+the receipt is the ID of the message in question. The member's cursors are
+moved forward, and the receipt is passed on to the sender of the message if
+the member's `ReadReceiptsScope` allows for it.
+*/
+func (w *Server) handleReceipt(s *melody.Session, room *WSRoom, reader *UserData, receipt chat.Message) {
+	//Get the ID of the message the receipt is for
+	msgID, err := util.ParseUUIDv7(receipt.Content)
+	if err != nil {
+		sendError(s, reader, chat.ServerError{
+			Code:   chat.ErrCodeMALFORMED,
+			Reason: "receipts must contain the ID of a message",
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+
+	//Get the message; it must belong to the room
+	var msg chatmessage.Message
+	if err := chatmessage.GetCollection().FindID(ctx, msgID).One(&msg); err != nil || msg.Room != room.ID {
+		sendError(s, reader, chat.ServerError{
+			Code:   chat.ErrCodeMALFORMED,
+			Reason: fmt.Sprintf("no message with ID %s exists in this room", msgID),
+		})
+		return
+	}
+
+	//Move the reader's cursors forward; reading a message implies it was delivered
+	cursors := []string{memberstate.DeliveredCursor}
+	if receipt.Type == chat.TypeREAD {
+		cursors = append(cursors, memberstate.ReadCursor)
+	}
+	if err := memberstate.GetCollection().Advance(ctx, room.ID, reader.ID, msgID, cursors...); err != nil {
+		fmt.Printf("wschat: failed to record receipt of user %s in room %s: %s\n", reader.ID, room.ID, err)
+		return
+	}
+
+	//Nobody needs to hear about receipts for their own messages
+	if msg.Sender == reader.ID {
+		return
+	}
+
+	//Get the reader's current settings and ensure the sender may receive the receipt
+	var ruser user.User
+	if err := user.GetCollection().FindID(ctx, reader.ID).One(&ruser); err != nil {
+		fmt.Printf("wschat: failed to get user %s: %s\n", reader.ID, err)
+		return
+	}
+	if !ruser.SendsReadReceiptsTo(msg.Sender) {
+		return
+	}
+
+	//Pass the receipt on to the sender of the message
+	out := chat.NewMessageTyp(msgID.String(), reader.ID, msg.Sender, receipt.Type)
+	room.SendTo(out.JSON(), msg.Sender)
+}
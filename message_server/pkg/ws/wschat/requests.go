@@ -0,0 +1,60 @@
+package wschat
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/qiniu/qmgo"
+	"wraith.me/message_server/pkg/http_types/ws/chat"
+	chatroom "wraith.me/message_server/pkg/schema/chat_room"
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+Ensures that the sender of a message request sends no more than the one
+message the request carries until it's accepted. Returns whether a request's
+message was used up, so it can be given back if the message isn't sent after
+all.
+*/
+func checkRequest(cmsg *chat.Message, room *WSRoom) (bool, *chat.ServerError) {
+	//Pending requests only have their sender as a member, so other rooms can skip the lookup
+	if room.memberCount() != 1 {
+		return false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+
+	var croom chatroom.Room
+	if err := chatroom.GetCollection().FindID(ctx, room.ID).One(&croom); err != nil && !qmgo.IsErrNoDocuments(err) {
+		fmt.Printf("wschat: failed to load room %s: %s\n", room.ID, err)
+		return false, &chat.ServerError{Code: chat.ErrCodeINTERNAL, Reason: "failed to send message"}
+	}
+	if !croom.IsRequest() {
+		return false, nil
+	}
+
+	//Use up the request's message, unless another message got there first
+	claimed, err := chatroom.GetCollection().MarkRequestSent(ctx, room.ID, cmsg.Sender, true)
+	if err != nil {
+		fmt.Printf("wschat: failed to mark the request of room %s as sent: %s\n", room.ID, err)
+		return false, &chat.ServerError{Code: chat.ErrCodeINTERNAL, Reason: "failed to send message"}
+	}
+	if !claimed {
+		return false, &chat.ServerError{
+			Code:   chat.ErrCodeAWAITING_ACCEPT,
+			Reason: fmt.Sprintf("user %s must accept your message request before you can send more", croom.Request.To),
+		}
+	}
+	return true, nil
+}
+
+// Gives back the message of a request whose message couldn't be sent.
+func releaseRequest(roomID util.UUID, sender util.UUID) {
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+
+	if _, err := chatroom.GetCollection().MarkRequestSent(ctx, roomID, sender, false); err != nil {
+		fmt.Printf("wschat: failed to release the request of room %s: %s\n", roomID, err)
+	}
+}
@@ -0,0 +1,27 @@
+package wschat
+
+import (
+	"github.com/olahol/melody"
+	"wraith.me/message_server/pkg/obj/session"
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+Closes the connections on this node that were opened with any of the given
+sessions, whether they're for a room or multiplexed. Sessions are matched by
+token family, so connections opened before the session was refreshed are
+closed too.
+*/
+func (w *Server) closeRevoked(families map[util.UUID]bool) {
+	for _, m := range []*melody.Melody{w.melody, w.mux} {
+		conns, err := m.Sessions()
+		if err != nil {
+			continue
+		}
+		for _, s := range conns {
+			if family, ok := session.Of(s.Request); ok && families[family] {
+				s.CloseWithMsg(melody.FormatCloseMessage(closeCodeREVOKED, "your session was revoked"))
+			}
+		}
+	}
+}
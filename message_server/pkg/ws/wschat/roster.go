@@ -0,0 +1,53 @@
+package wschat
+
+import (
+	"fmt"
+
+	"wraith.me/message_server/pkg/http_types/ws/chat"
+	chatroom "wraith.me/message_server/pkg/schema/chat_room"
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+Tells everyone connected to a room, on any node, that its list of members or
+their roles changed. Each node also picks up the new list, so key exchanges
+can no longer be addressed to users who were removed, and the sessions of
+those users are closed. Members are asked to rekey if anyone was removed.
+*/
+func (w *Server) AnnounceRoster(room chatroom.Room, added []util.UUID, removed []util.UUID) {
+	content := chat.RosterChange{
+		Members: room.Participants,
+		Added:   util.If(added != nil, added, []util.UUID{}),
+		Removed: util.If(removed != nil, removed, []util.UUID{}),
+		Rekey:   len(removed) > 0,
+	}
+	w.announceRoster(room.ID, content, envelope{
+		Participants: room.Participants,
+		Kick:         removed,
+	})
+}
+
+// Tells everyone connected to a room, on any node, that it was deleted and disconnects them.
+func (w *Server) AnnounceDeletion(room chatroom.Room) {
+	content := chat.RosterChange{
+		Members: chatroom.MembershipList{},
+		Added:   []util.UUID{},
+		Removed: room.Users(),
+		Deleted: true,
+	}
+	w.announceRoster(room.ID, content, envelope{
+		Participants: chatroom.MembershipList{},
+		Close:        true,
+	})
+}
+
+// Sends a roster change to the whole cluster, along with the instructions in the envelope.
+func (w *Server) announceRoster(roomID util.UUID, content chat.RosterChange, env envelope) {
+	msg := chat.NewMessageTyp(string(content.JSON()), roomID, roomID, chat.TypeMEMBERSHIP)
+	env.Origin = w.nodeID
+	env.Room = roomID
+	env.Payload = msg.JSON()
+	if err := w.publishEnvelope(env); err != nil {
+		fmt.Printf("wschat: failed to announce roster change in room %s: %s\n", roomID, err)
+	}
+}
@@ -1,9 +1,15 @@
 package wschat
 
 import (
+	"context"
+	"fmt"
 	"sync"
 
 	"github.com/olahol/melody"
+	"github.com/redis/go-redis/v9"
+	"wraith.me/message_server/pkg/amqp"
+	"wraith.me/message_server/pkg/blob"
+	cr "wraith.me/message_server/pkg/redis"
 	chatroom "wraith.me/message_server/pkg/schema/chat_room"
 	"wraith.me/message_server/pkg/util"
 )
@@ -20,48 +26,147 @@ var (
 
 /*
 Represents a Melody WebSocket chat server. This struct acts as a singleton
-wrapper on a Melody server that's responsible for chats.
+wrapper on a Melody server that's responsible for chats. Servers on different
+nodes share rooms with each other via Redis pub/sub.
 */
 type Server struct {
-	melody *melody.Melody
-	mutex  *sync.Mutex
-	rooms  map[util.UUID]*WSRoom
-	roomMu sync.RWMutex
+	melody   *melody.Melody
+	mux      *melody.Melody
+	mutex    *sync.Mutex
+	config   *WSConfig
+	rooms    map[util.UUID]*WSRoom
+	roomMu   sync.RWMutex
+	muxUsers map[util.UUID]map[*melody.Session]bool
+	muxMu    sync.RWMutex
+	nodeID   util.UUID
+	rclient  *redis.Client
+	pubsub   *redis.PubSub
+	stop     context.CancelFunc
+	events   *amqp.Bus
+	blobs    blob.BlobStore
 }
 
 // Gets the currently active chat server instance.
 func GetInstance() *Server {
 	once.Do(func() {
-		instance = &Server{
-			melody: melody.New(),
-			mutex:  &sync.Mutex{},
-			rooms:  make(map[util.UUID]*WSRoom),
+		srv, err := NewServer(cr.GetInstance().GetClient())
+		if err != nil {
+			panic(fmt.Sprintf("wschat: %s", err))
 		}
-		instance.setupHandlers()
+		instance = srv
 	})
 	return instance
 }
 
+/*
+Creates a new chat server that fans messages out to other nodes through the
+given Redis client. Most callers should use `GetInstance()` instead; this is
+mainly useful for running several nodes within the same process.
+*/
+func NewServer(rclient *redis.Client) (*Server, error) {
+	//Create the server object
+	srv := &Server{
+		melody:   melody.New(),
+		mux:      melody.New(),
+		mutex:    &sync.Mutex{},
+		rooms:    make(map[util.UUID]*WSRoom),
+		muxUsers: make(map[util.UUID]map[*melody.Session]bool),
+		nodeID:   util.MustNewUUID4(),
+		rclient:  rclient,
+	}
+	srv.Configure(DefaultWSConfig())
+	srv.setupHandlers()
+
+	//Subscribe to the room channels before any sessions can connect
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := srv.subscribe(ctx); err != nil {
+		cancel()
+		return nil, err
+	}
+	srv.stop = cancel
+
+	//Start the background workers
+	go srv.listen(ctx)
+	go srv.heartbeat(ctx)
+
+	return srv, nil
+}
+
+// Disconnects all sessions and stops relaying messages between nodes.
+func (w *Server) Close() error {
+	w.stop()
+	if err := w.melody.Close(); err != nil {
+		return err
+	}
+	if err := w.mux.Close(); err != nil {
+		return err
+	}
+	return w.pubsub.Close()
+}
+
 // Gets the backend Melody handler for the server.
 func (w *Server) GetMelody() *melody.Melody {
 	return w.melody
 }
 
-// Attempts to get a room by ID. If it doesn't exist, a new one is created.
-func (w *Server) getOrCreateRoom(id util.UUID, participants chatroom.MembershipList) *WSRoom {
+// Gets the backend Melody handler for multiplexed connections to the server.
+func (w *Server) GetMuxMelody() *melody.Melody {
+	return w.mux
+}
+
+// Applies a configuration to the server.
+func (w *Server) Configure(cfg *WSConfig) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.config = cfg
+	w.melody.Config.MaxMessageSize = cfg.readLimit()
+	w.mux.Config.MaxMessageSize = cfg.muxReadLimit()
+}
+
+// Gets the configuration of the server.
+func (w *Server) getConfig() *WSConfig {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.config
+}
+
+/*
+Adds a session to a room, creating the room if it doesn't exist yet. This
+happens atomically with respect to rooms being reaped, so a session can't
+land in a room that was just removed.
+*/
+func (w *Server) joinRoom(id util.UUID, participants chatroom.MembershipList, s *melody.Session, userData *UserData) *WSRoom {
 	w.roomMu.Lock()
 	defer w.roomMu.Unlock()
 
-	if room, exists := w.rooms[id]; exists {
-		return room
+	room, exists := w.rooms[id]
+	if !exists {
+		room = NewRoom(id)
+		room.srv = w
+		w.rooms[id] = room
 	}
 
-	room := NewRoom(id)
-	room.participants = participants
-	w.rooms[id] = room
+	//The joining session carries the freshest copy of the membership list
+	room.setParticipants(participants)
+	room.AddSession(s, userData)
 	return room
 }
 
+/*
+Removes a session from a room, reaping the room if the last local session
+left. This happens atomically with respect to sessions joining.
+*/
+func (w *Server) leaveRoom(room *WSRoom, s *melody.Session) {
+	w.roomMu.Lock()
+	defer w.roomMu.Unlock()
+
+	room.RemoveSession(s)
+	if room.IsEmpty() && w.rooms[room.ID] == room {
+		delete(w.rooms, room.ID)
+	}
+}
+
 // Gets a room by ID.
 func (w *Server) GetRoom(id util.UUID) *WSRoom {
 	w.roomMu.RLock()
@@ -70,12 +175,16 @@ func (w *Server) GetRoom(id util.UUID) *WSRoom {
 	return w.rooms[id]
 }
 
-// Removes a room from the handler.
-func (w *Server) removeRoom(id util.UUID) {
-	w.roomMu.Lock()
-	defer w.roomMu.Unlock()
+// Gets all of the rooms that have sessions on this node.
+func (w *Server) getRooms() []*WSRoom {
+	w.roomMu.RLock()
+	defer w.roomMu.RUnlock()
 
-	delete(w.rooms, id)
+	rooms := make([]*WSRoom, 0, len(w.rooms))
+	for _, room := range w.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
 }
 
 // Sets up the handlers for the chat server.
@@ -83,4 +192,7 @@ func (w *Server) setupHandlers() {
 	w.melody.HandleConnect(w.handleConnect)
 	w.melody.HandleDisconnect(w.handleDisconnect)
 	w.melody.HandleMessage(w.handleMessage)
+	w.mux.HandleConnect(w.handleMuxConnect)
+	w.mux.HandleDisconnect(w.handleMuxDisconnect)
+	w.mux.HandleMessage(w.handleMuxMessage)
 }
@@ -0,0 +1,86 @@
+package wschat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"wraith.me/message_server/pkg/http_types/ws/chat"
+	chatmessage "wraith.me/message_server/pkg/schema/chat_message"
+	"wraith.me/message_server/pkg/util"
+)
+
+/*
+Ensures that the messages a message replies to or threads under are in the
+same room and weren't deleted. Threads only go one level deep, so their roots
+can't be in a thread themselves. Replies to a message in a thread are put in
+that thread if the client didn't say otherwise.
+*/
+func checkReferences(cmsg *chat.Message, room *WSRoom) *chat.ServerError {
+	if cmsg.ReplyTo == nil && cmsg.ThreadRoot == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+	mc := chatmessage.GetCollection()
+
+	//Check the root of the thread
+	if cmsg.ThreadRoot != nil {
+		root, err := mc.GetInRoom(ctx, room.ID, *cmsg.ThreadRoot)
+		if err != nil {
+			return referenceError(err, *cmsg.ThreadRoot)
+		}
+		if root.IsDeleted() || root.ThreadRoot != nil {
+			return &chat.ServerError{
+				Code:   chat.ErrCodeBAD_REFERENCE,
+				Reason: fmt.Sprintf("message %s cannot start a thread", root.ID),
+			}
+		}
+	}
+
+	//Check the message being replied to
+	if cmsg.ReplyTo != nil {
+		target, err := mc.GetInRoom(ctx, room.ID, *cmsg.ReplyTo)
+		if err != nil {
+			return referenceError(err, *cmsg.ReplyTo)
+		}
+		if target.IsDeleted() {
+			return &chat.ServerError{
+				Code:   chat.ErrCodeBAD_REFERENCE,
+				Reason: fmt.Sprintf("message %s was deleted", target.ID),
+			}
+		}
+
+		//Replies stay in the thread of the message they reply to
+		if cmsg.ThreadRoot == nil {
+			cmsg.ThreadRoot = target.ThreadRoot
+		} else if target.ID != *cmsg.ThreadRoot && (target.ThreadRoot == nil || *target.ThreadRoot != *cmsg.ThreadRoot) {
+			return &chat.ServerError{
+				Code:   chat.ErrCodeBAD_REFERENCE,
+				Reason: fmt.Sprintf("message %s is not in thread %s", target.ID, *cmsg.ThreadRoot),
+			}
+		}
+	}
+	return nil
+}
+
+// Records that a reply was added to a thread.
+func countReply(rootID util.UUID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+	return chatmessage.GetCollection().AddReply(ctx, rootID, time.Now())
+}
+
+// Converts a failure to look up a referenced message into a server error.
+func referenceError(err error, msgID util.UUID) *chat.ServerError {
+	if errors.Is(err, chatmessage.ErrNoSuchMessage) {
+		return &chat.ServerError{
+			Code:   chat.ErrCodeBAD_REFERENCE,
+			Reason: fmt.Sprintf("no message with ID %s exists in this room", msgID),
+		}
+	}
+	fmt.Printf("wschat: failed to look up referenced message %s: %s\n", msgID, err)
+	return &chat.ServerError{Code: chat.ErrCodeINTERNAL, Reason: "failed to check the messages this one refers to"}
+}
@@ -0,0 +1,29 @@
+package wschat
+
+import (
+	"github.com/olahol/melody"
+	"wraith.me/message_server/pkg/http_types/ws/chat"
+)
+
+/*
+Relays a typing indicator to the rest of the room. Indicators are ephemeral:
+they're never persisted or included in backlogs, and repeats are throttled
+so that clients can send one on every keystroke.
+*/
+func (w *Server) handleTyping(s *melody.Session, room *WSRoom, sender *UserData, cmsg chat.Message) {
+	if !sender.setTyping(cmsg.Type == chat.TypeTYPINGSTART) {
+		return
+	}
+	cmsg.Content = ""
+	cmsg.Recipient = room.ID
+	room.Broadcast(cmsg.JSON(), s)
+}
+
+// Tells the rest of the room that a user who is leaving stopped typing, if they were.
+func stopTyping(s *melody.Session, room *WSRoom, uinfo *UserData) {
+	if !uinfo.setTyping(false) {
+		return
+	}
+	msg := chat.NewMessageTyp("", uinfo.ID, room.ID, chat.TypeTYPINGSTOP)
+	room.Broadcast(msg.JSON(), s)
+}
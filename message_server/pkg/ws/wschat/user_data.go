@@ -1,6 +1,13 @@
 package wschat
 
 import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/olahol/melody"
+	"golang.org/x/exp/maps"
+	"wraith.me/message_server/pkg/http_types/ws/chat"
 	"wraith.me/message_server/pkg/util"
 )
 
@@ -10,4 +17,138 @@ type UserData struct {
 	// Add more fields as needed, for example:
 	// Name	string
 	// Role	string
+
+	//The ID of the room the user is in.
+	room util.UUID
+	//Whether the user is in the room via a multiplexed connection, whose messages must be framed.
+	mux bool
+	//Live messages held back while the user's backlog is being delivered.
+	held [][]byte
+	//Whether live messages are currently being held back.
+	holding bool
+	//Whether the user is typing in the room, and when that was last relayed.
+	typing  bool
+	typedAt time.Time
+	//The users whose messages the user blocked.
+	blocked map[util.UUID]bool
+	mu      sync.Mutex
+}
+
+// Creates a user data object that holds back live messages until `release()` is called.
+func newHeldUserData(id util.UUID, room util.UUID, mux bool) *UserData {
+	return &UserData{
+		ID:      id,
+		room:    room,
+		mux:     mux,
+		holding: true,
+	}
+}
+
+// Writes a message from the room to the user's session, framing it if the session is multiplexed.
+func (u *UserData) write(s *melody.Session, msg []byte) {
+	if u.mux {
+		msg = chat.NewMessageFrame(u.room, msg).JSON()
+	}
+	s.Write(msg)
+}
+
+// Writes a live message to the user's session, or holds it back if the backlog is still being delivered.
+func (u *UserData) send(s *melody.Session, msg []byte) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.blocksSenderOf(msg) {
+		return
+	}
+	if u.holding {
+		u.held = append(u.held, msg)
+		return
+	}
+	u.write(s, msg)
+}
+
+/*
+Writes out the live messages that were held back and switches the user over
+to receiving live messages directly. The given filter is called for each held
+message and can return false to drop it, eg: if it was already part of the
+backlog.
+*/
+func (u *UserData) release(s *melody.Session, filter func(msg []byte) bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, msg := range u.held {
+		if filter(msg) {
+			u.write(s, msg)
+		}
+	}
+	u.held = nil
+	u.holding = false
+}
+
+/*
+Records whether the user is typing in the room, returning whether the change
+should be relayed. Users who keep typing are only relayed once every
+`typingThrottle`, and stopping is only relayed if starting was.
+*/
+func (u *UserData) setTyping(typing bool) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if !typing {
+		wasTyping := u.typing
+		u.typing = false
+		return wasTyping
+	}
+	if u.typing && time.Since(u.typedAt) < typingThrottle {
+		return false
+	}
+	u.typing = true
+	u.typedAt = time.Now()
+	return true
+}
+
+// Checks if a message was sent by someone the user blocked. Must be called with the lock held.
+func (u *UserData) blocksSenderOf(msg []byte) bool {
+	if len(u.blocked) == 0 {
+		return false
+	}
+	var from struct {
+		Sender util.UUID `json:"sender_id"`
+	}
+	if err := json.Unmarshal(msg, &from); err != nil {
+		return false
+	}
+	return u.blocked[from.Sender]
+}
+
+// Replaces the set of users whose messages the user blocked.
+func (u *UserData) setBlocked(blocked []util.UUID) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.blocked = make(map[util.UUID]bool, len(blocked))
+	for _, id := range blocked {
+		u.blocked[id] = true
+	}
+}
+
+// Marks whether the user blocked another user.
+func (u *UserData) block(other util.UUID, blocked bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !blocked {
+		delete(u.blocked, other)
+		return
+	}
+	if u.blocked == nil {
+		u.blocked = make(map[util.UUID]bool)
+	}
+	u.blocked[other] = true
+}
+
+// Gets the IDs of the users whose messages the user blocked.
+func (u *UserData) blockedIDs() []util.UUID {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return maps.Keys(u.blocked)
 }
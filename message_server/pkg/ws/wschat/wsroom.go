@@ -1,9 +1,12 @@
 package wschat
 
 import (
+	"fmt"
 	"sync"
+	"time"
 
 	"github.com/olahol/melody"
+	"wraith.me/message_server/pkg/http_types/ws/chat"
 	chatroom "wraith.me/message_server/pkg/schema/chat_room"
 	"wraith.me/message_server/pkg/util"
 )
@@ -14,7 +17,9 @@ type WSRoom struct {
 	sessions     map[*melody.Session]*UserData
 	userIDs      map[util.UUID]*melody.Session
 	participants chatroom.MembershipList
+	timer        *time.Duration
 	mu           sync.RWMutex
+	srv          *Server
 }
 
 // Creates a new room.
@@ -52,25 +57,107 @@ func (r *WSRoom) AddSession(s *melody.Session, userData *UserData) bool {
 	return true
 }
 
-// Broadcasts a message to the room.
+/*
+Broadcasts a message to the room. If the room is backed by a server, the
+message is fanned out to every node in the cluster; otherwise it only reaches
+the sessions held by this room.
+*/
 func (r *WSRoom) Broadcast(msg []byte, excludes ...*melody.Session) {
+	//Resolve the excluded sessions to users, since sessions are local to a node
+	r.mu.RLock()
+	excludeIDs := make([]util.UUID, 0, len(excludes))
+	for _, exclude := range excludes {
+		if userData, exists := r.sessions[exclude]; exists {
+			excludeIDs = append(excludeIDs, userData.ID)
+		}
+	}
+	r.mu.RUnlock()
+
+	r.send(msg, nil, excludeIDs)
+}
+
+// Sends a message only to the given users in the room, wherever in the cluster they're connected.
+func (r *WSRoom) SendTo(msg []byte, users ...util.UUID) {
+	if len(users) == 0 {
+		return
+	}
+	r.send(msg, users, nil)
+}
+
+// Publishes a message to the cluster, or delivers it locally if the room isn't backed by a server.
+func (r *WSRoom) send(msg []byte, to []util.UUID, excludes []util.UUID) {
+	//Deliver locally if there's no cluster to publish to
+	if r.srv == nil {
+		r.deliver(msg, to, excludes)
+		return
+	}
+
+	//Publish the message to the cluster
+	if err := r.srv.publish(r.ID, msg, to, excludes); err != nil {
+		fmt.Printf("wschat: failed to publish to room %s: %s\n", r.ID, err)
+	}
+}
+
+// Writes a message to the sessions of this room that are connected to this node.
+func (r *WSRoom) deliver(msg []byte, to []util.UUID, excludes []util.UUID) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	//Create a map for quick lookup of excluded sessions
-	excludeMap := make(map[*melody.Session]bool)
+	//Create maps for quick lookup of targeted and excluded users
+	toMap := make(map[util.UUID]bool)
+	for _, id := range to {
+		toMap[id] = true
+	}
+	excludeMap := make(map[util.UUID]bool)
 	for _, exclude := range excludes {
 		excludeMap[exclude] = true
 	}
 
 	//Iterate through the sessions map and send the message
-	for session := range r.sessions {
-		if !excludeMap[session] {
-			session.Write(msg)
+	for session, userData := range r.sessions {
+		if len(toMap) > 0 && !toMap[userData.ID] {
+			continue
+		}
+		if !excludeMap[userData.ID] {
+			userData.send(session, msg)
 		}
 	}
 }
 
+/*
+Removes the given users from the room on this node, or everyone if `all` is
+set. Room connections are closed, and the disconnect handler takes care of
+the rest, same as if the users had left on their own. Multiplexed
+connections are unsubscribed from the room instead.
+*/
+func (r *WSRoom) disconnect(users []util.UUID, all bool) {
+	//Collect the sessions first; closing them re-enters the room's lock
+	r.mu.RLock()
+	targets := make(map[*melody.Session]*UserData)
+	if all {
+		for s, userData := range r.sessions {
+			targets[s] = userData
+		}
+	} else {
+		for _, uid := range users {
+			if s, ok := r.userIDs[uid]; ok {
+				targets[s] = r.sessions[s]
+			}
+		}
+	}
+	r.mu.RUnlock()
+
+	//Multiplexed connections are only unsubscribed, since they may be in other rooms too
+	const reason = "you are no longer a member of this room"
+	for s, userData := range targets {
+		if conn := getMuxConn(s); userData.mux && conn != nil && r.srv != nil {
+			r.srv.unsubscribeRoom(s, conn, r.ID, &chat.ServerError{Code: chat.ErrCodeNOT_MEMBER, Reason: reason})
+			continue
+		}
+		s.CloseWithMsg(melody.FormatCloseMessage(closeCodeREMOVED, reason))
+	}
+}
+
 // Checks if a room has a session.
 func (r *WSRoom) HasSession(s *melody.Session) bool {
 	r.mu.RLock()
@@ -87,11 +174,60 @@ func (r *WSRoom) HasUser(uid util.UUID) bool {
 	return exists
 }
 
+// Checks if a user is a member of the room, regardless of whether they're connected.
+func (r *WSRoom) HasMember(uid util.UUID) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, exists := r.participants[uid]
+	return exists
+}
+
+// Gets the number of members of the room, regardless of whether they're connected.
+func (r *WSRoom) memberCount() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.participants)
+}
+
 // Checks if the room is empty.
 func (r *WSRoom) IsEmpty() bool {
 	return r.Size() == 0
 }
 
+// Replaces the membership list of the room.
+func (r *WSRoom) setParticipants(participants chatroom.MembershipList) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.participants = participants
+}
+
+// Gets the room's disappearing messages timer, if it's been loaded.
+func (r *WSRoom) getTimer() (time.Duration, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.timer == nil {
+		return 0, false
+	}
+	return *r.timer, true
+}
+
+// Replaces the room's disappearing messages timer.
+func (r *WSRoom) setTimer(timer time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.timer = &timer
+}
+
+// Sets the room's disappearing messages timer unless a newer one was already set, returning the timer in effect.
+func (r *WSRoom) initTimer(timer time.Duration) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.timer == nil {
+		r.timer = &timer
+	}
+	return *r.timer
+}
+
 // Removes a user from the room.
 func (r *WSRoom) RemoveSession(s *melody.Session) {
 	r.mu.Lock()
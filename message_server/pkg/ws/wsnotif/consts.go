@@ -0,0 +1,26 @@
+package wsnotif
+
+import (
+	"time"
+
+	"wraith.me/message_server/pkg/obj"
+)
+
+var (
+	// The context key name for the ID of the user connecting to the notification server.
+	WSNotifCtxObjKey = obj.CtxKey{S: "notifUserID"}
+)
+
+const (
+	// How long to wait for a Redis operation to complete.
+	redisTimeout = 5 * time.Second
+
+	// The maximum size of a message from a client. Clients have nothing to say on this channel.
+	maxMessageSize = 512
+
+	// The WebSocket close code sent to users whose session was revoked.
+	closeCodeREVOKED = 4002
+
+	// The prefix of the pub/sub channels that carry each user's notifications between nodes.
+	userChannelPrefix = "wsnotif:user:"
+)
@@ -0,0 +1,216 @@
+package wsnotif
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/olahol/melody"
+	"github.com/redis/go-redis/v9"
+	"wraith.me/message_server/pkg/obj/notification"
+	"wraith.me/message_server/pkg/obj/session"
+	cr "wraith.me/message_server/pkg/redis"
+	"wraith.me/message_server/pkg/util"
+)
+
+var (
+	// Holds the instance object for the global WebSocket notification server.
+	instance *Server
+
+	// Guard mutex to ensure that only one singleton object is created.
+	once sync.Once
+)
+
+//-- SINGLETON: Server
+
+/*
+Represents a Melody WebSocket server that pushes notifications to users as
+they're created. Each user gets a channel of their own, which they may have
+open from several devices at once. Servers on different nodes share
+notifications with each other via Redis pub/sub, so a notification reaches
+the user no matter which node stored it.
+*/
+type Server struct {
+	melody   *melody.Melody
+	sessions map[util.UUID]map[*melody.Session]bool
+	mu       sync.RWMutex
+	rclient  *redis.Client
+	pubsub   *redis.PubSub
+	stop     context.CancelFunc
+}
+
+// Gets the currently active notification server instance.
+func GetInstance() *Server {
+	once.Do(func() {
+		srv, err := NewServer(cr.GetInstance().GetClient())
+		if err != nil {
+			panic(fmt.Sprintf("wsnotif: %s", err))
+		}
+		instance = srv
+	})
+	return instance
+}
+
+/*
+Creates a new notification server that shares notifications with other nodes
+through the given Redis client. Most callers should use `GetInstance()`
+instead; this is mainly useful for running several nodes within the same
+process.
+*/
+func NewServer(rclient *redis.Client) (*Server, error) {
+	//Create the server object
+	srv := &Server{
+		melody:   melody.New(),
+		sessions: make(map[util.UUID]map[*melody.Session]bool),
+		rclient:  rclient,
+	}
+	srv.melody.Config.MaxMessageSize = maxMessageSize
+	srv.melody.HandleConnect(srv.handleConnect)
+	srv.melody.HandleDisconnect(srv.handleDisconnect)
+
+	//Subscribe to the user channels before any sessions can connect
+	ctx, cancel := context.WithCancel(context.Background())
+	patterns := []string{userChannelPrefix + "*", session.RevokedChannel}
+	srv.pubsub = rclient.PSubscribe(ctx, patterns...)
+	for range patterns {
+		if _, err := srv.pubsub.Receive(ctx); err != nil {
+			cancel()
+			srv.pubsub.Close()
+			return nil, fmt.Errorf("failed to subscribe to user channels: %w", err)
+		}
+	}
+	srv.stop = cancel
+
+	go srv.listen(ctx)
+	return srv, nil
+}
+
+// Disconnects all sessions and stops relaying notifications between nodes.
+func (w *Server) Close() error {
+	w.stop()
+	if err := w.melody.Close(); err != nil {
+		return err
+	}
+	return w.pubsub.Close()
+}
+
+// Gets the backend Melody handler for the server.
+func (w *Server) GetMelody() *melody.Melody {
+	return w.melody
+}
+
+/*
+Pushes a notification to every session its recipient has open, on any node.
+Users who aren't connected simply pick it up from the REST API later.
+*/
+func (w *Server) Push(notif notification.Notification) {
+	payload, err := json.Marshal(notif)
+	if err != nil {
+		fmt.Printf("wsnotif: failed to marshal notification %s: %s\n", notif.ID, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := w.rclient.Publish(ctx, userChannel(notif.Recipient), payload).Err(); err != nil {
+		fmt.Printf("wsnotif: failed to publish notification %s: %s\n", notif.ID, err)
+	}
+}
+
+// Handles new connections to the notification server.
+func (w *Server) handleConnect(s *melody.Session) {
+	uid := getUserID(s)
+	if uid == nil {
+		s.Close()
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.sessions[*uid] == nil {
+		w.sessions[*uid] = make(map[*melody.Session]bool)
+	}
+	w.sessions[*uid][s] = true
+}
+
+// Handles disconnections from the notification server.
+func (w *Server) handleDisconnect(s *melody.Session) {
+	uid := getUserID(s)
+	if uid == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.sessions[*uid], s)
+	if len(w.sessions[*uid]) == 0 {
+		delete(w.sessions, *uid)
+	}
+}
+
+// Listens for notifications from the cluster and writes them to the recipients' local sessions.
+func (w *Server) listen(ctx context.Context) {
+	ch := w.pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			//Connections opened with revoked sessions are closed
+			if msg.Channel == session.RevokedChannel {
+				if families, err := session.ParseRevoked(msg.Payload); err == nil {
+					w.closeRevoked(families)
+				}
+				continue
+			}
+
+			uid, err := util.ParseUUIDv7(strings.TrimPrefix(msg.Channel, userChannelPrefix))
+			if err != nil {
+				continue
+			}
+
+			w.mu.RLock()
+			for s := range w.sessions[uid] {
+				s.Write([]byte(msg.Payload))
+			}
+			w.mu.RUnlock()
+		}
+	}
+}
+
+/*
+Closes the connections on this node that were opened with any of the given
+sessions. Sessions are matched by token family, so connections opened before
+the session was refreshed are closed too.
+*/
+func (w *Server) closeRevoked(families map[util.UUID]bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	for _, conns := range w.sessions {
+		for s := range conns {
+			if family, ok := session.Of(s.Request); ok && families[family] {
+				s.CloseWithMsg(melody.FormatCloseMessage(closeCodeREVOKED, "your session was revoked"))
+			}
+		}
+	}
+}
+
+// Gets the pub/sub channel for a user.
+func userChannel(id util.UUID) string {
+	return userChannelPrefix + id.String()
+}
+
+// Gets the ID of a connecting user from a Melody session context.
+func getUserID(s *melody.Session) *util.UUID {
+	uid, ok := s.Request.Context().Value(WSNotifCtxObjKey).(util.UUID)
+	if !ok {
+		return nil
+	}
+	return &uid
+}
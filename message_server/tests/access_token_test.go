@@ -31,7 +31,7 @@ func TestGenAccessTok(t *testing.T) {
 		time.Now().Add(30*time.Minute),
 		nil,
 		nil,
-	).Encrypt(secrets.SK, true)
+	).Encrypt(secrets.Keys.Active(), true)
 
 	//Get the expiration
 	expr, err := token.GetExprFromFooter(tok)
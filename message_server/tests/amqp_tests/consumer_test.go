@@ -1,52 +1,66 @@
 package amqptests
 
 import (
-	"fmt"
+	"context"
 	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"wraith.me/message_server/pkg/amqp"
 )
 
-func TestConsumer(t *testing.T) {
+func TestConsumerGroups(t *testing.T) {
 	/* COMMON START */
 	//Connect to LavinMQ
 	connect()
 	defer disconnect()
-
-	//Declare a new queue
-	q, err := QueueDecl(ch, QueueName)
-	if err != nil {
-		t.Fatalf("failed to declare a queue %s", err)
-	}
 	/* COMMON STOP */
 
-	/*
-		//Peek at the queue to get the number of messages that can be consumed
-		messageCount, err := QueuePeek(ch, q.Name)
+	//Create two groups, the first of which has two members
+	group1, group2 := uuid.NewString(), uuid.NewString()
+	cleanupGroup(t, group1, amqp.ExchangeFRIEND_REQUEST)
+	cleanupGroup(t, group2, amqp.ExchangeFRIEND_REQUEST)
+	events1 := make(chan amqp.Event, 32)
+	events2 := make(chan amqp.Event, 32)
+	keys := []string{"frq.*"}
+	for _, sub := range []struct {
+		group  string
+		events chan amqp.Event
+	}{{group1, events1}, {group1, events1}, {group2, events2}} {
+		events := sub.events
+		err := bus.Subscribe(sub.group, amqp.ExchangeFRIEND_REQUEST, keys, func(ev amqp.Event) error {
+			events <- ev
+			return nil
+		})
 		if err != nil {
-			t.Fatalf("Failed to peek at queue with error %s", err)
+			t.Fatalf("Failed to subscribe: %s", err)
 		}
-	*/
-
-	//Show the number of messages
-	fmt.Printf("Found %d messages to consume from queue %s\n", q.Messages, q.Name)
+	}
 
-	//Setup a queue consumer
-	msgs, err := ch.Consume(
-		q.Name,
-		"",
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
-		t.Fatalf("Failed to register a consumer: %s", err)
+	//Publish a batch of events
+	messageCount := 10
+	for i := 0; i < messageCount; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := bus.Publish(ctx, amqp.ExchangeFRIEND_REQUEST, amqp.KeyFRQ_CREATED, testPayload{N: i})
+		cancel()
+		if err != nil {
+			t.Fatalf("Failed to publish an event: %s", err)
+		}
 	}
 
-	//Consume from the queue as messages come in
-	go func() {
-		for msg := range msgs {
-			fmt.Printf("Received: `%s`\n", string(msg.Body))
+	//Each group should see every event exactly once
+	for _, events := range []chan amqp.Event{events1, events2} {
+		seen := make(map[string]bool)
+		for _, ev := range awaitEvents(t, events, messageCount) {
+			if seen[ev.ID.String()] {
+				t.Fatalf("Event %s was delivered to a group more than once", ev.ID)
+			}
+			seen[ev.ID.String()] = true
 		}
-	}()
+	}
+	select {
+	case ev := <-events1:
+		t.Fatalf("Unexpected extra event %s", ev.ID)
+	case <-time.After(500 * time.Millisecond):
+	}
 }
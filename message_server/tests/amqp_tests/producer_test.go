@@ -2,56 +2,147 @@ package amqptests
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/rabbitmq/amqp091-go"
+	"wraith.me/message_server/pkg/amqp"
 )
 
-func TestProducer(t *testing.T) {
+// Represents the payload of the events published by these tests.
+type testPayload struct {
+	N    int    `json:"n"`
+	Body string `json:"body"`
+}
+
+func TestBusPublishConfirm(t *testing.T) {
 	/* COMMON START */
 	//Connect to LavinMQ
 	connect()
 	defer disconnect()
+	/* COMMON STOP */
 
-	//Declare a new queue
-	q, err := QueueDecl(ch, QueueName)
+	//Attach a consumer group so the events have somewhere to go
+	group := uuid.NewString()
+	cleanupGroup(t, group, amqp.ExchangeCHAT)
+	events := make(chan amqp.Event, 16)
+	err := bus.Subscribe(group, amqp.ExchangeCHAT, []string{amqp.KeyMESSAGE_CREATED}, func(ev amqp.Event) error {
+		events <- ev
+		return nil
+	})
 	if err != nil {
-		t.Fatalf("failed to declare a queue %s", err)
+		t.Fatalf("Failed to subscribe: %s", err)
 	}
-	/* COMMON STOP */
 
-	//Send x messages to the queue
+	//Send x events; each publish only returns once the broker confirms it
 	messageCount := 10
-	prefix := uuid.New()
 	for i := 0; i < messageCount; i++ {
-		//Create a context for the message
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-
-		//Create the message body
-		body := fmt.Sprintf("<%s> Message %d", prefix, i)
-
-		//Publish the message
-		err := ch.PublishWithContext(ctx,
-			"",
-			q.Name,
-			false,
-			false,
-			amqp091.Publishing{
-				ContentType: "text/plain",
-				Body:        []byte(body),
-			},
-		)
-
-		//Fail on errors
+		payload := testPayload{N: i, Body: fmt.Sprintf("Message %d", i)}
+		err := bus.Publish(ctx, amqp.ExchangeCHAT, amqp.KeyMESSAGE_CREATED, payload)
+		cancel()
+		if err != nil {
+			t.Fatalf("Failed to publish an event: %s", err)
+		}
+	}
+
+	//Ensure every event arrived intact and in order
+	for i, ev := range awaitEvents(t, events, messageCount) {
+		var payload testPayload
+		if err := ev.Decode(&payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload.N != i || ev.Key != amqp.KeyMESSAGE_CREATED {
+			t.Fatalf("Unexpected event #%d: %+v", i, payload)
+		}
+	}
+}
+
+func TestBusDeadLetter(t *testing.T) {
+	/* COMMON START */
+	//Connect to LavinMQ
+	connect()
+	defer disconnect()
+	/* COMMON STOP */
+
+	//Attach a consumer group that fails on every event
+	group := uuid.NewString()
+	cleanupGroup(t, group, amqp.ExchangeNOTIFICATION)
+	attempts := make(chan amqp.Event, 1)
+	handler := amqp.Typed(func(ev amqp.Event, payload testPayload) error {
+		attempts <- ev
+		return errors.New("cannot process this")
+	})
+	if err := bus.Subscribe(group, amqp.ExchangeNOTIFICATION, []string{"#"}, handler); err != nil {
+		t.Fatalf("Failed to subscribe: %s", err)
+	}
+
+	//Publish an event
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := bus.Publish(ctx, amqp.ExchangeNOTIFICATION, amqp.KeyNOTIFICATION_CREATED, testPayload{N: 1}); err != nil {
+		t.Fatalf("Failed to publish an event: %s", err)
+	}
+	awaitEvents(t, attempts, 1)
+
+	//The failed event should end up in the dead-letter queue
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		count, err := QueuePeek(ch, amqp.DeadQueueName(group, amqp.ExchangeNOTIFICATION))
 		if err != nil {
-			t.Fatalf("Failed to publish a message: %s", err)
+			t.Fatalf("Failed to peek at the dead-letter queue: %s", err)
+		}
+		if count == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 1 dead-lettered event; found %d", count)
 		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestBusReconnect(t *testing.T) {
+	/* COMMON START */
+	//Connect to LavinMQ
+	connect()
+	defer disconnect()
+	/* COMMON STOP */
+
+	//Attach a consumer group
+	group := uuid.NewString()
+	cleanupGroup(t, group, amqp.ExchangeMEMBERSHIP)
+	events := make(chan amqp.Event, 1)
+	err := bus.Subscribe(group, amqp.ExchangeMEMBERSHIP, []string{"member.*"}, func(ev amqp.Event) error {
+		events <- ev
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %s", err)
+	}
+
+	//Sever the connection out from under the client
+	ch.Close()
+	ch = nil
+	amqp.GetInstance().GetConn().Close()
 
-		//Log the message
-		fmt.Printf("Sent: `%s`\n", body)
+	//Keep publishing until the bus comes back
+	deadline := time.Now().Add(15 * time.Second)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := bus.Publish(ctx, amqp.ExchangeMEMBERSHIP, amqp.KeyMEMBER_JOINED, testPayload{N: 1})
+		cancel()
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("The bus did not recover: %s", err)
+		}
+		time.Sleep(250 * time.Millisecond)
 	}
+
+	//The restarted consumer should receive the event
+	awaitEvents(t, events, 1)
 }
@@ -2,6 +2,8 @@ package amqptests
 
 import (
 	"log"
+	"testing"
+	"time"
 
 	"github.com/rabbitmq/amqp091-go"
 	"wraith.me/message_server/pkg/amqp"
@@ -9,6 +11,7 @@ import (
 
 var conn *amqp091.Connection
 var ch *amqp091.Channel
+var bus *amqp.Bus
 
 var conf = amqp.AMQPConfig{
 	Host:     "127.0.0.1",
@@ -19,7 +22,7 @@ var conf = amqp.AMQPConfig{
 
 func connect() {
 	var err error
-	conn, err := amqp.GetInstance().Connect(&conf)
+	conn, err = amqp.GetInstance().Connect(&conf)
 	if err != nil {
 		log.Fatalf("Failed to connect to RabbitMQ: %s", err)
 	}
@@ -28,13 +31,49 @@ func connect() {
 	if err != nil {
 		log.Fatalf("Failed to open a channel: %s", err)
 	}
+
+	bus = amqp.NewBus(amqp.GetInstance())
+	if err := bus.Start(); err != nil {
+		log.Fatalf("Failed to start the event bus: %s", err)
+	}
 }
 
 func disconnect() {
+	if bus != nil {
+		bus.Close()
+	}
 	if ch != nil {
 		ch.Close()
 	}
 	if conn != nil {
-		conn.Close()
+		amqp.GetInstance().Disconnect()
+	}
+}
+
+// Deletes the queues of a consumer group once the test finishes.
+func cleanupGroup(t *testing.T, group string, exchange amqp.Exchange) {
+	t.Cleanup(func() {
+		dch, err := amqp.GetInstance().GetConn().Channel()
+		if err != nil {
+			return
+		}
+		defer dch.Close()
+		dch.QueueDelete(amqp.QueueName(group, exchange), false, false, false)
+		dch.QueueDelete(amqp.DeadQueueName(group, exchange), false, false, false)
+	})
+}
+
+// Waits for a number of events to come in over a channel.
+func awaitEvents(t *testing.T, events <-chan amqp.Event, n int) []amqp.Event {
+	out := make([]amqp.Event, 0, n)
+	timeout := time.After(10 * time.Second)
+	for len(out) < n {
+		select {
+		case ev := <-events:
+			out = append(out, ev)
+		case <-timeout:
+			t.Fatalf("timed out waiting for events; got %d of %d", len(out), n)
+		}
 	}
+	return out
 }
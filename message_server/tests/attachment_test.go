@@ -0,0 +1,204 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"wraith.me/message_server/pkg/blob"
+	"wraith.me/message_server/pkg/schema/attachment"
+	"wraith.me/message_server/pkg/util"
+)
+
+// Splits some content into the chunks of an upload and saves them to a store.
+func attachmentUpload(t *testing.T, store blob.BlobStore, content []byte, chunkSize int) attachment.Attachment {
+	sum := sha256.Sum256(content)
+	att := attachment.NewAttachment(
+		util.MustNewUUID7(), util.MustNewUUID7(),
+		"file.bin", "application/zip", int64(len(content)), hex.EncodeToString(sum[:]), chunkSize,
+	)
+	for i := 0; i < att.Chunks(); i++ {
+		start := int64(i * chunkSize)
+		if _, err := store.Put(context.Background(), att.ChunkKey(i), bytes.NewReader(content[start:start+att.ChunkLen(i)])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return att
+}
+
+func TestBlobFSStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := blob.NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Blobs can be read back, and are replaced when written again
+	for _, content := range []string{"first", "second"} {
+		if n, err := store.Put(ctx, "key", bytes.NewBufferString(content)); err != nil || n != int64(len(content)) {
+			t.Fatalf("failed to put blob; wrote %d bytes: %v", n, err)
+		}
+	}
+	rc, err := store.Get(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(got) != "second" {
+		t.Fatalf("mismatched content; expected 'second', got '%s'", got)
+	}
+
+	//Removed blobs are gone, and removing them again is harmless
+	for i := 0; i < 2; i++ {
+		if err := store.Delete(ctx, "key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.Get(ctx, "key"); !errors.Is(err, blob.ErrNotFound) {
+		t.Fatalf("expected the blob to be gone; got %v", err)
+	}
+
+	//Keys can't escape the store's directory
+	for _, bad := range []string{"", "../key", "a/b", ".hidden"} {
+		if _, err := store.Put(ctx, bad, bytes.NewBufferString("x")); !errors.Is(err, blob.ErrBadKey) {
+			t.Fatalf("expected key '%s' to be refused; got %v", bad, err)
+		}
+	}
+}
+
+func TestBlobAllowedTypes(t *testing.T) {
+	cfg := blob.DefaultBConfig()
+	cfg.AllowedTypes = "image/*, application/pdf"
+	for mime, want := range map[string]bool{
+		"image/png":                true,
+		"IMAGE/JPEG":               true,
+		"application/pdf":          true,
+		"text/plain; charset=utf8": false,
+		"application/x-msdownload": false,
+		"not a type":               false,
+	} {
+		if got := cfg.AllowsType(mime); got != want {
+			t.Errorf("type '%s'; expected %v, got %v", mime, want, got)
+		}
+	}
+}
+
+func TestAttachmentChunks(t *testing.T) {
+	att := attachment.NewAttachment(util.MustNewUUID7(), util.MustNewUUID7(), "a", "image/png", 10, "", 4)
+
+	//10 bytes in chunks of 4 leaves 2 for the last one
+	if att.Chunks() != 3 {
+		t.Fatalf("expected 3 chunks; got %d", att.Chunks())
+	}
+	for i, want := range []int64{-1, 4, 4, 2, -1} {
+		if got := att.ChunkLen(i - 1); got != want {
+			t.Errorf("chunk %d; expected %d bytes, got %d", i-1, want, got)
+		}
+	}
+
+	att.Received = []int{2, 0}
+	if missing := att.Missing(); !slices.Equal(missing, []int{1}) {
+		t.Fatalf("expected chunk 1 to be missing; got %v", missing)
+	}
+}
+
+func TestAttachmentAssemble(t *testing.T) {
+	ctx := context.Background()
+	store, err := blob.NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := make([]byte, 10_000)
+	rand.Read(content)
+
+	//The chunks are joined back into the original file, then discarded
+	att := attachmentUpload(t, store, content, 4096)
+	if err := attachment.Assemble(ctx, store, att); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := store.Get(ctx, att.BlobKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if !bytes.Equal(got, content) {
+		t.Fatalf("assembled file doesn't match; got %d bytes", len(got))
+	}
+	if _, err := store.Get(ctx, att.ChunkKey(0)); !errors.Is(err, blob.ErrNotFound) {
+		t.Fatalf("expected the chunks to be discarded; got %v", err)
+	}
+
+	//Files that don't match their hash are thrown out along with their chunks
+	bad := attachmentUpload(t, store, content, 4096)
+	bad.Hash = hex.EncodeToString(make([]byte, sha256.Size))
+	if err := attachment.Assemble(ctx, store, bad); !errors.Is(err, attachment.ErrHashMismatch) {
+		t.Fatalf("expected a hash mismatch; got %v", err)
+	}
+	for _, key := range []string{bad.BlobKey(), bad.ChunkKey(0)} {
+		if _, err := store.Get(ctx, key); !errors.Is(err, blob.ErrNotFound) {
+			t.Fatalf("expected '%s' to be thrown out; got %v", key, err)
+		}
+	}
+
+	//Missing chunks fail the upload but leave the rest for a retry
+	partial := attachmentUpload(t, store, content, 4096)
+	store.Delete(ctx, partial.ChunkKey(1))
+	if err := attachment.Assemble(ctx, store, partial); err == nil || errors.Is(err, attachment.ErrHashMismatch) {
+		t.Fatalf("expected a missing chunk to fail the upload; got %v", err)
+	}
+	if _, err := store.Get(ctx, partial.ChunkKey(0)); err != nil {
+		t.Fatalf("expected the chunks to be kept for a retry; got %v", err)
+	}
+}
+
+func TestAttachmentClaimAssembly(t *testing.T) {
+	mongoInit()
+	ctx := context.Background()
+	ac := attachment.GetCollection()
+
+	att := attachment.NewAttachment(util.MustNewUUID7(), util.MustNewUUID7(), "a", "image/png", 10, "", 4)
+	if _, err := ac.InsertOne(ctx, att); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ac.RemoveId(context.Background(), att.ID) })
+
+	//Only one caller may join the chunks at a time
+	if _, err := ac.ClaimAssembly(ctx, att.ID, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ac.ClaimAssembly(ctx, att.ID, time.Minute); !errors.Is(err, attachment.ErrNoSuchAttachment) {
+		t.Fatalf("expected a second claim to be refused; got %v", err)
+	}
+
+	//The claim can be taken again once it's given up
+	if err := ac.ReleaseAssembly(ctx, att.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ac.ClaimAssembly(ctx, att.ID, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	//Completed uploads can't be claimed or changed
+	done, err := ac.MarkComplete(ctx, att.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !done.Complete || done.AssemblingUntil != nil {
+		t.Fatalf("bad completed attachment: %+v", done)
+	}
+	if _, err := ac.ClaimAssembly(ctx, att.ID, time.Minute); !errors.Is(err, attachment.ErrNoSuchAttachment) {
+		t.Fatalf("expected a claim on a completed upload to be refused; got %v", err)
+	}
+	if _, err := ac.MarkReceived(ctx, att.ID, 0); !errors.Is(err, attachment.ErrNoSuchAttachment) {
+		t.Fatalf("expected a chunk for a completed upload to be refused; got %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+)
+
+func TestUserBlocked(t *testing.T) {
+	a := user.NewUserSimple("blocka", "blocka@example.com")
+	other := util.MustNewUUID7()
+	if a.HasBlocked(other) || len(a.BlockedIDs()) != 0 {
+		t.Fatalf("new user already blocks someone: %v", a.Blocked)
+	}
+	a.Blocked[other] = true
+	if !a.HasBlocked(other) || a.HasBlocked(a.ID) {
+		t.Fatalf("unexpected blocks: %v", a.Blocked)
+	}
+	if ids := a.BlockedIDs(); len(ids) != 1 || ids[0] != other {
+		t.Fatalf("expected [%s]; got %v", other, ids)
+	}
+}
+
+func TestUserBlock(t *testing.T) {
+	mongoInit()
+	uc := user.GetCollection()
+	ctx := context.Background()
+
+	//Create three users, two of whom are friends
+	suffix := util.MustNewUUID4().ShortString()[:8]
+	a := user.NewUserSimple("blka_"+suffix, "blka_"+suffix+"@example.com")
+	b := user.NewUserSimple("blkb_"+suffix, "blkb_"+suffix+"@example.com")
+	c := user.NewUserSimple("blkc_"+suffix, "blkc_"+suffix+"@example.com")
+	if _, err := uc.InsertMany(ctx, []*user.User{a, b, c}); err != nil {
+		t.Fatal(err)
+	}
+	ids := []util.UUID{a.ID, b.ID, c.ID}
+	defer uc.RemoveAll(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}})
+	if err := uc.AddFriendship(ctx, a.ID, b.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	//Blocking ends the friendship on both sides
+	if err := uc.Block(ctx, b.ID, a.ID); err != nil {
+		t.Fatal(err)
+	}
+	var ga, gb user.User
+	if err := uc.FindID(ctx, a.ID).One(&ga); err != nil {
+		t.Fatal(err)
+	}
+	if err := uc.FindID(ctx, b.ID).One(&gb); err != nil {
+		t.Fatal(err)
+	}
+	if ga.IsFriend(b.ID) || gb.IsFriend(a.ID) {
+		t.Fatalf("friendship survived the block; a: %v, b: %v", ga.Friends, gb.Friends)
+	}
+	if !gb.HasBlocked(a.ID) || ga.HasBlocked(b.ID) {
+		t.Fatalf("block isn't one-way; a: %v, b: %v", ga.Blocked, gb.Blocked)
+	}
+	if err := uc.Block(ctx, a.ID, a.ID); err == nil {
+		t.Fatal("user blocked themselves")
+	}
+
+	//Only the blocker is reported, and they're hidden from the blocked user
+	blockers, err := uc.BlockersOf(ctx, a.ID, ids)
+	if err != nil || len(blockers) != 1 || blockers[0] != b.ID {
+		t.Fatalf("expected [%s]; got %v (%v)", b.ID, blockers, err)
+	}
+	visible := bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}, user.NotBlocking(a.ID)}
+	if n, err := uc.Find(ctx, visible).Count(); err != nil || n != 2 {
+		t.Fatalf("expected 2 visible users; got %d (%v)", n, err)
+	}
+
+	//Unblocking lifts the block, but doesn't restore the friendship
+	if err := uc.Unblock(ctx, b.ID, a.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := uc.FindID(ctx, b.ID).One(&gb); err != nil {
+		t.Fatal(err)
+	}
+	if gb.HasBlocked(a.ID) || gb.IsFriend(a.ID) {
+		t.Fatalf("unexpected state after unblocking; blocked: %v, friends: %v", gb.Blocked, gb.Friends)
+	}
+	if blockers, err := uc.BlockersOf(ctx, a.ID, ids); err != nil || len(blockers) != 0 {
+		t.Fatalf("expected no blockers; got %v (%v)", blockers, err)
+	}
+}
+
+func TestUserBlockNullBlocklist(t *testing.T) {
+	mongoInit()
+	uc := user.GetCollection()
+	ctx := context.Background()
+
+	//Users saved before blocks were added have a null blocklist
+	suffix := util.MustNewUUID4().ShortString()[:8]
+	a := user.NewUserSimple("blkn_"+suffix, "blkn_"+suffix+"@example.com")
+	if _, err := uc.InsertOne(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	defer uc.RemoveId(ctx, a.ID)
+	if err := uc.UpdateId(ctx, a.ID, bson.D{{Key: "$set", Value: bson.D{{Key: "blocked", Value: nil}}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	//Blocking someone still works, and creates the blocklist
+	other := util.MustNewUUID7()
+	if err := uc.Block(ctx, a.ID, other); err != nil {
+		t.Fatal(err)
+	}
+	var got user.User
+	if err := uc.FindID(ctx, a.ID).One(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.HasBlocked(other) || len(got.Blocked) != 1 {
+		t.Fatalf("expected only %s to be blocked; got %v", other, got.Blocked)
+	}
+
+	//New users don't save an empty blocklist at all
+	b := user.NewUserSimple("blkm_"+suffix, "blkm_"+suffix+"@example.com")
+	if _, err := uc.InsertOne(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+	defer uc.RemoveId(ctx, b.ID)
+	if n, err := uc.Find(ctx, bson.D{{Key: "_id", Value: b.ID}, {Key: "blocked", Value: bson.D{{Key: "$exists", Value: false}}}}).Count(); err != nil || n != 1 {
+		t.Fatalf("expected the empty blocklist to be left out; got %d (%v)", n, err)
+	}
+}
@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"wraith.me/message_server/pkg/http_types/ws/chat"
+	chatmessage "wraith.me/message_server/pkg/schema/chat_message"
+	chatroom "wraith.me/message_server/pkg/schema/chat_room"
+	"wraith.me/message_server/pkg/util"
+)
+
+func TestChatMessageExpiry(t *testing.T) {
+	mongoInit()
+	ctx := context.Background()
+	mc := chatmessage.GetCollection()
+
+	//One message has expired and the other hasn't yet
+	alice, room := util.MustNewUUID7(), util.MustNewUUID7()
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	gone := chat.NewMessageTyp("gone", alice, room, chat.TypeUMSG)
+	kept := chat.NewMessageTyp("kept", alice, room, chat.TypeUMSG)
+	gone.Expiry, kept.Expiry = &past, &future
+	for _, msg := range []chat.Message{gone, kept} {
+		if _, err := mc.InsertOne(ctx, chatmessage.NewMessage(msg, room)); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { mc.RemoveId(context.Background(), msg.ID) })
+	}
+	if !gone.IsExpired() || kept.IsExpired() || kept.DurationToExpiry() <= 0 || !kept.IsExpiredAt(future) {
+		t.Fatalf("bad expiry checks; gone: %s, kept: %s", gone.Expiry, kept.Expiry)
+	}
+
+	//Expired messages are hidden until they're removed
+	if _, err := mc.GetInRoom(ctx, room, gone.ID); !errors.Is(err, chatmessage.ErrNoSuchMessage) {
+		t.Fatalf("expected the expired message to be hidden; got %v", err)
+	}
+	if _, err := mc.GetInRoom(ctx, room, kept.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	//Only the expired message is picked up for removal
+	expired, err := mc.FindExpired(ctx, time.Now(), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[util.UUID]bool{}
+	for _, msg := range expired {
+		found[msg.ID] = true
+	}
+	if !found[gone.ID] || found[kept.ID] {
+		t.Fatalf("wrong messages picked up for removal; gone: %v, kept: %v", found[gone.ID], found[kept.ID])
+	}
+
+	//An expired message can only be removed once, and unexpired ones not at all
+	for i, want := range []bool{true, false} {
+		if removed, err := mc.RemoveExpired(ctx, gone.ID, time.Now()); err != nil || removed != want {
+			t.Fatalf("removal #%d; expected %v, got %v, %v", i+1, want, removed, err)
+		}
+	}
+	if removed, err := mc.RemoveExpired(ctx, kept.ID, time.Now()); err != nil || removed {
+		t.Fatalf("expected the unexpired message to be kept; got %v, %v", removed, err)
+	}
+}
+
+func TestWSChatDisappearing(t *testing.T) {
+	//Messages are stamped and removed in MongoDB
+	mongoInit()
+
+	alice, bob := util.MustNewUUID7(), util.MustNewUUID7()
+	room := chatroom.NewRoom(alice, bob)
+	srv, node := wschatNode(t, room.ID, room.Participants)
+	aconn := wschatDial(t, node, alice)
+	wschatAwait(t, aconn, chat.TypeJOINEVENT)
+
+	//Alice turns on a one hour timer, and the room is told with a server message
+	room.DisappearAfter = int64(time.Hour / time.Second)
+	srv.AnnounceTimer(room, alice)
+	notice := wschatAwait(t, aconn, chat.TypeSMSG)
+	t.Cleanup(func() { chatmessage.GetCollection().RemoveId(context.Background(), notice.ID) })
+	if notice.Sender != alice || notice.Content == "" {
+		t.Fatalf("bad timer notice: %+v", notice)
+	}
+
+	send := func(content string, after int64) chat.Message {
+		out := chat.NewMessageTyp(content, alice, room.ID, chat.TypeUMSG)
+		out.DisappearAfter = after
+		if err := aconn.WriteMessage(websocket.TextMessage, out.JSON()); err != nil {
+			t.Fatal(err)
+		}
+		in := wschatAwait(t, aconn, chat.TypeUMSG)
+		t.Cleanup(func() { chatmessage.GetCollection().RemoveId(context.Background(), in.ID) })
+		if in.Expiry == nil || in.DisappearAfter != 0 {
+			t.Fatalf("message '%s' was not stamped: %+v", content, in)
+		}
+		return in
+	}
+
+	//Messages pick up the room's timer
+	if in := send("default", 0); in.DurationToExpiry() < 59*time.Minute || in.DurationToExpiry() > time.Hour {
+		t.Fatalf("expected the room's timer; message expires in %s", in.DurationToExpiry())
+	}
+
+	//Overrides can make messages disappear sooner, but not later
+	if in := send("later", 2*room.DisappearAfter); in.DurationToExpiry() > time.Hour {
+		t.Fatalf("override outlasted the room's timer; message expires in %s", in.DurationToExpiry())
+	}
+	sooner := send("sooner", 1)
+	if sooner.DurationToExpiry() > time.Second {
+		t.Fatalf("override was not applied; message expires in %s", sooner.DurationToExpiry())
+	}
+
+	//Overrides must be in range
+	bad := chat.NewMessageTyp("bad", alice, room.ID, chat.TypeUMSG)
+	bad.DisappearAfter = -1
+	if err := aconn.WriteMessage(websocket.TextMessage, bad.JSON()); err != nil {
+		t.Fatal(err)
+	}
+	wschatAwait(t, aconn, chat.TypeSERR)
+
+	//Once the message expires, it's removed and the room is told
+	time.Sleep(sooner.DurationToExpiry() + 100*time.Millisecond)
+	if removed, err := srv.ExpireMessages(context.Background()); err != nil || removed < 1 {
+		t.Fatalf("expected the message to be removed; got %d, %v", removed, err)
+	}
+	if evt := wschatAwait(t, aconn, chat.TypeDELETED); evt.ID != sooner.ID || evt.Content != "" {
+		t.Fatalf("bad removal event: %+v", evt)
+	}
+	if n, err := chatmessage.GetCollection().Find(context.Background(), bson.M{"_id": sooner.ID}).Count(); err != nil || n != 0 {
+		t.Fatalf("expected the message to be gone from storage; got %d, %v", n, err)
+	}
+}
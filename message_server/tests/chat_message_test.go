@@ -0,0 +1,363 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"wraith.me/message_server/pkg/blob"
+	"wraith.me/message_server/pkg/http_types/ws/chat"
+	"wraith.me/message_server/pkg/schema/attachment"
+	chatmessage "wraith.me/message_server/pkg/schema/chat_message"
+	chatroom "wraith.me/message_server/pkg/schema/chat_room"
+	"wraith.me/message_server/pkg/util"
+)
+
+// Saves a user message to a room and returns it.
+func chatMessageSeed(t *testing.T, roomID util.UUID, sender util.UUID, content string) chatmessage.Message {
+	msg := chatmessage.NewMessage(chat.NewMessageTyp(content, sender, roomID, chat.TypeUMSG), roomID)
+	if _, err := chatmessage.GetCollection().InsertOne(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		chatmessage.GetCollection().RemoveId(context.Background(), msg.ID)
+	})
+	return msg
+}
+
+func TestChatMessageEdit(t *testing.T) {
+	mongoInit()
+	ctx := context.Background()
+	mc := chatmessage.GetCollection()
+
+	alice, bob, room := util.MustNewUUID7(), util.MustNewUUID7(), util.MustNewUUID7()
+	msg := chatMessageSeed(t, room, alice, "first")
+
+	//Only the sender may edit a message
+	if _, err := mc.Edit(ctx, room, msg.ID, bob, "hijacked"); !errors.Is(err, chatmessage.ErrNoSuchMessage) {
+		t.Fatalf("expected someone else's edit to be refused; got %v", err)
+	}
+
+	//Messages can't be edited from another room
+	if _, err := mc.Edit(ctx, util.MustNewUUID7(), msg.ID, alice, "moved"); !errors.Is(err, chatmessage.ErrNoSuchMessage) {
+		t.Fatalf("expected an edit through the wrong room to be refused; got %v", err)
+	}
+
+	//Each edit pushes the old content onto the history; content is taken literally
+	if _, err := mc.Edit(ctx, room, msg.ID, alice, "second"); err != nil {
+		t.Fatal(err)
+	}
+	edited, err := mc.Edit(ctx, room, msg.ID, alice, "$content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited.Content != "$content" || edited.EditedAt == nil {
+		t.Fatalf("edit was not applied: %+v", edited)
+	}
+	if len(edited.Revisions) != 2 || edited.Revisions[0].Content != "first" || edited.Revisions[1].Content != "second" {
+		t.Fatalf("bad revision history: %+v", edited.Revisions)
+	}
+
+	//The change event carries the new content under the original ID
+	evt := edited.ChangeEvent()
+	if evt.Type != chat.TypeEDITED || evt.ID != msg.ID || evt.Content != "$content" {
+		t.Fatalf("bad change event: %+v", evt)
+	}
+}
+
+func TestChatMessageDelete(t *testing.T) {
+	mongoInit()
+	ctx := context.Background()
+	mc := chatmessage.GetCollection()
+
+	alice, bob, room := util.MustNewUUID7(), util.MustNewUUID7(), util.MustNewUUID7()
+	msg := chatMessageSeed(t, room, alice, "first")
+	if _, err := mc.Edit(ctx, room, msg.ID, alice, "second"); err != nil {
+		t.Fatal(err)
+	}
+
+	//Deleting leaves a tombstone with no trace of the content
+	tomb, err := mc.Delete(ctx, room, msg.ID, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tomb.IsDeleted() || tomb.Content != "" || len(tomb.Revisions) != 0 || tomb.EditedAt != nil {
+		t.Fatalf("bad tombstone: %+v", tomb)
+	}
+	if tomb.ID != msg.ID || tomb.Sender != alice || tomb.DeletedBy == nil || *tomb.DeletedBy != bob {
+		t.Fatalf("tombstone lost the message's identity: %+v", tomb)
+	}
+
+	//History sees the tombstone in place of the message
+	var stored chatmessage.Message
+	if err := mc.FindID(ctx, msg.ID).One(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if !stored.IsDeleted() || stored.Content != "" {
+		t.Fatalf("history still has the original message: %+v", stored)
+	}
+
+	//Tombstones can't be deleted again or edited
+	if _, err := mc.Delete(ctx, room, msg.ID, alice); !errors.Is(err, chatmessage.ErrNoSuchMessage) {
+		t.Fatalf("expected a second delete to be refused; got %v", err)
+	}
+	if _, err := mc.Edit(ctx, room, msg.ID, alice, "third"); !errors.Is(err, chatmessage.ErrNoSuchMessage) {
+		t.Fatalf("expected an edit of a tombstone to be refused; got %v", err)
+	}
+}
+
+func TestChatMessageReleaseAttachments(t *testing.T) {
+	mongoInit()
+	ctx := context.Background()
+	mc := chatmessage.GetCollection()
+	ac := attachment.GetCollection()
+	store, err := blob.NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//One file is only in the first message, and the other was passed along in a second one
+	alice, room := util.MustNewUUID7(), util.MustNewUUID7()
+	only := attachment.NewAttachment(room, alice, "only.bin", "application/zip", 4, "", 4)
+	shared := attachment.NewAttachment(room, alice, "shared.bin", "application/zip", 4, "", 4)
+	for _, att := range []attachment.Attachment{only, shared} {
+		if _, err := ac.InsertOne(ctx, att); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { ac.RemoveId(context.Background(), att.ID) })
+		if _, err := store.Put(ctx, att.BlobKey(), strings.NewReader("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first := chat.NewMessageTyp("first", alice, room, chat.TypeUMSG)
+	first.Attachments = []util.UUID{only.ID, shared.ID}
+	second := chat.NewMessageTyp("second", alice, room, chat.TypeUMSG)
+	second.Attachments = []util.UUID{shared.ID}
+	for _, msg := range []chat.Message{first, second} {
+		if _, err := mc.InsertOne(ctx, chatmessage.NewMessage(msg, room)); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { mc.RemoveId(context.Background(), msg.ID) })
+	}
+	exists := func(att attachment.Attachment) bool {
+		_, err := store.Get(ctx, att.BlobKey())
+		n, _ := ac.Find(ctx, bson.D{{Key: "_id", Value: att.ID}}).Count()
+		return err == nil && n == 1
+	}
+
+	//Deleting the first message only removes the file that nothing else refers to
+	if _, err := mc.Delete(ctx, room, first.ID, alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := chatmessage.ReleaseAttachments(ctx, store, first.Attachments); err != nil {
+		t.Fatal(err)
+	}
+	if exists(only) || !exists(shared) {
+		t.Fatalf("expected only the unshared file to be removed; only: %v, shared: %v", exists(only), exists(shared))
+	}
+
+	//Once the second message is gone too, so is the shared file
+	if _, err := mc.Delete(ctx, room, second.ID, alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := chatmessage.ReleaseAttachments(ctx, store, second.Attachments); err != nil {
+		t.Fatal(err)
+	}
+	if exists(shared) {
+		t.Fatal("expected the shared file to be removed once no message refers to it")
+	}
+}
+
+func TestWSChatMessageChange(t *testing.T) {
+	//Alice and Bob are connected to a room on different nodes
+	alice, bob := util.MustNewUUID7(), util.MustNewUUID7()
+	room := chatroom.NewRoom(alice, bob)
+	srv1, node1 := wschatNode(t, room.ID, room.Participants)
+	_, node2 := wschatNode(t, room.ID, room.Participants)
+	aconn := wschatDial(t, node1, alice)
+	wschatAwait(t, aconn, chat.TypeJOINEVENT)
+	bconn := wschatDial(t, node2, bob)
+	wschatAwait(t, bconn, chat.TypeJOINEVENT)
+
+	//One of Alice's messages is edited, then deleted
+	msg := chatmessage.NewMessage(chat.NewMessageTyp("edited", alice, room.ID, chat.TypeUMSG), room.ID)
+	srv1.AnnounceMessageChange(msg)
+	if in := wschatAwait(t, bconn, chat.TypeEDITED); in.ID != msg.ID || in.Content != "edited" {
+		t.Fatalf("bad edit event: %+v", in)
+	}
+	msg.Type = chat.TypeDELETED
+	srv1.AnnounceMessageChange(msg)
+	if in := wschatAwait(t, bconn, chat.TypeDELETED); in.ID != msg.ID || in.Content != "" {
+		t.Fatalf("bad delete event: %+v", in)
+	}
+}
+
+func TestChatMessageReactions(t *testing.T) {
+	mongoInit()
+	ctx := context.Background()
+	mc := chatmessage.GetCollection()
+
+	alice, bob, room := util.MustNewUUID7(), util.MustNewUUID7(), util.MustNewUUID7()
+	msg := chatMessageSeed(t, room, alice, "react to me")
+
+	//Reactions that can't be stored as keys are refused
+	for _, bad := range []string{"", "a.b", "$set", strings.Repeat("x", 33)} {
+		if _, _, err := mc.ToggleReaction(ctx, room, msg.ID, alice, bad); !errors.Is(err, chatmessage.ErrBadEmoji) {
+			t.Fatalf("expected reaction '%s' to be refused; got %v", bad, err)
+		}
+	}
+
+	//Both users react, and the reactions are aggregated
+	for _, uid := range []util.UUID{alice, bob} {
+		if added, _, err := mc.ToggleReaction(ctx, room, msg.ID, uid, "👍"); err != nil || !added {
+			t.Fatalf("expected reaction to be added; got %v, %v", added, err)
+		}
+	}
+	added, users, err := mc.ToggleReaction(ctx, room, msg.ID, alice, "👍")
+	if err != nil || added || len(users) != 1 || users[0] != bob {
+		t.Fatalf("expected alice's reaction to be removed; got %v, %v, %v", added, users, err)
+	}
+
+	//The emoji is dropped once nobody has it
+	if _, _, err := mc.ToggleReaction(ctx, room, msg.ID, bob, "👍"); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := mc.GetInRoom(ctx, room, msg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stored.Reactions["👍"]; ok {
+		t.Fatalf("expected the emoji to be dropped; got %v", stored.Reactions)
+	}
+
+	//Deleted messages can't be reacted to
+	if _, err := mc.Delete(ctx, room, msg.ID, alice); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := mc.ToggleReaction(ctx, room, msg.ID, bob, "👍"); !errors.Is(err, chatmessage.ErrNoSuchMessage) {
+		t.Fatalf("expected a reaction to a tombstone to be refused; got %v", err)
+	}
+}
+
+func TestWSChatRepliesAndReactions(t *testing.T) {
+	//References are checked against the history in MongoDB
+	mongoInit()
+
+	alice, bob := util.MustNewUUID7(), util.MustNewUUID7()
+	room := chatroom.NewRoom(alice, bob)
+	_, node := wschatNode(t, room.ID, room.Participants)
+	aconn := wschatDial(t, node, alice)
+	wschatAwait(t, aconn, chat.TypeJOINEVENT)
+
+	//Bob's message is saved after Alice joins, so it isn't part of her backlog
+	root := chatMessageSeed(t, room.ID, bob, "start of a thread")
+	send := func(msg chat.Message) {
+		if err := aconn.WriteMessage(websocket.TextMessage, msg.JSON()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//Alice replies in the thread
+	reply := chat.NewMessageTyp("first reply", alice, room.ID, chat.TypeUMSG)
+	reply.ThreadRoot = &root.ID
+	send(reply)
+	first := wschatAwait(t, aconn, chat.TypeUMSG)
+	if first.ThreadRoot == nil || *first.ThreadRoot != root.ID {
+		t.Fatalf("reply lost its thread: %+v", first)
+	}
+	t.Cleanup(func() { chatmessage.GetCollection().RemoveId(context.Background(), first.ID) })
+
+	//Replying to the reply keeps it in the thread
+	nested := chat.NewMessageTyp("second reply", alice, room.ID, chat.TypeUMSG)
+	nested.ReplyTo = &first.ID
+	send(nested)
+	second := wschatAwait(t, aconn, chat.TypeUMSG)
+	if second.ThreadRoot == nil || *second.ThreadRoot != root.ID || *second.ReplyTo != first.ID {
+		t.Fatalf("nested reply was not put in the thread: %+v", second)
+	}
+	t.Cleanup(func() { chatmessage.GetCollection().RemoveId(context.Background(), second.ID) })
+
+	//Threads can't be started from replies, and replies must refer to messages in the room
+	bad := chat.NewMessageTyp("bad thread", alice, room.ID, chat.TypeUMSG)
+	bad.ThreadRoot = &first.ID
+	send(bad)
+	wschatAwait(t, aconn, chat.TypeSERR)
+	missing := util.MustNewUUID7()
+	bad = chat.NewMessageTyp("bad reply", alice, room.ID, chat.TypeUMSG)
+	bad.ReplyTo = &missing
+	send(bad)
+	wschatAwait(t, aconn, chat.TypeSERR)
+
+	//The root keeps count of its replies
+	stored, err := chatmessage.GetCollection().GetInRoom(context.Background(), room.ID, root.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.ReplyCount != 2 || stored.LastReplyAt == nil {
+		t.Fatalf("expected 2 replies on the root; got %d", stored.ReplyCount)
+	}
+
+	//Alice reacts to the root, and the room is told who has the reaction
+	toggle := chat.Reaction{MessageID: root.ID, Emoji: "🎉"}
+	send(chat.NewMessageTyp(string(toggle.JSON()), alice, room.ID, chat.TypeREACTION))
+	var got chat.Reaction
+	if err := json.Unmarshal([]byte(wschatAwait(t, aconn, chat.TypeREACTION).Content), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Added || got.MessageID != root.ID || len(got.Users) != 1 || got.Users[0] != alice {
+		t.Fatalf("bad reaction event: %+v", got)
+	}
+}
+
+func TestChatMessageRemoveRoomMessages(t *testing.T) {
+	mongoInit()
+	ctx := context.Background()
+	mc := chatmessage.GetCollection()
+	ac := attachment.GetCollection()
+	store, err := blob.NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//One file is only in the deleted room, and the other was also passed along in another room
+	alice, room, other := util.MustNewUUID7(), util.MustNewUUID7(), util.MustNewUUID7()
+	only := attachment.NewAttachment(room, alice, "only.bin", "application/zip", 4, "", 4)
+	shared := attachment.NewAttachment(room, alice, "shared.bin", "application/zip", 4, "", 4)
+	for _, att := range []attachment.Attachment{only, shared} {
+		if _, err := ac.InsertOne(ctx, att); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { ac.RemoveId(context.Background(), att.ID) })
+		if _, err := store.Put(ctx, att.BlobKey(), strings.NewReader("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first := chat.NewMessageTyp("first", alice, room, chat.TypeUMSG)
+	first.Attachments = []util.UUID{only.ID, shared.ID}
+	forwarded := chat.NewMessageTyp("forwarded", alice, other, chat.TypeUMSG)
+	forwarded.Attachments = []util.UUID{shared.ID}
+	for _, msg := range []chat.Message{first, forwarded} {
+		if _, err := mc.InsertOne(ctx, chatmessage.NewMessage(msg, msg.Recipient)); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { mc.RemoveId(context.Background(), msg.ID) })
+	}
+
+	//The room's messages go, along with the file that no other message refers to
+	if err := chatmessage.RemoveRoomMessages(ctx, store, room); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := mc.Find(ctx, bson.D{{Key: "room_id", Value: room}}).Count(); n != 0 {
+		t.Fatalf("expected the room's messages to be removed; %d are left", n)
+	}
+	if _, err := store.Get(ctx, only.BlobKey()); err == nil {
+		t.Fatal("expected the file that was only in the room to be removed")
+	}
+	if _, err := store.Get(ctx, shared.BlobKey()); err != nil {
+		t.Fatalf("file that's still attached elsewhere was removed: %s", err)
+	}
+}
@@ -23,7 +23,7 @@ func TestCTokenClaims(t *testing.T) {
 		"jdoe@example.com",
 	)
 	fmt.Printf("%v\n", etok)
-	fmt.Printf("enc: %s\n", etok.Encrypt(sk))
+	fmt.Printf("enc: %s\n", etok.Encrypt(ccrypto.NewKeyring(sk).Active()))
 	fmt.Println()
 
 	//Test the pk token claim functionality
@@ -39,6 +39,6 @@ func TestCTokenClaims(t *testing.T) {
 		pk,
 	)
 	fmt.Printf("%v\n", pktok)
-	fmt.Printf("enc: %s\n", pktok.Encrypt(sk))
+	fmt.Printf("enc: %s\n", pktok.Encrypt(ccrypto.NewKeyring(sk).Active()))
 	fmt.Println()
 }
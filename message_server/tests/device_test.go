@@ -0,0 +1,173 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"wraith.me/message_server/pkg/config"
+	"wraith.me/message_server/pkg/controller/csolver"
+	ccrypto "wraith.me/message_server/pkg/crypto"
+	"wraith.me/message_server/pkg/obj/challenge"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+)
+
+func TestDeviceKeys(t *testing.T) {
+	//New users register with a single device key, which is their identity key
+	u := user.NewUserSimple("devk", "devk@example.com")
+	if len(u.DeviceKeys()) != 1 || !u.HasDeviceKey(u.Pubkey) {
+		t.Fatalf("expected the identity key to be the only device key; got %v", u.DeviceKeys())
+	}
+
+	//Users from before devices were tracked have their identity key as their only device key
+	u.Devices = nil
+	other, _, _ := ccrypto.NewKeypair(nil)
+	if len(u.DeviceKeys()) != 1 || !u.HasDeviceKey(u.Pubkey) || u.HasDeviceKey(other) {
+		t.Fatalf("unexpected device keys for a legacy user: %v", u.DeviceKeys())
+	}
+}
+
+func TestDeviceChallenge(t *testing.T) {
+	_, sk, _ := ccrypto.NewKeypair(nil)
+	env := &config.Env{ID: util.MustNewUUID7(), Keys: ccrypto.NewKeyring(sk)}
+	u := user.NewUserSimple("devc", "devc@example.com")
+	pk, _, _ := ccrypto.NewKeypair(nil)
+
+	//Device challenges claim the new key, and can't be used to log in
+	tok := csolver.IssueDeviceChallenge(*u, pk, env)
+	if _, err := challenge.DecryptPKStrict(tok, env.Keys, env.ID, challenge.CPurposeADDDEVICE, u.ID, pk); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := challenge.DecryptPKStrict(tok, env.Keys, env.ID, challenge.CPurposeLOGIN, u.ID, pk); err == nil {
+		t.Fatal("device challenge was accepted for login")
+	}
+	if _, err := challenge.DecryptPKStrict(tok, env.Keys, env.ID, challenge.CPurposeADDDEVICE, u.ID, u.Pubkey); err == nil {
+		t.Fatal("device challenge was accepted for a different key")
+	}
+}
+
+func TestUserDevices(t *testing.T) {
+	mongoInit()
+	uc := user.GetCollection()
+	ctx := context.Background()
+
+	//Create a user from before devices were tracked
+	suffix := util.MustNewUUID4().ShortString()[:8]
+	u := user.NewUserSimple("dev_"+suffix, "dev_"+suffix+"@example.com")
+	u.Devices = nil
+	other := user.NewUserSimple("devo_"+suffix, "devo_"+suffix+"@example.com")
+	if _, err := uc.InsertMany(ctx, []*user.User{u, other}); err != nil {
+		t.Fatal(err)
+	}
+	defer uc.RemoveAll(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: bson.A{u.ID, other.ID}}}}})
+	primary := u.Pubkey
+	if _, err := uc.RevokeDevice(ctx, u, primary.Fingerprint()); !errors.Is(err, user.ErrLastDevice) {
+		t.Fatalf("expected %v; got %v", user.ErrLastDevice, err)
+	}
+
+	//Adding a device stores the identity key as a device key too
+	pk, _, _ := ccrypto.NewKeypair(nil)
+	if err := uc.AddDevice(ctx, u, user.DeviceKey{Key: pk, Label: "laptop", Created: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	var got user.User
+	if err := uc.FindID(ctx, u.ID).One(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Devices) != 2 || !got.HasDeviceKey(primary) || !got.HasDeviceKey(pk) {
+		t.Fatalf("unexpected devices after adding one: %v", got.Devices)
+	}
+
+	//Keys can't be shared between users
+	if err := uc.AddDevice(ctx, other, user.DeviceKey{Key: pk, Label: "stolen"}); !errors.Is(err, user.ErrDeviceExists) {
+		t.Fatalf("expected %v; got %v", user.ErrDeviceExists, err)
+	}
+	if err := uc.AddDevice(ctx, other, user.DeviceKey{Key: primary, Label: "stolen"}); !errors.Is(err, user.ErrDeviceExists) {
+		t.Fatalf("expected %v; got %v", user.ErrDeviceExists, err)
+	}
+
+	//Revoking the identity key makes the remaining key the identity key
+	if err := uc.TouchDevice(ctx, &got, pk, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := uc.RevokeDevice(ctx, &got, primary.Fingerprint())
+	if err != nil || !rotated {
+		t.Fatalf("expected the identity key to be rotated; got %v (%v)", rotated, err)
+	}
+	if err := uc.FindID(ctx, u.ID).One(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Pubkey.Equal(pk) || len(got.Devices) != 1 || got.HasDeviceKey(primary) || got.Devices[pk.Fingerprint()].LastUsed.IsZero() {
+		t.Fatalf("unexpected state after revoking the identity key; pubkey: %s, devices: %v", got.Pubkey.Fingerprint(), got.Devices)
+	}
+
+	//A stale copy of the user can't revoke keys that changed in the meantime
+	if _, err := uc.RevokeDevice(ctx, u, pk.Fingerprint()); !errors.Is(err, user.ErrDeviceNotFound) {
+		t.Fatalf("expected %v; got %v", user.ErrDeviceNotFound, err)
+	}
+}
+
+func TestDeviceFamilies(t *testing.T) {
+	//Only the families of sessions opened with the key are returned, once each
+	u := user.NewUserSimple("devf", "devf@example.com")
+	exp := time.Now().Add(time.Hour)
+	laptop, phone, legacy := util.MustNewUUID7().String(), util.MustNewUUID7().String(), util.MustNewUUID7().String()
+	u.AddDeviceToken(laptop, "laptop", exp, "fp_laptop")
+	u.AddDeviceToken(phone, "phone", exp, "fp_phone")
+	u.AddToken(legacy, "legacy", exp)
+	next := util.MustNewUUID7().String()
+	u.Tokens[next] = user.UserToken{Token: "laptop2", Expiry: exp, Family: util.UUIDFromString(laptop), Device: "fp_laptop"}
+
+	families := u.DeviceFamilies("fp_laptop")
+	if len(families) != 1 || families[0].String() != laptop {
+		t.Fatalf("expected family %s; got %v", laptop, families)
+	}
+	if families := u.DeviceFamilies("fp_unknown"); len(families) != 0 {
+		t.Fatalf("expected no families; got %v", families)
+	}
+}
+
+func TestDeviceSessions(t *testing.T) {
+	mongoInit()
+	uc := user.GetCollection()
+	ctx := context.Background()
+
+	//Create a user with a session on each of two devices
+	suffix := util.MustNewUUID4().ShortString()[:8]
+	u := user.NewUserSimple("devs_"+suffix, "devs_"+suffix+"@example.com")
+	exp := time.Now().Add(time.Hour)
+	laptop, phone := util.MustNewUUID7().String(), util.MustNewUUID7().String()
+	u.AddDeviceToken(laptop, "laptop", exp, "fp_laptop")
+	u.AddDeviceToken(phone, "phone", exp, "fp_phone")
+	if _, err := uc.InsertOne(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	defer uc.RemoveId(ctx, u.ID)
+
+	//The device carries over when the session is refreshed
+	var got user.User
+	if err := uc.FindID(ctx, u.ID).One(&got); err != nil {
+		t.Fatal(err)
+	}
+	next := util.MustNewUUID7().String()
+	if err := uc.RotateToken(ctx, &got, laptop, next, "laptop2", exp); err != nil {
+		t.Fatal(err)
+	}
+	if dev := got.Tokens[next].Device; dev != "fp_laptop" {
+		t.Fatalf("expected the refreshed token to keep its device; got %q", dev)
+	}
+
+	//Revoking the device's families only ends its sessions
+	if _, err := uc.RevokeFamilies(ctx, u.ID, got.DeviceFamilies("fp_laptop")); err != nil {
+		t.Fatal(err)
+	}
+	if err := uc.FindID(ctx, u.ID).One(&got); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got.Tokens[next]; ok || len(got.Tokens) != 1 || got.Tokens[phone].Device != "fp_phone" {
+		t.Fatalf("unexpected sessions after revoking the laptop: %v", got.Tokens)
+	}
+}
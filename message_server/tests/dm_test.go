@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	chatroom "wraith.me/message_server/pkg/schema/chat_room"
+	"wraith.me/message_server/pkg/util"
+)
+
+func TestDirectRoom(t *testing.T) {
+	alice, bob := util.MustNewUUID7(), util.MustNewUUID7()
+	if chatroom.DMKey(alice, bob) != chatroom.DMKey(bob, alice) {
+		t.Fatal("expected the key to be the same regardless of order")
+	}
+
+	//Friends are both in the room straight away, and neither outranks the other
+	room := chatroom.NewDirectRoom(alice, bob, false)
+	if !room.Direct || room.IsRequest() || room.Size() != 2 {
+		t.Fatalf("bad direct room: %+v", room)
+	}
+	if room.Participants[alice] != chatroom.RoleOWNER || room.Participants[bob] != chatroom.RoleOWNER {
+		t.Fatalf("expected both users to own the room; got %+v", room.Participants)
+	}
+
+	//Requests only have their sender in the room
+	req := chatroom.NewDirectRoom(alice, bob, true)
+	if !req.IsRequest() || req.HasMember(bob) || req.Request.From != alice || req.Request.To != bob {
+		t.Fatalf("bad message request: %+v", req)
+	}
+}
+
+func TestRoomMessageRequests(t *testing.T) {
+	mongoInit()
+	ctx := context.Background()
+	rc := chatroom.GetCollection()
+	if err := rc.SetupIndexes(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	//Only one conversation may exist between two users
+	alice, bob, eve := util.MustNewUUID7(), util.MustNewUUID7(), util.MustNewUUID7()
+	first, created, err := rc.CreateDirect(ctx, chatroom.NewDirectRoom(alice, bob, true))
+	if err != nil || !created {
+		t.Fatalf("failed to create request; created: %v, err: %v", created, err)
+	}
+	t.Cleanup(func() { rc.RemoveId(context.Background(), first.ID) })
+	second, created, err := rc.CreateDirect(ctx, chatroom.NewDirectRoom(bob, alice, false))
+	if err != nil || created || second.ID != first.ID {
+		t.Fatalf("expected the existing conversation back; got %s, created: %v, err: %v", second.ID, created, err)
+	}
+	if found, err := rc.GetDirect(ctx, bob, alice); err != nil || found == nil || found.ID != first.ID {
+		t.Fatalf("expected to find the conversation; got %v, %v", found, err)
+	}
+
+	//The request carries a single message, which can be given back
+	for i, want := range []bool{true, false} {
+		if ok, err := rc.MarkRequestSent(ctx, first.ID, alice, true); err != nil || ok != want {
+			t.Fatalf("claim #%d; expected %v, got %v, %v", i+1, want, ok, err)
+		}
+	}
+	if ok, err := rc.MarkRequestSent(ctx, first.ID, alice, false); err != nil || !ok {
+		t.Fatalf("expected the message to be given back; got %v, %v", ok, err)
+	}
+	if ok, err := rc.MarkRequestSent(ctx, first.ID, bob, true); err != nil || ok {
+		t.Fatalf("expected the recipient not to be able to use up the message; got %v, %v", ok, err)
+	}
+
+	//The recipient shows up in the request listing
+	requests, err := rc.ListRequests(ctx, bob)
+	if err != nil || len(requests) != 1 || requests[0].ID != first.ID {
+		t.Fatalf("expected one request for the recipient; got %d, %v", len(requests), err)
+	}
+
+	//Only the recipient can answer the request
+	if _, err := rc.AcceptRequest(ctx, first.ID, eve); !errors.Is(err, chatroom.ErrNoSuchRequest) {
+		t.Fatalf("expected a stranger's answer to be refused; got %v", err)
+	}
+	accepted, err := rc.AcceptRequest(ctx, first.ID, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accepted.IsRequest() || !accepted.HasMember(bob) || accepted.Participants[bob] != chatroom.RoleOWNER {
+		t.Fatalf("bad accepted room: %+v", accepted)
+	}
+
+	//Answered requests can't be answered again
+	if _, err := rc.DeclineRequest(ctx, first.ID, bob); !errors.Is(err, chatroom.ErrNoSuchRequest) {
+		t.Fatalf("expected an answered request to be gone; got %v", err)
+	}
+}
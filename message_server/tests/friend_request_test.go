@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	friendrequest "wraith.me/message_server/pkg/schema/friend_request"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+)
+
+func TestFriendRequestDedup(t *testing.T) {
+	mongoInit()
+	frc := friendrequest.GetCollection()
+	ctx := context.Background()
+	if err := frc.SetupIndexes(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	//Only one pending request may exist between two users, whichever way it goes
+	a, b := util.MustNewUUID7(), util.MustNewUUID7()
+	if err := frc.Create(ctx, friendrequest.NewFriendRequest(a, b)); err != nil {
+		t.Fatal(err)
+	}
+	if err := frc.Create(ctx, friendrequest.NewFriendRequest(a, b)); err != friendrequest.ErrDuplicateRequest {
+		t.Fatalf("expected %v; got %v", friendrequest.ErrDuplicateRequest, err)
+	}
+	if err := frc.Create(ctx, friendrequest.NewFriendRequest(b, a)); err != friendrequest.ErrDuplicateRequest {
+		t.Fatalf("expected %v; got %v", friendrequest.ErrDuplicateRequest, err)
+	}
+
+	//Stale requests shouldn't block new ones once they're expired
+	c := util.MustNewUUID7()
+	stale := friendrequest.NewFriendRequest(a, c)
+	stale.Expires = time.Now().Add(-time.Minute)
+	if err := frc.Create(ctx, stale); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := frc.ExpireStale(ctx, a); err != nil || n != 1 {
+		t.Fatalf("expected 1 request to expire; got %d (%v)", n, err)
+	}
+	if err := frc.Create(ctx, friendrequest.NewFriendRequest(c, a)); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := frc.Get(ctx, stale.ID); got == nil || got.State != friendrequest.StateEXPIRED {
+		t.Fatalf("expected request %s to be expired; got %v", stale.ID, got)
+	}
+}
+
+func TestFriendRequestTransitionOnce(t *testing.T) {
+	mongoInit()
+	frc := friendrequest.GetCollection()
+	ctx := context.Background()
+
+	//Race an accept against a cancel; only one of them may win
+	frq := friendrequest.NewFriendRequest(util.MustNewUUID7(), util.MustNewUUID7())
+	if err := frc.Create(ctx, frq); err != nil {
+		t.Fatal(err)
+	}
+	var wins atomic.Int32
+	var wg sync.WaitGroup
+	for _, to := range []friendrequest.State{friendrequest.StateACCEPTED, friendrequest.StateCANCELLED} {
+		wg.Add(1)
+		go func(to friendrequest.State) {
+			defer wg.Done()
+			ok, err := frc.Transition(ctx, frq.ID, friendrequest.StatePENDING, to)
+			if err != nil {
+				t.Error(err)
+			}
+			if ok {
+				wins.Add(1)
+			}
+		}(to)
+	}
+	wg.Wait()
+	if wins.Load() != 1 {
+		t.Fatalf("expected exactly 1 transition to succeed; got %d", wins.Load())
+	}
+
+	//Expired requests can't be answered at all
+	expired := friendrequest.NewFriendRequest(util.MustNewUUID7(), util.MustNewUUID7())
+	expired.Expires = time.Now().Add(-time.Minute)
+	if err := frc.Create(ctx, expired); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := frc.Transition(ctx, expired.ID, friendrequest.StatePENDING, friendrequest.StateACCEPTED); ok || err != nil {
+		t.Fatalf("expired request was accepted (%v)", err)
+	}
+}
+
+func TestAddFriendship(t *testing.T) {
+	mongoInit()
+	uc := user.GetCollection()
+	ctx := context.Background()
+
+	//Create two users, one of whom already has a friend
+	suffix := util.MustNewUUID4().ShortString()[:8]
+	a := user.NewUserSimple("frqa_"+suffix, "frqa_"+suffix+"@example.com")
+	b := user.NewUserSimple("frqb_"+suffix, "frqb_"+suffix+"@example.com")
+	old := util.MustNewUUID7()
+	a.Friends[old] = true
+	if _, err := uc.InsertMany(ctx, []*user.User{a, b}); err != nil {
+		t.Fatal(err)
+	}
+	defer uc.RemoveAll(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: bson.A{a.ID, b.ID}}}}})
+
+	//Befriend them and check both sides
+	if err := uc.AddFriendship(ctx, a.ID, b.ID); err != nil {
+		t.Fatal(err)
+	}
+	var ga, gb user.User
+	if err := uc.FindID(ctx, a.ID).One(&ga); err != nil {
+		t.Fatal(err)
+	}
+	if err := uc.FindID(ctx, b.ID).One(&gb); err != nil {
+		t.Fatal(err)
+	}
+	if !ga.IsFriend(b.ID) || !gb.IsFriend(a.ID) {
+		t.Fatalf("friendship is one-sided; a->b: %v, b->a: %v", ga.IsFriend(b.ID), gb.IsFriend(a.ID))
+	}
+	if !ga.IsFriend(old) || ga.IsFriend(a.ID) || gb.IsFriend(b.ID) || len(gb.Friends) != 1 {
+		t.Fatalf("unexpected friends maps; a: %v, b: %v", ga.Friends, gb.Friends)
+	}
+
+	//Users can't befriend themselves or users that don't exist
+	if err := uc.AddFriendship(ctx, a.ID, a.ID); err == nil {
+		t.Fatal("user was befriended with themselves")
+	}
+	ghost := util.MustNewUUID7()
+	if err := uc.AddFriendship(ctx, a.ID, ghost); err == nil {
+		t.Fatal("user was befriended with a nonexistent user")
+	}
+	if err := uc.FindID(ctx, a.ID).One(&ga); err != nil || ga.IsFriend(ghost) {
+		t.Fatalf("failed friendship was written to one side (%v)", err)
+	}
+}
@@ -0,0 +1,158 @@
+package tests
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"aidanwoods.dev/go-paseto"
+	"wraith.me/message_server/pkg/config"
+	ccrypto "wraith.me/message_server/pkg/crypto"
+	"wraith.me/message_server/pkg/obj/challenge"
+	"wraith.me/message_server/pkg/obj/token"
+	"wraith.me/message_server/pkg/util"
+)
+
+func TestKeyringRotate(t *testing.T) {
+	_, sk, _ := ccrypto.NewKeypair(nil)
+	kr := ccrypto.NewKeyring(sk)
+	if kr.Active().ID() != ccrypto.LegacyKeyID || !kr.Active().Key.Equal(sk) {
+		t.Fatalf("expected the only key to be the legacy key; got %s", kr.Active().ID())
+	}
+
+	//Rotating adds a new active key and sets the old one to retire after the grace period
+	now := time.Now()
+	active, err := kr.Rotate(time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active.ID() != "2" || kr.Active().ID() != "2" || kr.Len() != 2 {
+		t.Fatalf("expected key 2 to be active; got %s of %d", kr.Active().ID(), kr.Len())
+	}
+	if _, err := kr.Get(ccrypto.LegacyKeyID, now.Add(time.Minute)); err != nil {
+		t.Fatalf("old key isn't usable during the grace period: %s", err)
+	}
+	if _, err := kr.Get(ccrypto.LegacyKeyID, now.Add(2*time.Hour)); !errors.Is(err, ccrypto.ErrKeyRetired) {
+		t.Fatalf("expected %v; got %v", ccrypto.ErrKeyRetired, err)
+	}
+	if _, err := kr.Get("9", now); !errors.Is(err, ccrypto.ErrKeyUnknown) {
+		t.Fatalf("expected %v; got %v", ccrypto.ErrKeyUnknown, err)
+	}
+
+	//Keys that were already retired are dropped on the next rotation
+	if _, err := kr.Rotate(time.Hour, now.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if ids := kr.Keys(); len(ids) != 2 || ids[0].ID() != "2" || ids[1].ID() != "3" {
+		t.Fatalf("expected keys 2 and 3; got %v", ids)
+	}
+
+	//The keyring survives a round trip through its text form
+	text, err := kr.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ccrypto.ParseKeyring(string(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, k := range parsed.Keys() {
+		orig := kr.Keys()[i]
+		if k.ID() != orig.ID() || !k.Key.Equal(orig.Key) || k.Retires.Unix() != orig.Retires.Unix() {
+			t.Fatalf("key %s changed after parsing", orig.ID())
+		}
+	}
+}
+
+func TestKeyringTokens(t *testing.T) {
+	_, sk, _ := ccrypto.NewKeypair(nil)
+	kr := ccrypto.NewKeyring(sk)
+	issuer := util.MustNewUUID7()
+	exp := time.Now().Add(time.Hour)
+
+	//Tokens from before the rotation are still accepted, and carry the ID of their key
+	tok := token.NewToken(util.MustNewUUID7(), issuer, token.TokenTypeACCESS, exp, nil, nil)
+	old := tok.Encrypt(kr.Active(), true)
+	ctok := challenge.NewEmailChallenge(issuer, util.MustNewUUID7(), challenge.CPurposeCONFIRM, exp, "jdoe@example.com")
+	oldc := ctok.Encrypt(kr.Active())
+	if _, err := kr.Rotate(time.Hour, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if footer := token.ParseFooter(old); footer.KeyID != ccrypto.LegacyKeyID || footer.Expiry == "" {
+		t.Fatalf("unexpected footer: %+v", footer)
+	}
+	if _, err := token.Decrypt(old, kr, issuer, token.TokenTypeACCESS); err != nil {
+		t.Fatalf("token from before the rotation was rejected: %s", err)
+	}
+	if _, err := challenge.Decrypt(oldc, kr, issuer, challenge.CPurposeCONFIRM); err != nil {
+		t.Fatalf("challenge from before the rotation was rejected: %s", err)
+	}
+	if _, err := token.Decrypt(tok.Encrypt(kr.Active(), false), kr, issuer, token.TokenTypeACCESS); err != nil {
+		t.Fatalf("token with the active key was rejected: %s", err)
+	}
+
+	//Tokens from before key IDs existed only have the expiry in the footer, and use the legacy key
+	legacy := paseto.NewToken()
+	legacy.SetIssuedAt(time.Now())
+	legacy.SetNotBefore(time.Now())
+	legacy.SetExpiration(exp)
+	legacy.SetJti(tok.ID.String())
+	legacy.SetString("tparent", tok.Parent.String())
+	legacy.SetIssuer(issuer.String())
+	legacy.SetSubject(tok.Subject.String())
+	legacy.SetString("ttype", token.TokenTypeACCESS.String())
+	legacy.SetString("tipaddr", "")
+	legacy.SetString("tuagent", "")
+	legacy.SetFooter([]byte(exp.UTC().Format(token.TimeFmt)))
+	lstr := legacy.V4Encrypt(util.Edsk2PasetoSK(sk), nil)
+	if _, err := token.Decrypt(lstr, kr, issuer, token.TokenTypeACCESS); err != nil {
+		t.Fatalf("token from before key IDs existed was rejected: %s", err)
+	}
+	if _, err := token.GetExprFromFooter(lstr); err != nil {
+		t.Fatalf("failed to get the expiry of a legacy token: %s", err)
+	}
+
+	//Once the old key is retired, its tokens are no longer accepted
+	if _, err := kr.Rotate(time.Hour, time.Now().Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := token.Decrypt(old, kr, issuer, token.TokenTypeACCESS); !errors.Is(err, ccrypto.ErrKeyUnknown) {
+		t.Fatalf("expected %v; got %v", ccrypto.ErrKeyUnknown, err)
+	}
+}
+
+func TestEnvRotateKeys(t *testing.T) {
+	//Env files from before keys were versioned only have the single key
+	_, sk, _ := ccrypto.NewKeypair(nil)
+	path := filepath.Join(t.TempDir(), "secrets.env")
+	id := util.MustNewUUID7()
+	legacy := "ID=" + id.String() + "\nSK=" + base64.RawStdEncoding.EncodeToString(sk[:]) + "\n"
+	if err := os.WriteFile(path, []byte(legacy), 0660); err != nil {
+		t.Fatal(err)
+	}
+	env, err := config.EnvInit(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.ID != id || env.Keys.Len() != 1 || !env.SK.Equal(sk) {
+		t.Fatalf("legacy env wasn't loaded as a single key keyring: %+v", env.Keys.Keys())
+	}
+
+	//Rotating keeps the old key around and makes the new one active
+	active, err := config.RotateEnvKeys(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env, err = config.EnvInit(path); err != nil {
+		t.Fatal(err)
+	}
+	if env.ID != id || env.Keys.Len() != 2 || !env.SK.Equal(active.Key) {
+		t.Fatalf("unexpected env after rotating: %+v", env.Keys.Keys())
+	}
+	if old, err := env.Keys.Get(ccrypto.LegacyKeyID, time.Now()); err != nil || !old.Key.Equal(sk) || old.Retires.IsZero() {
+		t.Fatalf("old key wasn't kept until it retires: %+v (%v)", old, err)
+	}
+}
@@ -37,7 +37,7 @@ func TestUser2BSON(t *testing.T) {
 		nil,
 		nil,
 	)
-	tstr := tok.Encrypt(issuerKey, true)
+	tstr := tok.Encrypt(ccrypto.NewKeyring(issuerKey).Active(), true)
 	usr.AddToken(tok.ID.String(), tstr, tok.Expiry)
 
 	//Marshal to BSON
@@ -75,7 +75,7 @@ func TestUser2JSON(t *testing.T) {
 		nil,
 		nil,
 	)
-	tstr := tok.Encrypt(issuerKey, true)
+	tstr := tok.Encrypt(ccrypto.NewKeyring(issuerKey).Active(), true)
 	usr.AddToken(tok.ID.String(), tstr, tok.Expiry)
 
 	//Marshal to JSON
@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"wraith.me/message_server/pkg/obj/notification"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+)
+
+func TestNotificationExpiry(t *testing.T) {
+	//Notifications should live for the default TTL, not expire on creation
+	notif := notification.OutgoingFRQNotif(*user.NewUserSimple("notif_test", "notif@example.com"), util.MustNewUUID7())
+	ttl := notif.Expires.Sub(notif.ID.Time())
+	if ttl != notification.DefaultNotifTTL.ToDur() {
+		t.Fatalf("expected the notification to live for %s; got %s", notification.DefaultNotifTTL, ttl)
+	}
+}
+
+func TestNotificationReadAndRemove(t *testing.T) {
+	mongoInit()
+	nc := notification.GetCollection()
+	ctx := context.Background()
+	if err := nc.SetupIndexes(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	//Store a few notifications for a user, and one for someone else
+	sender := *user.NewUserSimple("notif_sender", "notif_sender@example.com")
+	owner, other := util.MustNewUUID7(), util.MustNewUUID7()
+	notifs := make([]notification.Notification, 3)
+	for i := range notifs {
+		notifs[i] = notification.OutgoingFRQNotif(sender, owner)
+		if err := nc.Store(ctx, notifs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	foreign := notification.OutgoingFRQNotif(sender, other)
+	if err := nc.Store(ctx, foreign); err != nil {
+		t.Fatal(err)
+	}
+
+	//Storing a redelivered notification shouldn't fail or reset it
+	if n, err := nc.SetRead(ctx, owner, []util.UUID{notifs[0].ID}, true); err != nil || n != 1 {
+		t.Fatalf("expected 1 notification to be marked; got %d (%v)", n, err)
+	}
+	if err := nc.Store(ctx, notifs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := nc.CountUnread(ctx, owner); n != 2 {
+		t.Fatalf("expected 2 unread notifications; got %d", n)
+	}
+
+	//Users can't touch others' notifications
+	if n, _ := nc.SetRead(ctx, owner, []util.UUID{foreign.ID}, true); n != 0 {
+		t.Fatal("marked another user's notification as read")
+	}
+	if n, _ := nc.Remove(ctx, owner, []util.UUID{foreign.ID}); n != 0 {
+		t.Fatal("removed another user's notification")
+	}
+
+	//Mark everything read, then unread one
+	if n, _ := nc.SetRead(ctx, owner, nil, true); n != 3 {
+		t.Fatalf("expected 3 notifications to be marked; got %d", n)
+	}
+	nc.SetRead(ctx, owner, []util.UUID{notifs[2].ID}, false)
+	if n, _ := nc.CountUnread(ctx, owner); n != 1 {
+		t.Fatalf("expected 1 unread notification; got %d", n)
+	}
+
+	//Remove everything of the owner's; the other user's should be left alone
+	if n, _ := nc.Remove(ctx, owner, nil); n != 3 {
+		t.Fatalf("expected 3 notifications to be removed; got %d", n)
+	}
+	if n, _ := nc.CountUnread(ctx, other); n != 1 {
+		t.Fatalf("expected the other user to still have 1 unread notification; got %d", n)
+	}
+	nc.Remove(ctx, other, nil)
+}
+
+func TestNotificationTTLIndex(t *testing.T) {
+	mongoInit()
+	nc := notification.GetCollection()
+	ctx := context.Background()
+	if err := nc.SetupIndexes(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	//Mongo's TTL monitor runs once a minute, so just check the index is there
+	coll, err := nc.Collection.CloneCollection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cur, err := coll.Indexes().List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var specs []struct {
+		Key    map[string]int `bson:"key"`
+		Expire *int32         `bson:"expireAfterSeconds"`
+	}
+	if err := cur.All(ctx, &specs); err != nil {
+		t.Fatal(err)
+	}
+	for _, spec := range specs {
+		if _, ok := spec.Key["expires"]; ok && spec.Expire != nil && *spec.Expire == 0 {
+			return
+		}
+	}
+	t.Fatalf("no TTL index on `expires` was found; got %v", specs)
+}
@@ -0,0 +1,129 @@
+package tests
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	ccrypto "wraith.me/message_server/pkg/crypto"
+	"wraith.me/message_server/pkg/schema/prekey"
+	"wraith.me/message_server/pkg/util"
+)
+
+// Creates a prekey for a user, signed by the given identity key.
+func signedPrekey(t *testing.T, owner util.UUID, kind prekey.Kind, identity ccrypto.Privkey) prekey.Prekey {
+	key, _, err := ccrypto.NewKeypair(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prekey.NewPrekey(owner, kind, key, ccrypto.Sign(identity, key[:]))
+}
+
+func TestPrekeySignature(t *testing.T) {
+	//Create an identity and a prekey signed by it
+	ipk, isk, err := ccrypto.NewKeypair(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk := signedPrekey(t, util.MustNewUUID7(), prekey.KindSIGNED, isk)
+	if !pk.VerifyWith(ipk) {
+		t.Fatal("prekey did not verify with the identity key that signed it")
+	}
+
+	//Prekeys signed by someone else should be refused
+	opk, _, _ := ccrypto.NewKeypair(nil)
+	if pk.VerifyWith(opk) {
+		t.Fatal("prekey verified with an unrelated identity key")
+	}
+
+	//Tampered prekeys should be refused too
+	pk.Key[0] ^= 0xFF
+	if pk.VerifyWith(ipk) {
+		t.Fatal("tampered prekey verified")
+	}
+}
+
+func TestPrekeyOneTimeHandedOutOnce(t *testing.T) {
+	mongoInit()
+	pkc := prekey.GetCollection()
+	ctx := context.Background()
+
+	//Publish a batch of one-time prekeys for a user
+	const count = 20
+	_, isk, _ := ccrypto.NewKeypair(nil)
+	owner := util.MustNewUUID7()
+	keys := make([]prekey.Prekey, count)
+	for i := range keys {
+		keys[i] = signedPrekey(t, owner, prekey.KindONETIME, isk)
+	}
+	if _, err := pkc.InsertMany(ctx, keys); err != nil {
+		t.Fatal(err)
+	}
+
+	//Race more initiators than there are keys
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	seen := make(map[util.UUID]int)
+	for i := 0; i < count*2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pk, err := pkc.PopOneTime(ctx, owner)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if pk != nil {
+				mu.Lock()
+				seen[pk.ID]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	//Every key should have been handed out exactly once
+	if len(seen) != count {
+		t.Fatalf("expected %d distinct keys to be handed out; got %d", count, len(seen))
+	}
+	for id, n := range seen {
+		if n != 1 {
+			t.Fatalf("key %s was handed out %d times", id, n)
+		}
+	}
+	if left, _ := pkc.CountOneTime(ctx, owner); left != 0 {
+		t.Fatalf("expected no keys to be left; got %d", left)
+	}
+}
+
+func TestPrekeySignedRotation(t *testing.T) {
+	mongoInit()
+	pkc := prekey.GetCollection()
+	ctx := context.Background()
+
+	//Publish a signed prekey, then rotate it
+	_, isk, _ := ccrypto.NewKeypair(nil)
+	owner := util.MustNewUUID7()
+	first := signedPrekey(t, owner, prekey.KindSIGNED, isk)
+	second := signedPrekey(t, owner, prekey.KindSIGNED, isk)
+	if err := pkc.SetSigned(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := pkc.SetSigned(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+
+	//Only the newest key should be left
+	got, err := pkc.GetSigned(ctx, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.ID != second.ID || !got.Key.Equal(second.Key) {
+		t.Fatalf("expected the rotated key %s; got %v", second.ID, got)
+	}
+	if n, _ := pkc.Find(ctx, bson.D{{Key: "user_id", Value: owner}}).Count(); n != 1 {
+		t.Fatalf("expected the old signed prekey to be removed; %d remain", n)
+	}
+}
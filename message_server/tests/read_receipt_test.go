@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	memberstate "wraith.me/message_server/pkg/schema/member_state"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+)
+
+func TestSendsReadReceiptsTo(t *testing.T) {
+	reader := user.NewUserSimple("rrreader", "rrreader@example.com")
+	friend, stranger := util.MustNewUUID7(), util.MustNewUUID7()
+	reader.Friends[friend] = true
+
+	tests := []struct {
+		name     string
+		scope    user.ReadReceiptsScope
+		other    util.UUID
+		expected bool
+	}{
+		{"everyone to friend", user.ReadReceiptsScopeEVERYONE, friend, true},
+		{"everyone to stranger", user.ReadReceiptsScopeEVERYONE, stranger, true},
+		{"friends to friend", user.ReadReceiptsScopeFRIENDS, friend, true},
+		{"friends to stranger", user.ReadReceiptsScopeFRIENDS, stranger, false},
+		{"nobody to friend", user.ReadReceiptsScopeNOBODY, friend, false},
+		{"nobody to stranger", user.ReadReceiptsScopeNOBODY, stranger, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader.Options.ReadReceipts = tt.scope
+			if got := reader.SendsReadReceiptsTo(tt.other); got != tt.expected {
+				t.Fatalf("expected %v; got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestMemberStateAdvance(t *testing.T) {
+	mongoInit()
+	sc := memberstate.GetCollection()
+	ctx := context.Background()
+
+	room, member := util.MustNewUUID7(), util.MustNewUUID7()
+	defer sc.RemoveAll(ctx, bson.D{{Key: "room_id", Value: room}})
+	first, second, third := util.MustNewUUID7(), util.MustNewUUID7(), util.MustNewUUID7()
+
+	//The state is created on the first receipt, and reading implies delivery
+	if err := sc.Advance(ctx, room, member, second, memberstate.DeliveredCursor, memberstate.ReadCursor); err != nil {
+		t.Fatal(err)
+	}
+	state, err := sc.Get(ctx, room, member)
+	if err != nil || state == nil {
+		t.Fatalf("expected a state to be created (%v)", err)
+	}
+	if state.Delivered != second || state.Read != second {
+		t.Fatalf("expected both cursors at %s; got delivered %s, read %s", second, state.Delivered, state.Read)
+	}
+
+	//Receipts that arrive out of order don't move the cursors back
+	if err := sc.Advance(ctx, room, member, first, memberstate.DeliveredCursor, memberstate.ReadCursor); err != nil {
+		t.Fatal(err)
+	}
+	if state, err = sc.Get(ctx, room, member); err != nil {
+		t.Fatal(err)
+	}
+	if state.Delivered != second || state.Read != second {
+		t.Fatalf("cursors moved backwards; delivered %s, read %s", state.Delivered, state.Read)
+	}
+
+	//Delivery receipts only move the delivery cursor
+	if err := sc.Advance(ctx, room, member, third, memberstate.DeliveredCursor); err != nil {
+		t.Fatal(err)
+	}
+	if state, err = sc.Get(ctx, room, member); err != nil {
+		t.Fatal(err)
+	}
+	if state.Delivered != third || state.Read != second {
+		t.Fatalf("expected delivered %s and read %s; got %s and %s", third, second, state.Delivered, state.Read)
+	}
+}
@@ -0,0 +1,210 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+	ccrypto "wraith.me/message_server/pkg/crypto"
+	"wraith.me/message_server/pkg/obj/session"
+	"wraith.me/message_server/pkg/obj/token"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+	"wraith.me/message_server/pkg/ws/wsnotif"
+)
+
+func TestSessionRevoke(t *testing.T) {
+	rcl := redisInit()
+	ctx := context.Background()
+
+	//Only the revoked sessions are marked, and the marks lapse
+	revoked, kept := util.MustNewUUID7(), util.MustNewUUID7()
+	if err := session.Revoke(ctx, rcl, time.Second, revoked); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := session.IsRevoked(ctx, rcl, revoked); err != nil || !ok {
+		t.Fatalf("expected session %s to be revoked (%v)", revoked, err)
+	}
+	if ok, err := session.IsRevoked(ctx, rcl, kept); err != nil || ok {
+		t.Fatalf("expected session %s not to be revoked (%v)", kept, err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if ok, err := session.IsRevoked(ctx, rcl, revoked); err != nil || ok {
+		t.Fatalf("expected the mark on session %s to lapse (%v)", revoked, err)
+	}
+}
+
+func TestSessionRevokeFamilies(t *testing.T) {
+	mongoInit()
+	uc := user.GetCollection()
+	ctx := context.Background()
+
+	//Create a user logged in on three devices, one of which has refreshed its token
+	suffix := util.MustNewUUID4().ShortString()[:8]
+	u := user.NewUserSimple("sesh_"+suffix, "sesh_"+suffix+"@example.com")
+	exp := time.Now().Add(time.Hour)
+	a, b, c := util.MustNewUUID7().String(), util.MustNewUUID7().String(), util.MustNewUUID7().String()
+	u.AddToken(a, "a", exp)
+	u.AddToken(b, "b", exp)
+	u.AddToken(c, "c", exp)
+	if _, err := uc.InsertOne(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	defer uc.RemoveId(ctx, u.ID)
+	next := util.MustNewUUID7().String()
+	if err := uc.RotateToken(ctx, u, a, next, "next", exp); err != nil {
+		t.Fatal(err)
+	}
+
+	//Revoking two of the sessions leaves the third alone
+	families := []util.UUID{u.Tokens[next].FamilyOf(next), u.Tokens[b].FamilyOf(b)}
+	live, err := uc.RevokeFamilies(ctx, u.ID, families)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(live) != 2 {
+		t.Fatalf("expected the 2 live tokens of the families to be returned; got %v", live)
+	}
+	var got user.User
+	if err := uc.FindID(ctx, u.ID).One(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Tokens) != 1 || !got.HasTokenById(c) || len(got.Rotated) != 0 {
+		t.Fatalf("unexpected tokens after revocation; live: %v, rotated: %v", got.Tokens, got.Rotated)
+	}
+}
+
+func TestSessionOfToken(t *testing.T) {
+	_, sk, _ := ccrypto.NewKeypair(nil)
+	kr := ccrypto.NewKeyring(sk)
+	issuer, parent, family := util.MustNewUUID7(), util.MustNewUUID7(), util.MustNewUUID7()
+
+	//Access tokens carry the family of their session through encryption
+	tok := token.NewToken(util.MustNewUUID7(), issuer, token.TokenTypeACCESS, time.Now().Add(time.Hour), &parent, nil)
+	tok.Family = family
+	dec, err := token.Decrypt(tok.Encrypt(kr.Active(), false), kr, issuer, token.TokenTypeACCESS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec.Family != family || session.OfToken(*dec) != family {
+		t.Fatalf("expected the session to be family %s; got %s", family, session.OfToken(*dec))
+	}
+
+	//Tokens without a family fall back to their parent
+	tok.Family = util.NilUUID()
+	if session.OfToken(*tok) != parent {
+		t.Fatalf("expected the session to be parent %s; got %s", parent, session.OfToken(*tok))
+	}
+}
+
+// Starts a notification server whose connections are authenticated by the parent and family in the query string.
+func wsnotifSessionNode(t *testing.T, uid util.UUID) (*redis.Client, func(parent, family util.UUID) *websocket.Conn) {
+	rcl := redisInit()
+	srv, err := wsnotif.NewServer(rcl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//The access token is passed via the query string in lieu of the auth middleware
+	hts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parent, perr := util.ParseUUIDv7(r.URL.Query().Get("parent"))
+		family, ferr := util.ParseUUIDv7(r.URL.Query().Get("family"))
+		if perr != nil || ferr != nil {
+			http.Error(w, "bad session", http.StatusBadRequest)
+			return
+		}
+		ctx := context.WithValue(r.Context(), wsnotif.WSNotifCtxObjKey, uid)
+		ctx = context.WithValue(ctx, token.CtxKey, token.Token{Parent: parent, Family: family, Subject: uid})
+		srv.GetMelody().HandleRequest(w, r.WithContext(ctx))
+	}))
+	t.Cleanup(func() {
+		srv.Close()
+		hts.Close()
+	})
+	dial := func(parent, family util.UUID) *websocket.Conn {
+		url := "ws" + strings.TrimPrefix(hts.URL, "http") + "/?parent=" + parent.String() + "&family=" + family.String()
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	return rcl, dial
+}
+
+// Checks that one connection was closed because its session was revoked, and that another is still open.
+func wsnotifAwaitRevoked(t *testing.T, closed *websocket.Conn, open *websocket.Conn) {
+	closed.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := closed.ReadMessage()
+	var cerr *websocket.CloseError
+	if !errors.As(err, &cerr) || cerr.Code != 4002 {
+		t.Fatalf("expected the revoked session's connection to be closed with code 4002; got %v", err)
+	}
+	open.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	if _, _, err := open.ReadMessage(); errors.As(err, &cerr) {
+		t.Fatalf("connection of the other session was closed: %v", err)
+	}
+}
+
+func TestWSNotifSessionRevoke(t *testing.T) {
+	rcl, dial := wsnotifSessionNode(t, util.MustNewUUID7())
+
+	//The same user is connected from two sessions; only the revoked one is closed
+	revoked, kept := util.MustNewUUID7(), util.MustNewUUID7()
+	stolen, own := dial(revoked, revoked), dial(kept, kept)
+	time.Sleep(100 * time.Millisecond)
+	if err := session.Revoke(context.Background(), rcl, time.Minute, revoked); err != nil {
+		t.Fatal(err)
+	}
+	wsnotifAwaitRevoked(t, stolen, own)
+}
+
+func TestWSNotifSessionRevokeRotated(t *testing.T) {
+	mongoInit()
+	uc := user.GetCollection()
+	ctx := context.Background()
+
+	//Create a user who's logged in on two devices
+	suffix := util.MustNewUUID4().ShortString()[:8]
+	u := user.NewUserSimple("seshr_"+suffix, "seshr_"+suffix+"@example.com")
+	exp := time.Now().Add(time.Hour)
+	a, b := util.MustNewUUID7().String(), util.MustNewUUID7().String()
+	u.AddToken(a, "a", exp)
+	u.AddToken(b, "b", exp)
+	if _, err := uc.InsertOne(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	defer uc.RemoveId(ctx, u.ID)
+
+	//Both connect, then the first refreshes its token, which leaves its connection with the old one
+	rcl, dial := wsnotifSessionNode(t, u.ID)
+	family := u.Tokens[a].FamilyOf(a)
+	stolen := dial(util.UUIDFromString(a), family)
+	own := dial(util.UUIDFromString(b), u.Tokens[b].FamilyOf(b))
+	next := util.MustNewUUID7().String()
+	if err := uc.RotateToken(ctx, u, a, next, "next", exp); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	//Revoking the session by its current token still closes the connection opened before the refresh
+	families := []util.UUID{u.Tokens[next].FamilyOf(next)}
+	live, err := uc.RevokeFamilies(ctx, u.ID, families)
+	if err != nil || len(live) != 1 || live[0].String() != next {
+		t.Fatalf("expected only token %s to be revoked; got %v (%v)", next, live, err)
+	}
+	if err := session.Revoke(ctx, rcl, time.Minute, families...); err != nil {
+		t.Fatal(err)
+	}
+	wsnotifAwaitRevoked(t, stolen, own)
+	if ok, err := session.IsRevoked(ctx, rcl, family); err != nil || !ok {
+		t.Fatalf("expected access tokens of family %s to be denied (%v)", family, err)
+	}
+}
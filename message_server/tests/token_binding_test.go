@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"wraith.me/message_server/pkg/config"
+	ccrypto "wraith.me/message_server/pkg/crypto"
+	"wraith.me/message_server/pkg/mw"
+	"wraith.me/message_server/pkg/obj/token"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+)
+
+func TestTokenBinding(t *testing.T) {
+	tok := token.Token{IPAddr: net.ParseIP("10.0.0.1"), UserAgent: "client/1.0"}
+
+	//Requests from where the token was issued to always pass
+	same := httptest.NewRequest("GET", "/", nil)
+	same.RemoteAddr = "10.0.0.1:4000"
+	same.Header.Set("User-Agent", "client/1.0")
+	if warnings, err := tok.CheckBinding(same, token.BindingModeENFORCE, token.BindingModeENFORCE); err != nil || len(warnings) != 0 {
+		t.Fatalf("matching request was flagged; warnings: %v, err: %v", warnings, err)
+	}
+
+	//Requests from elsewhere pass, get flagged or get rejected depending on the mode
+	moved := httptest.NewRequest("GET", "/", nil)
+	moved.RemoteAddr = "10.0.0.2:4000"
+	moved.Header.Set("User-Agent", "client/2.0")
+	if warnings, err := tok.CheckBinding(moved, token.BindingModeOFF, token.BindingModeOFF); err != nil || len(warnings) != 0 {
+		t.Fatalf("binding isn't off; warnings: %v, err: %v", warnings, err)
+	}
+	if warnings, err := tok.CheckBinding(moved, token.BindingModeWARN, token.BindingModeWARN); err != nil || len(warnings) != 2 {
+		t.Fatalf("expected 2 warnings; got %v (%v)", warnings, err)
+	}
+	if warnings, err := tok.CheckBinding(moved, token.BindingModeWARN, token.BindingModeENFORCE); err == nil || len(warnings) != 1 {
+		t.Fatalf("expected 1 warning and an error; got %v (%v)", warnings, err)
+	}
+}
+
+func TestTokenBindingModes(t *testing.T) {
+	cfg := token.DefaultTConfig()
+	if ip, ua, err := cfg.BindingModes(); err != nil || ip != token.BindingModeOFF || ua != token.BindingModeOFF {
+		t.Fatalf("expected binding to be off by default; got %s, %s (%v)", ip, ua, err)
+	}
+	cfg.IPBinding, cfg.UABinding = "enforce", "Warn"
+	if ip, ua, err := cfg.BindingModes(); err != nil || ip != token.BindingModeENFORCE || ua != token.BindingModeWARN {
+		t.Fatalf("expected enforce, warn; got %s, %s (%v)", ip, ua, err)
+	}
+	cfg.UABinding = "sometimes"
+	if _, _, err := cfg.BindingModes(); err == nil {
+		t.Fatal("bad binding mode was accepted")
+	}
+}
+
+func TestAuthMiddlewareOwnership(t *testing.T) {
+	mongoInit()
+	redisInit()
+	uc := user.GetCollection()
+	ctx := context.Background()
+	_, sk, _ := ccrypto.NewKeypair(nil)
+	env := &config.Env{ID: util.MustNewUUID7(), Keys: ccrypto.NewKeyring(sk)}
+
+	//Create a user with one session
+	suffix := util.MustNewUUID4().ShortString()[:8]
+	u := user.NewUserSimple("amw_"+suffix, "amw_"+suffix+"@example.com")
+	exp := time.Now().Add(time.Hour)
+	rtid := util.MustNewUUID7()
+	u.AddToken(rtid.String(), "r", exp)
+	if _, err := uc.InsertOne(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	defer uc.RemoveId(ctx, u.ID)
+
+	//The handler reports the session it was given
+	handler := mw.NewAuthMiddleware(env)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get(mw.AuthAccessParentTokID)))
+	}))
+	access := token.NewToken(u.ID, env.ID, token.TokenTypeACCESS, exp, &rtid, nil)
+	access.Family = rtid
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+access.Encrypt(env.Keys.Active(), false))
+		req.Header.Set(mw.AuthAccessParentTokID, util.MustNewUUID7().String())
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	//The session headers can't be spoofed by the client
+	if rec := send(); rec.Code != http.StatusOK || rec.Body.String() != rtid.String() {
+		t.Fatalf("expected the request to pass with session %s; got %d: %s", rtid, rec.Code, rec.Body.String())
+	}
+
+	//Once the refresh token is gone, its access tokens are refused
+	if err := uc.UpdateId(ctx, u.ID, bson.D{{Key: "$unset", Value: bson.D{{Key: "tokens." + rtid.String(), Value: ""}}}}); err != nil {
+		t.Fatal(err)
+	}
+	if rec := send(); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a token with a removed parent to be refused; got %d", rec.Code)
+	}
+}
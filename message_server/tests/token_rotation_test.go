@@ -0,0 +1,190 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+)
+
+func TestTokenFamily(t *testing.T) {
+	//Tokens issued at login start their own family
+	u := user.NewUserSimple("tokfam", "tokfam@example.com")
+	tid := util.MustNewUUID7().String()
+	u.AddToken(tid, "tok", time.Now().Add(time.Hour))
+	if fam := u.Tokens[tid].FamilyOf(tid); fam.String() != tid {
+		t.Fatalf("expected family %s; got %s", tid, fam)
+	}
+
+	//Tokens issued before families were tracked are their own family too
+	legacy := util.MustNewUUID7().String()
+	if fam := (user.UserToken{}).FamilyOf(legacy); fam.String() != legacy {
+		t.Fatalf("expected family %s; got %s", legacy, fam)
+	}
+	if _, ok := u.RotatedFamily(tid); ok {
+		t.Fatal("token that was never rotated out was reported as rotated")
+	}
+}
+
+func TestTokenReuse(t *testing.T) {
+	mongoInit()
+	uc := user.GetCollection()
+	ctx := context.Background()
+
+	//Create a user with two sessions, one of which is shared by the victim and the attacker
+	suffix := util.MustNewUUID4().ShortString()[:8]
+	u := user.NewUserSimple("tokr_"+suffix, "tokr_"+suffix+"@example.com")
+	exp := time.Now().Add(time.Hour)
+	stolen, other := util.MustNewUUID7().String(), util.MustNewUUID7().String()
+	u.AddToken(stolen, "stolen", exp)
+	u.AddToken(other, "other", exp)
+	if _, err := uc.InsertOne(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	defer uc.RemoveId(ctx, u.ID)
+
+	//The victim refreshes first
+	var victim, attacker user.User
+	if err := uc.FindID(ctx, u.ID).One(&victim); err != nil {
+		t.Fatal(err)
+	}
+	attacker = victim
+	attacker.Tokens = map[string]user.UserToken{stolen: victim.Tokens[stolen]}
+	next := util.MustNewUUID7().String()
+	if err := uc.RotateToken(ctx, &victim, stolen, next, "next", exp); err != nil {
+		t.Fatal(err)
+	}
+	if tok := victim.Tokens[next]; tok.Parent.String() != stolen || tok.Family.String() != stolen {
+		t.Fatalf("bad successor token: %+v", tok)
+	}
+
+	//The attacker replays the stolen token, which was already rotated out
+	if err := uc.RotateToken(ctx, &attacker, stolen, util.MustNewUUID7().String(), "evil", exp); !errors.Is(err, user.ErrTokenReused) {
+		t.Fatalf("expected %v; got %v", user.ErrTokenReused, err)
+	}
+	var got user.User
+	if err := uc.FindID(ctx, u.ID).One(&got); err != nil {
+		t.Fatal(err)
+	}
+	family, ok := got.RotatedFamily(stolen)
+	if !ok || family.String() != stolen {
+		t.Fatalf("expected the stolen token to be tracked under family %s; got %s (%v)", stolen, family, ok)
+	}
+
+	//The whole family goes, but the other session survives
+	live, err := uc.RevokeFamily(ctx, u.ID, family)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(live) != 1 || live[0].String() != next {
+		t.Fatalf("expected [%s] to be revoked; got %v", next, live)
+	}
+	if err := uc.FindID(ctx, u.ID).One(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Tokens) != 1 || !got.HasTokenById(other) || len(got.Rotated) != 0 {
+		t.Fatalf("unexpected tokens after revocation; live: %v, rotated: %v", got.Tokens, got.Rotated)
+	}
+}
+
+func TestTokenReuseRace(t *testing.T) {
+	mongoInit()
+	uc := user.GetCollection()
+	ctx := context.Background()
+
+	suffix := util.MustNewUUID4().ShortString()[:8]
+	u := user.NewUserSimple("tokrr_"+suffix, "tokrr_"+suffix+"@example.com")
+	exp := time.Now().Add(time.Hour)
+	stolen := util.MustNewUUID7().String()
+	u.AddToken(stolen, "stolen", exp)
+	if _, err := uc.InsertOne(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	defer uc.RemoveId(ctx, u.ID)
+
+	//The victim and the attacker present the same token at the same time, each having loaded the user beforehand
+	const holders = 8
+	holds := make([]user.User, holders)
+	for i := range holds {
+		if err := uc.FindID(ctx, u.ID).One(&holds[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, holders)
+	for i := range holds {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = uc.RotateToken(ctx, &holds[i], stolen, util.MustNewUUID7().String(), "next", exp)
+		}(i)
+	}
+	wg.Wait()
+
+	//Only one of them may win; everyone else is caught reusing the token
+	winners := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			winners++
+		case !errors.Is(err, user.ErrTokenReused):
+			t.Fatal(err)
+		}
+	}
+	if winners != 1 {
+		t.Fatalf("expected exactly one rotation to win; got %d", winners)
+	}
+
+	//Revoking the family logs out the winner too, whoever they were
+	live, err := uc.RevokeFamily(ctx, u.ID, util.UUIDFromString(stolen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(live) != 1 {
+		t.Fatalf("expected the winner's token to be revoked; got %v", live)
+	}
+	var got user.User
+	if err := uc.FindID(ctx, u.ID).One(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Tokens) != 0 || len(got.Rotated) != 0 {
+		t.Fatalf("family survived revocation; live: %v, rotated: %v", got.Tokens, got.Rotated)
+	}
+}
+
+func TestPruneRotated(t *testing.T) {
+	mongoInit()
+	uc := user.GetCollection()
+	ctx := context.Background()
+
+	//Rotated tokens are only tracked until they expire
+	suffix := util.MustNewUUID4().ShortString()[:8]
+	u := user.NewUserSimple("tokp_"+suffix, "tokp_"+suffix+"@example.com")
+	stale, fresh := util.MustNewUUID7().String(), util.MustNewUUID7().String()
+	u.Rotated = map[string]user.RotatedToken{
+		stale: {Expiry: time.Now().Add(-time.Minute)},
+		fresh: {Expiry: time.Now().Add(time.Hour)},
+	}
+	if _, err := uc.InsertOne(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	defer uc.RemoveId(ctx, u.ID)
+
+	if err := uc.PruneRotated(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	var got user.User
+	if err := uc.FindID(ctx, u.ID).One(&got); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got.RotatedFamily(stale); ok {
+		t.Fatal("expired token is still tracked")
+	}
+	if _, ok := got.RotatedFamily(fresh); !ok {
+		t.Fatal("unexpired token was pruned")
+	}
+}
@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"wraith.me/message_server/pkg/http_types/ws/chat"
+	chatroom "wraith.me/message_server/pkg/schema/chat_room"
+	"wraith.me/message_server/pkg/util"
+)
+
+func TestWSChatBacklog(t *testing.T) {
+	mongoInit()
+
+	//Create a room with two members, each on their own node
+	alice, bob := util.MustNewUUID7(), util.MustNewUUID7()
+	room := chatroom.NewRoom(alice, bob)
+	_, node1 := wschatNode(t, room.ID, room.Participants)
+	_, node2 := wschatNode(t, room.ID, room.Participants)
+
+	//Alice sends a few messages while Bob is away
+	aconn := wschatDial(t, node1, alice)
+	wschatAwait(t, aconn, chat.TypeJOINEVENT)
+	contents := []string{"one", "two", "three"}
+	for _, content := range contents {
+		out := chat.NewMessageTyp(content, alice, room.ID, chat.TypeUMSG)
+		if err := aconn.WriteMessage(websocket.TextMessage, out.JSON()); err != nil {
+			t.Fatal(err)
+		}
+		wschatAwait(t, aconn, chat.TypeUMSG)
+	}
+
+	//Bob connects and should get the backlog in order
+	bconn := wschatDial(t, node2, bob)
+	var last chat.Message
+	for _, content := range contents {
+		last = wschatAwait(t, bconn, chat.TypeUMSG)
+		if last.Content != content {
+			t.Fatalf("out of order backlog; expected '%s', got '%s'", content, last.Content)
+		}
+	}
+
+	//Bob acknowledges everything and reconnects
+	ack := chat.NewMessageTyp(last.ID.String(), bob, room.ID, chat.TypeACK)
+	if err := bconn.WriteMessage(websocket.TextMessage, ack.JSON()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	bconn.Close()
+	wschatAwait(t, aconn, chat.TypeQUITEVENT)
+	bconn = wschatDial(t, node2, bob)
+
+	//Nothing should be left in the backlog, so the next message Bob sees is a live one
+	wschatAwait(t, aconn, chat.TypeJOINEVENT)
+	out := chat.NewMessageTyp("four", alice, room.ID, chat.TypeUMSG)
+	if err := aconn.WriteMessage(websocket.TextMessage, out.JSON()); err != nil {
+		t.Fatal(err)
+	}
+	if in := wschatAwait(t, bconn, chat.TypeUMSG); in.Content != out.Content {
+		t.Fatalf("acknowledged messages were redelivered; got '%s'", in.Content)
+	}
+}
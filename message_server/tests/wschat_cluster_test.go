@@ -0,0 +1,313 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"wraith.me/message_server/pkg/http_types/ws/chat"
+	chatroom "wraith.me/message_server/pkg/schema/chat_room"
+	"wraith.me/message_server/pkg/util"
+	"wraith.me/message_server/pkg/ws/wschat"
+)
+
+// Spins up a chat server node that lets any user into the given room.
+func wschatNode(t *testing.T, roomID util.UUID, members chatroom.MembershipList) (*wschat.Server, *httptest.Server) {
+	srv, err := wschat.NewServer(redisInit())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//The user ID is passed via the query string in lieu of the auth middleware
+	hts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uid, err := util.ParseUUIDv7(r.URL.Query().Get("uid"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx := wschat.Context{
+			RoomID:       roomID,
+			MemberID:     uid,
+			Participants: members,
+		}
+		r = r.WithContext(context.WithValue(r.Context(), wschat.WSChatCtxObjKey, ctx))
+		srv.GetMelody().HandleRequest(w, r)
+	}))
+
+	t.Cleanup(func() {
+		srv.Close()
+		hts.Close()
+	})
+	return srv, hts
+}
+
+// Connects a user to a chat server node.
+func wschatDial(t *testing.T, hts *httptest.Server, uid util.UUID) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(hts.URL, "http") + "/?uid=" + uid.String()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// Reads chat messages off a connection until one of the given type arrives.
+func wschatAwait(t *testing.T, conn *websocket.Conn, typ chat.Type) chat.Message {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("did not receive a %s message: %s", typ, err)
+		}
+		var msg chat.Message
+		if err := json.Unmarshal(raw, &msg); err == nil && msg.Type == typ {
+			return msg
+		}
+	}
+}
+
+func TestWSChatClusterBroadcast(t *testing.T) {
+	//Messages get persisted, so MongoDB is needed too
+	mongoInit()
+
+	//Create a room with two members and a node for each of them
+	alice, bob := util.MustNewUUID7(), util.MustNewUUID7()
+	room := chatroom.NewRoom(alice, bob)
+	_, node1 := wschatNode(t, room.ID, room.Participants)
+	_, node2 := wschatNode(t, room.ID, room.Participants)
+
+	//Alice joins on node 1
+	aconn := wschatDial(t, node1, alice)
+	wschatAwait(t, aconn, chat.TypeJOINEVENT)
+
+	//Bob joins on node 2; Alice should see the cluster-wide count
+	bconn := wschatDial(t, node2, bob)
+	wschatAwait(t, bconn, chat.TypeJOINEVENT)
+	join := wschatAwait(t, aconn, chat.TypeJOINEVENT)
+	var change chat.MembershipChange
+	if err := json.Unmarshal([]byte(join.Content), &change); err != nil {
+		t.Fatal(err)
+	}
+	if change.Old != 1 || change.New != 2 {
+		t.Fatalf("expected membership to go from 1 to 2; got %d to %d", change.Old, change.New)
+	}
+
+	//Alice sends a message, spoofing Bob as the sender
+	out := chat.NewMessageTyp("hello from node 1", bob, room.ID, chat.TypeUMSG)
+	if err := aconn.WriteMessage(websocket.TextMessage, out.JSON()); err != nil {
+		t.Fatal(err)
+	}
+
+	//Bob should get the message on the other node, attributed to Alice
+	in := wschatAwait(t, bconn, chat.TypeUMSG)
+	if in.Content != out.Content {
+		t.Fatalf("mismatched content; expected '%s', got '%s'", out.Content, in.Content)
+	}
+	if in.Sender != alice {
+		t.Fatalf("sender was not overwritten; expected %s, got %s", alice, in.Sender)
+	}
+
+	//Bob leaves; Alice should see the count drop
+	bconn.Close()
+	quit := wschatAwait(t, aconn, chat.TypeQUITEVENT)
+	if err := json.Unmarshal([]byte(quit.Content), &change); err != nil {
+		t.Fatal(err)
+	}
+	if change.Old != 2 || change.New != 1 {
+		t.Fatalf("expected membership to go from 2 to 1; got %d to %d", change.Old, change.New)
+	}
+}
+
+func TestWSChatClusterDuplicateJoin(t *testing.T) {
+	//Backlogs are loaded from MongoDB on join
+	mongoInit()
+
+	//Create a room with a single member and two nodes
+	alice := util.MustNewUUID7()
+	room := chatroom.NewRoom(alice)
+	srv1, node1 := wschatNode(t, room.ID, room.Participants)
+	_, node2 := wschatNode(t, room.ID, room.Participants)
+
+	//Alice joins on node 1
+	aconn := wschatDial(t, node1, alice)
+	wschatAwait(t, aconn, chat.TypeJOINEVENT)
+
+	//Alice tries joining again on node 2, which should be refused
+	dconn := wschatDial(t, node2, alice)
+	dconn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, raw, err := dconn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != "You are already in the room" {
+		t.Fatalf("duplicate session was not rejected; got '%s'", raw)
+	}
+
+	//Alice should still be online as far as the cluster is concerned
+	online, err := srv1.OnlineMembers(context.Background(), room.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(online) != 1 || online[0] != alice {
+		t.Fatalf("expected only %s to be online; got %v", alice, online)
+	}
+}
+
+func TestWSChatClusterKeyExchangeRouting(t *testing.T) {
+	//Messages get persisted, so MongoDB is needed too
+	mongoInit()
+
+	//Create a room with three members spread across two nodes
+	alice, bob, carol := util.MustNewUUID7(), util.MustNewUUID7(), util.MustNewUUID7()
+	room := chatroom.NewRoom(alice, bob, carol)
+	_, node1 := wschatNode(t, room.ID, room.Participants)
+	_, node2 := wschatNode(t, room.ID, room.Participants)
+	aconn := wschatDial(t, node1, alice)
+	wschatAwait(t, aconn, chat.TypeJOINEVENT)
+	bconn := wschatDial(t, node2, bob)
+	wschatAwait(t, bconn, chat.TypeJOINEVENT)
+	cconn := wschatDial(t, node2, carol)
+	wschatAwait(t, cconn, chat.TypeJOINEVENT)
+
+	//Alice starts a key exchange with Bob, then says hello to the room
+	kex := chat.NewMessageTyp("kex1 payload", alice, bob, chat.TypeKEX1)
+	if err := aconn.WriteMessage(websocket.TextMessage, kex.JSON()); err != nil {
+		t.Fatal(err)
+	}
+	hello := chat.NewMessageTyp("hello", alice, room.ID, chat.TypeUMSG)
+	if err := aconn.WriteMessage(websocket.TextMessage, hello.JSON()); err != nil {
+		t.Fatal(err)
+	}
+
+	//Bob should get the key exchange
+	in := wschatAwait(t, bconn, chat.TypeKEX1)
+	if in.Content != kex.Content || in.Sender != alice {
+		t.Fatalf("bad key exchange message: %+v", in)
+	}
+
+	//Carol should only get the hello
+	cconn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, raw, err := cconn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg chat.Message
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			continue
+		}
+		if msg.Type == chat.TypeKEX1 {
+			t.Fatal("key exchange was leaked to a bystander")
+		}
+		if msg.Type == chat.TypeUMSG {
+			break
+		}
+	}
+
+	//Key exchanges addressed to the room itself should be refused
+	bad := chat.NewMessageTyp("kex1 payload", alice, room.ID, chat.TypeKEX1)
+	if err := aconn.WriteMessage(websocket.TextMessage, bad.JSON()); err != nil {
+		t.Fatal(err)
+	}
+	wschatAwait(t, aconn, chat.TypeSERR)
+}
+
+func TestWSChatClusterRosterChange(t *testing.T) {
+	//Create a room with three members; Carol never connects
+	alice, bob, carol := util.MustNewUUID7(), util.MustNewUUID7(), util.MustNewUUID7()
+	room := chatroom.NewRoom(alice, bob, carol)
+	srv1, node1 := wschatNode(t, room.ID, maps.Clone(room.Participants))
+	_, node2 := wschatNode(t, room.ID, maps.Clone(room.Participants))
+	aconn := wschatDial(t, node1, alice)
+	wschatAwait(t, aconn, chat.TypeJOINEVENT)
+	bconn := wschatDial(t, node2, bob)
+	wschatAwait(t, bconn, chat.TypeJOINEVENT)
+
+	//Sender keys are passed along point-to-point
+	ek := chat.NewMessageTyp("sender key", alice, bob, chat.TypeEK)
+	if err := aconn.WriteMessage(websocket.TextMessage, ek.JSON()); err != nil {
+		t.Fatal(err)
+	}
+	if in := wschatAwait(t, bconn, chat.TypeEK); in.Content != ek.Content {
+		t.Fatalf("mismatched content; expected '%s', got '%s'", ek.Content, in.Content)
+	}
+
+	//Carol is removed; everyone should be told to rekey
+	room.RemoveMember(carol)
+	srv1.AnnounceRoster(room, nil, []util.UUID{carol})
+	for _, conn := range []*websocket.Conn{aconn, bconn} {
+		msg := wschatAwait(t, conn, chat.TypeMEMBERSHIP)
+		var change chat.RosterChange
+		if err := json.Unmarshal([]byte(msg.Content), &change); err != nil {
+			t.Fatal(err)
+		}
+		if !change.Rekey || len(change.Removed) != 1 || change.Removed[0] != carol || len(change.Members) != 2 {
+			t.Fatalf("bad roster change: %+v", change)
+		}
+	}
+
+	//Sender keys can no longer be addressed to Carol
+	bad := chat.NewMessageTyp("sender key", alice, carol, chat.TypeEK)
+	if err := aconn.WriteMessage(websocket.TextMessage, bad.JSON()); err != nil {
+		t.Fatal(err)
+	}
+	wschatAwait(t, aconn, chat.TypeSERR)
+}
+
+func TestWSChatClusterKickAndDelete(t *testing.T) {
+	//Create a room with three members spread across two nodes
+	alice, bob, carol := util.MustNewUUID7(), util.MustNewUUID7(), util.MustNewUUID7()
+	room := chatroom.NewRoom(alice, bob, carol)
+	srv1, node1 := wschatNode(t, room.ID, maps.Clone(room.Participants))
+	_, node2 := wschatNode(t, room.ID, maps.Clone(room.Participants))
+	aconn := wschatDial(t, node1, alice)
+	wschatAwait(t, aconn, chat.TypeJOINEVENT)
+	bconn := wschatDial(t, node2, bob)
+	wschatAwait(t, bconn, chat.TypeJOINEVENT)
+	cconn := wschatDial(t, node2, carol)
+	wschatAwait(t, cconn, chat.TypeJOINEVENT)
+
+	//Bob is kicked; he should be told, then disconnected
+	room.RemoveMember(bob)
+	srv1.AnnounceRoster(room, nil, []util.UUID{bob})
+	for _, conn := range []*websocket.Conn{aconn, bconn, cconn} {
+		wschatAwait(t, conn, chat.TypeMEMBERSHIP)
+	}
+	wschatAwaitClose(t, bconn)
+
+	//The room is deleted; everyone left should be disconnected
+	srv1.AnnounceDeletion(room)
+	for _, conn := range []*websocket.Conn{aconn, cconn} {
+		msg := wschatAwait(t, conn, chat.TypeMEMBERSHIP)
+		var change chat.RosterChange
+		if err := json.Unmarshal([]byte(msg.Content), &change); err != nil {
+			t.Fatal(err)
+		}
+		if !change.Deleted {
+			t.Fatalf("expected the room to be deleted: %+v", change)
+		}
+		wschatAwaitClose(t, conn)
+	}
+}
+
+// Reads off a connection until the server closes it, ensuring it was closed for removal.
+func wschatAwaitClose(t *testing.T, conn *websocket.Conn) {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if !websocket.IsCloseError(err, 4001) {
+			t.Fatalf("expected the session to be closed for removal; got %s", err)
+		}
+		return
+	}
+}
@@ -0,0 +1,210 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"wraith.me/message_server/pkg/http_types/ws/chat"
+	chatroom "wraith.me/message_server/pkg/schema/chat_room"
+	"wraith.me/message_server/pkg/util"
+	"wraith.me/message_server/pkg/ws/wschat"
+)
+
+// Spins up a chat server node that accepts multiplexed connections.
+func wschatMuxNode(t *testing.T) (*wschat.Server, *httptest.Server) {
+	srv, err := wschat.NewServer(redisInit())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//The user ID is passed via the query string in lieu of the auth middleware
+	hts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uid, err := util.ParseUUIDv7(r.URL.Query().Get("uid"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), wschat.WSMuxCtxObjKey, uid))
+		srv.GetMuxMelody().HandleRequest(w, r)
+	}))
+
+	t.Cleanup(func() {
+		srv.Close()
+		hts.Close()
+	})
+	return srv, hts
+}
+
+// Saves a chat room so multiplexed connections can subscribe to it.
+func wschatMuxRoom(t *testing.T, room chatroom.Room) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := chatroom.GetCollection().InsertOne(ctx, room); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		chatroom.GetCollection().RemoveId(context.Background(), room.ID)
+	})
+}
+
+// Writes a frame to a multiplexed connection.
+func wschatMuxSend(t *testing.T, conn *websocket.Conn, frame chat.Frame) {
+	if err := conn.WriteMessage(websocket.TextMessage, frame.JSON()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Reads frames off a multiplexed connection until one with the given op arrives.
+func wschatMuxAwait(t *testing.T, conn *websocket.Conn, op chat.FrameOp) chat.Frame {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("did not receive a %s frame: %s", op, err)
+		}
+		var frame chat.Frame
+		if err := json.Unmarshal(raw, &frame); err == nil && frame.Op == op {
+			return frame
+		}
+	}
+}
+
+// Reads room messages off a multiplexed connection until one of the given type arrives.
+func wschatMuxAwaitMsg(t *testing.T, conn *websocket.Conn, roomID util.UUID, typ chat.Type) chat.Message {
+	for {
+		frame := wschatMuxAwait(t, conn, chat.FrameOpMESSAGE)
+		var msg chat.Message
+		if err := json.Unmarshal(frame.Message, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if frame.RoomID != nil && *frame.RoomID == roomID && msg.Type == typ {
+			return msg
+		}
+	}
+}
+
+func TestWSChatMuxRooms(t *testing.T) {
+	//Subscriptions check room membership in MongoDB
+	mongoInit()
+
+	//Alice is in two rooms with Bob, but not the third
+	alice, bob := util.MustNewUUID7(), util.MustNewUUID7()
+	room1, room2 := chatroom.NewRoom(alice, bob), chatroom.NewRoom(alice, bob)
+	room3 := chatroom.NewRoom(bob)
+	for _, room := range []chatroom.Room{room1, room2, room3} {
+		wschatMuxRoom(t, room)
+	}
+	_, mnode := wschatMuxNode(t)
+	_, rnode := wschatNode(t, room1.ID, room1.Participants)
+
+	//Alice subscribes to both of her rooms over one connection
+	aconn := wschatDial(t, mnode, alice)
+	for _, room := range []chatroom.Room{room1, room2} {
+		wschatMuxSend(t, aconn, chat.NewRoomFrame(chat.FrameOpSUBSCRIBE, room.ID, nil))
+		if frame := wschatMuxAwait(t, aconn, chat.FrameOpSUBSCRIBED); *frame.RoomID != room.ID {
+			t.Fatalf("subscribed to the wrong room; expected %s, got %s", room.ID, *frame.RoomID)
+		}
+	}
+
+	//Subscribing to someone else's room is refused
+	wschatMuxSend(t, aconn, chat.NewRoomFrame(chat.FrameOpSUBSCRIBE, room3.ID, nil))
+	wschatMuxAwait(t, aconn, chat.FrameOpERROR)
+
+	//Bob joins the first room on a plain room socket on another node
+	bconn := wschatDial(t, rnode, bob)
+	wschatAwait(t, bconn, chat.TypeJOINEVENT)
+	wschatMuxAwaitMsg(t, aconn, room1.ID, chat.TypeJOINEVENT)
+
+	//Bob's messages reach Alice wrapped in a frame for the room
+	out := chat.NewMessageTyp("hello from a room socket", bob, room1.ID, chat.TypeUMSG)
+	if err := bconn.WriteMessage(websocket.TextMessage, out.JSON()); err != nil {
+		t.Fatal(err)
+	}
+	if in := wschatMuxAwaitMsg(t, aconn, room1.ID, chat.TypeUMSG); in.Content != out.Content {
+		t.Fatalf("mismatched content; expected '%s', got '%s'", out.Content, in.Content)
+	}
+
+	//Bob also gets his own message echoed back
+	wschatAwait(t, bconn, chat.TypeUMSG)
+
+	//Alice's messages reach Bob in the usual format, attributed to her
+	reply := chat.NewMessageTyp("hello from a mux socket", bob, room1.ID, chat.TypeUMSG)
+	wschatMuxSend(t, aconn, chat.NewMessageFrame(room1.ID, reply.JSON()))
+	in := wschatAwait(t, bconn, chat.TypeUMSG)
+	if in.Content != reply.Content {
+		t.Fatalf("mismatched content; expected '%s', got '%s'", reply.Content, in.Content)
+	}
+	if in.Sender != alice {
+		t.Fatalf("sender was not overwritten; expected %s, got %s", alice, in.Sender)
+	}
+
+	//Alice unsubscribes from the first room; Bob should see her leave
+	wschatMuxSend(t, aconn, chat.NewRoomFrame(chat.FrameOpUNSUBSCRIBE, room1.ID, nil))
+	wschatMuxAwait(t, aconn, chat.FrameOpUNSUBSCRIBED)
+	wschatAwait(t, bconn, chat.TypeQUITEVENT)
+
+	//Messages can no longer be sent to the room
+	wschatMuxSend(t, aconn, chat.NewMessageFrame(room1.ID, reply.JSON()))
+	wschatMuxAwait(t, aconn, chat.FrameOpERROR)
+}
+
+func TestWSChatMuxUserEvents(t *testing.T) {
+	//Alice connects to one node, and an event for her is raised on another
+	alice := util.MustNewUUID7()
+	_, mnode := wschatMuxNode(t)
+	srv2, _ := wschatMuxNode(t)
+	aconn := wschatDial(t, mnode, alice)
+
+	//Give the first node a moment to register the connection
+	time.Sleep(100 * time.Millisecond)
+	payload := map[string]string{"content": "you have a new friend request"}
+	if err := srv2.SendToUser(alice, chat.EventNOTIFICATION, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	//Alice should get the event on her multiplexed connection
+	frame := wschatMuxAwait(t, aconn, chat.FrameOpEVENT)
+	if frame.Event != chat.EventNOTIFICATION {
+		t.Fatalf("wrong event; expected '%s', got '%s'", chat.EventNOTIFICATION, frame.Event)
+	}
+	var got map[string]string
+	if err := json.Unmarshal(frame.Payload, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["content"] != payload["content"] {
+		t.Fatalf("mismatched payload; expected '%s', got '%s'", payload["content"], got["content"])
+	}
+}
+
+func TestWSChatMuxNotificationsOptOut(t *testing.T) {
+	//Alice gets her notifications from the notification socket, so she turns them off here
+	alice := util.MustNewUUID7()
+	srv, mnode := wschatMuxNode(t)
+	url := "ws" + strings.TrimPrefix(mnode.URL, "http") + "/?notifications=false&uid=" + alice.String()
+	aconn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer aconn.Close()
+
+	//Give the node a moment to register the connection
+	time.Sleep(100 * time.Millisecond)
+	if err := srv.SendToUser(alice, chat.EventNOTIFICATION, map[string]string{"content": "skipped"}); err != nil {
+		t.Fatal(err)
+	}
+	presence := chat.Presence{User: util.MustNewUUID7(), Status: chat.PresenceStatusONLINE}
+	if err := srv.SendToUser(alice, chat.EventPRESENCE, presence); err != nil {
+		t.Fatal(err)
+	}
+
+	//The notification is skipped, but other events still arrive
+	if frame := wschatMuxAwait(t, aconn, chat.FrameOpEVENT); frame.Event != chat.EventPRESENCE {
+		t.Fatalf("wrong event; expected '%s', got '%s'", chat.EventPRESENCE, frame.Event)
+	}
+}
@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"wraith.me/message_server/pkg/http_types/ws/chat"
+	chatroom "wraith.me/message_server/pkg/schema/chat_room"
+	"wraith.me/message_server/pkg/util"
+	"wraith.me/message_server/pkg/ws/wschat"
+)
+
+// Waits for a user's presence to reach the given status, as seen by a server node.
+func wschatAwaitPresence(t *testing.T, srv *wschat.Server, uid util.UUID, status chat.PresenceStatus) chat.Presence {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		presence, err := srv.GetPresence(context.Background(), uid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if presence[0].Status == status {
+			return presence[0]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected user %s to be %s; they're %s", uid, status, presence[0].Status)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Reads messages off a connection until a typing indicator arrives.
+func wschatAwaitTyping(t *testing.T, conn *websocket.Conn) chat.Message {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("did not receive a typing indicator: %s", err)
+		}
+		var msg chat.Message
+		if err := json.Unmarshal(raw, &msg); err == nil && (msg.Type == chat.TypeTYPINGSTART || msg.Type == chat.TypeTYPINGSTOP) {
+			return msg
+		}
+	}
+}
+
+func TestWSChatPresence(t *testing.T) {
+	//Alice has never connected
+	alice := util.MustNewUUID7()
+	srv1, node1 := wschatMuxNode(t)
+	srv2, node2 := wschatMuxNode(t)
+	if presence := wschatAwaitPresence(t, srv2, alice, chat.PresenceStatusOFFLINE); !presence.LastSeen.IsZero() {
+		t.Fatalf("expected no last seen time; got %s", presence.LastSeen)
+	}
+
+	//Alice connects on the first node, and is seen as online by the second
+	conn1 := wschatDial(t, node1, alice)
+	wschatAwaitPresence(t, srv2, alice, chat.PresenceStatusONLINE)
+
+	//Alice marks her only connection as away
+	wschatMuxSend(t, conn1, chat.Frame{Op: chat.FrameOpPRESENCE, Payload: json.RawMessage(`"AWAY"`)})
+	wschatAwaitPresence(t, srv2, alice, chat.PresenceStatusAWAY)
+
+	//Users can't mark themselves as offline while connected
+	wschatMuxSend(t, conn1, chat.Frame{Op: chat.FrameOpPRESENCE, Payload: json.RawMessage(`"OFFLINE"`)})
+	wschatMuxAwait(t, conn1, chat.FrameOpERROR)
+
+	//A second connection that isn't away brings her back online, until it's closed
+	conn2 := wschatDial(t, node2, alice)
+	wschatAwaitPresence(t, srv1, alice, chat.PresenceStatusONLINE)
+	conn2.Close()
+	wschatAwaitPresence(t, srv1, alice, chat.PresenceStatusAWAY)
+
+	//Closing her last connection takes her offline, but she was seen just now
+	conn1.Close()
+	presence := wschatAwaitPresence(t, srv2, alice, chat.PresenceStatusOFFLINE)
+	if time.Since(presence.LastSeen) > 5*time.Second {
+		t.Fatalf("expected a recent last seen time; got %s", presence.LastSeen)
+	}
+}
+
+func TestWSChatTyping(t *testing.T) {
+	//Create a room with two members and a node for each of them
+	alice, bob := util.MustNewUUID7(), util.MustNewUUID7()
+	room := chatroom.NewRoom(alice, bob)
+	_, node1 := wschatNode(t, room.ID, room.Participants)
+	_, node2 := wschatNode(t, room.ID, room.Participants)
+	aconn := wschatDial(t, node1, alice)
+	wschatAwait(t, aconn, chat.TypeJOINEVENT)
+	bconn := wschatDial(t, node2, bob)
+	wschatAwait(t, bconn, chat.TypeJOINEVENT)
+
+	//Alice starts typing a few times in a row, then stops
+	for _, typ := range []chat.Type{chat.TypeTYPINGSTART, chat.TypeTYPINGSTART, chat.TypeTYPINGSTART, chat.TypeTYPINGSTOP} {
+		msg := chat.NewMessageTyp("", alice, room.ID, typ)
+		if err := aconn.WriteMessage(websocket.TextMessage, msg.JSON()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//Bob should see only the first start, followed by the stop
+	if msg := wschatAwaitTyping(t, bconn); msg.Type != chat.TypeTYPINGSTART || msg.Sender != alice {
+		t.Fatalf("expected alice to start typing; got %s from %s", msg.Type, msg.Sender)
+	}
+	if msg := wschatAwaitTyping(t, bconn); msg.Type != chat.TypeTYPINGSTOP {
+		t.Fatalf("repeated typing indicators were not throttled; got %s", msg.Type)
+	}
+
+	//Alice starts typing again and leaves before she's done
+	start := chat.NewMessageTyp("", alice, room.ID, chat.TypeTYPINGSTART)
+	if err := aconn.WriteMessage(websocket.TextMessage, start.JSON()); err != nil {
+		t.Fatal(err)
+	}
+	wschatAwaitTyping(t, bconn)
+	aconn.Close()
+	if msg := wschatAwaitTyping(t, bconn); msg.Type != chat.TypeTYPINGSTOP {
+		t.Fatalf("expected alice to stop typing when she left; got %s", msg.Type)
+	}
+}
@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"wraith.me/message_server/pkg/obj/notification"
+	"wraith.me/message_server/pkg/schema/user"
+	"wraith.me/message_server/pkg/util"
+	"wraith.me/message_server/pkg/ws/wsnotif"
+)
+
+// Spins up a notification server node.
+func wsnotifNode(t *testing.T) (*wsnotif.Server, *httptest.Server) {
+	srv, err := wsnotif.NewServer(redisInit())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//The user ID is passed via the query string in lieu of the auth middleware
+	hts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uid, err := util.ParseUUIDv7(r.URL.Query().Get("uid"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), wsnotif.WSNotifCtxObjKey, uid))
+		srv.GetMelody().HandleRequest(w, r)
+	}))
+
+	t.Cleanup(func() {
+		srv.Close()
+		hts.Close()
+	})
+	return srv, hts
+}
+
+// Reads a notification off a connection, failing if none arrives in time.
+func wsnotifAwait(t *testing.T, conn *websocket.Conn, timeout time.Duration) (notification.Notification, error) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	var notif notification.Notification
+	_, raw, err := conn.ReadMessage()
+	if err != nil {
+		return notif, err
+	}
+	return notif, json.Unmarshal(raw, &notif)
+}
+
+func TestWSNotifClusterPush(t *testing.T) {
+	//Create two nodes; the recipient is connected to one from two devices
+	nodeA, _ := wsnotifNode(t)
+	_, htsB := wsnotifNode(t)
+	recipient, bystander := util.MustNewUUID7(), util.MustNewUUID7()
+	dial := func(uid util.UUID) *websocket.Conn {
+		url := "ws" + strings.TrimPrefix(htsB.URL, "http") + "/?uid=" + uid.String()
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	phone, laptop, other := dial(recipient), dial(recipient), dial(bystander)
+
+	//Give the connections a moment to be registered, then push from the other node
+	time.Sleep(100 * time.Millisecond)
+	sent := notification.OutgoingFRQNotif(*user.NewUserSimple("wsnotif_test", "wsnotif@example.com"), recipient)
+	nodeA.Push(sent)
+
+	//Both of the recipient's devices should get it
+	for _, conn := range []*websocket.Conn{phone, laptop} {
+		got, err := wsnotifAwait(t, conn, 5*time.Second)
+		if err != nil {
+			t.Fatalf("did not receive the notification: %s", err)
+		}
+		if got.ID != sent.ID || got.Type != notification.TypeFRQNEW {
+			t.Fatalf("expected notification %s; got %+v", sent.ID, got)
+		}
+	}
+
+	//Nobody else should
+	if got, err := wsnotifAwait(t, other, 500*time.Millisecond); err == nil {
+		t.Fatalf("bystander received notification %s", got.ID)
+	}
+}